@@ -0,0 +1,193 @@
+// Package client is a typed Go client for the withdrawals API.
+package client
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Client calls the API at a base URL with a bearer token. It is safe for
+// concurrent use.
+type Client struct {
+    baseURL    string
+    token      string
+    httpClient *http.Client
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient makes the client send requests with hc instead of
+// http.DefaultClient, e.g. to set timeouts or a custom transport.
+func WithHTTPClient(hc *http.Client) Option {
+    return func(c *Client) {
+        c.httpClient = hc
+    }
+}
+
+// RequestOption adds headers to a single request.
+type RequestOption func(http.Header)
+
+// WithTenantToken sends token as X-Tenant-Token, the JWT naming the tenant a
+// request acts for when the server requires one.
+func WithTenantToken(token string) RequestOption {
+    return func(h http.Header) {
+        h.Set("X-Tenant-Token", token)
+    }
+}
+
+// WithIfMatch sends etag, as returned in a withdrawal's ETag, as If-Match,
+// so the request fails with ErrVersionConflict if the withdrawal has changed
+// since.
+func WithIfMatch(etag string) RequestOption {
+    return func(h http.Header) {
+        h.Set("If-Match", etag)
+    }
+}
+
+// New returns a client for the API at baseURL, e.g. "http://localhost:8080",
+// authenticating with token.
+func New(baseURL, token string, opts ...Option) *Client {
+    c := &Client{
+        baseURL:    strings.TrimRight(baseURL, "/"),
+        token:      token,
+        httpClient: http.DefaultClient,
+    }
+    for _, opt := range opts {
+        opt(c)
+    }
+    return c
+}
+
+type User struct {
+    ID         int64     `json:"id"`
+    Balance    int64     `json:"balance"`
+    Tier       string    `json:"tier"`
+    ExternalID *string   `json:"external_id,omitempty"`
+    CreatedAt  time.Time `json:"created_at"`
+    UpdatedAt  time.Time `json:"updated_at"`
+    // OverdraftLimit is how far below zero withdrawals may take Balance.
+    OverdraftLimit int64 `json:"overdraft_limit"`
+}
+
+type CreateUserRequest struct {
+    ID         int64   `json:"id"`
+    Balance    int64   `json:"balance"`
+    ExternalID *string `json:"external_id,omitempty"`
+}
+
+type Withdrawal struct {
+    ID             int64      `json:"id"`
+    UserID         int64      `json:"user_id"`
+    Amount         int64      `json:"amount"`
+    Fee            int64      `json:"fee"`
+    Currency       string     `json:"currency"`
+    Destination    string     `json:"destination"`
+    Status         string     `json:"status"`
+    IdempotencyKey string     `json:"idempotency_key"`
+    ReservedUntil  *time.Time `json:"reserved_until,omitempty"`
+    CreatedAt      time.Time  `json:"created_at"`
+    UpdatedAt      time.Time  `json:"updated_at"`
+    ConfirmedAt    *time.Time `json:"confirmed_at"`
+    NoteCount      int        `json:"note_count"`
+    ReversalReason string     `json:"reversal_reason,omitempty"`
+    Version        int64      `json:"version"`
+    ExternalTxHash *string    `json:"external_tx_hash,omitempty"`
+
+    // ResultingBalance is only set by CreateWithdrawal.
+    ResultingBalance *int64 `json:"resulting_balance,omitempty"`
+}
+
+// CreateWithdrawalRequest describes a withdrawal. Amount is in minor units.
+type CreateWithdrawalRequest struct {
+    UserID         int64  `json:"user_id"`
+    Amount         int64  `json:"amount"`
+    Currency       string `json:"currency"`
+    Destination    string `json:"destination"`
+    IdempotencyKey string `json:"idempotency_key"`
+}
+
+func (c *Client) CreateUser(ctx context.Context, req CreateUserRequest, opts ...RequestOption) (User, error) {
+    var u User
+    err := c.do(ctx, http.MethodPost, "/v1/users", req, nil, &u, opts)
+    return u, err
+}
+
+func (c *Client) CreateWithdrawal(ctx context.Context, req CreateWithdrawalRequest, opts ...RequestOption) (Withdrawal, error) {
+    var w Withdrawal
+    err := c.do(ctx, http.MethodPost, "/v1/withdrawals", req, nil, &w, opts)
+    return w, err
+}
+
+// ConfirmWithdrawal confirms a pending withdrawal. operator is sent as
+// X-Operator and may be empty when the server does not require it. Pass
+// WithIfMatch to confirm only the version last seen.
+func (c *Client) ConfirmWithdrawal(ctx context.Context, id int64, operator string, opts ...RequestOption) (Withdrawal, error) {
+    var header http.Header
+    if operator != "" {
+        header = http.Header{"X-Operator": {operator}}
+    }
+    var w Withdrawal
+    err := c.do(ctx, http.MethodPost, "/v1/withdrawals/"+strconv.FormatInt(id, 10)+"/confirm", nil, header, &w, opts)
+    return w, err
+}
+
+func (c *Client) GetWithdrawal(ctx context.Context, id int64, opts ...RequestOption) (Withdrawal, error) {
+    var w Withdrawal
+    err := c.do(ctx, http.MethodGet, "/v1/withdrawals/"+strconv.FormatInt(id, 10), nil, nil, &w, opts)
+    return w, err
+}
+
+// do sends a request with body encoded as JSON, if not nil, and decodes a
+// successful response into out. Error responses are returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, body any, header http.Header, out any, opts []RequestOption) error {
+    var reader io.Reader
+    if body != nil {
+        data, err := json.Marshal(body)
+        if err != nil {
+            return fmt.Errorf("encode request: %w", err)
+        }
+        reader = bytes.NewReader(data)
+    }
+    u, err := url.JoinPath(c.baseURL, path)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, method, u, reader)
+    if err != nil {
+        return err
+    }
+    for key, values := range header {
+        req.Header[key] = values
+    }
+    for _, opt := range opts {
+        opt(req.Header)
+    }
+    req.Header.Set("Authorization", "Bearer "+c.token)
+    req.Header.Set("Accept", "application/json")
+    if body != nil {
+        req.Header.Set("Content-Type", "application/json")
+    }
+
+    resp, err := c.httpClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if resp.StatusCode >= http.StatusBadRequest {
+        return decodeError(resp)
+    }
+    if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+        return fmt.Errorf("decode response: %w", err)
+    }
+    return nil
+}
@@ -0,0 +1,113 @@
+package client_test
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "task.hh/client"
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestClientSendsRequests(t *testing.T) {
+    var got *http.Request
+    var body map[string]any
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got = r
+        body = nil
+        _ = json.NewDecoder(r.Body).Decode(&body)
+        w.Header().Set("Content-Type", "application/json")
+        _, _ = io.WriteString(w, `{"id":7,"user_id":1,"amount":100,"currency":"USDT","status":"pending","resulting_balance":900}`)
+    }))
+    defer srv.Close()
+
+    c := client.New(srv.URL+"/", "secret")
+    ctx := context.Background()
+
+    w, err := c.CreateWithdrawal(ctx, client.CreateWithdrawalRequest{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "addr", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    if w.ID != 7 || w.Status != "pending" || w.ResultingBalance == nil || *w.ResultingBalance != 900 {
+        t.Fatalf("unexpected withdrawal: %+v", w)
+    }
+    if got.Method != http.MethodPost || got.URL.Path != "/v1/withdrawals" {
+        t.Fatalf("unexpected request %s %s", got.Method, got.URL.Path)
+    }
+    if auth := got.Header.Get("Authorization"); auth != "Bearer secret" {
+        t.Fatalf("expected bearer token, got %q", auth)
+    }
+    if body["idempotency_key"] != "k1" || body["amount"] != float64(100) {
+        t.Fatalf("unexpected body: %v", body)
+    }
+
+    if _, err := c.ConfirmWithdrawal(ctx, 7, "alice", client.WithIfMatch(`"2"`), client.WithTenantToken("jwt")); err != nil {
+        t.Fatalf("confirm withdrawal: %v", err)
+    }
+    if got.URL.Path != "/v1/withdrawals/7/confirm" || got.Header.Get("X-Operator") != "alice" {
+        t.Fatalf("unexpected confirm request %s with operator %q", got.URL.Path, got.Header.Get("X-Operator"))
+    }
+    if got.Header.Get("If-Match") != `"2"` || got.Header.Get("X-Tenant-Token") != "jwt" {
+        t.Fatalf("expected If-Match and X-Tenant-Token, got %v", got.Header)
+    }
+
+    if _, err := c.GetWithdrawal(ctx, 7); err != nil {
+        t.Fatalf("get withdrawal: %v", err)
+    }
+    if got.Method != http.MethodGet || got.URL.Path != "/v1/withdrawals/7" {
+        t.Fatalf("unexpected request %s %s", got.Method, got.URL.Path)
+    }
+    if got.Header.Get("If-Match") != "" || got.Header.Get("X-Tenant-Token") != "" {
+        t.Fatalf("expected request options not to carry over, got %v", got.Header)
+    }
+}
+
+func TestClientErrors(t *testing.T) {
+    srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.WriteHeader(http.StatusConflict)
+        _, _ = io.WriteString(w, `{"error":"insufficient_balance","code":"insufficient_balance","message":"balance is too low","request_id":"r1","details":{"balance":10,"requested":100,"shortfall":90}}`)
+    }))
+    defer srv.Close()
+
+    _, err := client.New(srv.URL, "secret").CreateWithdrawal(context.Background(), client.CreateWithdrawalRequest{UserID: 1, Amount: 100})
+    if !errors.Is(err, client.ErrInsufficientBalance) {
+        t.Fatalf("expected ErrInsufficientBalance, got %v", err)
+    }
+    var apiErr *client.Error
+    if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusConflict || apiErr.RequestID != "r1" {
+        t.Fatalf("unexpected error: %#v", err)
+    }
+    var details struct {
+        Shortfall int64 `json:"shortfall"`
+    }
+    if err := json.Unmarshal(apiErr.Details, &details); err != nil || details.Shortfall != 90 {
+        t.Fatalf("unexpected details %s: %v", apiErr.Details, err)
+    }
+}
+
+func TestClientAgainstServer(t *testing.T) {
+    handler := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0)).Routes()
+    srv := httptest.NewServer(handler)
+    defer srv.Close()
+    ctx := context.Background()
+
+    _, err := client.New(srv.URL, "wrong").GetWithdrawal(ctx, 1)
+    if !errors.Is(err, client.ErrUnauthorized) {
+        t.Fatalf("expected ErrUnauthorized, got %v", err)
+    }
+
+    // Rejected before the store is touched.
+    _, err = client.New(srv.URL, "test-token").GetWithdrawal(ctx, 0)
+    var apiErr *client.Error
+    if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Code != "invalid_id" {
+        t.Fatalf("expected 400 invalid_id, got %v", err)
+    }
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+)
+
+// Sentinel errors for the API error codes callers usually branch on. An
+// *Error matches the one for its code with errors.Is.
+var (
+    ErrUnauthorized        = errors.New("unauthorized")
+    ErrForbidden           = errors.New("forbidden")
+    ErrNotFound            = errors.New("not found")
+    ErrUserNotFound        = errors.New("user not found")
+    ErrUserExists          = errors.New("user already exists")
+    ErrInsufficientBalance = errors.New("insufficient balance")
+    ErrIdempotencyConflict = errors.New("idempotency conflict")
+    ErrInvalidStatus       = errors.New("invalid status")
+    ErrReservationExpired  = errors.New("reservation expired")
+    ErrRateLimited         = errors.New("rate limited")
+    ErrVersionConflict     = errors.New("version conflict")
+)
+
+var codeErrors = map[string]error{
+    "forbidden":            ErrForbidden,
+    "idempotency_conflict": ErrIdempotencyConflict,
+    "insufficient_balance": ErrInsufficientBalance,
+    "invalid_status":       ErrInvalidStatus,
+    "not_found":            ErrNotFound,
+    "rate_limited":         ErrRateLimited,
+    "reservation_expired":  ErrReservationExpired,
+    "unauthorized":         ErrUnauthorized,
+    "user_exists":          ErrUserExists,
+    "user_not_found":       ErrUserNotFound,
+    "version_conflict":     ErrVersionConflict,
+}
+
+// Error is an error response from the API.
+type Error struct {
+    StatusCode    int    `json:"-"`
+    Code          string `json:"code"`
+    Message       string `json:"message"`
+    RequestID     string `json:"request_id"`
+    CurrentStatus string `json:"current_status"`
+
+    // Details holds the raw details object, e.g. balance, requested and
+    // shortfall for insufficient_balance.
+    Details json.RawMessage `json:"details"`
+}
+
+func (e *Error) Error() string {
+    if e.Code == "" {
+        return fmt.Sprintf("api error %d: %s", e.StatusCode, http.StatusText(e.StatusCode))
+    }
+    if e.Message == "" {
+        return fmt.Sprintf("api error %d: %s", e.StatusCode, e.Code)
+    }
+    return fmt.Sprintf("api error %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Unwrap returns the sentinel error for e.Code, if there is one.
+func (e *Error) Unwrap() error {
+    return codeErrors[e.Code]
+}
+
+func decodeError(resp *http.Response) error {
+    apiErr := &Error{StatusCode: resp.StatusCode}
+    // A body that is not an error envelope, e.g. from a proxy, still yields
+    // an *Error carrying the status code.
+    _ = json.NewDecoder(resp.Body).Decode(apiErr)
+    return apiErr
+}
@@ -5,94 +5,139 @@ import (
     "errors"
     "fmt"
     "log"
+    "log/slog"
+    "net"
     "net/http"
     "os"
     "os/signal"
-    "strings"
     "syscall"
     "time"
 
     "github.com/jackc/pgx/v5/pgxpool"
+    "golang.org/x/net/http2"
+    "golang.org/x/net/http2/h2c"
 
     "task.hh/internal/api"
+    "task.hh/internal/config"
+    "task.hh/internal/notify"
     "task.hh/internal/store"
+    "task.hh/internal/tracing"
 )
 
-type config struct {
-    DatabaseURL string
-    AuthToken   string
-    Port        string
-}
-
-func loadConfig() (config, error) {
-    dbURL := strings.TrimSpace(os.Getenv("DATABASE_URL"))
-    if dbURL == "" {
-        host := strings.TrimSpace(os.Getenv("DB_HOST"))
-        if host == "" {
-            host = "localhost"
-        }
-        port := strings.TrimSpace(os.Getenv("DB_PORT"))
-        if port == "" {
-            port = "5432"
-        }
-        user := strings.TrimSpace(os.Getenv("DB_USER"))
-        password := strings.TrimSpace(os.Getenv("DB_PASSWORD"))
-        name := strings.TrimSpace(os.Getenv("DB_NAME"))
-        sslmode := strings.TrimSpace(os.Getenv("DB_SSLMODE"))
-        if sslmode == "" {
-            sslmode = "disable"
-        }
-        if user == "" || password == "" || name == "" {
-            return config{}, errors.New("DATABASE_URL or DB_USER/DB_PASSWORD/DB_NAME are required")
-        }
-        dbURL = fmt.Sprintf(
-            "host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-            host,
-            port,
-            user,
-            password,
-            name,
-            sslmode,
-        )
-    }
-
-    authToken := strings.TrimSpace(os.Getenv("AUTH_TOKEN"))
-    if authToken == "" {
-        return config{}, errors.New("AUTH_TOKEN is required")
+func main() {
+    cfg, err := config.Load(os.Args[1:], os.Getenv)
+    if err != nil {
+        log.Fatalf("config error: %v", err)
     }
-
-    port := strings.TrimSpace(os.Getenv("PORT"))
-    if port == "" {
-        port = "8080"
+    if cfg.PrintConfig {
+        cfg.Print(os.Stdout)
+        return
     }
 
-    return config{
-        DatabaseURL: dbURL,
-        AuthToken:   authToken,
-        Port:        port,
-    }, nil
-}
-
-func main() {
-    cfg, err := loadConfig()
+    poolCfg, err := pgxpool.ParseConfig(cfg.DatabaseURL)
     if err != nil {
-        log.Fatalf("config error: %v", err)
+        log.Fatalf("db config error: %v", err)
     }
+    if cfg.DBMaxConns > 0 {
+        poolCfg.MaxConns = cfg.DBMaxConns
+    }
+    poolCfg.MinConns = cfg.DBMinConns
+    poolCfg.ConnConfig.Tracer = store.QueryTracer{}
 
     ctx := context.Background()
-    pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+    shutdownTracing, err := tracing.Setup(ctx, os.Getenv)
+    if err != nil {
+        log.Fatalf("tracing error: %v", err)
+    }
+    pool, err := pgxpool.NewWithConfig(ctx, poolCfg)
     if err != nil {
         log.Fatalf("db error: %v", err)
     }
     defer pool.Close()
 
     logger := log.New(os.Stdout, "", log.LstdFlags)
-    srv := api.NewServer(store.New(pool), cfg.AuthToken, logger)
 
-    httpServer := &http.Server{
-        Addr:              ":" + cfg.Port,
-        Handler:           srv.Routes(),
-        ReadHeaderTimeout: 5 * time.Second,
+    if err := waitForDatabase(ctx, pool.Ping, cfg.DBConnectTimeout, logger); err != nil {
+        logger.Fatalf("db error: %v", err)
+    }
+    var auditSink store.AuditSink
+    if cfg.BalanceAudit {
+        auditSink = store.PostgresAuditSink{}
+    }
+    st := store.New(pool,
+        store.WithMaxPendingWithdrawals(cfg.MaxPendingWithdrawals),
+        store.WithFeePolicies(cfg.WithdrawalFees),
+        store.WithFeeExemptTiers(cfg.FeeExemptTiers...),
+        store.WithReservationTTL(cfg.ReservationTTL),
+        store.WithCountCap(int64(cfg.ListCountCap)),
+        store.WithOpeningLedgerEntries(cfg.OpeningLedgerEntries),
+        store.WithSettlementLedgerEntries(cfg.SettlementLedgerEntries),
+        store.WithIdempotencyCache(cfg.IdempotencyCacheSize, cfg.IdempotencyCacheTTL),
+        store.WithAuditSink(auditSink),
+        store.WithCanonicalIdempotencyKeys(cfg.CanonicalIdempotencyKeys),
+        store.WithSupportedCurrencies(cfg.SupportedCurrencies...),
+    )
+    if err := st.CheckSchema(ctx); err != nil {
+        logger.Fatalf("%v; apply the schema first: psql \"$DATABASE_URL\" -f schema.sql", err)
+    }
+    if err := st.RegisterCurrencies(ctx); err != nil {
+        logger.Fatalf("register currencies: %v", err)
+    }
+
+    authKeys := make(map[string]string, len(cfg.AuthKeys)+1)
+    for name, token := range cfg.AuthKeys {
+        authKeys[name] = token
+    }
+    if cfg.AuthToken != "" {
+        authKeys["default"] = cfg.AuthToken
+    }
+    opts := []api.Option{
+        api.WithAuthKeys(authKeys),
+        api.WithReplayStatusOK(cfg.ReplayStatusOK),
+        api.WithOperatorRequired(cfg.OperatorRequired),
+        api.WithConfirmByCreatingKey(cfg.ConfirmByCreatingKey),
+        api.WithAdminToken(cfg.AdminToken),
+        api.WithIdempotencyKeyPattern(cfg.IdempotencyKeyPattern),
+        api.WithLogRedaction(cfg.LogRedactFields...),
+        api.WithResponseSigningKeys(cfg.ResponseSigningKeys),
+        api.WithMaintenanceRetryAfter(cfg.MaintenanceRetryAfter),
+    }
+    if cfg.TenantJWTSecret != "" {
+        opts = append(opts, api.WithTenantSecret([]byte(cfg.TenantJWTSecret)))
+    }
+    if cfg.DebugLogBodies {
+        opts = append(opts, api.WithDebugBodyLogger(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+    }
+    srv := api.NewServer(st, cfg.AuthToken, logger, opts...)
+    if err := srv.RefreshMaintenance(ctx); err != nil {
+        logger.Fatalf("read maintenance mode: %v", err)
+    }
+
+    httpServer := newHTTPServer(cfg, srv.Routes())
+    httpServer.BaseContext = func(net.Listener) context.Context {
+        return srv.BaseContext()
+    }
+
+    if cfg.AuthTokenFile != "" {
+        go watchAuthTokenFile(srv.BaseContext(), cfg.AuthTokenFile, cfg.AuthTokenPollInterval, srv, logger)
+    }
+
+    if cfg.SMTPHost != "" {
+        summary := &notify.DailySummary{
+            Stats:    st,
+            Mailer:   notify.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPFrom),
+            Sent:     st,
+            To:       cfg.SummaryEmailTo,
+            SendHour: cfg.SummarySendHour,
+            Logger:   logger,
+        }
+        go summary.Run(srv.BaseContext(), time.Minute)
+    }
+
+    go srv.RunMaintenanceWatcher(srv.BaseContext(), cfg.MaintenancePollInterval)
+
+    if cfg.ReservationTTL > 0 {
+        go srv.RunReservationSweeper(srv.BaseContext(), cfg.ReservationSweepInterval)
     }
 
     go func() {
@@ -106,7 +151,114 @@ func main() {
     signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
     <-quit
 
-    ctxShutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    ctxShutdown, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
     defer cancel()
-    _ = httpServer.Shutdown(ctxShutdown)
+
+    stats := srv.Shutdown(ctxShutdown)
+    if stats.Abandoned > 0 {
+        _ = httpServer.Close()
+    } else {
+        _ = httpServer.Shutdown(ctxShutdown)
+    }
+    logger.Printf("shutdown complete: drained=%d abandoned=%d", stats.Drained, stats.Abandoned)
+    if err := shutdownTracing(ctxShutdown); err != nil {
+        logger.Printf("tracing shutdown error: %v", err)
+    }
+}
+
+// newHTTPServer applies the connection settings from cfg. With cfg.H2C the
+// handler also accepts HTTP/2 over plain TCP, both with prior knowledge and
+// via an Upgrade from HTTP/1.1; TLS is expected to end at the mesh sidecar.
+func newHTTPServer(cfg config.Config, handler http.Handler) *http.Server {
+    s := &http.Server{
+        Addr:              ":" + cfg.Port,
+        Handler:           handler,
+        ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+        ReadTimeout:       cfg.ReadTimeout,
+        WriteTimeout:      cfg.WriteTimeout,
+        IdleTimeout:       cfg.IdleTimeout,
+        MaxHeaderBytes:    cfg.MaxHeaderBytes,
+    }
+    if cfg.H2C {
+        s.Handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: cfg.IdleTimeout})
+    }
+    return s
+}
+
+// watchAuthTokenFile re-reads the token file on SIGHUP and, when interval is
+// positive, whenever the file's mtime changes.
+func watchAuthTokenFile(ctx context.Context, path string, interval time.Duration, srv *api.Server, logger *log.Logger) {
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    defer signal.Stop(hup)
+
+    var tick <-chan time.Time
+    if interval > 0 {
+        ticker := time.NewTicker(interval)
+        defer ticker.Stop()
+        tick = ticker.C
+    }
+
+    var lastMod time.Time
+    if info, err := os.Stat(path); err == nil {
+        lastMod = info.ModTime()
+    }
+
+    reload := func() {
+        token, err := config.ReadSecretFile(path)
+        if err != nil {
+            logger.Printf("auth token reload failed: %v", err)
+            return
+        }
+        if err := srv.ReloadAuthToken(token); err != nil {
+            logger.Printf("auth token reload failed: %v", err)
+        }
+    }
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-hup:
+            reload()
+        case <-tick:
+            info, err := os.Stat(path)
+            if err != nil {
+                logger.Printf("auth token stat failed: %v", err)
+                continue
+            }
+            if !info.ModTime().Equal(lastMod) {
+                lastMod = info.ModTime()
+                reload()
+            }
+        }
+    }
+}
+
+// waitForDatabase pings until the database answers, backing off
+// exponentially, and gives up after timeout.
+func waitForDatabase(ctx context.Context, ping func(context.Context) error, timeout time.Duration, logger *log.Logger) error {
+    ctx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    backoff := 250 * time.Millisecond
+    const maxBackoff = 5 * time.Second
+
+    for attempt := 1; ; attempt++ {
+        err := ping(ctx)
+        if err == nil {
+            return nil
+        }
+        logger.Printf("database not ready (attempt %d): %v", attempt, err)
+
+        select {
+        case <-ctx.Done():
+            return fmt.Errorf("database unreachable after %s: %w", timeout, err)
+        case <-time.After(backoff):
+        }
+        backoff *= 2
+        if backoff > maxBackoff {
+            backoff = maxBackoff
+        }
+    }
 }
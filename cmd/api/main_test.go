@@ -0,0 +1,97 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "errors"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "golang.org/x/net/http2"
+
+    "task.hh/internal/config"
+)
+
+func TestWaitForDatabaseRetries(t *testing.T) {
+    calls := 0
+    ping := func(context.Context) error {
+        calls++
+        if calls < 3 {
+            return errors.New("connection refused")
+        }
+        return nil
+    }
+
+    if err := waitForDatabase(context.Background(), ping, 5*time.Second, log.New(io.Discard, "", 0)); err != nil {
+        t.Fatalf("expected database to become ready, got %v", err)
+    }
+    if calls != 3 {
+        t.Fatalf("expected 3 attempts, got %d", calls)
+    }
+}
+
+func TestWaitForDatabaseGivesUp(t *testing.T) {
+    ping := func(context.Context) error {
+        return errors.New("connection refused")
+    }
+
+    start := time.Now()
+    err := waitForDatabase(context.Background(), ping, 300*time.Millisecond, log.New(io.Discard, "", 0))
+    if err == nil {
+        t.Fatalf("expected error")
+    }
+    if elapsed := time.Since(start); elapsed > 2*time.Second {
+        t.Fatalf("expected to give up near the timeout, took %s", elapsed)
+    }
+}
+
+func TestNewHTTPServerH2C(t *testing.T) {
+    handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        _, _ = io.WriteString(w, r.Proto)
+    })
+    cfg := config.Config{Port: "0", IdleTimeout: time.Minute, MaxHeaderBytes: 1 << 16}
+
+    h2cClient := &http.Client{Transport: &http2.Transport{
+        AllowHTTP: true,
+        DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+            var d net.Dialer
+            return d.DialContext(ctx, network, addr)
+        },
+    }}
+
+    for _, enabled := range []bool{false, true} {
+        cfg.H2C = enabled
+        ts := httptest.NewServer(newHTTPServer(cfg, handler).Handler)
+
+        resp, err := h2cClient.Get(ts.URL)
+        if enabled {
+            if err != nil {
+                t.Fatalf("h2c request: %v", err)
+            }
+            body, _ := io.ReadAll(resp.Body)
+            resp.Body.Close()
+            if string(body) != "HTTP/2.0" {
+                t.Fatalf("expected HTTP/2.0, got %q", body)
+            }
+        } else if err == nil {
+            resp.Body.Close()
+            t.Fatalf("expected prior-knowledge HTTP/2 to fail without h2c")
+        }
+
+        resp, err = http.Get(ts.URL)
+        if err != nil {
+            t.Fatalf("HTTP/1.1 request: %v", err)
+        }
+        body, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if string(body) != "HTTP/1.1" {
+            t.Fatalf("expected HTTP/1.1 to keep working, got %q", body)
+        }
+        ts.Close()
+    }
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+// handleAdminWithdrawals lets fraud analysts find every withdrawal sent to a
+// destination, across users.
+func (s *Server) handleAdminWithdrawals(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    filter, err := parseDestinationFilter(r.URL.Query())
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        return
+    }
+
+    withCount, err := parseWithCount(r.URL.Query())
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        return
+    }
+
+    var withdrawals []store.Withdrawal
+    var total store.Total
+    if withCount {
+        withdrawals, total, err = s.store.FindWithdrawalsByDestinationWithTotal(r.Context(), filter)
+    } else {
+        withdrawals, err = s.store.FindWithdrawalsByDestination(r.Context(), filter)
+    }
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidFilter) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+            return
+        }
+        s.logger.Printf("find withdrawals by destination error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := withdrawalPageResponse{Withdrawals: make([]withdrawalResponse, 0, len(withdrawals))}
+    for _, wd := range withdrawals {
+        resp.Withdrawals = append(resp.Withdrawals, toWithdrawalResponse(wd))
+    }
+    limit := filter.Limit
+    if limit == 0 {
+        limit = store.DefaultListLimit
+    }
+    if len(withdrawals) == limit {
+        resp.NextCursor = withdrawals[len(withdrawals)-1].ID
+    }
+    if withCount {
+        resp.setTotal(total)
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+const stuckWithdrawalsPath = "/v1/admin/withdrawals/stuck"
+
+// handleAdminStuckWithdrawals lists withdrawals pending for longer than
+// older_than, a Go duration such as 30m, across users, so alerting can page
+// when confirmations stall. At most limit are listed, oldest first; none
+// stuck is an empty list.
+func (s *Server) handleAdminStuckWithdrawals(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    olderThan, err := time.ParseDuration(r.URL.Query().Get("older_than"))
+    if err != nil || olderThan < 0 {
+        writeError(w, http.StatusBadRequest, "invalid_older_than")
+        return
+    }
+
+    limit := 0
+    if raw := r.URL.Query().Get("limit"); raw != "" {
+        limit, err = strconv.Atoi(raw)
+        if err != nil || limit <= 0 {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("invalid limit %q", raw))
+            return
+        }
+    }
+
+    withdrawals, err := s.store.GetStuckPendingWithdrawals(r.Context(), olderThan, limit)
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidFilter) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+            return
+        }
+        s.logger.Printf("get stuck withdrawals error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := withdrawalListResponse{Withdrawals: make([]withdrawalResponse, 0, len(withdrawals))}
+    for _, wd := range withdrawals {
+        resp.Withdrawals = append(resp.Withdrawals, toWithdrawalResponse(wd))
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func parseDestinationFilter(q url.Values) (store.DestinationFilter, error) {
+    filter := store.DestinationFilter{Destination: strings.TrimSpace(q.Get("destination"))}
+    for _, p := range []struct {
+        key string
+        dst **time.Time
+    }{
+        {"from", &filter.From},
+        {"to", &filter.To},
+    } {
+        raw := q.Get(p.key)
+        if raw == "" {
+            continue
+        }
+        t, err := time.Parse(time.RFC3339Nano, raw)
+        if err != nil {
+            return store.DestinationFilter{}, fmt.Errorf("invalid %s %q", p.key, raw)
+        }
+        *p.dst = &t
+    }
+    if raw := q.Get("before"); raw != "" {
+        n, err := strconv.ParseInt(raw, 10, 64)
+        if err != nil || n <= 0 {
+            return store.DestinationFilter{}, fmt.Errorf("invalid before %q", raw)
+        }
+        filter.Before = n
+    }
+    // Zero is a real bound: max_amount=0 matches nothing.
+    for _, p := range []struct {
+        key string
+        dst **int64
+    }{
+        {"min_amount", &filter.MinAmount},
+        {"max_amount", &filter.MaxAmount},
+    } {
+        raw := q.Get(p.key)
+        if raw == "" {
+            continue
+        }
+        n, err := strconv.ParseInt(raw, 10, 64)
+        if err != nil || n < 0 {
+            return store.DestinationFilter{}, fmt.Errorf("invalid %s %q", p.key, raw)
+        }
+        *p.dst = &n
+    }
+    if raw := q.Get("limit"); raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n <= 0 {
+            return store.DestinationFilter{}, fmt.Errorf("invalid limit %q", raw)
+        }
+        filter.Limit = n
+    }
+    return filter, nil
+}
@@ -0,0 +1,371 @@
+package api_test
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestAdminWithdrawalsByDestination(t *testing.T) {
+    env := setupTest(t, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    seedUser(t, env.pool, 2, 1000)
+    first := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"flagged","idempotency_key":"k1"}`)
+    createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"other","idempotency_key":"k2"}`)
+    second := createWithdrawal(t, env, `{"user_id":2,"amount":100,"currency":"USDT","destination":"flagged","idempotency_key":"k1"}`)
+
+    for _, tt := range []struct {
+        query string
+        want  []int64
+    }{
+        {"destination=flagged", []int64{second.ID, first.ID}},
+        {"destination=unknown", nil},
+        {"destination=flagged&to=2000-01-01T00:00:00Z", nil},
+        {"destination=flagged&min_amount=100&max_amount=100", []int64{second.ID, first.ID}},
+        {"destination=flagged&max_amount=0", nil},
+    } {
+        resp := env.doRequestWithHeaders(t, http.MethodGet, "/v1/admin/withdrawals?"+tt.query, "", map[string]string{
+            "Authorization": "Bearer admin-token",
+        })
+        if resp.StatusCode != http.StatusOK {
+            resp.Body.Close()
+            t.Fatalf("%s: expected %d, got %d", tt.query, http.StatusOK, resp.StatusCode)
+        }
+        var got struct {
+            Withdrawals []withdrawalResponse `json:"withdrawals"`
+        }
+        err := json.NewDecoder(resp.Body).Decode(&got)
+        resp.Body.Close()
+        if err != nil {
+            t.Fatalf("decode response: %v", err)
+        }
+        if got.Withdrawals == nil || len(got.Withdrawals) != len(tt.want) {
+            t.Fatalf("%s: expected %d withdrawals, got %+v", tt.query, len(tt.want), got.Withdrawals)
+        }
+        for i, w := range got.Withdrawals {
+            if w.ID != tt.want[i] {
+                t.Fatalf("%s: expected ids %v, got %+v", tt.query, tt.want, got.Withdrawals)
+            }
+        }
+    }
+}
+
+func TestAdminWithdrawalsInvalidFilter(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    for _, query := range []string{"", "destination=", "destination=a&from=yesterday", "destination=a&before=0", "destination=a&from=2026-02-01T00:00:00Z&to=2026-01-01T00:00:00Z", "destination=a&min_amount=-1", "destination=a&min_amount=5&max_amount=1"} {
+        req := httptest.NewRequest(http.MethodGet, "/v1/admin/withdrawals?"+query, nil)
+        req.Header.Set("Authorization", "Bearer admin-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%s: expected %d, got %d", query, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
+
+func TestAdminStuckWithdrawals(t *testing.T) {
+    env := setupTest(t, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    stuck := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k2"}`)
+    if _, err := env.pool.Exec(context.Background(), "UPDATE withdrawals SET created_at = now() - INTERVAL '45 minutes' WHERE id = $1", stuck.ID); err != nil {
+        t.Fatalf("backdate withdrawal: %v", err)
+    }
+
+    admin := map[string]string{"Authorization": "Bearer admin-token"}
+    for _, tt := range []struct {
+        query string
+        want  []int64
+    }{
+        {"older_than=30m", []int64{stuck.ID}},
+        {"older_than=1h", []int64{}},
+        {"older_than=0s&limit=1", []int64{stuck.ID}},
+    } {
+        resp := env.doRequestWithHeaders(t, http.MethodGet, "/v1/admin/withdrawals/stuck?"+tt.query, "", admin)
+        body, err := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err != nil {
+            t.Fatalf("%s: read body: %v", tt.query, err)
+        }
+        var page struct {
+            Withdrawals []struct {
+                ID int64 `json:"id"`
+            } `json:"withdrawals"`
+        }
+        if err := json.Unmarshal(body, &page); err != nil {
+            t.Fatalf("%s: decode response: %v", tt.query, err)
+        }
+        got := []int64{}
+        for _, w := range page.Withdrawals {
+            got = append(got, w.ID)
+        }
+        if resp.StatusCode != http.StatusOK || page.Withdrawals == nil || fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Fatalf("%s: expected 200 with %v, got %d %s", tt.query, tt.want, resp.StatusCode, body)
+        }
+    }
+}
+
+func TestAdminStuckWithdrawalsInvalidDuration(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    for _, query := range []string{"", "older_than=", "older_than=30", "older_than=soon", "older_than=-5m"} {
+        req := httptest.NewRequest(http.MethodGet, "/v1/admin/withdrawals/stuck?"+query, nil)
+        req.Header.Set("Authorization", "Bearer admin-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_older_than") {
+            t.Fatalf("%s: expected 400 invalid_older_than, got %d %s", query, rec.Code, rec.Body.String())
+        }
+    }
+
+    for _, query := range []string{"older_than=30m&limit=0", "older_than=30m&limit=many", "older_than=30m&limit=501"} {
+        req := httptest.NewRequest(http.MethodGet, "/v1/admin/withdrawals/stuck?"+query, nil)
+        req.Header.Set("Authorization", "Bearer admin-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_filter") {
+            t.Fatalf("%s: expected 400 invalid_filter, got %d %s", query, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestAdminLedger(t *testing.T) {
+    env := setupTestWithStore(t, []store.Option{store.WithSupportedCurrencies("USDT", "EUR")}, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    if _, err := env.pool.Exec(context.Background(), "INSERT INTO currencies (code) VALUES ('EUR') ON CONFLICT (code) DO NOTHING"); err != nil {
+        t.Fatalf("register EUR: %v", err)
+    }
+    seedUser(t, env.pool, 1, 1000)
+    seedUser(t, env.pool, 2, 1000)
+    createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"a","idempotency_key":"k1"}`)
+    createWithdrawal(t, env, `{"user_id":2,"amount":200,"currency":"USDT","destination":"a","idempotency_key":"k1"}`)
+    createWithdrawal(t, env, `{"user_id":1,"amount":300,"currency":"USDT","destination":"a","idempotency_key":"k2"}`)
+    createWithdrawal(t, env, `{"user_id":1,"amount":50,"currency":"EUR","destination":"a","idempotency_key":"k3"}`)
+
+    type page struct {
+        Entries []struct {
+            ID         int64 `json:"id"`
+            UserID     int64 `json:"user_id"`
+            Amount     int64 `json:"amount"`
+            RunningSum int64 `json:"running_sum"`
+        } `json:"entries"`
+        PageSums   map[string]int64 `json:"page_sums"`
+        NextCursor string           `json:"next_cursor"`
+    }
+    get := func(query string) page {
+        t.Helper()
+        resp := env.doRequestWithHeaders(t, http.MethodGet, "/v1/admin/ledger?"+query, "", map[string]string{
+            "Authorization": "Bearer admin-token",
+        })
+        defer resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            t.Fatalf("%s: expected %d, got %d", query, http.StatusOK, resp.StatusCode)
+        }
+        var p page
+        if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+            t.Fatalf("decode response: %v", err)
+        }
+        return p
+    }
+
+    first := get("direction=debit&limit=2")
+    if len(first.Entries) != 2 || first.Entries[0].Amount != 100 || first.Entries[1].Amount != 200 {
+        t.Fatalf("unexpected first page: %+v", first)
+    }
+    if first.Entries[1].RunningSum != -300 || fmt.Sprint(first.PageSums) != "map[USDT:-300]" || first.NextCursor == "" {
+        t.Fatalf("unexpected sums or cursor: %+v", first)
+    }
+    // Sums are kept per currency: the EUR entry does not add to USDT's.
+    second := get("direction=debit&limit=3&after=" + first.NextCursor)
+    if len(second.Entries) != 2 || second.Entries[0].Amount != 300 || second.Entries[1].RunningSum != -50 || second.NextCursor != "" {
+        t.Fatalf("unexpected second page: %+v", second)
+    }
+    if fmt.Sprint(second.PageSums) != "map[EUR:-50 USDT:-300]" {
+        t.Fatalf("unexpected second page sums: %v", second.PageSums)
+    }
+    if byUser := get("user_id=2"); len(byUser.Entries) != 1 || byUser.Entries[0].UserID != 2 {
+        t.Fatalf("unexpected user page: %+v", byUser)
+    }
+
+    resp := env.doRequestWithHeaders(t, http.MethodGet, "/v1/admin/ledger?user_id=1", "", map[string]string{
+        "Authorization": "Bearer admin-token",
+        "Accept":        "application/x-ndjson",
+    })
+    defer resp.Body.Close()
+    if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "application/x-ndjson" {
+        t.Fatalf("expected NDJSON stream, got %d %s", resp.StatusCode, ct)
+    }
+    dec := json.NewDecoder(resp.Body)
+    var sums []int64
+    for dec.More() {
+        var line struct {
+            RunningSum int64 `json:"running_sum"`
+        }
+        if err := dec.Decode(&line); err != nil {
+            t.Fatalf("decode line: %v", err)
+        }
+        sums = append(sums, line.RunningSum)
+    }
+    if fmt.Sprint(sums) != "[-100 -400 -50]" {
+        t.Fatalf("unexpected streamed running sums: %v", sums)
+    }
+}
+
+// deadlineRecorder records the write deadlines a handler sets through its
+// http.ResponseController.
+type deadlineRecorder struct {
+    *httptest.ResponseRecorder
+    deadlines []time.Time
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+    d.deadlines = append(d.deadlines, deadline)
+    return nil
+}
+
+func TestAdminLedgerStreamExtendsWriteDeadline(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    if _, err := env.pool.Exec(context.Background(), `
+        INSERT INTO ledger_entries (user_id, amount, currency, direction)
+        SELECT 1, 1, 'USDT', 'credit' FROM generate_series(1, 250)
+    `); err != nil {
+        t.Fatalf("seed ledger entries: %v", err)
+    }
+    srv := api.NewServer(store.New(env.pool), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/admin/ledger", nil)
+    req.Header.Set("Authorization", "Bearer admin-token")
+    req.Header.Set("Accept", "application/x-ndjson")
+    rec := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+    start := time.Now()
+    srv.Routes().ServeHTTP(rec, req)
+
+    if lines := strings.Count(rec.Body.String(), "\n"); rec.Code != http.StatusOK || lines != 250 {
+        t.Fatalf("expected 250 streamed lines, got %d with %d lines", rec.Code, lines)
+    }
+    // One deadline before the first line and one after each flush of 100.
+    if len(rec.deadlines) != 3 {
+        t.Fatalf("expected the deadline moved 3 times, got %v", rec.deadlines)
+    }
+    for _, d := range rec.deadlines {
+        if !d.After(start) {
+            t.Fatalf("expected deadlines after the request started, got %v", rec.deadlines)
+        }
+    }
+}
+
+func TestAdminLedgerInvalidFilter(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    for _, query := range []string{"direction=sideways", "user_id=0", "withdrawal_id=x", "from=yesterday", "after=nope", "limit=1000", "from=2026-02-01T00:00:00Z&to=2026-01-01T00:00:00Z"} {
+        req := httptest.NewRequest(http.MethodGet, "/v1/admin/ledger?"+query, nil)
+        req.Header.Set("Authorization", "Bearer admin-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%s: expected %d, got %d", query, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
+
+func TestAdminArchiveLedger(t *testing.T) {
+    env := setupTest(t, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    old := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    createWithdrawal(t, env, `{"user_id":1,"amount":200,"currency":"USDT","destination":"addr","idempotency_key":"k2"}`)
+    if _, err := env.pool.Exec(context.Background(), "UPDATE ledger_entries SET created_at = now() - INTERVAL '91 days'"); err != nil {
+        t.Fatalf("backdate entries: %v", err)
+    }
+    // Only entries of a withdrawal that can no longer change are archived.
+    if _, err := env.pool.Exec(context.Background(), "UPDATE withdrawals SET status = 'expired' WHERE id = $1", old.ID); err != nil {
+        t.Fatalf("expire withdrawal: %v", err)
+    }
+    withLedger := fmt.Sprintf("/v1/withdrawals/%d?include=ledger", old.ID)
+    before := env.doRequest(t, http.MethodGet, withLedger, "")
+    before.Body.Close()
+
+    admin := map[string]string{"Authorization": "Bearer admin-token"}
+    resp := env.doRequestWithHeaders(t, http.MethodPost, "/v1/admin/ledger/archive", `{"older_than_days":90}`, admin)
+    defer resp.Body.Close()
+    var body struct {
+        Archived      int64 `json:"archived"`
+        OlderThanDays int64 `json:"older_than_days"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if resp.StatusCode != http.StatusOK || body.Archived != 1 || body.OlderThanDays != 90 {
+        t.Fatalf("expected 200 with 1 archived entry, got %d %+v", resp.StatusCode, body)
+    }
+
+    var live, archived int
+    err := env.pool.QueryRow(context.Background(), "SELECT (SELECT COUNT(*) FROM ledger_entries), (SELECT COUNT(*) FROM ledger_entries_archive WHERE amount = 100)").Scan(&live, &archived)
+    if err != nil {
+        t.Fatalf("count entries: %v", err)
+    }
+    if live != 1 || archived != 1 {
+        t.Fatalf("expected 1 entry left and 1 archived, got %d and %d", live, archived)
+    }
+
+    // The archived entry is still part of the withdrawal's ledger, so a
+    // cached copy listing it stays valid.
+    after := env.doRequestWithHeaders(t, http.MethodGet, withLedger, "", map[string]string{"If-None-Match": before.Header.Get("ETag")})
+    after.Body.Close()
+    if after.StatusCode != http.StatusNotModified {
+        t.Fatalf("expected %d after archiving, got %d", http.StatusNotModified, after.StatusCode)
+    }
+}
+
+func TestAdminArchiveLedgerInvalidRequest(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    for _, tt := range []struct {
+        method string
+        body   string
+        token  string
+        want   int
+    }{
+        {http.MethodPost, `{"older_than_days":30}`, "test-token", http.StatusUnauthorized},
+        {http.MethodGet, "", "admin-token", http.StatusMethodNotAllowed},
+        {http.MethodPost, `{}`, "admin-token", http.StatusBadRequest},
+        {http.MethodPost, `{"older_than_days":0}`, "admin-token", http.StatusBadRequest},
+        {http.MethodPost, `{"older_than_days":36501}`, "admin-token", http.StatusBadRequest},
+        {http.MethodPost, `{"older_than_days":1.5}`, "admin-token", http.StatusBadRequest},
+        {http.MethodPost, `{"older_than_days":"30"}`, "admin-token", http.StatusBadRequest},
+        {http.MethodPost, `{"older_than_days":30,"extra":1}`, "admin-token", http.StatusBadRequest},
+    } {
+        req := httptest.NewRequest(tt.method, "/v1/admin/ledger/archive", strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer "+tt.token)
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != tt.want {
+            t.Fatalf("%s %s: expected %d, got %d", tt.method, tt.body, tt.want, rec.Code)
+        }
+    }
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "math"
+    "strconv"
+    "strings"
+)
+
+var (
+    errInvalidAmount    = errors.New("invalid amount")
+    errNotNumber        = errors.New("not a number")
+    errAmountNotInteger = errors.New("number is not a whole number of base units")
+    errAmountOutOfRange = errors.New("number is out of range")
+)
+
+// DecimalAmount is an amount in base units decoded from either a JSON
+// integer, taken as base units as is, or a decimal string such as "10.50",
+// taken in whole units of the currency and converted by Scale once the
+// currency is known. The two are told apart by type, so 200 is always 200
+// base units and "200" always 200 whole units; a JSON number with a fraction
+// or exponent, even 200.0, is ambiguous and fails with errAmountNotInteger.
+// Decimals are converted exactly, without going through float64. Values
+// outside int64 fail with errAmountOutOfRange, and null and strings that are
+// not decimals with errInvalidAmount.
+type DecimalAmount struct {
+    Value int64
+    // decimal is the text of a decimal string until Scale converts it.
+    decimal string
+}
+
+func (d *DecimalAmount) UnmarshalJSON(data []byte) error {
+    text := string(data)
+    if strings.HasPrefix(text, `"`) {
+        if err := json.Unmarshal(data, &text); err != nil {
+            return errInvalidAmount
+        }
+        // Scale checks the places; here only the shape.
+        if _, err := parseInteger(text, 1e18); errors.Is(err, errNotNumber) {
+            return errInvalidAmount
+        } else if errors.Is(err, errAmountNotInteger) {
+            return err
+        }
+        d.Value, d.decimal = 0, text
+        return nil
+    }
+    n, err := parseInteger(text, 1)
+    if errors.Is(err, errNotNumber) {
+        return errInvalidAmount
+    }
+    if err != nil {
+        return err
+    }
+    d.Value, d.decimal = n, ""
+    return nil
+}
+
+// Decimal reports whether the amount was a decimal string that Scale has not
+// converted yet.
+func (d DecimalAmount) Decimal() bool {
+    return d.decimal != ""
+}
+
+// Scale converts a decimal string into base units of a currency with the
+// given exponent, so "10.50" becomes 10500000 for an exponent of 6. More
+// places than the exponent allows fail with errAmountNotInteger. An integer
+// amount is left as it is.
+func (d *DecimalAmount) Scale(exponent int) error {
+    if d.decimal == "" {
+        return nil
+    }
+    if exponent < 0 || exponent > 18 {
+        return errAmountOutOfRange
+    }
+    precision := int64(1)
+    for i := 0; i < exponent; i++ {
+        precision *= 10
+    }
+    n, err := parseInteger(d.decimal, precision)
+    if err != nil {
+        return err
+    }
+    d.Value, d.decimal = n, ""
+    return nil
+}
+
+// Integer is an int64 request field that, unlike a plain int64, tells a
+// malformed number apart from a malformed body: a fraction, even 200.0, or an
+// exponent fails with errAmountNotInteger and a value outside int64 with
+// errAmountOutOfRange. Quoted numbers fail with errNotNumber; null leaves the
+// value unchanged, as it does for int64.
+type Integer int64
+
+func (i *Integer) UnmarshalJSON(data []byte) error {
+    if string(data) == "null" {
+        return nil
+    }
+    n, err := parseInteger(string(data), 1)
+    if err != nil {
+        return err
+    }
+    *i = Integer(n)
+    return nil
+}
+
+// parseInteger converts the number text to base units by multiplying it by
+// precision, a power of ten; a precision of 1 accepts no decimal places.
+func parseInteger(text string, precision int64) (int64, error) {
+    negative := strings.HasPrefix(text, "-")
+    digits := strings.TrimPrefix(text, "-")
+    // A JSON value starting with a digit is a number; the text of a string
+    // is checked digit by digit below.
+    if digits == "" || digits[0] < '0' || digits[0] > '9' {
+        return 0, errNotNumber
+    }
+    if strings.ContainsAny(digits, "eE") {
+        return 0, errAmountNotInteger
+    }
+    whole, frac, decimal := strings.Cut(digits, ".")
+    if !allDigits(whole) || (decimal && !allDigits(frac)) {
+        return 0, errNotNumber
+    }
+    n, err := strconv.ParseInt(whole, 10, 64)
+    if err != nil {
+        return 0, errAmountOutOfRange
+    }
+
+    if precision <= 0 {
+        precision = 1
+    }
+    var f int64
+    if decimal {
+        if len(frac) > len(strconv.FormatInt(precision, 10))-1 {
+            return 0, errAmountNotInteger
+        }
+        if f, err = strconv.ParseInt(frac, 10, 64); err != nil {
+            return 0, errAmountNotInteger
+        }
+        scale := precision
+        for range frac {
+            scale /= 10
+        }
+        f *= scale
+    }
+    if n > (math.MaxInt64-f)/precision {
+        return 0, errAmountOutOfRange
+    }
+    n = n*precision + f
+
+    if negative {
+        n = -n
+    }
+    return n, nil
+}
+
+// decodeErrorCode is the error code for a request body that failed to
+// decode with err.
+func decodeErrorCode(err error) string {
+    switch {
+    case errors.Is(err, errInvalidAmount):
+        return "invalid_amount"
+    case errors.Is(err, errAmountNotInteger):
+        return "amount_not_integer"
+    case errors.Is(err, errAmountOutOfRange):
+        return "amount_out_of_range"
+    default:
+        return "invalid_request"
+    }
+}
+
+func allDigits(s string) bool {
+    if s == "" {
+        return false
+    }
+    for _, c := range s {
+        if c < '0' || c > '9' {
+            return false
+        }
+    }
+    return true
+}
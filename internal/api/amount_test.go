@@ -0,0 +1,90 @@
+package api_test
+
+import (
+    "encoding/json"
+    "testing"
+
+    "task.hh/internal/api"
+)
+
+func TestDecimalAmount(t *testing.T) {
+    tests := []struct {
+        json     string
+        exponent int
+        want     int64
+        wantErr  bool
+    }{
+        {json: "1000", exponent: 6, want: 1000},
+        {json: "-5", exponent: 6, want: -5},
+        {json: `"10.50"`, exponent: 6, want: 10500000},
+        {json: `"10.5"`, exponent: 2, want: 1050},
+        {json: `"0.01"`, exponent: 2, want: 1},
+        {json: `"200"`, exponent: 6, want: 200000000},
+        {json: `"200.0"`, exponent: 6, want: 200000000},
+        {json: `"10"`, exponent: 0, want: 10},
+        {json: `"10.505"`, exponent: 2, wantErr: true},
+        {json: `"10.5"`, exponent: 0, wantErr: true},
+        {json: `"92233720368547758.08"`, exponent: 2, wantErr: true},
+        {json: `"1e3"`, exponent: 6, wantErr: true},
+        {json: `"ten"`, exponent: 6, wantErr: true},
+        {json: `""`, exponent: 6, wantErr: true},
+        {json: "10.50", exponent: 6, wantErr: true},
+        {json: "200.0", exponent: 6, wantErr: true},
+        {json: "1e3", exponent: 6, wantErr: true},
+        {json: "9223372036854775808", exponent: 6, wantErr: true},
+        {json: "null", exponent: 6, wantErr: true},
+    }
+    for _, tt := range tests {
+        var amount api.DecimalAmount
+        err := json.Unmarshal([]byte(tt.json), &amount)
+        if err == nil {
+            err = amount.Scale(tt.exponent)
+        }
+        if tt.wantErr {
+            if err == nil {
+                t.Fatalf("%s with exponent %d: expected an error, got %d", tt.json, tt.exponent, amount.Value)
+            }
+            continue
+        }
+        if err != nil {
+            t.Fatalf("%s with exponent %d: %v", tt.json, tt.exponent, err)
+        }
+        if amount.Value != tt.want || amount.Decimal() {
+            t.Fatalf("%s with exponent %d: expected %d, got %d", tt.json, tt.exponent, tt.want, amount.Value)
+        }
+    }
+}
+
+func TestInteger(t *testing.T) {
+    tests := []struct {
+        json    string
+        want    int64
+        wantErr bool
+    }{
+        {json: "200", want: 200},
+        {json: "-5", want: -5},
+        {json: "9223372036854775807", want: 9223372036854775807},
+        {json: "null", want: 7},
+        {json: "200.0", wantErr: true},
+        {json: "200.5", wantErr: true},
+        {json: "1e3", wantErr: true},
+        {json: "9223372036854775808", wantErr: true},
+        {json: `"200"`, wantErr: true},
+    }
+    for _, tt := range tests {
+        n := api.Integer(7)
+        err := json.Unmarshal([]byte(tt.json), &n)
+        if tt.wantErr {
+            if err == nil {
+                t.Fatalf("%s: expected an error, got %d", tt.json, n)
+            }
+            continue
+        }
+        if err != nil {
+            t.Fatalf("%s: %v", tt.json, err)
+        }
+        if int64(n) != tt.want {
+            t.Fatalf("%s: expected %d, got %d", tt.json, tt.want, n)
+        }
+    }
+}
@@ -0,0 +1,190 @@
+package api
+
+import (
+    "net/http"
+    "reflect"
+    "strconv"
+    "strings"
+
+    "task.hh/internal/store"
+)
+
+const (
+    amountFormatMinor   = "minor"
+    amountFormatDecimal = "decimal"
+)
+
+// minorAmount is a monetary amount in a response. It renders as an integer
+// number of minor units, or, for a request with ?amount_format=decimal, as a
+// decimal string with as many places as its currency's exponent, e.g.
+// "12.500000" for 12500000 USDT minor units.
+type minorAmount struct {
+    value    int64
+    currency string
+
+    // Set by writeJSON for decimal responses.
+    decimal  bool
+    exponent int
+}
+
+// amountIn returns value minor units of currency for a response.
+func amountIn(value int64, currency string) minorAmount {
+    return minorAmount{value: value, currency: currency}
+}
+
+// balanceAmount returns value minor units of the currency balances are kept
+// in, for balances and totals that span withdrawals.
+func balanceAmount(value int64) minorAmount {
+    return amountIn(value, store.BalanceCurrency)
+}
+
+func (a minorAmount) MarshalJSON() ([]byte, error) {
+    if !a.decimal {
+        return strconv.AppendInt(nil, a.value, 10), nil
+    }
+    return strconv.AppendQuote(nil, formatDecimal(a.value, a.exponent)), nil
+}
+
+// formatDecimal renders value minor units with exponent decimal places.
+func formatDecimal(value int64, exponent int) string {
+    if exponent <= 0 {
+        return strconv.FormatInt(value, 10)
+    }
+    sign := ""
+    abs := uint64(value)
+    if value < 0 {
+        sign = "-"
+        abs = -abs
+    }
+    digits := strconv.FormatUint(abs, 10)
+    if len(digits) <= exponent {
+        digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+    }
+    point := len(digits) - exponent
+    return sign + digits[:point] + "." + digits[point:]
+}
+
+// amountFormatWriter marks a response whose amounts writeJSON renders as
+// decimal strings.
+type amountFormatWriter struct {
+    http.ResponseWriter
+    exponent func(currency string) (int, bool)
+}
+
+func (w *amountFormatWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// amountFormatMiddleware reads ?amount_format: "minor", the default, keeps
+// integer minor units for machine clients, and "decimal" renders amounts for
+// display clients.
+func (s *Server) amountFormatMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch r.URL.Query().Get("amount_format") {
+        case "", amountFormatMinor:
+            next.ServeHTTP(w, r)
+        case amountFormatDecimal:
+            next.ServeHTTP(&amountFormatWriter{ResponseWriter: w, exponent: s.currencyExponent}, r)
+        default:
+            writeError(w, http.StatusBadRequest, "invalid_amount_format")
+        }
+    })
+}
+
+func (s *Server) currencyExponent(code string) (int, bool) {
+    c, ok := s.currency(code)
+    return c.Exponent, ok
+}
+
+// decimalFormat returns the amountFormatWriter in w's wrapper chain, if any.
+func decimalFormat(w http.ResponseWriter) (*amountFormatWriter, bool) {
+    for {
+        switch ww := w.(type) {
+        case *amountFormatWriter:
+            return ww, true
+        case interface{ Unwrap() http.ResponseWriter }:
+            w = ww.Unwrap()
+        default:
+            return nil, false
+        }
+    }
+}
+
+var minorAmountType = reflect.TypeOf(minorAmount{})
+
+// withDecimalAmounts returns a deep copy of v with every minorAmount in it set
+// to render as a decimal string; v itself is left as is. Amounts in a
+// currency without a known exponent stay integers.
+func withDecimalAmounts(v any, exponent func(string) (int, bool)) any {
+    if v == nil {
+        return v
+    }
+    copied := reflect.New(reflect.TypeOf(v)).Elem()
+    copied.Set(reflect.ValueOf(v))
+    markDecimal(copied, exponent)
+    return copied.Interface()
+}
+
+// markDecimal marks the amounts in the settable value v, replacing whatever
+// v shares with the original through pointers, slices, maps and interfaces
+// with marked copies.
+func markDecimal(v reflect.Value, exponent func(string) (int, bool)) {
+    switch v.Kind() {
+    case reflect.Pointer:
+        if v.IsNil() {
+            return
+        }
+        copied := reflect.New(v.Type().Elem())
+        copied.Elem().Set(v.Elem())
+        markDecimal(copied.Elem(), exponent)
+        v.Set(copied)
+    case reflect.Interface:
+        if v.IsNil() {
+            return
+        }
+        copied := reflect.New(v.Elem().Type()).Elem()
+        copied.Set(v.Elem())
+        markDecimal(copied, exponent)
+        v.Set(copied)
+    case reflect.Struct:
+        if v.Type() == minorAmountType {
+            a := v.Addr().Interface().(*minorAmount)
+            if exp, ok := exponent(a.currency); ok {
+                a.decimal, a.exponent = true, exp
+            }
+            return
+        }
+        for i := 0; i < v.NumField(); i++ {
+            if v.Type().Field(i).IsExported() {
+                markDecimal(v.Field(i), exponent)
+            }
+        }
+    case reflect.Slice:
+        if v.IsNil() {
+            return
+        }
+        copied := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+        reflect.Copy(copied, v)
+        for i := 0; i < copied.Len(); i++ {
+            markDecimal(copied.Index(i), exponent)
+        }
+        v.Set(copied)
+    case reflect.Array:
+        for i := 0; i < v.Len(); i++ {
+            markDecimal(v.Index(i), exponent)
+        }
+    case reflect.Map:
+        if v.IsNil() {
+            return
+        }
+        copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+        iter := v.MapRange()
+        for iter.Next() {
+            value := reflect.New(iter.Value().Type()).Elem()
+            value.Set(iter.Value())
+            markDecimal(value, exponent)
+            copied.SetMapIndex(iter.Key(), value)
+        }
+        v.Set(copied)
+    }
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+    "encoding/json"
+    "io"
+    "log"
+    "math"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "task.hh/internal/store"
+)
+
+func TestFormatDecimal(t *testing.T) {
+    tests := []struct {
+        value    int64
+        exponent int
+        want     string
+    }{
+        {12500000, 6, "12.500000"},
+        {1, 6, "0.000001"},
+        {0, 6, "0.000000"},
+        {-1050, 2, "-10.50"},
+        {1050, 0, "1050"},
+        {math.MinInt64, 2, "-92233720368547758.08"},
+    }
+    for _, tt := range tests {
+        if got := formatDecimal(tt.value, tt.exponent); got != tt.want {
+            t.Fatalf("formatDecimal(%d, %d) = %q, want %q", tt.value, tt.exponent, got, tt.want)
+        }
+    }
+}
+
+func TestWithDecimalAmounts(t *testing.T) {
+    exponent := func(code string) (int, bool) {
+        if code == "USDT" {
+            return 6, true
+        }
+        return 0, false
+    }
+    balance := balanceAmount(900)
+    existing := amountIn(100, "USDT")
+    resp := withdrawalResponse{
+        Amount:           amountIn(12500000, "USDT"),
+        Fee:              amountIn(100, "USDT"),
+        Currency:         "USDT",
+        ResultingBalance: &balance,
+        LedgerEntries:    &[]ledgerEntryResponse{{Amount: amountIn(1, "USDT")}},
+    }
+
+    tests := []struct {
+        name string
+        v    any
+        want []string
+    }{
+        {"minor", resp, []string{`"amount":12500000`, `"fee":100`, `"resulting_balance":900`, `"ledger_entries":[{"id":0,"amount":1,`}},
+        {"decimal", withDecimalAmounts(resp, exponent), []string{`"amount":"12.500000"`, `"fee":"0.000100"`, `"resulting_balance":"0.000900"`, `"ledger_entries":[{"id":0,"amount":"0.000001",`}},
+        {"map", withDecimalAmounts(map[string]statusStatsResponse{"pending": {Amount: balanceAmount(5)}}, exponent), []string{`"amount":"0.000005"`}},
+        {"interface", withDecimalAmounts(errorResponse{Details: insufficientBalanceDetails{Shortfall: balanceAmount(2)}}, exponent), []string{`"shortfall":"0.000002"`}},
+        {"idempotency conflict", withDecimalAmounts(errorResponse{Code: "idempotency_conflict", ExistingAmount: &existing}, exponent), []string{`"existing_amount":"0.000100"`}},
+        {"unknown currency", withDecimalAmounts(statusStatsResponse{Amount: amountIn(5, "EUR")}, exponent), []string{`"amount":5`}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            data, err := json.Marshal(tt.v)
+            if err != nil {
+                t.Fatalf("marshal: %v", err)
+            }
+            for _, want := range tt.want {
+                if !strings.Contains(string(data), want) {
+                    t.Fatalf("expected %s in %s", want, data)
+                }
+            }
+        })
+    }
+}
+
+func TestAmountFormatInvalid(t *testing.T) {
+    s := NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/withdrawals?amount_format=float", nil)
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec := httptest.NewRecorder()
+    s.Routes().ServeHTTP(rec, req)
+
+    var body errorResponse
+    if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if rec.Code != http.StatusBadRequest || body.Code != "invalid_amount_format" {
+        t.Fatalf("expected 400 invalid_amount_format, got %d %q", rec.Code, body.Code)
+    }
+}
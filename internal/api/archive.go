@@ -0,0 +1,73 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+
+    "task.hh/internal/store"
+)
+
+const ledgerArchivePath = "/v1/admin/ledger/archive"
+
+// maxArchiveDays keeps older_than_days a plausible retention period.
+const maxArchiveDays = 36500
+
+type archiveLedgerRequest struct {
+    OlderThanDays *Integer `json:"older_than_days"`
+}
+
+type archiveLedgerResponse struct {
+    Archived      int64 `json:"archived"`
+    OlderThanDays int64 `json:"older_than_days"`
+}
+
+// handleAdminArchiveLedger moves settled ledger entries older than the given
+// number of days out of ledger_entries, for a scheduled job to call.
+func (s *Server) handleAdminArchiveLedger(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    var req archiveLedgerRequest
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&req); err != nil {
+        writeError(w, http.StatusBadRequest, decodeErrorCode(err))
+        return
+    }
+    if err := dec.Decode(&struct{}{}); err != io.EOF {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if req.OlderThanDays == nil || *req.OlderThanDays < 1 || *req.OlderThanDays > maxArchiveDays {
+        writeError(w, http.StatusBadRequest, "invalid_older_than_days")
+        return
+    }
+    days := int64(*req.OlderThanDays)
+
+    ctx := withAudit(r, "ledger.archive", "ledger", func(archived int64) (string, map[string]any) {
+        return "", map[string]any{
+            "older_than_days": days,
+            "archived":        archived,
+        }
+    })
+    archived, err := s.store.ArchiveLedgerEntries(ctx, int(days))
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidRetention) {
+            writeError(w, http.StatusBadRequest, "invalid_older_than_days")
+            return
+        }
+        s.logger.Printf("archive ledger entries error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    s.logEvent("ledger_archived", map[string]any{
+        "older_than_days": days,
+        "archived":        archived,
+    })
+    writeJSON(w, http.StatusOK, archiveLedgerResponse{Archived: archived, OlderThanDays: days})
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+    "context"
+    "errors"
+    "net/http"
+    "net/url"
+    "strconv"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+type auditEntryResponse struct {
+    ID           int64          `json:"id"`
+    Actor        string         `json:"actor"`
+    Action       string         `json:"action"`
+    ResourceType string         `json:"resource_type"`
+    ResourceID   string         `json:"resource_id"`
+    RequestID    string         `json:"request_id"`
+    Summary      map[string]any `json:"summary"`
+    CreatedAt    time.Time      `json:"created_at"`
+    Hash         string         `json:"hash"`
+}
+
+type auditListResponse struct {
+    Entries []auditEntryResponse `json:"entries"`
+}
+
+// withAudit returns the context of r for the store operation that serves it.
+// The operation records the audit entry describe gives for its result in its
+// own transaction, so the entry is committed exactly when the change is; T is
+// the result, as listed at store.WithAudit.
+func withAudit[T any](r *http.Request, action, resourceType string, describe func(T) (resourceID string, summary map[string]any)) context.Context {
+    actor := actorFromContext(r.Context())
+    requestID := requestIDFromContext(r.Context())
+    return store.WithAudit(r.Context(), func(result T) store.AuditEntry {
+        resourceID, summary := describe(result)
+        return store.AuditEntry{
+            Actor:        actor,
+            Action:       action,
+            ResourceType: resourceType,
+            ResourceID:   resourceID,
+            RequestID:    requestID,
+            Summary:      summary,
+        }
+    })
+}
+
+// redact keeps only the last four characters of values that are long enough
+// to stay unidentifiable, e.g. destination addresses and idempotency keys.
+func redact(value string) string {
+    if len(value) <= 8 {
+        return "****"
+    }
+    return "****" + value[len(value)-4:]
+}
+
+func (s *Server) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    filter, err := parseAuditFilter(r.URL.Query())
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        return
+    }
+
+    entries, err := s.store.ListAuditEntries(r.Context(), filter)
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidFilter) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+            return
+        }
+        s.logger.Printf("list audit entries error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := auditListResponse{Entries: make([]auditEntryResponse, 0, len(entries))}
+    for _, e := range entries {
+        resp.Entries = append(resp.Entries, auditEntryResponse{
+            ID:           e.ID,
+            Actor:        e.Actor,
+            Action:       e.Action,
+            ResourceType: e.ResourceType,
+            ResourceID:   e.ResourceID,
+            RequestID:    e.RequestID,
+            Summary:      e.Summary,
+            CreatedAt:    e.CreatedAt,
+            Hash:         e.Hash,
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func parseAuditFilter(q url.Values) (store.AuditFilter, error) {
+    filter := store.AuditFilter{
+        Actor:        q.Get("actor"),
+        ResourceType: q.Get("resource_type"),
+        ResourceID:   q.Get("resource_id"),
+    }
+    for _, p := range []struct {
+        key string
+        dst **time.Time
+    }{
+        {"from", &filter.From},
+        {"to", &filter.To},
+    } {
+        raw := q.Get(p.key)
+        if raw == "" {
+            continue
+        }
+        t, err := time.Parse(time.RFC3339Nano, raw)
+        if err != nil {
+            return store.AuditFilter{}, err
+        }
+        *p.dst = &t
+    }
+    if raw := q.Get("limit"); raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n <= 0 {
+            return store.AuditFilter{}, errors.New("invalid limit")
+        }
+        filter.Limit = n
+    }
+    return filter, nil
+}
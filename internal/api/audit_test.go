@@ -0,0 +1,110 @@
+package api_test
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestAuditLogRecordsMutations(t *testing.T) {
+    env := setupTest(t, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/users", `{"id":1,"balance":1000}`)
+    resp.Body.Close()
+    w := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"TXyz1234567890abcd","idempotency_key":"order-42-attempt"}`)
+    touch := env.doRequest(t, http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/touch", w.ID), "")
+    touch.Body.Close()
+    confirm := env.doRequestWithHeaders(t, http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/confirm", w.ID), "", map[string]string{
+        "X-Request-ID": "req-confirm",
+        "X-Operator":   " ali\tce ",
+    })
+    confirm.Body.Close()
+
+    audit := env.doRequestWithHeaders(t, http.MethodGet, "/v1/admin/audit?resource_type=withdrawal", "", map[string]string{
+        "Authorization": "Bearer admin-token",
+    })
+    defer audit.Body.Close()
+    if audit.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, audit.StatusCode)
+    }
+
+    var got struct {
+        Entries []struct {
+            Actor     string         `json:"actor"`
+            Action    string         `json:"action"`
+            RequestID string         `json:"request_id"`
+            Summary   map[string]any `json:"summary"`
+        } `json:"entries"`
+    }
+    if err := json.NewDecoder(audit.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if len(got.Entries) != 3 {
+        t.Fatalf("expected 3 withdrawal entries, got %d", len(got.Entries))
+    }
+    confirmed, touched, created := got.Entries[0], got.Entries[1], got.Entries[2]
+    if touched.Action != "withdrawal.touch" {
+        t.Fatalf("unexpected touch entry: %+v", touched)
+    }
+    if confirmed.Action != "withdrawal.confirm" || confirmed.RequestID != "req-confirm" || confirmed.Actor != "default" || confirmed.Summary["operator"] != "alice" {
+        t.Fatalf("unexpected confirm entry: %+v", confirmed)
+    }
+    if created.Action != "withdrawal.create" || created.Summary["destination"] != "****abcd" || created.Summary["idempotency_key"] != "****empt" {
+        t.Fatalf("unexpected create entry: %+v", created)
+    }
+}
+
+func TestAdminAuditAuth(t *testing.T) {
+    disabled := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+    enabled := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    tests := []struct {
+        name   string
+        srv    *api.Server
+        token  string
+        status int
+    }{
+        {"disabled", disabled, "test-token", http.StatusNotFound},
+        {"api token", enabled, "test-token", http.StatusUnauthorized},
+        {"no token", enabled, "", http.StatusUnauthorized},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodGet, "/v1/admin/audit", nil)
+        if tt.token != "" {
+            req.Header.Set("Authorization", "Bearer "+tt.token)
+        }
+        rec := httptest.NewRecorder()
+        tt.srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != tt.status {
+            t.Fatalf("%s: expected %d, got %d", tt.name, tt.status, rec.Code)
+        }
+    }
+}
+
+func TestRequestIDHeader(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/currencies", nil)
+    req.Header.Set("X-Request-ID", "abc-123")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+    if got := rec.Header().Get("X-Request-ID"); got != "abc-123" {
+        t.Fatalf("expected request id to be echoed, got %q", got)
+    }
+
+    rec = httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/currencies", nil))
+    if got := rec.Header().Get("X-Request-ID"); len(got) != 32 || strings.Trim(got, "0123456789abcdef") != "" {
+        t.Fatalf("expected a generated request id, got %q", got)
+    }
+}
@@ -0,0 +1,109 @@
+package api
+
+import (
+    "errors"
+    "time"
+)
+
+// tokenRotationGrace is how long the previous tokens stay valid after a
+// reload, so clients can switch over without failed requests.
+const tokenRotationGrace = time.Minute
+
+const defaultKeyName = "default"
+
+type authTokens struct {
+    keys          map[string]string
+    previous      map[string]string
+    previousUntil time.Time
+}
+
+// match returns the name of the key that token belongs to.
+func (t *authTokens) match(token string, now time.Time) (string, bool) {
+    if name, ok := matchKey(t.keys, token); ok {
+        return name, true
+    }
+    if now.Before(t.previousUntil) {
+        return matchKey(t.previous, token)
+    }
+    return "", false
+}
+
+// matchKey compares against every key so the timing does not depend on
+// which one matched.
+func matchKey(keys map[string]string, token string) (string, bool) {
+    matched := ""
+    for name, key := range keys {
+        if secureCompare(token, key) {
+            matched = name
+        }
+    }
+    return matched, matched != ""
+}
+
+// ReloadAuthToken replaces the default key, keeping any other named keys.
+func (s *Server) ReloadAuthToken(token string) error {
+    if token == "" {
+        return errors.New("auth token is empty")
+    }
+    keys := map[string]string{}
+    if current := s.tokens.Load(); current != nil {
+        for name, key := range current.keys {
+            keys[name] = key
+        }
+    }
+    keys[defaultKeyName] = token
+    return s.ReloadAuthKeys(keys)
+}
+
+// ReloadAuthKeys swaps the accepted keys (name to token). The previous keys
+// keep working for tokenRotationGrace.
+func (s *Server) ReloadAuthKeys(keys map[string]string) error {
+    if len(keys) == 0 {
+        return errors.New("no auth keys")
+    }
+    next := &authTokens{keys: make(map[string]string, len(keys))}
+    for name, token := range keys {
+        if name == "" || token == "" {
+            return errors.New("auth key name and token must not be empty")
+        }
+        next.keys[name] = token
+    }
+
+    s.tokenMu.Lock()
+    defer s.tokenMu.Unlock()
+
+    if old := s.tokens.Load(); old != nil {
+        if sameKeys(old.keys, next.keys) {
+            return nil
+        }
+        next.previous = old.keys
+        next.previousUntil = time.Now().Add(tokenRotationGrace)
+    }
+    s.tokens.Store(next)
+
+    s.logEvent("token_reloaded", map[string]any{
+        "keys":          len(next.keys),
+        "grace_seconds": tokenRotationGrace.Seconds(),
+    })
+    return nil
+}
+
+func sameKeys(a, b map[string]string) bool {
+    if len(a) != len(b) {
+        return false
+    }
+    for name, token := range a {
+        if b[name] != token {
+            return false
+        }
+    }
+    return true
+}
+
+func (s *Server) authenticate(token string) (string, bool) {
+    tokens := s.tokens.Load()
+    if tokens == nil || token == "" {
+        return "", false
+    }
+    return tokens.match(token, time.Now())
+}
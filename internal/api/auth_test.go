@@ -0,0 +1,106 @@
+package api_test
+
+import (
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+// authStatus sends an invalid create-user body so an authenticated request
+// stops at validation (400) without touching the store.
+func authStatus(h http.Handler, token string) int {
+    req := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(`{}`))
+    req.Header.Set("Authorization", "Bearer "+token)
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+    return rec.Code
+}
+
+func TestReloadAuthToken(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "old-token", log.New(io.Discard, "", 0))
+    h := srv.Routes()
+
+    if err := srv.ReloadAuthToken(""); err == nil {
+        t.Fatalf("expected empty token to be rejected")
+    }
+    if got := authStatus(h, "old-token"); got != http.StatusBadRequest {
+        t.Fatalf("expected old token to work before reload, got %d", got)
+    }
+
+    if err := srv.ReloadAuthToken("new-token"); err != nil {
+        t.Fatalf("reload: %v", err)
+    }
+
+    if got := authStatus(h, "new-token"); got != http.StatusBadRequest {
+        t.Fatalf("expected new token to be accepted, got %d", got)
+    }
+    if got := authStatus(h, "old-token"); got != http.StatusBadRequest {
+        t.Fatalf("expected old token to be accepted during rotation, got %d", got)
+    }
+    if got := authStatus(h, "other-token"); got != http.StatusUnauthorized {
+        t.Fatalf("expected unknown token to be rejected, got %d", got)
+    }
+}
+
+func TestReloadAuthTokenConcurrent(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "token-0", log.New(io.Discard, "", 0))
+    h := srv.Routes()
+
+    var wg sync.WaitGroup
+    for i := 0; i < 4; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for j := 0; j < 50; j++ {
+                authStatus(h, "token-0")
+            }
+        }()
+    }
+    for i := 1; i <= 50; i++ {
+        if err := srv.ReloadAuthToken("token-" + strings.Repeat("x", i)); err != nil {
+            t.Fatalf("reload: %v", err)
+        }
+    }
+    wg.Wait()
+}
+
+func TestAuthKeyNameHeader(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "", log.New(io.Discard, "", 0), api.WithAuthKeys(map[string]string{
+        "billing": "billing-token",
+        "ops":     "ops-token",
+    }))
+    h := srv.Routes()
+
+    for name, token := range map[string]string{"billing": "billing-token", "ops": "ops-token"} {
+        req := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(`{}`))
+        req.Header.Set("Authorization", "Bearer "+token)
+        rec := httptest.NewRecorder()
+        h.ServeHTTP(rec, req)
+
+        if rec.Code == http.StatusUnauthorized {
+            t.Fatalf("expected %s token to authenticate", name)
+        }
+        if got := rec.Header().Get("X-Auth-Key-Name"); got != name {
+            t.Fatalf("expected X-Auth-Key-Name %q, got %q", name, got)
+        }
+    }
+
+    req := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(`{}`))
+    req.Header.Set("Authorization", "Bearer wrong")
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, req)
+
+    if rec.Code != http.StatusUnauthorized {
+        t.Fatalf("expected %d, got %d", http.StatusUnauthorized, rec.Code)
+    }
+    if got := rec.Header().Get("X-Auth-Key-Name"); got != "" {
+        t.Fatalf("expected no X-Auth-Key-Name on 401, got %q", got)
+    }
+}
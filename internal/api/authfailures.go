@@ -0,0 +1,116 @@
+package api
+
+import (
+    "net"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+const (
+    authFailureLogSize     = 1000
+    authFailureTokenPrefix = 4
+)
+
+type authFailure struct {
+    IP          string    `json:"ip"`
+    Path        string    `json:"path"`
+    Method      string    `json:"method"`
+    TS          time.Time `json:"ts"`
+    TokenPrefix string    `json:"token_prefix"`
+}
+
+type authFailureListResponse struct {
+    Failures []authFailure `json:"failures"`
+}
+
+// authFailureLog keeps the most recent authentication failures in a ring
+// buffer. It is in-memory, so each replica only sees its own failures.
+type authFailureLog struct {
+    mu      sync.Mutex
+    entries []authFailure
+    next    int
+    full    bool
+}
+
+func newAuthFailureLog(size int) *authFailureLog {
+    return &authFailureLog{entries: make([]authFailure, size)}
+}
+
+// add records f, overwriting the oldest failure once the buffer is full.
+func (l *authFailureLog) add(f authFailure) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    l.entries[l.next] = f
+    l.next = (l.next + 1) % len(l.entries)
+    if l.next == 0 {
+        l.full = true
+    }
+}
+
+// recent returns the recorded failures, newest first.
+func (l *authFailureLog) recent() []authFailure {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+
+    n := l.next
+    if l.full {
+        n = len(l.entries)
+    }
+    out := make([]authFailure, 0, n)
+    for i := 1; i <= n; i++ {
+        out = append(out, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+    }
+    return out
+}
+
+// recordAuthFailure logs an auth_failed event and keeps it for
+// GET /v1/admin/auth-failures. Only a prefix of the token is recorded, so
+// that brute-force attempts can be told apart without leaking credentials.
+func (s *Server) recordAuthFailure(r *http.Request, token string) {
+    f := authFailure{
+        IP:          remoteIP(r),
+        Path:        r.URL.Path,
+        Method:      r.Method,
+        TS:          time.Now().UTC(),
+        TokenPrefix: maskTokenPrefix(token),
+    }
+    s.authFailures.add(f)
+    s.logEvent("auth_failed", map[string]any{
+        "ip":           f.IP,
+        "path":         f.Path,
+        "method":       f.Method,
+        "token_prefix": f.TokenPrefix,
+    })
+}
+
+// remoteIP is r.RemoteAddr without the port.
+func remoteIP(r *http.Request) string {
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
+
+// maskTokenPrefix returns the first characters of token. A token no longer
+// than the prefix would be logged whole, so it is masked instead.
+func maskTokenPrefix(token string) string {
+    if token == "" {
+        return ""
+    }
+    if len(token) <= authFailureTokenPrefix {
+        return strings.Repeat("*", authFailureTokenPrefix)
+    }
+    return token[:authFailureTokenPrefix]
+}
+
+func (s *Server) handleAdminAuthFailures(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+    writeJSON(w, http.StatusOK, authFailureListResponse{Failures: s.authFailures.recent()})
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "task.hh/internal/store"
+)
+
+func TestAuthFailureLogOverflow(t *testing.T) {
+    l := newAuthFailureLog(3)
+    if got := l.recent(); len(got) != 0 {
+        t.Fatalf("expected an empty log, got %+v", got)
+    }
+    for i := 1; i <= 5; i++ {
+        l.add(authFailure{Path: fmt.Sprintf("/%d", i)})
+    }
+
+    got := l.recent()
+    var paths []string
+    for _, f := range got {
+        paths = append(paths, f.Path)
+    }
+    if strings.Join(paths, ",") != "/5,/4,/3" {
+        t.Fatalf("expected the 3 newest failures, newest first, got %v", paths)
+    }
+}
+
+func TestMaskTokenPrefix(t *testing.T) {
+    tests := []struct {
+        token string
+        want  string
+    }{
+        {"", ""},
+        {"ab", "****"},
+        {"abcd", "****"},
+        {"abcde", "abcd"},
+        {"secret-token", "secr"},
+    }
+    for _, tt := range tests {
+        if got := maskTokenPrefix(tt.token); got != tt.want {
+            t.Fatalf("maskTokenPrefix(%q) = %q, want %q", tt.token, got, tt.want)
+        }
+    }
+}
+
+func TestAuthFailuresRecorded(t *testing.T) {
+    var buf bytes.Buffer
+    s := NewServer(store.New(nil), "test-token", log.New(&buf, "", 0), WithAdminToken("admin-token"))
+    handler := s.Routes()
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/withdrawals", nil)
+    req.RemoteAddr = "203.0.113.7:51234"
+    req.Header.Set("Authorization", "Bearer wrong-token")
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusUnauthorized {
+        t.Fatalf("expected %d, got %d", http.StatusUnauthorized, rec.Code)
+    }
+    if line := buf.String(); !strings.Contains(line, `"event":"auth_failed"`) || !strings.Contains(line, `"ip":"203.0.113.7"`) || strings.Contains(line, "wrong-token") {
+        t.Fatalf("unexpected log line: %s", line)
+    }
+
+    // The API token does not open admin endpoints.
+    req = httptest.NewRequest(http.MethodGet, "/v1/admin/auth-failures", nil)
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec = httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusUnauthorized {
+        t.Fatalf("expected %d with the API token, got %d", http.StatusUnauthorized, rec.Code)
+    }
+
+    req = httptest.NewRequest(http.MethodGet, "/v1/admin/auth-failures", nil)
+    req.Header.Set("Authorization", "Bearer admin-token")
+    rec = httptest.NewRecorder()
+    handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+    }
+    var resp authFailureListResponse
+    if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if len(resp.Failures) != 1 {
+        t.Fatalf("expected 1 failure, got %+v", resp.Failures)
+    }
+    f := resp.Failures[0]
+    if f.IP != "203.0.113.7" || f.Path != "/v1/withdrawals" || f.Method != http.MethodGet || f.TokenPrefix != "wron" || f.TS.IsZero() {
+        t.Fatalf("unexpected failure: %+v", f)
+    }
+}
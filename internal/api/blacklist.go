@@ -0,0 +1,98 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strings"
+
+    "task.hh/internal/store"
+)
+
+const blacklistPath = "/v1/admin/blacklist"
+
+type blacklistRequest struct {
+    Address string `json:"address"`
+}
+
+type blacklistResponse struct {
+    Address string `json:"address"`
+}
+
+// describeDestination is the audit entry of a blacklist change, which names
+// the address.
+func describeDestination(address string) (string, map[string]any) {
+    return address, nil
+}
+
+// handleAdminBlacklist blocks withdrawals to an address. Blocking an address
+// twice is not an error: the first time answers 201, later ones 200.
+func (s *Server) handleAdminBlacklist(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    var req blacklistRequest
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&req); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if err := dec.Decode(&struct{}{}); err != io.EOF {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    address := strings.TrimSpace(req.Address)
+    if address == "" {
+        writeError(w, http.StatusBadRequest, "invalid_address")
+        return
+    }
+
+    added, err := s.store.BlacklistDestination(withAudit(r, "destination.blacklist", "destination", describeDestination), address)
+    if err != nil {
+        s.logger.Printf("blacklist destination error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    status := http.StatusOK
+    if added {
+        status = http.StatusCreated
+        s.logEvent("destination_blacklisted", map[string]any{
+            "destination": address,
+        })
+    }
+    writeJSON(w, status, blacklistResponse{Address: address})
+}
+
+// handleAdminBlacklistAddress unblocks the address named by the rest of the
+// path, which must be escaped if it contains a slash.
+func (s *Server) handleAdminBlacklistAddress(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodDelete {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+    address := strings.TrimPrefix(r.URL.Path, blacklistPath+"/")
+    if strings.TrimSpace(address) == "" {
+        writeError(w, http.StatusNotFound, "not_found")
+        return
+    }
+
+    if err := s.store.UnblacklistDestination(withAudit(r, "destination.unblacklist", "destination", describeDestination), address); err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            writeError(w, http.StatusNotFound, "not_found")
+            return
+        }
+        s.logger.Printf("unblacklist destination error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    s.logEvent("destination_unblacklisted", map[string]any{
+        "destination": address,
+    })
+    w.WriteHeader(http.StatusNoContent)
+}
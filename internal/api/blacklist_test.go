@@ -0,0 +1,111 @@
+package api_test
+
+import (
+    "context"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "strings"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestAdminBlacklist(t *testing.T) {
+    env := setupTest(t, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    before := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"bad/addr","idempotency_key":"k1"}`)
+
+    admin := map[string]string{"Authorization": "Bearer admin-token"}
+    do := func(method, path, body string, headers map[string]string) (int, string) {
+        t.Helper()
+        resp := env.doRequestWithHeaders(t, method, path, body, headers)
+        defer resp.Body.Close()
+        b, err := io.ReadAll(resp.Body)
+        if err != nil {
+            t.Fatalf("read body: %v", err)
+        }
+        return resp.StatusCode, string(b)
+    }
+
+    if code, _ := do(http.MethodPost, "/v1/admin/blacklist", `{"address":"bad/addr"}`, nil); code != http.StatusUnauthorized {
+        t.Fatalf("api token: expected %d, got %d", http.StatusUnauthorized, code)
+    }
+    if code, body := do(http.MethodPost, "/v1/admin/blacklist", `{"address":" bad/addr "}`, admin); code != http.StatusCreated || !strings.Contains(body, `"address":"bad/addr"`) {
+        t.Fatalf("add: expected %d, got %d %s", http.StatusCreated, code, body)
+    }
+    if code, _ := do(http.MethodPost, "/v1/admin/blacklist", `{"address":"bad/addr"}`, admin); code != http.StatusOK {
+        t.Fatalf("add again: expected %d, got %d", http.StatusOK, code)
+    }
+
+    code, body := do(http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":100,"currency":"USDT","destination":"bad/addr","idempotency_key":"k2"}`, nil)
+    if code != http.StatusForbidden || !strings.Contains(body, `"code":"destination_blacklisted"`) {
+        t.Fatalf("create: expected %d destination_blacklisted, got %d %s", http.StatusForbidden, code, body)
+    }
+    if balance := getBalance(t, env.pool, 1); balance != 900 {
+        t.Fatalf("expected balance 900 after rejected create, got %d", balance)
+    }
+    // A retry of a withdrawal created before the address was blocked is
+    // still answered with that withdrawal.
+    if code, _ := do(http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":100,"currency":"USDT","destination":"bad/addr","idempotency_key":"k1"}`, nil); code != http.StatusOK {
+        t.Fatalf("replay of withdrawal %d: expected %d, got %d", before.ID, http.StatusOK, code)
+    }
+
+    path := "/v1/admin/blacklist/" + url.PathEscape("bad/addr")
+    if code, _ := do(http.MethodDelete, path, "", admin); code != http.StatusNoContent {
+        t.Fatalf("delete: expected %d, got %d", http.StatusNoContent, code)
+    }
+    if code, _ := do(http.MethodDelete, path, "", admin); code != http.StatusNotFound {
+        t.Fatalf("delete again: expected %d, got %d", http.StatusNotFound, code)
+    }
+    createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"bad/addr","idempotency_key":"k2"}`)
+
+    var actions []string
+    rows, err := env.pool.Query(context.Background(), "SELECT action FROM audit_log WHERE resource_type = 'destination' ORDER BY id")
+    if err != nil {
+        t.Fatalf("read audit log: %v", err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var action string
+        if err := rows.Scan(&action); err != nil {
+            t.Fatalf("scan audit entry: %v", err)
+        }
+        actions = append(actions, action)
+    }
+    if strings.Join(actions, ",") != "destination.blacklist,destination.unblacklist" {
+        t.Fatalf("unexpected audit entries: %v", actions)
+    }
+}
+
+func TestAdminBlacklistInvalidRequest(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    for _, tt := range []struct {
+        method string
+        path   string
+        body   string
+        want   int
+    }{
+        {http.MethodPost, "/v1/admin/blacklist", `{}`, http.StatusBadRequest},
+        {http.MethodPost, "/v1/admin/blacklist", `{"address":"  "}`, http.StatusBadRequest},
+        {http.MethodPost, "/v1/admin/blacklist", `{"address":"a","extra":1}`, http.StatusBadRequest},
+        {http.MethodGet, "/v1/admin/blacklist", "", http.StatusMethodNotAllowed},
+        {http.MethodPost, "/v1/admin/blacklist/a", "", http.StatusMethodNotAllowed},
+        {http.MethodDelete, "/v1/admin/blacklist/", "", http.StatusNotFound},
+    } {
+        req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer admin-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != tt.want {
+            t.Fatalf("%s %s %s: expected %d, got %d", tt.method, tt.path, tt.body, tt.want, rec.Code)
+        }
+    }
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "errors"
+    "net/http"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+
+    "task.hh/internal/store"
+)
+
+type contextKey int
+
+const (
+    actorKey contextKey = iota
+    requestIDKey
+)
+
+// withActor also names actor to the store, for its balance audit trail.
+func withActor(ctx context.Context, actor string) context.Context {
+    return context.WithValue(store.WithActor(ctx, actor), actorKey, actor)
+}
+
+// actorFromContext returns the name of the key that authenticated the
+// request.
+func actorFromContext(ctx context.Context) string {
+    actor, _ := ctx.Value(actorKey).(string)
+    return actor
+}
+
+const maxOperatorLength = 64
+
+var (
+    errOperatorRequired = errors.New("operator required")
+    errInvalidOperator  = errors.New("invalid operator")
+)
+
+// operatorFromRequest returns who triggered a withdrawal state change: the
+// X-Operator header with control and other non-printable characters removed,
+// or the key name when the header is absent and not required.
+func (s *Server) operatorFromRequest(r *http.Request) (string, error) {
+    operator := strings.TrimSpace(strings.Map(func(c rune) rune {
+        if !unicode.IsPrint(c) {
+            return -1
+        }
+        return c
+    }, r.Header.Get("X-Operator")))
+    if utf8.RuneCountInString(operator) > maxOperatorLength {
+        return "", errInvalidOperator
+    }
+    if operator != "" {
+        return operator, nil
+    }
+    if s.operatorRequired {
+        return "", errOperatorRequired
+    }
+    return actorFromContext(r.Context()), nil
+}
+
+func requestIDFromContext(ctx context.Context) string {
+    id, _ := ctx.Value(requestIDKey).(string)
+    return id
+}
+
+// requestIDMiddleware propagates X-Request-ID, generating one when the client
+// did not send it, and echoes it on the response.
+func requestIDMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        id := r.Header.Get("X-Request-ID")
+        if id == "" || len(id) > 128 {
+            id = newRequestID()
+        }
+        w.Header().Set("X-Request-ID", id)
+        next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+    })
+}
+
+func newRequestID() string {
+    var b [16]byte
+    _, _ = rand.Read(b[:])
+    return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+    "math"
+    "net/http"
+    "slices"
+)
+
+type CurrencyConfig struct {
+    Code     string
+    Exponent int
+    Min      int64
+    Max      int64
+    Enabled  bool
+}
+
+type currencyResponse struct {
+    Code     string `json:"code"`
+    Exponent int    `json:"exponent"`
+    Min      int64  `json:"min"`
+    Max      int64  `json:"max"`
+    Enabled  bool   `json:"enabled"`
+}
+
+type currenciesResponse struct {
+    Currencies []currencyResponse `json:"currencies"`
+}
+
+func DefaultCurrencies() []CurrencyConfig {
+    return []CurrencyConfig{
+        {Code: "USDT", Exponent: 6, Min: 1, Max: math.MaxInt64, Enabled: true},
+    }
+}
+
+// currencyRegistry returns the registry entries of the given codes: the
+// default entry where there is one, and otherwise an enabled one counted in
+// whole units without limits.
+func currencyRegistry(codes []string) []CurrencyConfig {
+    defaults := DefaultCurrencies()
+    registry := make([]CurrencyConfig, 0, len(codes))
+    for _, code := range codes {
+        c := CurrencyConfig{Code: code, Min: 1, Max: math.MaxInt64, Enabled: true}
+        if i := slices.IndexFunc(defaults, func(d CurrencyConfig) bool { return d.Code == code }); i >= 0 {
+            c = defaults[i]
+        }
+        registry = append(registry, c)
+    }
+    return registry
+}
+
+func (s *Server) currency(code string) (CurrencyConfig, bool) {
+    for _, c := range s.currencies {
+        if c.Code == code {
+            return c, true
+        }
+    }
+    return CurrencyConfig{}, false
+}
+
+func (s *Server) handleCurrencies(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    writeJSON(w, http.StatusOK, currenciesResponse{Currencies: s.currencyResponses()})
+}
+
+func (s *Server) currencyResponses() []currencyResponse {
+    resp := make([]currencyResponse, 0, len(s.currencies))
+    for _, c := range s.currencies {
+        resp = append(resp, currencyResponse{
+            Code:     c.Code,
+            Exponent: c.Exponent,
+            Min:      c.Min,
+            Max:      c.Max,
+            Enabled:  c.Enabled,
+        })
+    }
+    return resp
+}
@@ -0,0 +1,44 @@
+package api_test
+
+import (
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestListCurrencies(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/currencies", nil))
+
+    if rec.Code != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+    }
+
+    var got struct {
+        Currencies []struct {
+            Code     string `json:"code"`
+            Exponent int    `json:"exponent"`
+            Min      int64  `json:"min"`
+            Enabled  bool   `json:"enabled"`
+        } `json:"currencies"`
+    }
+    if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+
+    if len(got.Currencies) != 1 {
+        t.Fatalf("expected 1 currency, got %d", len(got.Currencies))
+    }
+    usdt := got.Currencies[0]
+    if usdt.Code != "USDT" || usdt.Exponent != 6 || usdt.Min != 1 || !usdt.Enabled {
+        t.Fatalf("unexpected currency: %+v", usdt)
+    }
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+    "bytes"
+    "encoding/json"
+    "io"
+    "log/slog"
+    "net/http"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+)
+
+// maxDebugBodyBytes caps how much of a request body is buffered for logging.
+// Larger bodies are passed through untouched and not logged.
+const maxDebugBodyBytes = 64 << 10
+
+// redactedFieldPattern matches a JSON member named one of fields with a
+// scalar value, for masking bodies that do not parse. It is nil when there
+// is nothing to redact.
+func redactedFieldPattern(fields map[string]bool) *regexp.Regexp {
+    if len(fields) == 0 {
+        return nil
+    }
+    names := make([]string, 0, len(fields))
+    for f := range fields {
+        names = append(names, regexp.QuoteMeta(f))
+    }
+    sort.Strings(names)
+    return regexp.MustCompile(`("(?:` + strings.Join(names, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"|[^\s,\]}]+)`)
+}
+
+// debugBodyMiddleware logs the request body of failed requests at debug level
+// so that validation failures can be reproduced. The fields redacted in event
+// logs are hashed the same way here.
+func (s *Server) debugBodyMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var buf bytes.Buffer
+        n, err := io.Copy(&buf, io.LimitReader(r.Body, maxDebugBodyBytes+1))
+        if err != nil {
+            writeError(w, http.StatusBadRequest, "invalid_request")
+            return
+        }
+        truncated := n > maxDebugBodyBytes
+        r.Body = readCloser{io.MultiReader(bytes.NewReader(buf.Bytes()), r.Body), r.Body}
+
+        rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(rec, r)
+
+        if rec.status < http.StatusBadRequest {
+            return
+        }
+        attrs := []any{
+            slog.String("method", r.Method),
+            slog.String("path", r.URL.Path),
+            slog.Int("status", rec.status),
+            slog.String("request_id", requestIDFromContext(r.Context())),
+        }
+        if truncated {
+            attrs = append(attrs, slog.Bool("body_too_large", true))
+        } else {
+            attrs = append(attrs, slog.String("body", s.maskBody(buf.Bytes())))
+        }
+        s.bodyLogger.DebugContext(r.Context(), "request_failed_body", attrs...)
+    })
+}
+
+type readCloser struct {
+    io.Reader
+    io.Closer
+}
+
+// maskBody hashes the redacted fields at any depth of a JSON body. Bodies
+// that are not valid JSON are masked textually, since they are the ones most
+// likely to need reproducing.
+func (s *Server) maskBody(body []byte) string {
+    var v any
+    if err := json.Unmarshal(body, &v); err != nil {
+        if s.logRedactPattern == nil {
+            return string(body)
+        }
+        return s.logRedactPattern.ReplaceAllStringFunc(string(body), func(member string) string {
+            m := s.logRedactPattern.FindStringSubmatch(member)
+            return m[1] + strconv.Quote(hashForLog(bodyValueText(json.RawMessage(m[2]))))
+        })
+    }
+    data, err := json.Marshal(s.maskValue(v))
+    if err != nil {
+        return ""
+    }
+    return string(data)
+}
+
+func (s *Server) maskValue(v any) any {
+    switch v := v.(type) {
+    case map[string]any:
+        for k, field := range v {
+            if s.logRedactFields[k] && field != nil {
+                v[k] = hashForLog(bodyValueText(field))
+                continue
+            }
+            v[k] = s.maskValue(field)
+        }
+    case []any:
+        for i, item := range v {
+            v[i] = s.maskValue(item)
+        }
+    }
+    return v
+}
+
+// bodyValueText is the text a body value is hashed in: strings as they are,
+// like logEvent, and anything else as its JSON.
+func bodyValueText(v any) string {
+    if raw, ok := v.(json.RawMessage); ok {
+        var str string
+        if json.Unmarshal(raw, &str) == nil {
+            return str
+        }
+        return string(raw)
+    }
+    if str, ok := v.(string); ok {
+        return str
+    }
+    data, _ := json.Marshal(v)
+    return string(data)
+}
@@ -0,0 +1,109 @@
+package api_test
+
+import (
+    "bytes"
+    "io"
+    "log"
+    "log/slog"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestDebugBodyLoggingMasksFields(t *testing.T) {
+    var buf bytes.Buffer
+    bodyLogger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithDebugBodyLogger(bodyLogger))
+
+    tests := []struct {
+        name string
+        body string
+    }{
+        {"json", `{"user_id":1,"amount":0,"currency":"USDT","destination":"TXyz1234567890abcd","idempotency_key":"order-42-attempt"}`},
+        {"malformed", `{"destination":"TXyz1234567890abcd","idempotency_key":"order-42-attempt",`},
+    }
+    for _, tt := range tests {
+        buf.Reset()
+        req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals", strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%s: expected %d, got %d", tt.name, http.StatusBadRequest, rec.Code)
+        }
+        logged := buf.String()
+        if !strings.Contains(logged, `"level":"DEBUG"`) || !strings.Contains(logged, "request_failed_body") {
+            t.Fatalf("%s: expected a debug body log, got %q", tt.name, logged)
+        }
+        if strings.Contains(logged, "TXyz1234567890") || strings.Contains(logged, "order-42") {
+            t.Fatalf("%s: sensitive fields were not masked: %q", tt.name, logged)
+        }
+        if !strings.Contains(logged, "sha256:") {
+            t.Fatalf("%s: expected masked fields hashed like event fields, got %q", tt.name, logged)
+        }
+    }
+}
+
+func TestDebugBodyLoggingFollowsLogRedaction(t *testing.T) {
+    var buf bytes.Buffer
+    bodyLogger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+    tests := []struct {
+        name    string
+        fields  []string
+        body    string
+        hidden  []string
+        visible []string
+    }{
+        {"json", []string{"user_id"}, `{"user_id":987654,"amount":0,"currency":"USDT","destination":"TXyz1234567890abcd","idempotency_key":"k"}`, []string{"987654"}, []string{"TXyz1234567890abcd"}},
+        {"malformed", []string{"user_id"}, `{"user_id":987654,"destination":"TXyz1234567890abcd",`, []string{"987654"}, []string{"TXyz1234567890abcd"}},
+        {"disabled", nil, `{"user_id":987654,"amount":0,"currency":"USDT","destination":"TXyz1234567890abcd","idempotency_key":"k"}`, nil, []string{"987654", "TXyz1234567890abcd"}},
+    }
+    for _, tt := range tests {
+        srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithDebugBodyLogger(bodyLogger), api.WithLogRedaction(tt.fields...))
+        buf.Reset()
+        req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals", strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        srv.Routes().ServeHTTP(httptest.NewRecorder(), req)
+
+        logged := buf.String()
+        for _, s := range tt.hidden {
+            if strings.Contains(logged, s) {
+                t.Fatalf("%s: expected %s masked, got %q", tt.name, s, logged)
+            }
+        }
+        for _, s := range tt.visible {
+            if !strings.Contains(logged, s) {
+                t.Fatalf("%s: expected %s logged as is, got %q", tt.name, s, logged)
+            }
+        }
+    }
+}
+
+func TestDebugBodyLoggingPassesBodyThrough(t *testing.T) {
+    var buf bytes.Buffer
+    bodyLogger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithDebugBodyLogger(bodyLogger))
+
+    req := httptest.NewRequest(http.MethodPost, "/v1/users:batch", strings.NewReader("[]"))
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    if !strings.Contains(rec.Body.String(), "invalid_batch_size") {
+        t.Fatalf("expected the handler to see the body, got %d %s", rec.Code, rec.Body.String())
+    }
+
+    buf.Reset()
+    req = httptest.NewRequest(http.MethodGet, "/v1/currencies", nil)
+    rec = httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+    if rec.Code != http.StatusOK || buf.Len() != 0 {
+        t.Fatalf("expected no body log for a successful request, got %d %q", rec.Code, buf.String())
+    }
+}
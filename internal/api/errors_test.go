@@ -0,0 +1,129 @@
+package api_test
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+    "task.hh/internal/testutil/fixtures"
+)
+
+type errorEnvelope struct {
+    Error         string `json:"error"`
+    Code          string `json:"code"`
+    Message       string `json:"message"`
+    RequestID     string `json:"request_id"`
+    CurrentStatus string `json:"current_status"`
+    Details       struct {
+        Balance   int64 `json:"balance"`
+        Requested int64 `json:"requested"`
+        Shortfall int64 `json:"shortfall"`
+    } `json:"details"`
+}
+
+func TestErrorEnvelope(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithOperatorRequired(true))
+
+    tests := []struct {
+        method string
+        path   string
+        body   string
+        token  string
+        status int
+        code   string
+    }{
+        {http.MethodGet, "/v1/withdrawals/1", "", "wrong", http.StatusUnauthorized, "unauthorized"},
+        {http.MethodDelete, "/v1/withdrawals/1", "", "test-token", http.StatusMethodNotAllowed, "method_not_allowed"},
+        {http.MethodGet, "/v1/withdrawals/1/history", "", "test-token", http.StatusNotFound, "not_found"},
+        {http.MethodGet, "/v1/withdrawals/abc", "", "test-token", http.StatusBadRequest, "invalid_id"},
+        {http.MethodGet, "/v1/withdrawals?ids=a,b", "", "test-token", http.StatusBadRequest, "invalid_ids"},
+        {http.MethodGet, "/v1/withdrawals?limit=0", "", "test-token", http.StatusBadRequest, "invalid_filter"},
+        {http.MethodGet, "/v1/withdrawals/stale", "", "test-token", http.StatusBadRequest, "invalid_older_than_minutes"},
+        {http.MethodPost, "/v1/withdrawals", `{"user_id":0}`, "test-token", http.StatusBadRequest, "invalid_request"},
+        {http.MethodPost, "/v1/withdrawals/1/confirm", "", "test-token", http.StatusBadRequest, "operator_required"},
+        {http.MethodPut, "/v1/users/1/tier", `{"tier":"gold"}`, "test-token", http.StatusBadRequest, "invalid_tier"},
+        {http.MethodGet, "/v1/users/1?include=ledger", "", "test-token", http.StatusBadRequest, "invalid_include"},
+        {http.MethodPost, "/v1/users:batch", "[]", "test-token", http.StatusBadRequest, "invalid_batch_size"},
+        {http.MethodGet, "/v1/admin/audit", "", "test-token", http.StatusNotFound, "not_found"},
+    }
+    for _, tt := range tests {
+        name := tt.method + " " + tt.path
+        req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer "+tt.token)
+        req.Header.Set("X-Request-ID", "req-"+tt.code)
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != tt.status {
+            t.Fatalf("%s: expected %d, got %d", name, tt.status, rec.Code)
+        }
+        var got errorEnvelope
+        if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+            t.Fatalf("%s: decode response: %v", name, err)
+        }
+        if got.Code != tt.code || got.Error != tt.code {
+            t.Fatalf("%s: expected code %s in both fields, got %+v", name, tt.code, got)
+        }
+        if got.Message == "" || got.RequestID != "req-"+tt.code {
+            t.Fatalf("%s: expected a message and the request id, got %+v", name, got)
+        }
+    }
+}
+
+func TestErrorEnvelopeShape(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/withdrawals?sort=nope", nil)
+    req.Header.Set("Authorization", "Bearer test-token")
+    req.Header.Set("X-Request-ID", "req-shape")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    if rec.Code != http.StatusBadRequest {
+        t.Fatalf("expected %d, got %d", http.StatusBadRequest, rec.Code)
+    }
+    fixtures.AssertShape(t, "error_with_details", rec.Body.Bytes())
+}
+
+func TestErrorEnvelopeConflicts(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 100)
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":200,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    var got errorEnvelope
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusConflict || got.Code != "insufficient_balance" || !strings.Contains(got.Message, "balance 100 is less than requested 200") {
+        t.Fatalf("unexpected insufficient balance response: %d %+v", resp.StatusCode, got)
+    }
+    if got.Details.Balance != 100 || got.Details.Requested != 200 || got.Details.Shortfall != 100 {
+        t.Fatalf("unexpected insufficient balance details: %+v", got.Details)
+    }
+
+    created := createWithdrawal(t, env, `{"user_id":1,"amount":50,"currency":"USDT","destination":"addr","idempotency_key":"k2"}`)
+    if _, err := env.pool.Exec(context.Background(), "UPDATE withdrawals SET status = 'expired' WHERE id = $1", created.ID); err != nil {
+        t.Fatalf("expire withdrawal: %v", err)
+    }
+
+    resp = env.doRequest(t, http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/confirm", created.ID), "")
+    got = errorEnvelope{}
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusConflict || got.Code != "reservation_expired" || got.CurrentStatus != store.StatusExpired {
+        t.Fatalf("unexpected confirm conflict response: %d %+v", resp.StatusCode, got)
+    }
+}
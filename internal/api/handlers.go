@@ -3,56 +3,352 @@ package api
 import (
     "encoding/json"
     "errors"
+    "fmt"
     "io"
+    "math"
     "net/http"
+    "net/url"
+    "slices"
     "strconv"
     "strings"
     "time"
 
+    "go.opentelemetry.io/otel/attribute"
+
     "task.hh/internal/store"
 )
 
+const (
+    maxBatchIDs   = 500
+    maxBatchUsers = 1000
+)
+
 type createWithdrawalRequest struct {
-    UserID         int64  `json:"user_id"`
-    Amount         int64  `json:"amount"`
-    Currency       string `json:"currency"`
-    Destination    string `json:"destination"`
-    IdempotencyKey string `json:"idempotency_key"`
+    UserID         Integer       `json:"user_id"`
+    Amount         DecimalAmount `json:"amount"`
+    Currency       string        `json:"currency"`
+    Destination    string        `json:"destination"`
+    IdempotencyKey string        `json:"idempotency_key"`
 }
 
 type createUserRequest struct {
-    ID      int64 `json:"id"`
-    Balance int64 `json:"balance"`
+    ID         Integer `json:"id"`
+    Balance    Integer `json:"balance"`
+    ExternalID *string `json:"external_id"`
+}
+
+const maxExternalIDLength = 128
+
+type batchUserResult struct {
+    ID     int64         `json:"id"`
+    Status string        `json:"status"`
+    Error  string        `json:"error,omitempty"`
+    User   *userResponse `json:"user,omitempty"`
+}
+
+// batchItemDetails names the item that failed an atomic batch.
+type batchItemDetails struct {
+    Index int   `json:"index"`
+    ID    int64 `json:"id"`
+}
+
+type batchUsersResponse struct {
+    Created int               `json:"created"`
+    Failed  int               `json:"failed"`
+    Results []batchUserResult `json:"results"`
+}
+
+// confirmWithdrawalRequest is the optional body of a confirm. ExpectedVersion
+// is an alternative to If-Match for clients that cannot set headers.
+type confirmWithdrawalRequest struct {
+    ExpectedVersion *int64 `json:"expected_version"`
+}
+
+type updateUserTierRequest struct {
+    Tier string `json:"tier"`
 }
 
 type withdrawalResponse struct {
-    ID             int64     `json:"id"`
-    UserID         int64     `json:"user_id"`
-    Amount         int64     `json:"amount"`
-    Currency       string    `json:"currency"`
-    Destination    string    `json:"destination"`
-    Status         string    `json:"status"`
-    IdempotencyKey string    `json:"idempotency_key"`
-    CreatedAt      time.Time `json:"created_at"`
+    ID             int64       `json:"id"`
+    UserID         int64       `json:"user_id"`
+    Amount         minorAmount `json:"amount"`
+    Fee            minorAmount `json:"fee"`
+    Currency       string      `json:"currency"`
+    Destination    string      `json:"destination"`
+    Status         string      `json:"status"`
+    IdempotencyKey string      `json:"idempotency_key"`
+    ReservedUntil  *time.Time  `json:"reserved_until,omitempty"`
+    CreatedAt      time.Time   `json:"created_at"`
+    UpdatedAt      time.Time   `json:"updated_at"`
+    ConfirmedAt    *time.Time  `json:"confirmed_at"`
+    NoteCount      int         `json:"note_count"`
+    ReversalReason string      `json:"reversal_reason,omitempty"`
+    Version        int64       `json:"version"`
+    CreatedByKey   string      `json:"created_by_key,omitempty"`
+    ExternalTxHash *string     `json:"external_tx_hash,omitempty"`
+
+    // ResultingBalance is only set on creation, to spare clients a follow-up
+    // balance lookup.
+    ResultingBalance *minorAmount `json:"resulting_balance,omitempty"`
+
+    // LedgerEntries is only set with ?include=ledger; a pointer so that an
+    // empty ledger is still rendered as [].
+    LedgerEntries *[]ledgerEntryResponse `json:"ledger_entries,omitempty"`
+
+    // User is only set with ?embed=user.
+    User *embeddedUserResponse `json:"user,omitempty"`
+}
+
+type embeddedUserResponse struct {
+    Balance minorAmount `json:"balance"`
+    Tier    string      `json:"tier"`
+}
+
+type ledgerEntryResponse struct {
+    ID        int64       `json:"id"`
+    Amount    minorAmount `json:"amount"`
+    Currency  string      `json:"currency"`
+    Direction string      `json:"direction"`
+    Reason    string      `json:"reason,omitempty"`
+    CreatedAt time.Time   `json:"created_at"`
+}
+
+type withdrawalAgeResponse struct {
+    AgeMinutes float64 `json:"age_minutes"`
+}
+
+type withdrawalListResponse struct {
+    Withdrawals []withdrawalResponse `json:"withdrawals"`
+}
+
+type withdrawalBatchResponse struct {
+    Withdrawals []withdrawalResponse `json:"withdrawals"`
+    MissingIDs  []int64              `json:"missing_ids"`
+}
+
+type withdrawalPageResponse struct {
+    Withdrawals []withdrawalResponse `json:"withdrawals"`
+    // NextCursor is the id to pass as after (asc) or before (desc) to fetch
+    // the next page. It is omitted on the last page.
+    NextCursor int64 `json:"next_cursor,omitempty"`
+    // NextPageCursor replaces NextCursor when the list is sorted: pass it as
+    // page_cursor with the same sort to fetch the next page.
+    NextPageCursor string `json:"next_page_cursor,omitempty"`
+    // TotalCount is set with ?with_count=true. TotalCountCapped means the
+    // count stopped at the configured cap and the real total is larger.
+    TotalCount       *int64 `json:"total_count,omitempty"`
+    TotalCountCapped bool   `json:"total_count_capped,omitempty"`
+}
+
+type invalidSortDetails struct {
+    Allowed []string `json:"allowed"`
 }
 
 type userResponse struct {
-    ID        int64     `json:"id"`
-    Balance   int64     `json:"balance"`
-    CreatedAt time.Time `json:"created_at"`
+    ID         int64       `json:"id"`
+    Balance    minorAmount `json:"balance"`
+    Tier       string      `json:"tier"`
+    ExternalID *string     `json:"external_id,omitempty"`
+    CreatedAt  time.Time   `json:"created_at"`
+    UpdatedAt  time.Time   `json:"updated_at"`
+
+    // OverdraftLimit is how far below zero withdrawals may take Balance.
+    OverdraftLimit minorAmount `json:"overdraft_limit"`
+
+    Stats *userStatsResponse `json:"stats,omitempty"`
+}
+
+type userStatsResponse struct {
+    WithdrawalCount int64                          `json:"withdrawal_count"`
+    TotalWithdrawn  minorAmount                    `json:"total_withdrawn"`
+    PendingAmount   minorAmount                    `json:"pending_amount"`
+    ByStatus        map[string]statusStatsResponse `json:"by_status"`
+}
+
+type statusStatsResponse struct {
+    Count  int64       `json:"count"`
+    Amount minorAmount `json:"amount"`
 }
 
 func (s *Server) handleUsers(w http.ResponseWriter, r *http.Request) {
-    if r.Method == http.MethodPost {
+    switch r.Method {
+    case http.MethodPost:
         s.handleCreateUser(w, r)
+    case http.MethodGet:
+        s.handleGetUserByExternalID(w, r)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+    }
+}
+
+func (s *Server) handleGetUserByExternalID(w http.ResponseWriter, r *http.Request) {
+    externalID := r.URL.Query().Get("external_id")
+    if externalID == "" {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_request", "external_id is required")
         return
     }
-    writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+
+    user, err := s.store.GetUserByExternalID(r.Context(), externalID)
+    if err != nil {
+        if errors.Is(err, store.ErrUserNotFound) {
+            writeError(w, http.StatusNotFound, "user_not_found")
+            return
+        }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
+        s.logger.Printf("get user by external id error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+    writeJSON(w, http.StatusOK, toUserResponse(user))
+}
+
+func (s *Server) handleUserByID(w http.ResponseWriter, r *http.Request) {
+    path := strings.TrimPrefix(r.URL.Path, "/v1/users/")
+    parts := strings.Split(path, "/")
+    method := http.MethodGet
+    switch {
+    case len(parts) == 1 && parts[0] != "":
+    case len(parts) == 2 && parts[1] == "tier":
+        method = http.MethodPut
+    case len(parts) == 2 && parts[1] == "top-recipients":
+    case len(parts) == 3 && parts[1] == "ledger" && parts[2] == "summary":
+    case len(parts) == 2 && parts[1] == "fee-summary":
+    case len(parts) == 2 && parts[1] == "withdrawals":
+        method = http.MethodPost
+    case len(parts) == 3 && parts[1] == "withdrawals" && parts[2] == "by-key":
+    default:
+        writeError(w, http.StatusNotFound, "not_found")
+        return
+    }
+    if r.Method != method {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    id, err := strconv.ParseInt(parts[0], 10, 64)
+    if err != nil || id <= 0 {
+        writeError(w, http.StatusBadRequest, "invalid_id")
+        return
+    }
+    if len(parts) == 1 {
+        s.handleGetUser(w, r, id)
+        return
+    }
+    switch parts[1] {
+    case "tier":
+        s.handleUpdateUserTier(w, r, id)
+    case "top-recipients":
+        s.handleTopRecipients(w, r, id)
+    case "ledger":
+        s.handleLedgerSummary(w, r, id)
+    case "fee-summary":
+        s.handleFeeSummary(w, r, id)
+    case "withdrawals":
+        if len(parts) == 3 {
+            s.handleGetWithdrawalByKey(w, r, id)
+            return
+        }
+        s.handleCreateWithdrawal(w, r, id)
+    }
+}
+
+// handleGetWithdrawalByKey serves GET
+// /v1/users/{id}/withdrawals/by-key?idempotency_key=..., for a client that
+// lost the response to a create and wants the withdrawal without retrying
+// it. The key is matched as a create would store it.
+func (s *Server) handleGetWithdrawalByKey(w http.ResponseWriter, r *http.Request, userID int64) {
+    // The key is checked as on creation, so a key no withdrawal could have
+    // gets the same 400 instead of a lookup.
+    key, err := s.store.NormalizeIdempotencyKey(r.URL.Query().Get("idempotency_key"))
+    var fields []store.FieldError
+    var invalid *store.InvalidInputError
+    if errors.As(err, &invalid) {
+        fields = invalid.Fields
+    }
+    if len(fields) == 0 && !s.idempotencyKeyPattern.MatchString(key) {
+        fields = append(fields, store.FieldError{Field: "idempotency_key", Reason: store.ReasonInvalidFormat})
+    }
+    if len(fields) > 0 {
+        writeErrorResponse(w, http.StatusBadRequest, errorResponse{
+            Code:    "invalid_idempotency_key",
+            Details: fieldErrorDetails(fields),
+        })
+        return
+    }
+
+    withdrawal, err := s.store.GetWithdrawalByIdempotencyKey(r.Context(), userID, key)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            writeError(w, http.StatusNotFound, "not_found")
+            return
+        }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
+        s.logger.Printf("get withdrawal by idempotency key error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    w.Header().Set("ETag", withdrawalETag(withdrawal))
+    writeJSON(w, http.StatusOK, toWithdrawalResponse(withdrawal))
+}
+
+func (s *Server) handleGetUser(w http.ResponseWriter, r *http.Request, id int64) {
+    includeStats := false
+    if raw := r.URL.Query().Get("include"); raw != "" {
+        for _, include := range strings.Split(raw, ",") {
+            if strings.TrimSpace(include) != "stats" {
+                writeError(w, http.StatusBadRequest, "invalid_include")
+                return
+            }
+            includeStats = true
+        }
+    }
+
+    user, err := s.store.GetUser(r.Context(), id)
+    if err != nil {
+        if errors.Is(err, store.ErrUserNotFound) {
+            writeError(w, http.StatusNotFound, "user_not_found")
+            return
+        }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
+        s.logger.Printf("get user error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := toUserResponse(user)
+    if includeStats {
+        stats, err := s.store.GetUserStats(r.Context(), id)
+        if err != nil {
+            s.logger.Printf("get user stats error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+            return
+        }
+        resp.Stats = toUserStatsResponse(stats)
+    }
+    writeJSON(w, http.StatusOK, resp)
 }
 
 func (s *Server) handleWithdrawals(w http.ResponseWriter, r *http.Request) {
     if r.Method == http.MethodPost {
-        s.handleCreateWithdrawal(w, r)
+        s.handleCreateWithdrawal(w, r, 0)
+        return
+    }
+    if r.Method == http.MethodGet {
+        if r.URL.Query().Has("ids") {
+            s.handleGetWithdrawals(w, r)
+            return
+        }
+        s.handleListWithdrawals(w, r)
         return
     }
 
@@ -65,43 +361,415 @@ func (s *Server) handleWithdrawalByID(w http.ResponseWriter, r *http.Request) {
         writeError(w, http.StatusNotFound, "not_found")
         return
     }
-    parts := strings.Split(path, "/")
-    if len(parts) == 2 && parts[1] == "confirm" {
-        id, err := strconv.ParseInt(parts[0], 10, 64)
-        if err != nil || id <= 0 {
-            writeError(w, http.StatusBadRequest, "invalid_id")
-            return
-        }
-        s.handleConfirmWithdrawal(w, r, id)
+    // At most id/action is routed; splitting once more keeps anything longer
+    // in a third part instead of building an unbounded slice.
+    parts := strings.SplitN(path, "/", 3)
+    if len(parts) == 1 && parts[0] == "stale" {
+        s.handleStaleWithdrawals(w, r)
         return
     }
-    if len(parts) != 1 {
+    var action string
+    switch {
+    case len(parts) == 1:
+        if r.Method != http.MethodGet {
+            writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+            return
+        }
+    case len(parts) == 2 && (parts[1] == "age" || parts[1] == "touch" || parts[1] == "confirm" || parts[1] == "notes" || parts[1] == "reverse" || parts[1] == "tx-hash"):
+        action = parts[1]
+    default:
         writeError(w, http.StatusNotFound, "not_found")
         return
     }
-    if r.Method != http.MethodGet {
-        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
-        return
-    }
 
     id, err := strconv.ParseInt(parts[0], 10, 64)
     if err != nil || id <= 0 {
         writeError(w, http.StatusBadRequest, "invalid_id")
         return
     }
+    switch action {
+    case "age":
+        s.handleWithdrawalAge(w, r, id)
+        return
+    case "touch":
+        s.handleTouchWithdrawal(w, r, id)
+        return
+    case "confirm":
+        s.handleConfirmWithdrawal(w, r, id)
+        return
+    case "notes":
+        s.handleWithdrawalNotes(w, r, id)
+        return
+    case "reverse":
+        s.handleReverseWithdrawal(w, r, id)
+        return
+    case "tx-hash":
+        s.handleRecordTxHash(w, r, id)
+        return
+    }
 
-    withdrawal, err := s.store.GetWithdrawal(r.Context(), id)
+    includeLedger := false
+    if raw := r.URL.Query().Get("include"); raw != "" {
+        for _, include := range strings.Split(raw, ",") {
+            if strings.TrimSpace(include) != "ledger" {
+                writeErrorMessage(w, http.StatusBadRequest, "invalid_include", "include supports only ledger")
+                return
+            }
+            includeLedger = true
+        }
+    }
+    embedUser := false
+    if raw := r.URL.Query().Get("embed"); raw != "" {
+        for _, embed := range strings.Split(raw, ",") {
+            if strings.TrimSpace(embed) != "user" {
+                writeError(w, http.StatusBadRequest, "invalid_embed")
+                return
+            }
+            embedUser = true
+        }
+    }
+
+    setSpanAttributes(r, attribute.Int64("withdrawal_id", id))
+    var (
+        withdrawal store.Withdrawal
+        entries    []store.LedgerEntry
+        user       *embeddedUserResponse
+    )
+    switch {
+    case includeLedger:
+        withdrawal, entries, err = s.store.GetWithdrawalWithLedger(r.Context(), id)
+        if err == nil && embedUser {
+            var u store.User
+            if u, err = s.store.GetUser(r.Context(), withdrawal.UserID); err == nil {
+                user = &embeddedUserResponse{Balance: balanceAmount(u.Balance), Tier: u.Tier}
+            }
+        }
+    case embedUser:
+        var ww store.WithdrawalWithUser
+        if ww, err = s.store.GetWithdrawalWithUser(r.Context(), id); err == nil {
+            withdrawal = ww.Withdrawal
+            user = &embeddedUserResponse{Balance: balanceAmount(ww.UserBalance), Tier: ww.UserTier}
+        }
+    default:
+        withdrawal, err = s.store.GetWithdrawal(r.Context(), id)
+    }
     if err != nil {
         if errors.Is(err, store.ErrNotFound) {
             writeError(w, http.StatusNotFound, "not_found")
             return
         }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
         s.logger.Printf("get withdrawal error: %v", err)
         writeError(w, http.StatusInternalServerError, "internal_error")
         return
     }
 
-    writeJSON(w, http.StatusOK, toWithdrawalResponse(withdrawal))
+    // The ledger variant lists more than the withdrawal itself, so its tag
+    // also names how many entries it lists and the newest of them.
+    etag := withdrawalETag(withdrawal)
+    if includeLedger {
+        var lastID int64
+        for _, e := range entries {
+            lastID = max(lastID, e.ID)
+        }
+        etag = strings.TrimSuffix(etag, `"`) + fmt.Sprintf(`:ledger:%d:%d"`, len(entries), lastID)
+    }
+    // The user's balance and tier change without touching the withdrawal.
+    if user != nil {
+        etag = strings.TrimSuffix(etag, `"`) + fmt.Sprintf(`:user:%d:%s"`, user.Balance.value, user.Tier)
+    }
+    w.Header().Set("ETag", etag)
+    if etagMatches(r.Header.Get("If-None-Match"), etag) {
+        w.WriteHeader(http.StatusNotModified)
+        return
+    }
+
+    resp := toWithdrawalResponse(withdrawal)
+    if includeLedger {
+        ledger := make([]ledgerEntryResponse, 0, len(entries))
+        for _, e := range entries {
+            ledger = append(ledger, ledgerEntryResponse{
+                ID:        e.ID,
+                Amount:    amountIn(e.Amount, e.Currency),
+                Currency:  e.Currency,
+                Direction: e.Direction,
+                Reason:    e.Reason,
+                CreatedAt: e.CreatedAt,
+            })
+        }
+        resp.LedgerEntries = &ledger
+    }
+    resp.User = user
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleWithdrawalAge(w http.ResponseWriter, r *http.Request, id int64) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+    setSpanAttributes(r, attribute.Int64("withdrawal_id", id))
+
+    minutes, err := s.store.GetWithdrawalAge(r.Context(), id)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            writeError(w, http.StatusNotFound, "not_found")
+            return
+        }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
+        s.logger.Printf("get withdrawal age error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    writeJSON(w, http.StatusOK, withdrawalAgeResponse{AgeMinutes: minutes})
+}
+
+func (s *Server) handleStaleWithdrawals(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    olderThan, err := strconv.ParseFloat(r.URL.Query().Get("older_than_minutes"), 64)
+    if err != nil || olderThan < 0 || math.IsInf(olderThan, 0) || math.IsNaN(olderThan) {
+        writeError(w, http.StatusBadRequest, "invalid_older_than_minutes")
+        return
+    }
+
+    limit := 0
+    if raw := r.URL.Query().Get("limit"); raw != "" {
+        limit, err = strconv.Atoi(raw)
+        if err != nil || limit <= 0 {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("invalid limit %q", raw))
+            return
+        }
+    }
+
+    withdrawals, err := s.store.GetStalePendingWithdrawals(r.Context(), olderThan, limit)
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidFilter) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+            return
+        }
+        s.logger.Printf("get stale withdrawals error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := withdrawalListResponse{Withdrawals: make([]withdrawalResponse, 0, len(withdrawals))}
+    for _, wd := range withdrawals {
+        resp.Withdrawals = append(resp.Withdrawals, toWithdrawalResponse(wd))
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleGetWithdrawals(w http.ResponseWriter, r *http.Request) {
+    ids, err := parseIDList(r.URL.Query().Get("ids"), maxBatchIDs)
+    if err != nil {
+        writeError(w, http.StatusBadRequest, "invalid_ids")
+        return
+    }
+
+    withdrawals, err := s.store.GetWithdrawalsByIDs(r.Context(), ids)
+    if err != nil {
+        s.logger.Printf("get withdrawals error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := withdrawalBatchResponse{
+        Withdrawals: make([]withdrawalResponse, 0, len(withdrawals)),
+        MissingIDs:  []int64{},
+    }
+    found := make(map[int64]bool, len(withdrawals))
+    for _, wd := range withdrawals {
+        resp.Withdrawals = append(resp.Withdrawals, toWithdrawalResponse(wd))
+        found[wd.ID] = true
+    }
+    for _, id := range ids {
+        if !found[id] {
+            resp.MissingIDs = append(resp.MissingIDs, id)
+        }
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleListWithdrawals(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    if sort := q.Get("sort"); sort != "" && !store.ValidSort(sort) {
+        writeErrorResponse(w, http.StatusBadRequest, errorResponse{
+            Code:    "invalid_sort",
+            Details: invalidSortDetails{Allowed: store.SortKeys},
+        })
+        return
+    }
+    filter, err := parseListWithdrawalsFilter(q)
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        return
+    }
+    withCount, err := parseWithCount(q)
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        return
+    }
+
+    var withdrawals []store.Withdrawal
+    var total store.Total
+    if withCount {
+        withdrawals, total, err = s.store.ListWithdrawalsWithTotal(r.Context(), filter)
+    } else {
+        withdrawals, err = s.store.ListWithdrawals(r.Context(), filter)
+    }
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidFilter) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+            return
+        }
+        s.logger.Printf("list withdrawals error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := withdrawalPageResponse{Withdrawals: make([]withdrawalResponse, 0, len(withdrawals))}
+    for _, wd := range withdrawals {
+        resp.Withdrawals = append(resp.Withdrawals, toWithdrawalResponse(wd))
+    }
+    limit := filter.Limit
+    if limit == 0 {
+        limit = store.DefaultListLimit
+    }
+    if len(withdrawals) == limit {
+        last := withdrawals[len(withdrawals)-1]
+        if filter.Sort != "" {
+            resp.NextPageCursor = store.EncodeSortCursor(filter.Sort, last)
+        } else {
+            resp.NextCursor = last.ID
+        }
+    }
+    if withCount {
+        resp.setTotal(total)
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (p *withdrawalPageResponse) setTotal(total store.Total) {
+    p.TotalCount = &total.Count
+    p.TotalCountCapped = total.Capped
+}
+
+// parseWithCount reads the with_count flag of list endpoints.
+func parseWithCount(q url.Values) (bool, error) {
+    raw := q.Get("with_count")
+    if raw == "" {
+        return false, nil
+    }
+    withCount, err := strconv.ParseBool(raw)
+    if err != nil {
+        return false, fmt.Errorf("invalid with_count %q", raw)
+    }
+    return withCount, nil
+}
+
+func parseListWithdrawalsFilter(q url.Values) (store.ListWithdrawalsFilter, error) {
+    filter := store.ListWithdrawalsFilter{
+        Status:      q.Get("status"),
+        Direction:   q.Get("direction"),
+        Sort:        q.Get("sort"),
+        Cursor:      q.Get("page_cursor"),
+        Destination: strings.TrimSpace(q.Get("destination")),
+    }
+    ints := []struct {
+        key string
+        dst *int64
+    }{
+        {"user_id", &filter.UserID},
+        {"after", &filter.After},
+        {"before", &filter.Before},
+    }
+    for _, p := range ints {
+        raw := q.Get(p.key)
+        if raw == "" {
+            continue
+        }
+        n, err := strconv.ParseInt(raw, 10, 64)
+        if err != nil || n <= 0 {
+            return store.ListWithdrawalsFilter{}, fmt.Errorf("invalid %s %q", p.key, raw)
+        }
+        *p.dst = n
+    }
+    // amount_gte and amount_lte are aliases of min_amount and max_amount;
+    // passing both spellings of a bound with different values is rejected.
+    bounds := []struct {
+        keys []string
+        dst  **int64
+    }{
+        {[]string{"min_amount", "amount_gte"}, &filter.MinAmount},
+        {[]string{"max_amount", "amount_lte"}, &filter.MaxAmount},
+    }
+    for _, p := range bounds {
+        for _, key := range p.keys {
+            raw := q.Get(key)
+            if raw == "" {
+                continue
+            }
+            n, err := strconv.ParseInt(raw, 10, 64)
+            if err != nil || n < 0 {
+                return store.ListWithdrawalsFilter{}, fmt.Errorf("invalid %s %q", key, raw)
+            }
+            if *p.dst != nil && **p.dst != n {
+                return store.ListWithdrawalsFilter{}, fmt.Errorf("%s %d conflicts with %s %d", key, n, p.keys[0], **p.dst)
+            }
+            *p.dst = &n
+        }
+    }
+    if raw := q.Get("limit"); raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n <= 0 {
+            return store.ListWithdrawalsFilter{}, fmt.Errorf("invalid limit %q", raw)
+        }
+        filter.Limit = n
+    }
+    if raw := q.Get("updated_after"); raw != "" {
+        t, err := time.Parse(time.RFC3339Nano, raw)
+        if err != nil {
+            return store.ListWithdrawalsFilter{}, fmt.Errorf("invalid updated_after %q", raw)
+        }
+        filter.UpdatedAfter = &t
+    }
+    return filter, nil
+}
+
+// parseIDList parses a comma-separated list of positive ids, allowing at
+// most max entries. Repeated ids are dropped, keeping the first occurrence.
+func parseIDList(raw string, max int) ([]int64, error) {
+    if strings.TrimSpace(raw) == "" {
+        return nil, errors.New("ids are required")
+    }
+    parts := strings.Split(raw, ",")
+    if len(parts) > max {
+        return nil, fmt.Errorf("at most %d ids are allowed", max)
+    }
+    ids := make([]int64, 0, len(parts))
+    seen := make(map[int64]bool, len(parts))
+    for _, p := range parts {
+        id, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64)
+        if err != nil || id <= 0 {
+            return nil, fmt.Errorf("invalid id %q", p)
+        }
+        if !seen[id] {
+            seen[id] = true
+            ids = append(ids, id)
+        }
+    }
+    return ids, nil
 }
 
 func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
@@ -110,10 +778,11 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
     dec := json.NewDecoder(r.Body)
     dec.DisallowUnknownFields()
     if err := dec.Decode(&req); err != nil {
+        reason := decodeErrorCode(err)
         s.logEvent("user_create_failed", map[string]any{
-            "reason": "invalid_request",
+            "reason": reason,
         })
-        writeError(w, http.StatusBadRequest, "invalid_request")
+        writeError(w, http.StatusBadRequest, reason)
         return
     }
     if err := dec.Decode(&struct{}{}); err != io.EOF {
@@ -133,13 +802,22 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    user, err := s.store.CreateUser(r.Context(), req.ID, req.Balance)
+    ctx := withAudit(r, "user.create", "user", func(user store.User) (string, map[string]any) {
+        return strconv.FormatInt(user.ID, 10), map[string]any{
+            "balance":     user.Balance,
+            "external_id": user.ExternalID,
+        }
+    })
+    user, err := s.store.CreateUser(ctx, int64(req.ID), int64(req.Balance), req.ExternalID)
     if err != nil {
         reason := "internal_error"
         switch {
         case errors.Is(err, store.ErrUserExists):
             reason = "user_exists"
             writeError(w, http.StatusConflict, "user_exists")
+        case errors.Is(err, store.ErrExternalIDExists):
+            reason = "external_id_exists"
+            writeError(w, http.StatusConflict, "external_id_exists")
         default:
             s.logger.Printf("create user error: %v", err)
             writeError(w, http.StatusInternalServerError, "internal_error")
@@ -159,16 +837,223 @@ func (s *Server) handleCreateUser(w http.ResponseWriter, r *http.Request) {
     writeJSON(w, http.StatusCreated, toUserResponse(user))
 }
 
-func (s *Server) handleCreateWithdrawal(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleCreateUsersBatch(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    var reqs []createUserRequest
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&reqs); err != nil {
+        writeError(w, http.StatusBadRequest, decodeErrorCode(err))
+        return
+    }
+    if err := dec.Decode(&struct{}{}); err != io.EOF {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if len(reqs) == 0 || len(reqs) > maxBatchUsers {
+        writeError(w, http.StatusBadRequest, "invalid_batch_size")
+        return
+    }
+    atomic := false
+    if raw := r.URL.Query().Get("atomic"); raw != "" {
+        var err error
+        if atomic, err = strconv.ParseBool(raw); err != nil {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_request", fmt.Sprintf("invalid atomic %q", raw))
+            return
+        }
+    }
+    if atomic {
+        s.createUsersBatchAtomic(w, r, reqs)
+        return
+    }
+
+    results := make([]batchUserResult, len(reqs))
+    var valid []store.NewUser
+    var validIdx []int
+    for i, req := range reqs {
+        results[i].ID = int64(req.ID)
+        // External ids are not supported in batches yet.
+        if err := validateCreateUser(req); err != nil || req.ExternalID != nil {
+            results[i].Status = "error"
+            results[i].Error = "invalid_request"
+            continue
+        }
+        valid = append(valid, store.NewUser{ID: int64(req.ID), Balance: int64(req.Balance)})
+        validIdx = append(validIdx, i)
+    }
+
+    if len(valid) > 0 {
+        created, err := s.store.CreateUsers(withAudit(r, "user.batch_create", "user", describeUsersBatch), valid)
+        if err != nil {
+            s.logger.Printf("create users batch error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+            return
+        }
+        for j, res := range created {
+            i := validIdx[j]
+            if res.Err != nil {
+                results[i].Status = "error"
+                results[i].Error = "user_exists"
+                continue
+            }
+            user := toUserResponse(res.User)
+            results[i].Status = "created"
+            results[i].User = &user
+        }
+    }
+
+    s.writeUsersBatch(w, results, false)
+}
+
+// createUsersBatchAtomic creates every user of the batch or none. The first
+// failing item aborts the batch and its error is returned for the request.
+func (s *Server) createUsersBatchAtomic(w http.ResponseWriter, r *http.Request, reqs []createUserRequest) {
+    users := make([]store.NewUser, 0, len(reqs))
+    for i, req := range reqs {
+        if err := validateCreateUser(req); err != nil || req.ExternalID != nil {
+            s.logEvent("users_batch_failed", map[string]any{
+                "reason": "invalid_request",
+                "index":  i,
+            })
+            writeErrorResponse(w, http.StatusBadRequest, errorResponse{
+                Code:    "invalid_request",
+                Details: batchItemDetails{Index: i, ID: int64(req.ID)},
+            })
+            return
+        }
+        users = append(users, store.NewUser{ID: int64(req.ID), Balance: int64(req.Balance)})
+    }
+
+    created, err := s.store.CreateUsersAtomic(withAudit(r, "user.batch_create", "user", describeUsersBatch), users)
+    if err != nil {
+        var item *store.BatchItemError
+        if errors.As(err, &item) && errors.Is(err, store.ErrUserExists) {
+            s.logEvent("users_batch_failed", map[string]any{
+                "reason": "user_exists",
+                "index":  item.Index,
+            })
+            writeErrorResponse(w, http.StatusConflict, errorResponse{
+                Code:    "user_exists",
+                Details: batchItemDetails{Index: item.Index, ID: int64(reqs[item.Index].ID)},
+            })
+            return
+        }
+        s.logger.Printf("create users batch error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    results := make([]batchUserResult, len(created))
+    for i, u := range created {
+        user := toUserResponse(u)
+        results[i] = batchUserResult{ID: u.ID, Status: "created", User: &user}
+    }
+    s.writeUsersBatch(w, results, true)
+}
+
+// describeUsersBatch is the audit entry of the users a batch created, which
+// names no single resource.
+func describeUsersBatch(users []store.User) (string, map[string]any) {
+    ids := make([]int64, len(users))
+    for i, u := range users {
+        ids[i] = u.ID
+    }
+    return "", map[string]any{"created_ids": ids}
+}
+
+func (s *Server) writeUsersBatch(w http.ResponseWriter, results []batchUserResult, atomic bool) {
+    resp := batchUsersResponse{Results: results}
+    for _, res := range results {
+        if res.Status == "created" {
+            resp.Created++
+        } else {
+            resp.Failed++
+        }
+    }
+    s.logEvent("users_batch_created", map[string]any{
+        "created": resp.Created,
+        "failed":  resp.Failed,
+        "atomic":  atomic,
+    })
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) handleUpdateUserTier(w http.ResponseWriter, r *http.Request, id int64) {
+    var req updateUserTierRequest
+
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&req); err != nil {
+        s.logEvent("user_tier_update_failed", map[string]any{
+            "reason":  "invalid_request",
+            "user_id": id,
+        })
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if err := dec.Decode(&struct{}{}); err != io.EOF {
+        s.logEvent("user_tier_update_failed", map[string]any{
+            "reason":  "invalid_request",
+            "user_id": id,
+        })
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+
+    ctx := withAudit(r, "user.update_tier", "user", func(user store.User) (string, map[string]any) {
+        return strconv.FormatInt(user.ID, 10), map[string]any{"tier": user.Tier}
+    })
+    user, err := s.store.UpdateUserTier(ctx, id, req.Tier)
+    if err != nil {
+        reason := "internal_error"
+        switch {
+        case errors.Is(err, store.ErrInvalidTier):
+            reason = "invalid_tier"
+            writeError(w, http.StatusBadRequest, "invalid_tier")
+        case errors.Is(err, store.ErrUserNotFound):
+            reason = "user_not_found"
+            writeError(w, http.StatusNotFound, "user_not_found")
+        case errors.Is(err, store.ErrTenantMismatch):
+            reason = "forbidden"
+            writeError(w, http.StatusForbidden, "forbidden")
+        default:
+            s.logger.Printf("update user tier error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+        }
+        s.logEvent("user_tier_update_failed", map[string]any{
+            "reason":  reason,
+            "user_id": id,
+            "tier":    req.Tier,
+        })
+        return
+    }
+
+    s.logEvent("user_tier_updated", map[string]any{
+        "user_id": user.ID,
+        "tier":    user.Tier,
+    })
+    writeJSON(w, http.StatusOK, toUserResponse(user))
+}
+
+// handleCreateWithdrawal serves POST /v1/withdrawals and its nested alias POST
+// /v1/users/{id}/withdrawals, for which userID is the id from the path. The
+// path wins over a user_id in the body, and a body naming another user is
+// rejected rather than silently redirected.
+func (s *Server) handleCreateWithdrawal(w http.ResponseWriter, r *http.Request, userID int64) {
     var req createWithdrawalRequest
 
     dec := json.NewDecoder(r.Body)
     dec.DisallowUnknownFields()
     if err := dec.Decode(&req); err != nil {
+        reason := decodeErrorCode(err)
         s.logEvent("withdrawal_create_failed", map[string]any{
-            "reason": "invalid_request",
+            "reason": reason,
         })
-        writeError(w, http.StatusBadRequest, "invalid_request")
+        writeError(w, http.StatusBadRequest, reason)
         return
     }
     if err := dec.Decode(&struct{}{}); err != io.EOF {
@@ -178,58 +1063,176 @@ func (s *Server) handleCreateWithdrawal(w http.ResponseWriter, r *http.Request)
         writeError(w, http.StatusBadRequest, "invalid_request")
         return
     }
+    if userID != 0 {
+        if req.UserID != 0 && int64(req.UserID) != userID {
+            s.logEvent("withdrawal_create_failed", map[string]any{
+                "reason":  "user_id_mismatch",
+                "user_id": userID,
+            })
+            writeError(w, http.StatusBadRequest, "user_id_mismatch")
+            return
+        }
+        req.UserID = Integer(userID)
+    }
+
+    // A decimal amount is in whole units of the currency. Without a known
+    // currency it cannot be converted, and only the currency is reported.
+    exponent, known := s.currencyExponent(strings.ToUpper(req.Currency))
+    if known {
+        if err := req.Amount.Scale(exponent); err != nil {
+            code := decodeErrorCode(err)
+            s.logEvent("withdrawal_create_failed", map[string]any{
+                "reason": code,
+            })
+            writeError(w, http.StatusBadRequest, code)
+            return
+        }
+    }
 
-    if err := validateCreateWithdrawal(req); err != nil {
+    input, err := s.store.NormalizeWithdrawalInput(store.CreateWithdrawalInput{
+        UserID:         int64(req.UserID),
+        Amount:         req.Amount.Value,
+        Currency:       req.Currency,
+        Destination:    req.Destination,
+        IdempotencyKey: req.IdempotencyKey,
+        CreatedByKey:   actorFromContext(r.Context()),
+    })
+    var fields []store.FieldError
+    var invalid *store.InvalidInputError
+    if errors.As(err, &invalid) {
+        fields = invalid.Fields
+    }
+    for _, f := range s.validateCreateWithdrawal(input) {
+        if f.Field == "amount" && req.Amount.Decimal() {
+            continue
+        }
+        if !hasFieldError(fields, f.Field) {
+            fields = append(fields, f)
+        }
+    }
+    if len(fields) == 0 && !s.idempotencyKeyPattern.MatchString(input.IdempotencyKey) {
+        fields = append(fields, store.FieldError{Field: "idempotency_key", Reason: store.ReasonInvalidFormat})
+    }
+    if len(fields) > 0 {
+        // A request whose only fault is its key keeps the code clients
+        // already retry with a new key on.
+        reason := "invalid_idempotency_key"
+        for _, f := range fields {
+            if f.Field != "idempotency_key" {
+                reason = "invalid_request"
+            }
+        }
+        details := fieldErrorDetails(fields)
+        if slices.Contains(fields, store.FieldError{Field: "currency", Reason: store.ReasonUnsupported}) {
+            reason = "unsupported_currency"
+            details.SupportedCurrencies = s.store.SupportedCurrencies()
+        }
         s.logEvent("withdrawal_create_failed", map[string]any{
-            "reason":  "invalid_request",
+            "reason":  reason,
             "user_id": req.UserID,
         })
-        writeError(w, http.StatusBadRequest, "invalid_request")
+        writeErrorResponse(w, http.StatusBadRequest, errorResponse{
+            Code:    reason,
+            Details: details,
+        })
         return
     }
 
-    input := store.CreateWithdrawalInput{
-        UserID:         req.UserID,
-        Amount:         req.Amount,
-        Currency:       strings.TrimSpace(req.Currency),
-        Destination:    strings.TrimSpace(req.Destination),
-        IdempotencyKey: strings.TrimSpace(req.IdempotencyKey),
-    }
-
-    withdrawal, err := s.store.CreateWithdrawal(r.Context(), input)
+    ctx := withAudit(r, "withdrawal.create", "withdrawal", func(created store.Withdrawal) (string, map[string]any) {
+        return strconv.FormatInt(created.ID, 10), map[string]any{
+            "user_id":         created.UserID,
+            "amount":          created.Amount,
+            "fee":             created.Fee,
+            "currency":        created.Currency,
+            "destination":     redact(created.Destination),
+            "idempotency_key": redact(created.IdempotencyKey),
+        }
+    })
+    result, err := s.store.CreateWithdrawal(ctx, input)
     if err != nil {
         reason := "internal_error"
         switch {
         case errors.Is(err, store.ErrInsufficientBalance):
             reason = "insufficient_balance"
-            writeError(w, http.StatusConflict, "insufficient_balance")
+            resp := errorResponse{Code: reason, Message: err.Error()}
+            var insufficient *store.InsufficientBalanceError
+            if errors.As(err, &insufficient) {
+                details := insufficientBalanceDetails{
+                    Balance:   balanceAmount(insufficient.Balance),
+                    Requested: balanceAmount(insufficient.Requested),
+                    Shortfall: balanceAmount(insufficient.Shortfall()),
+                }
+                if insufficient.Overdraft > 0 {
+                    overdraft := balanceAmount(insufficient.Overdraft)
+                    details.Overdraft = &overdraft
+                }
+                resp.Details = details
+            }
+            writeErrorResponse(w, http.StatusConflict, resp)
         case errors.Is(err, store.ErrIdempotencyConflict):
             reason = "idempotency_conflict"
-            writeError(w, http.StatusUnprocessableEntity, "idempotency_conflict")
+            resp := errorResponse{Code: reason}
+            var conflict *store.IdempotencyConflictError
+            if errors.As(err, &conflict) {
+                resp.ExistingWithdrawalID = conflict.Existing.ID
+                amount := amountIn(conflict.Existing.Amount, conflict.Existing.Currency)
+                resp.ExistingAmount = &amount
+                resp.ExistingCurrency = conflict.Existing.Currency
+                resp.ExistingIdempotencyKey = conflict.Existing.IdempotencyKey
+            }
+            writeErrorResponse(w, http.StatusUnprocessableEntity, resp)
         case errors.Is(err, store.ErrUserNotFound):
             reason = "user_not_found"
             writeError(w, http.StatusNotFound, "user_not_found")
+        case errors.Is(err, store.ErrTenantMismatch):
+            reason = "forbidden"
+            writeError(w, http.StatusForbidden, "forbidden")
+        case errors.Is(err, store.ErrTooManyPending):
+            reason = "too_many_pending"
+            writeError(w, http.StatusConflict, "too_many_pending")
+        case errors.Is(err, store.ErrDestinationBlacklisted):
+            reason = "destination_blacklisted"
+            writeError(w, http.StatusForbidden, "destination_blacklisted")
         default:
             s.logger.Printf("create withdrawal error: %v", err)
             writeError(w, http.StatusInternalServerError, "internal_error")
         }
         s.logEvent("withdrawal_create_failed", map[string]any{
-            "reason":  reason,
-            "user_id": input.UserID,
-            "amount":  input.Amount,
-            "currency": input.Currency,
+            "reason":      reason,
+            "user_id":     input.UserID,
+            "amount":      input.Amount,
+            "currency":    input.Currency,
+            "destination": input.Destination,
         })
         return
     }
+    withdrawal := result.Withdrawal
 
+    setSpanAttributes(r,
+        attribute.Int64("withdrawal_id", withdrawal.ID),
+        attribute.Int64("user_id", withdrawal.UserID),
+    )
     s.logEvent("withdrawal_created", map[string]any{
         "withdrawal_id": withdrawal.ID,
         "user_id":       withdrawal.UserID,
         "amount":        withdrawal.Amount,
         "currency":      withdrawal.Currency,
+        "destination":   withdrawal.Destination,
         "status":        withdrawal.Status,
+        "replayed":      withdrawal.Replayed,
     })
-    writeJSON(w, http.StatusCreated, toWithdrawalResponse(withdrawal))
+
+    status := http.StatusCreated
+    if withdrawal.Replayed {
+        w.Header().Set("Idempotency-Replayed", "true")
+        if s.replayStatusOK {
+            status = http.StatusOK
+        }
+    }
+    resp := toWithdrawalResponse(withdrawal)
+    balance := balanceAmount(result.Balance)
+    resp.ResultingBalance = &balance
+    writeJSON(w, status, resp)
 }
 
 func (s *Server) handleConfirmWithdrawal(w http.ResponseWriter, r *http.Request, id int64) {
@@ -237,17 +1240,84 @@ func (s *Server) handleConfirmWithdrawal(w http.ResponseWriter, r *http.Request,
         writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
         return
     }
+    setSpanAttributes(r, attribute.Int64("withdrawal_id", id))
 
-    withdrawal, err := s.store.ConfirmWithdrawal(r.Context(), id)
+    operator, err := s.operatorFromRequest(r)
+    if err != nil {
+        reason := "invalid_operator"
+        if errors.Is(err, errOperatorRequired) {
+            reason = "operator_required"
+        }
+        s.logEvent("withdrawal_confirm_failed", map[string]any{
+            "withdrawal_id": id,
+            "reason":        reason,
+        })
+        writeError(w, http.StatusBadRequest, reason)
+        return
+    }
+
+    version, err := parseIfMatch(r.Header.Get("If-Match"))
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_version", err.Error())
+        return
+    }
+    var req confirmWithdrawalRequest
+    if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if req.ExpectedVersion != nil {
+        if *req.ExpectedVersion <= 0 || (version != 0 && version != *req.ExpectedVersion) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_version", "expected_version must be a positive integer matching If-Match")
+            return
+        }
+        version = *req.ExpectedVersion
+    }
+
+    ctx := withAudit(r, "withdrawal.confirm", "withdrawal", func(confirmed store.Withdrawal) (string, map[string]any) {
+        return strconv.FormatInt(confirmed.ID, 10), map[string]any{
+            "user_id":  confirmed.UserID,
+            "operator": operator,
+        }
+    })
+    if s.confirmByCreatingKey {
+        ctx = store.WithOriginKey(ctx, actorFromContext(ctx))
+    }
+    var withdrawal store.Withdrawal
+    if version != 0 {
+        withdrawal, err = s.store.ConfirmWithdrawalIfVersion(ctx, id, version)
+    } else {
+        withdrawal, err = s.store.ConfirmWithdrawal(ctx, id)
+    }
     if err != nil {
         reason := "internal_error"
+        var conflict *store.VersionConflictError
         switch {
         case errors.Is(err, store.ErrNotFound):
             reason = "not_found"
             writeError(w, http.StatusNotFound, "not_found")
+        case errors.Is(err, store.ErrTenantMismatch), errors.Is(err, store.ErrOriginKeyMismatch):
+            reason = "forbidden"
+            writeError(w, http.StatusForbidden, "forbidden")
+        case errors.Is(err, store.ErrReservationExpired):
+            // The sweeper may not have marked it yet, but the hold is gone.
+            reason = "reservation_expired"
+            writeErrorResponse(w, http.StatusConflict, errorResponse{Code: reason, CurrentStatus: store.StatusExpired})
+        case errors.As(err, &conflict):
+            reason = "version_conflict"
+            w.Header().Set("ETag", withdrawalETag(conflict.Current))
+            writeErrorResponse(w, http.StatusPreconditionFailed, errorResponse{
+                Code:          reason,
+                CurrentStatus: conflict.Current.Status,
+                Details:       toWithdrawalResponse(conflict.Current),
+            })
         case errors.Is(err, store.ErrInvalidStatus):
             reason = "invalid_status"
-            writeError(w, http.StatusConflict, "invalid_status")
+            resp := errorResponse{Code: reason}
+            if current, err := s.store.GetWithdrawal(r.Context(), id); err == nil {
+                resp.CurrentStatus = current.Status
+            }
+            writeErrorResponse(w, http.StatusConflict, resp)
         default:
             s.logger.Printf("confirm withdrawal error: %v", err)
             writeError(w, http.StatusInternalServerError, "internal_error")
@@ -255,35 +1325,122 @@ func (s *Server) handleConfirmWithdrawal(w http.ResponseWriter, r *http.Request,
         s.logEvent("withdrawal_confirm_failed", map[string]any{
             "withdrawal_id": id,
             "reason":        reason,
+            "operator":      operator,
         })
         return
     }
 
+    setSpanAttributes(r, attribute.Int64("user_id", withdrawal.UserID))
     s.logEvent("withdrawal_confirmed", map[string]any{
         "withdrawal_id": withdrawal.ID,
         "user_id":       withdrawal.UserID,
         "status":        withdrawal.Status,
+        "operator":      operator,
     })
+    w.Header().Set("ETag", withdrawalETag(withdrawal))
     writeJSON(w, http.StatusOK, toWithdrawalResponse(withdrawal))
 }
 
-func validateCreateWithdrawal(req createWithdrawalRequest) error {
-    if req.UserID <= 0 {
-        return errors.New("invalid user_id")
+// handleTouchWithdrawal lets an external processor mark a pending withdrawal
+// as being worked on, so it does not show up as stale.
+func (s *Server) handleTouchWithdrawal(w http.ResponseWriter, r *http.Request, id int64) {
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
     }
-    if req.Amount <= 0 {
-        return errors.New("invalid amount")
+    setSpanAttributes(r, attribute.Int64("withdrawal_id", id))
+
+    fail := func(err error) {
+        if errors.Is(err, store.ErrNotFound) {
+            writeError(w, http.StatusNotFound, "not_found")
+            return
+        }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
+        s.logger.Printf("touch withdrawal error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
     }
-    if strings.TrimSpace(req.Currency) != "USDT" {
-        return errors.New("invalid currency")
+
+    // The withdrawal is looked up before the throttle, so a touch that
+    // could not succeed, e.g. for a mistyped or foreign id, does not use up
+    // the slot of the withdrawal it names.
+    withdrawal, err := s.store.GetWithdrawal(r.Context(), id)
+    if err == nil && withdrawal.Status != store.StatusPending {
+        err = store.ErrNotFound
     }
-    if strings.TrimSpace(req.Destination) == "" {
-        return errors.New("invalid destination")
+    if err != nil {
+        fail(err)
+        return
     }
-    if strings.TrimSpace(req.IdempotencyKey) == "" {
-        return errors.New("invalid idempotency_key")
+    if ok, wait := s.touchThrottle.allow(id); !ok {
+        writeRetryError(w, http.StatusTooManyRequests, "rate_limited", wait)
+        return
     }
-    return nil
+
+    ctx := withAudit(r, "withdrawal.touch", "withdrawal", func(touched store.Withdrawal) (string, map[string]any) {
+        return strconv.FormatInt(touched.ID, 10), map[string]any{"user_id": touched.UserID}
+    })
+    if err := s.store.TouchWithdrawal(ctx, id); err != nil {
+        fail(err)
+        return
+    }
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// validateCreateWithdrawal checks the fields Store.NormalizeWithdrawalInput
+// leaves to the API: the user, and the amount against the currency's limits
+// in the registry. A supported currency the registry does not know, or has
+// disabled, is unsupported all the same.
+func (s *Server) validateCreateWithdrawal(input store.CreateWithdrawalInput) []store.FieldError {
+    var fields []store.FieldError
+    if input.UserID <= 0 {
+        fields = append(fields, store.FieldError{Field: "user_id", Reason: "not_positive"})
+    }
+    if input.Amount <= 0 {
+        fields = append(fields, store.FieldError{Field: "amount", Reason: "not_positive"})
+    }
+    if input.Currency == "" {
+        return fields
+    }
+    currency, ok := s.currency(input.Currency)
+    if !ok || !currency.Enabled {
+        return append(fields, store.FieldError{Field: "currency", Reason: store.ReasonUnsupported})
+    }
+    if input.Amount > 0 && (input.Amount < currency.Min || input.Amount > currency.Max) {
+        fields = append(fields, store.FieldError{Field: "amount", Reason: "out_of_range"})
+    }
+    return fields
+}
+
+func hasFieldError(fields []store.FieldError, field string) bool {
+    for _, f := range fields {
+        if f.Field == field {
+            return true
+        }
+    }
+    return false
+}
+
+type fieldErrorResponse struct {
+    Field  string `json:"field"`
+    Reason string `json:"reason"`
+}
+
+type fieldErrorsDetails struct {
+    Fields              []fieldErrorResponse `json:"fields"`
+    SupportedCurrencies []string             `json:"supported_currencies,omitempty"`
+}
+
+// fieldErrorDetails lists the rejected fields of a request in the order they
+// were found.
+func fieldErrorDetails(fields []store.FieldError) fieldErrorsDetails {
+    details := fieldErrorsDetails{Fields: make([]fieldErrorResponse, len(fields))}
+    for i, f := range fields {
+        details.Fields[i] = fieldErrorResponse{Field: f.Field, Reason: f.Reason}
+    }
+    return details
 }
 
 func validateCreateUser(req createUserRequest) error {
@@ -293,26 +1450,101 @@ func validateCreateUser(req createUserRequest) error {
     if req.Balance < 0 {
         return errors.New("invalid balance")
     }
+    if req.ExternalID != nil && (strings.TrimSpace(*req.ExternalID) != *req.ExternalID || *req.ExternalID == "" || len(*req.ExternalID) > maxExternalIDLength) {
+        return errors.New("invalid external_id")
+    }
     return nil
 }
 
+// withdrawalETag is the withdrawal's version, which changes with every update
+// to the row, not only with status transitions.
+func withdrawalETag(w store.Withdrawal) string {
+    return fmt.Sprintf(`"%d"`, w.Version)
+}
+
+// parseIfMatch returns the version named by an If-Match header holding a
+// single withdrawal ETag. The tags of GET's ledger and user variants name
+// the same version followed by ":ledger…" or ":user:…", which is dropped.
+// An empty header or "*" names no version and returns 0.
+func parseIfMatch(header string) (int64, error) {
+    header = strings.TrimSpace(header)
+    if header == "" || header == "*" {
+        return 0, nil
+    }
+    unquoted, err := strconv.Unquote(header)
+    if err != nil {
+        return 0, fmt.Errorf("invalid If-Match %q", header)
+    }
+    unquoted, variant, found := strings.Cut(unquoted, ":")
+    if found && variant != "ledger" && !strings.HasPrefix(variant, "ledger:") && !strings.HasPrefix(variant, "user:") {
+        return 0, fmt.Errorf("invalid If-Match %q", header)
+    }
+    version, err := strconv.ParseInt(unquoted, 10, 64)
+    if err != nil || version <= 0 {
+        return 0, fmt.Errorf("invalid If-Match %q", header)
+    }
+    return version, nil
+}
+
+// etagMatches implements the If-None-Match comparison: "*" or any listed tag,
+// compared weakly.
+func etagMatches(header, etag string) bool {
+    if header == "" {
+        return false
+    }
+    for _, candidate := range strings.Split(header, ",") {
+        candidate = strings.TrimSpace(candidate)
+        if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+            return true
+        }
+    }
+    return false
+}
+
 func toWithdrawalResponse(w store.Withdrawal) withdrawalResponse {
     return withdrawalResponse{
         ID:             w.ID,
         UserID:         w.UserID,
-        Amount:         w.Amount,
+        Amount:         amountIn(w.Amount, w.Currency),
+        Fee:            amountIn(w.Fee, w.Currency),
         Currency:       w.Currency,
         Destination:    w.Destination,
         Status:         w.Status,
         IdempotencyKey: w.IdempotencyKey,
+        ReservedUntil:  w.ReservedUntil,
         CreatedAt:      w.CreatedAt,
+        UpdatedAt:      w.UpdatedAt,
+        ConfirmedAt:    w.ConfirmedAt,
+        NoteCount:      w.NoteCount,
+        ReversalReason: w.ReversalReason,
+        Version:        w.Version,
+        CreatedByKey:   w.CreatedByKey,
+        ExternalTxHash: w.ExternalTxHash,
     }
 }
 
+func toUserStatsResponse(st store.UserStats) *userStatsResponse {
+    resp := &userStatsResponse{
+        WithdrawalCount: st.WithdrawalCount,
+        TotalWithdrawn:  balanceAmount(st.TotalWithdrawn),
+        PendingAmount:   balanceAmount(st.PendingAmount),
+        ByStatus:        make(map[string]statusStatsResponse, len(st.ByStatus)),
+    }
+    for status, s := range st.ByStatus {
+        resp.ByStatus[status] = statusStatsResponse{Count: s.Count, Amount: balanceAmount(s.Amount)}
+    }
+    return resp
+}
+
 func toUserResponse(u store.User) userResponse {
     return userResponse{
-        ID:        u.ID,
-        Balance:   u.Balance,
-        CreatedAt: u.CreatedAt,
+        ID:         u.ID,
+        Balance:    balanceAmount(u.Balance),
+        Tier:       u.Tier,
+        ExternalID: u.ExternalID,
+        CreatedAt:  u.CreatedAt,
+        UpdatedAt:  u.UpdatedAt,
+
+        OverdraftLimit: balanceAmount(u.OverdraftLimit),
     }
 }
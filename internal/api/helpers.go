@@ -1,20 +1,150 @@
 package api
 
 import (
+    "bytes"
     "encoding/json"
+    "math"
     "net/http"
+    "strconv"
+    "time"
 )
 
+// errorResponse is the body of every error. Error repeats Code so clients of
+// the original {"error":"<code>"} body keep working for one deprecation
+// cycle; new clients should read Code.
 type errorResponse struct {
-    Error string `json:"error"`
+    Error         string `json:"error"`
+    Code          string `json:"code"`
+    Message       string `json:"message"`
+    RequestID     string `json:"request_id,omitempty"`
+    CurrentStatus string `json:"current_status,omitempty"`
+
+    // RetryAfterSeconds is set on throttled and busy responses and repeated
+    // in the Retry-After header.
+    RetryAfterSeconds int `json:"retry_after_seconds,omitempty"`
+
+    // Set on idempotency_conflict, describing the withdrawal the key belongs to.
+    ExistingWithdrawalID   int64        `json:"existing_withdrawal_id,omitempty"`
+    ExistingAmount         *minorAmount `json:"existing_amount,omitempty"`
+    ExistingCurrency       string       `json:"existing_currency,omitempty"`
+    ExistingIdempotencyKey string       `json:"existing_idempotency_key,omitempty"`
+
+    Details any `json:"details,omitempty"`
+}
+
+type insufficientBalanceDetails struct {
+    Balance   minorAmount  `json:"balance"`
+    Requested minorAmount  `json:"requested"`
+    Shortfall minorAmount  `json:"shortfall"`
+    Overdraft *minorAmount `json:"overdraft_limit,omitempty"`
+}
+
+var errorMessages = map[string]string{
+    "amount_not_integer":         "amounts and ids must be whole numbers of minor units, without an exponent",
+    "amount_out_of_range":        "number is outside the range of a 64-bit integer",
+    "external_id_exists":         "external_id is already used by another user",
+    "forbidden":                  "resource belongs to another tenant",
+    "idempotency_conflict":       "idempotency key was already used with a different payload",
+    "insufficient_balance":       "balance is too low for the requested amount and fee",
+    "internal_error":             "internal error",
+    "invalid_amount":             "amount must be an integer number of minor units",
+    "invalid_amount_format":      "amount_format must be minor or decimal",
+    "invalid_batch_size":         "batch must contain between 1 and 1000 users",
+    "invalid_embed":              "embed supports only user",
+    "invalid_filter":             "invalid filter",
+    "invalid_id":                 "id must be a positive integer",
+    "invalid_idempotency_key":    "idempotency_key must be 1 to 128 printable ASCII characters",
+    "invalid_ids":                "ids must be a comma-separated list of at most 500 positive integers",
+    "invalid_include":            "include supports only stats",
+    "invalid_note":               "text must be 1 to 2000 characters",
+    "invalid_older_than":         "older_than must be a non-negative duration such as 30m",
+    "invalid_older_than_days":    "older_than_days must be an integer from 1 to 36500",
+    "invalid_older_than_minutes": "older_than_minutes must be a non-negative number",
+    "invalid_operator":           "X-Operator must be at most 64 characters",
+    "invalid_overdraft_limit":    "overdraft_limit must be a non-negative integer number of minor units",
+    "invalid_reason":             "reason must be 1 to 500 characters",
+    "invalid_request":            "invalid request",
+    "invalid_sort":               "sort must be one of the allowed values",
+    "invalid_status":             "withdrawal is not in a status that allows this operation",
+    "invalid_tenant_token":       "X-Tenant-Token must be a valid HS256 JWT with a positive tid claim",
+    "invalid_tier":               "tier must be one of standard, premium, enterprise",
+    "invalid_tx_hash":            "tx_hash must be 1 to 128 characters",
+    "invalid_version":            "If-Match must be a single withdrawal ETag and expected_version a positive integer",
+    "maintenance":                "the service is in maintenance mode and accepts only reads",
+    "method_not_allowed":         "method not allowed",
+    "not_found":                  "not found",
+    "not_ready":                  "service is not ready",
+    "operator_required":          "X-Operator header is required",
+    "overdraft_in_use":           "balance is already below the requested overdraft limit",
+    "rate_limited":               "too many requests, retry later",
+    "reservation_expired":        "withdrawal reservation has expired",
+    "shutting_down":              "service is shutting down",
+    "too_many_pending":           "too many pending withdrawals for this user",
+    "tx_hash_conflict":           "withdrawal already has a different transaction hash",
+    "unauthorized":               "missing or invalid token",
+    "unsupported_currency":       "currency is not supported",
+    "user_exists":                "user already exists",
+    "user_id_mismatch":           "user_id in the body does not match the user in the path",
+    "user_not_found":             "user not found",
+    "version_conflict":           "withdrawal was updated since the expected version",
 }
 
+// writeJSON encodes v into a buffer first, so that the body can be signed
+// for partners with a response signing key.
 func writeJSON(w http.ResponseWriter, status int, v any) {
+    if f, ok := decimalFormat(w); ok {
+        v = withDecimalAmounts(v, f.exponent)
+    }
+    var buf bytes.Buffer
+    _ = json.NewEncoder(&buf).Encode(v)
     w.Header().Set("Content-Type", "application/json")
+    if sw, ok := w.(*signingWriter); ok {
+        w.Header().Set(signatureHeader, signBody(sw.key, buf.Bytes()))
+    }
     w.WriteHeader(status)
-    _ = json.NewEncoder(w).Encode(v)
+    _, _ = w.Write(buf.Bytes())
 }
 
 func writeError(w http.ResponseWriter, status int, code string) {
-    writeJSON(w, status, errorResponse{Error: code})
+    writeErrorResponse(w, status, errorResponse{Code: code})
+}
+
+// writeErrorMessage is writeError with a message specific to this failure,
+// e.g. one naming the offending value.
+func writeErrorMessage(w http.ResponseWriter, status int, code, message string) {
+    writeErrorResponse(w, status, errorResponse{Code: code, Message: message})
+}
+
+// writeRetryError is writeError for a request that may succeed if retried
+// after wait, such as a throttled or busy one.
+func writeRetryError(w http.ResponseWriter, status int, code string, wait time.Duration) {
+    writeErrorResponse(w, status, errorResponse{Code: code, RetryAfterSeconds: retryAfterSeconds(wait)})
+}
+
+// retryAfterSeconds rounds wait up to whole seconds, and to at least one, so
+// a client honouring it never retries too early.
+func retryAfterSeconds(wait time.Duration) int {
+    seconds := int(math.Ceil(wait.Seconds()))
+    if seconds < 1 {
+        return 1
+    }
+    return seconds
+}
+
+// writeErrorResponse fills in the legacy error field, a default message and
+// the request id set by requestIDMiddleware, and sets Retry-After from
+// RetryAfterSeconds.
+func writeErrorResponse(w http.ResponseWriter, status int, resp errorResponse) {
+    if resp.RetryAfterSeconds > 0 {
+        w.Header().Set("Retry-After", strconv.Itoa(resp.RetryAfterSeconds))
+    }
+    resp.Error = resp.Code
+    if resp.Message == "" {
+        resp.Message = errorMessages[resp.Code]
+    }
+    if resp.Message == "" {
+        resp.Message = http.StatusText(status)
+    }
+    resp.RequestID = w.Header().Get("X-Request-ID")
+    writeJSON(w, status, resp)
 }
@@ -0,0 +1,321 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+const (
+    ndjsonContentType = "application/x-ndjson"
+    // ndjsonFlushEvery is how many streamed lines are buffered between flushes.
+    ndjsonFlushEvery = 100
+    // ndjsonWriteWindow is how long the client gets to take each flushed
+    // batch. The deadline moves with every flush, so a stream longer than
+    // the server's write_timeout is not cut off while the client keeps up.
+    ndjsonWriteWindow = 30 * time.Second
+)
+
+type adminLedgerEntryResponse struct {
+    ID           int64       `json:"id"`
+    UserID       int64       `json:"user_id"`
+    WithdrawalID int64       `json:"withdrawal_id,omitempty"`
+    Amount       minorAmount `json:"amount"`
+    Currency     string      `json:"currency"`
+    Direction    string      `json:"direction"`
+    Reason       string      `json:"reason,omitempty"`
+    CreatedAt    time.Time   `json:"created_at"`
+    // RunningSum is the net balance effect of this and all preceding entries
+    // of the response in the entry's currency: credits add, debits and fees
+    // subtract.
+    RunningSum minorAmount `json:"running_sum"`
+}
+
+type adminLedgerPageResponse struct {
+    Entries []adminLedgerEntryResponse `json:"entries"`
+    // PageSums is the net balance effect of the page per currency, the last
+    // RunningSum in each.
+    PageSums map[string]minorAmount `json:"page_sums"`
+    // NextCursor is passed as after to fetch the next page. It is omitted on
+    // the last page.
+    NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// signedAmount is the effect of e on the user's balance.
+func signedAmount(e store.LedgerEntry) int64 {
+    switch e.Direction {
+    case store.DirectionCredit:
+        return e.Amount
+    case store.DirectionSettlement:
+        return 0
+    }
+    return -e.Amount
+}
+
+func toAdminLedgerEntryResponse(e store.LedgerEntry, runningSum int64) adminLedgerEntryResponse {
+    return adminLedgerEntryResponse{
+        ID:           e.ID,
+        UserID:       e.UserID,
+        WithdrawalID: e.WithdrawalID,
+        Amount:       amountIn(e.Amount, e.Currency),
+        Currency:     e.Currency,
+        Direction:    e.Direction,
+        Reason:       e.Reason,
+        CreatedAt:    e.CreatedAt,
+        RunningSum:   amountIn(runningSum, e.Currency),
+    }
+}
+
+// handleAdminLedger lets reconciliation slice the whole ledger. Clients that
+// send Accept: application/x-ndjson get every matching entry streamed one
+// per line instead of a page.
+func (s *Server) handleAdminLedger(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    filter, err := parseLedgerFilter(r.URL.Query())
+    if err == nil {
+        err = filter.Validate()
+    }
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        return
+    }
+
+    if strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+        s.streamAdminLedger(w, r, filter)
+        return
+    }
+
+    entries, err := s.store.ListLedgerEntriesAdmin(r.Context(), filter)
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidFilter) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+            return
+        }
+        s.logger.Printf("list ledger entries error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := adminLedgerPageResponse{
+        Entries:  make([]adminLedgerEntryResponse, 0, len(entries)),
+        PageSums: map[string]minorAmount{},
+    }
+    pageSums := map[string]int64{}
+    for _, e := range entries {
+        pageSums[e.Currency] += signedAmount(e)
+        resp.Entries = append(resp.Entries, toAdminLedgerEntryResponse(e, pageSums[e.Currency]))
+    }
+    for currency, sum := range pageSums {
+        resp.PageSums[currency] = amountIn(sum, currency)
+    }
+    limit := filter.Limit
+    if limit == 0 {
+        limit = store.DefaultListLimit
+    }
+    if len(entries) == limit {
+        resp.NextCursor = entries[len(entries)-1].Cursor().String()
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// streamAdminLedger writes every entry matching filter as NDJSON. Once the
+// first line is out the status can no longer change, so a failure midway is
+// only logged and the stream is cut short.
+func (s *Server) streamAdminLedger(w http.ResponseWriter, r *http.Request, filter store.LedgerFilter) {
+    rc := http.NewResponseController(w)
+    _ = rc.SetWriteDeadline(time.Now().Add(ndjsonWriteWindow))
+    enc := json.NewEncoder(w)
+    decimal, isDecimal := decimalFormat(w)
+    sums := map[string]int64{}
+    var written int
+    err := s.store.StreamLedgerEntriesAdmin(r.Context(), filter, func(e store.LedgerEntry) error {
+        if written == 0 {
+            w.Header().Set("Content-Type", ndjsonContentType)
+            w.WriteHeader(http.StatusOK)
+        }
+        sums[e.Currency] += signedAmount(e)
+        var line any = toAdminLedgerEntryResponse(e, sums[e.Currency])
+        if isDecimal {
+            line = withDecimalAmounts(line, decimal.exponent)
+        }
+        if err := enc.Encode(line); err != nil {
+            return err
+        }
+        written++
+        if written%ndjsonFlushEvery == 0 {
+            _ = rc.Flush()
+            _ = rc.SetWriteDeadline(time.Now().Add(ndjsonWriteWindow))
+        }
+        return nil
+    })
+    if err != nil {
+        s.logger.Printf("stream ledger entries error: %v", err)
+        if written == 0 {
+            writeError(w, http.StatusInternalServerError, "internal_error")
+        }
+        return
+    }
+    if written == 0 {
+        w.Header().Set("Content-Type", ndjsonContentType)
+        w.WriteHeader(http.StatusOK)
+    }
+}
+
+func parseLedgerFilter(q url.Values) (store.LedgerFilter, error) {
+    filter := store.LedgerFilter{
+        Direction: q.Get("direction"),
+        Currency:  q.Get("currency"),
+    }
+    for _, p := range []struct {
+        key string
+        dst *int64
+    }{
+        {"user_id", &filter.UserID},
+        {"withdrawal_id", &filter.WithdrawalID},
+    } {
+        raw := q.Get(p.key)
+        if raw == "" {
+            continue
+        }
+        n, err := strconv.ParseInt(raw, 10, 64)
+        if err != nil || n <= 0 {
+            return store.LedgerFilter{}, fmt.Errorf("invalid %s %q", p.key, raw)
+        }
+        *p.dst = n
+    }
+    for _, p := range []struct {
+        key string
+        dst **time.Time
+    }{
+        {"from", &filter.From},
+        {"to", &filter.To},
+    } {
+        raw := q.Get(p.key)
+        if raw == "" {
+            continue
+        }
+        t, err := time.Parse(time.RFC3339Nano, raw)
+        if err != nil {
+            return store.LedgerFilter{}, fmt.Errorf("invalid %s %q", p.key, raw)
+        }
+        *p.dst = &t
+    }
+    if raw := q.Get("after"); raw != "" {
+        cursor, err := store.ParseLedgerCursor(raw)
+        if err != nil {
+            return store.LedgerFilter{}, fmt.Errorf("invalid after %q", raw)
+        }
+        filter.After = &cursor
+    }
+    if raw := q.Get("limit"); raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n <= 0 {
+            return store.LedgerFilter{}, fmt.Errorf("invalid limit %q", raw)
+        }
+        filter.Limit = n
+    }
+    return filter, nil
+}
+
+type ledgerSummaryResponse struct {
+    UserID  int64       `json:"user_id"`
+    Count   int64       `json:"count"`
+    Debits  minorAmount `json:"debits"`
+    Credits minorAmount `json:"credits"`
+    Net     minorAmount `json:"net"`
+}
+
+// handleLedgerSummary reports a user's ledger totals, optionally bounded by
+// from/to (RFC 3339) as [from, to).
+func (s *Server) handleLedgerSummary(w http.ResponseWriter, r *http.Request, userID int64) {
+    var filter store.LedgerSummaryFilter
+    q := r.URL.Query()
+    for _, p := range []struct {
+        key string
+        dst **time.Time
+    }{
+        {"from", &filter.From},
+        {"to", &filter.To},
+    } {
+        raw := q.Get(p.key)
+        if raw == "" {
+            continue
+        }
+        t, err := time.Parse(time.RFC3339Nano, raw)
+        if err != nil {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("invalid %s %q", p.key, raw))
+            return
+        }
+        *p.dst = &t
+    }
+
+    sum, err := s.store.GetLedgerSummary(r.Context(), userID, filter)
+    if err != nil {
+        switch {
+        case errors.Is(err, store.ErrInvalidFilter):
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        case errors.Is(err, store.ErrUserNotFound):
+            writeError(w, http.StatusNotFound, "user_not_found")
+        case errors.Is(err, store.ErrTenantMismatch):
+            writeError(w, http.StatusForbidden, "forbidden")
+        default:
+            s.logger.Printf("ledger summary error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+        }
+        return
+    }
+    writeJSON(w, http.StatusOK, ledgerSummaryResponse{
+        UserID:  userID,
+        Count:   sum.Count,
+        Debits:  balanceAmount(sum.Debits),
+        Credits: balanceAmount(sum.Credits),
+        Net:     balanceAmount(sum.Net),
+    })
+}
+
+type feeTotalResponse struct {
+    Currency  string      `json:"currency"`
+    FeeCount  int64       `json:"fee_count"`
+    TotalFees minorAmount `json:"total_fees"`
+}
+
+type feeSummaryResponse struct {
+    UserID int64              `json:"user_id"`
+    Fees   []feeTotalResponse `json:"fees"`
+}
+
+func (s *Server) handleFeeSummary(w http.ResponseWriter, r *http.Request, userID int64) {
+    sums, err := s.store.GetFeeSummary(r.Context(), userID)
+    if err != nil {
+        switch {
+        case errors.Is(err, store.ErrUserNotFound):
+            writeError(w, http.StatusNotFound, "user_not_found")
+        case errors.Is(err, store.ErrTenantMismatch):
+            writeError(w, http.StatusForbidden, "forbidden")
+        default:
+            s.logger.Printf("fee summary error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+        }
+        return
+    }
+    resp := feeSummaryResponse{UserID: userID, Fees: make([]feeTotalResponse, 0, len(sums))}
+    for _, sum := range sums {
+        resp.Fees = append(resp.Fees, feeTotalResponse{
+            Currency:  sum.Currency,
+            FeeCount:  sum.Count,
+            TotalFees: amountIn(sum.Total, sum.Currency),
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+    "context"
+    "net/http"
+    "time"
+)
+
+type DrainStats struct {
+    Drained   int64
+    Abandoned int64
+}
+
+// BaseContext is the parent of every request context. It is cancelled only
+// after in-flight requests have drained or the shutdown deadline has passed.
+func (s *Server) BaseContext() context.Context {
+    return s.baseCtx
+}
+
+func (s *Server) Ready() bool {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return !s.draining
+}
+
+// Shutdown stops accepting new requests, waits for in-flight ones until ctx
+// is done and then cancels the base context.
+func (s *Server) Shutdown(ctx context.Context) DrainStats {
+    s.mu.Lock()
+    s.draining = true
+    pending := s.inFlight.Load()
+    s.mu.Unlock()
+
+    s.logEvent("shutdown_started", map[string]any{
+        "in_flight": pending,
+    })
+
+    done := make(chan struct{})
+    go func() {
+        s.wg.Wait()
+        close(done)
+    }()
+
+    var stats DrainStats
+    select {
+    case <-done:
+        stats.Drained = pending
+    case <-ctx.Done():
+        stats.Abandoned = s.inFlight.Load()
+        stats.Drained = pending - stats.Abandoned
+    }
+    s.cancelBase()
+
+    s.logEvent("shutdown_completed", map[string]any{
+        "drained":   stats.Drained,
+        "abandoned": stats.Abandoned,
+    })
+    return stats
+}
+
+func (s *Server) inFlightMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        s.mu.Lock()
+        if s.draining {
+            s.mu.Unlock()
+            w.Header().Set("Connection", "close")
+            // Another replica can take the request right away.
+            writeRetryError(w, http.StatusServiceUnavailable, "shutting_down", time.Second)
+            return
+        }
+        s.wg.Add(1)
+        s.inFlight.Add(1)
+        s.mu.Unlock()
+
+        defer func() {
+            s.inFlight.Add(-1)
+            s.wg.Done()
+        }()
+        next.ServeHTTP(w, r)
+    })
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+    if !s.Ready() {
+        writeError(w, http.StatusServiceUnavailable, "not_ready")
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
@@ -0,0 +1,133 @@
+package api_test
+
+import (
+    "context"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+    "time"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestShutdownRejectsNewRequests(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+    handler := srv.Routes()
+
+    rec := httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+
+    stats := srv.Shutdown(ctx)
+    if stats.Drained != 0 || stats.Abandoned != 0 {
+        t.Fatalf("expected empty drain stats, got %+v", stats)
+    }
+
+    select {
+    case <-srv.BaseContext().Done():
+    default:
+        t.Fatalf("expected base context to be cancelled")
+    }
+
+    rec = httptest.NewRecorder()
+    handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+    if rec.Code != http.StatusServiceUnavailable {
+        t.Fatalf("expected %d, got %d", http.StatusServiceUnavailable, rec.Code)
+    }
+
+    rec = httptest.NewRecorder()
+    req := httptest.NewRequest(http.MethodPost, "/v1/users", nil)
+    req.Header.Set("Authorization", "Bearer test-token")
+    handler.ServeHTTP(rec, req)
+    if rec.Code != http.StatusServiceUnavailable {
+        t.Fatalf("expected %d, got %d", http.StatusServiceUnavailable, rec.Code)
+    }
+    if rec.Header().Get("Retry-After") != "1" || !strings.Contains(rec.Body.String(), `"retry_after_seconds":1`) {
+        t.Fatalf("expected a retry hint of 1 second, got %q %s", rec.Header().Get("Retry-After"), rec.Body)
+    }
+}
+
+// slowBody is a request body that reports its first read on started and
+// then blocks until release is closed.
+type slowBody struct {
+    started chan struct{}
+    release chan struct{}
+    body    io.Reader
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+    select {
+    case <-b.started:
+    default:
+        close(b.started)
+    }
+    <-b.release
+    return b.body.Read(p)
+}
+
+func TestShutdownDrainsInFlightRequest(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+    handler := srv.Routes()
+
+    // The request is in its handler, reading the body, when shutdown starts.
+    body := &slowBody{
+        started: make(chan struct{}),
+        release: make(chan struct{}),
+        body:    strings.NewReader(`{"id":0,"balance":100}`),
+    }
+    req := httptest.NewRequest(http.MethodPost, "/v1/users", body)
+    req.Header.Set("Authorization", "Bearer test-token")
+    req.Header.Set("Content-Type", "application/json")
+    rec := httptest.NewRecorder()
+    served := make(chan struct{})
+    go func() {
+        defer close(served)
+        handler.ServeHTTP(rec, req)
+    }()
+    <-body.started
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    shutdown := make(chan api.DrainStats, 1)
+    go func() {
+        shutdown <- srv.Shutdown(ctx)
+    }()
+
+    select {
+    case stats := <-shutdown:
+        t.Fatalf("shutdown returned with a request in flight: %+v", stats)
+    case <-srv.BaseContext().Done():
+        t.Fatalf("base context cancelled with a request in flight")
+    case <-time.After(100 * time.Millisecond):
+    }
+
+    close(body.release)
+    <-served
+    // The handler ran to its own response rather than being cut off.
+    if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"invalid_request"`) {
+        t.Fatalf("expected the request to complete with 400 invalid_request, got %d %s", rec.Code, rec.Body)
+    }
+
+    select {
+    case stats := <-shutdown:
+        if stats.Drained != 1 || stats.Abandoned != 0 {
+            t.Fatalf("expected 1 drained and 0 abandoned, got %+v", stats)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatalf("shutdown did not return after the request completed")
+    }
+    select {
+    case <-srv.BaseContext().Done():
+    default:
+        t.Fatalf("expected base context to be cancelled after draining")
+    }
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+    "bytes"
+    "log"
+    "strings"
+    "testing"
+
+    "task.hh/internal/store"
+)
+
+func TestLogEventRedactsDestination(t *testing.T) {
+    const destination = "TXyz1234567890abcd"
+
+    var buf bytes.Buffer
+    s := NewServer(store.New(nil), "test-token", log.New(&buf, "", 0))
+    s.logEvent("withdrawal_created", map[string]any{
+        "withdrawal_id": int64(1),
+        "destination":   destination,
+    })
+
+    line := buf.String()
+    if strings.Contains(line, destination) {
+        t.Fatalf("raw destination leaked into log line: %s", line)
+    }
+    if !strings.Contains(line, hashForLog(destination)) {
+        t.Fatalf("expected hashed destination in log line: %s", line)
+    }
+
+    buf.Reset()
+    s = NewServer(store.New(nil), "test-token", log.New(&buf, "", 0), WithLogRedaction("user_id"))
+    s.logEvent("user_created", map[string]any{"user_id": int64(421337)})
+    if line := buf.String(); strings.Contains(line, "421337") || !strings.Contains(line, hashForLog("421337")) {
+        t.Fatalf("expected hashed user_id in log line: %s", line)
+    }
+
+    buf.Reset()
+    s = NewServer(store.New(nil), "test-token", log.New(&buf, "", 0), WithLogRedaction())
+    s.logEvent("withdrawal_created", map[string]any{"destination": destination})
+    if !strings.Contains(buf.String(), destination) {
+        t.Fatalf("expected destination to be logged as is with redaction disabled: %s", buf.String())
+    }
+}
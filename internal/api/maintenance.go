@@ -0,0 +1,138 @@
+package api
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "net/http"
+    "time"
+)
+
+const (
+    maintenancePath = "/v1/admin/maintenance"
+    // defaultMaintenanceRetryAfter is what clients are told to wait before
+    // retrying a write rejected during maintenance.
+    defaultMaintenanceRetryAfter = 60 * time.Second
+)
+
+type maintenanceRequest struct {
+    Enabled *bool `json:"enabled"`
+}
+
+type maintenanceResponse struct {
+    Enabled bool `json:"enabled"`
+}
+
+// Maintenance reports whether mutating requests are being rejected, as this
+// replica last read the mode from the store.
+func (s *Server) Maintenance() bool {
+    return s.maintenance.Load()
+}
+
+// SetMaintenance turns maintenance mode on or off in the store, so every
+// replica picks it up. While it is on, mutating requests get 503 maintenance
+// and reads keep being served.
+func (s *Server) SetMaintenance(ctx context.Context, enabled bool, actor string) error {
+    changed, err := s.store.SetMaintenance(ctx, enabled)
+    if err != nil {
+        return err
+    }
+    s.maintenance.Store(enabled)
+    if !changed {
+        return nil
+    }
+    event := "maintenance_exited"
+    if enabled {
+        event = "maintenance_entered"
+    }
+    s.logEvent(event, map[string]any{
+        "actor": actor,
+    })
+    return nil
+}
+
+// RefreshMaintenance reads the maintenance mode from the store, picking up a
+// change made through another replica.
+func (s *Server) RefreshMaintenance(ctx context.Context) error {
+    enabled, err := s.store.Maintenance(ctx)
+    if err != nil {
+        return err
+    }
+    s.maintenance.Store(enabled)
+    return nil
+}
+
+// RunMaintenanceWatcher refreshes the maintenance mode every interval until
+// ctx is done.
+func (s *Server) RunMaintenanceWatcher(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            if err := s.RefreshMaintenance(ctx); err != nil {
+                s.logger.Printf("refresh maintenance mode error: %v", err)
+            }
+        }
+    }
+}
+
+// maintenanceMiddleware rejects everything but reads while maintenance mode
+// is on. The auth middlewares apply it once the caller is authenticated, so
+// anonymous requests learn nothing about the mode. The maintenance endpoint
+// itself stays writable so the mode can be turned off.
+func (s *Server) maintenanceMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if s.maintenance.Load() && isMutating(r.Method) && r.URL.Path != maintenancePath {
+            writeRetryError(w, http.StatusServiceUnavailable, "maintenance", s.maintenanceRetryAfter)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func isMutating(method string) bool {
+    switch method {
+    case http.MethodGet, http.MethodHead, http.MethodOptions:
+        return false
+    }
+    return true
+}
+
+func (s *Server) handleAdminMaintenance(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        if err := s.RefreshMaintenance(r.Context()); err != nil {
+            s.logger.Printf("get maintenance mode error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+            return
+        }
+    case http.MethodPut:
+        var req maintenanceRequest
+        dec := json.NewDecoder(r.Body)
+        dec.DisallowUnknownFields()
+        if err := dec.Decode(&req); err != nil || req.Enabled == nil {
+            writeError(w, http.StatusBadRequest, "invalid_request")
+            return
+        }
+        if err := dec.Decode(&struct{}{}); err != io.EOF {
+            writeError(w, http.StatusBadRequest, "invalid_request")
+            return
+        }
+        ctx := withAudit(r, "maintenance.set", "maintenance", func(enabled bool) (string, map[string]any) {
+            return "", map[string]any{"enabled": enabled}
+        })
+        if err := s.SetMaintenance(ctx, *req.Enabled, actorFromContext(r.Context())); err != nil {
+            s.logger.Printf("set maintenance mode error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+            return
+        }
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+    writeJSON(w, http.StatusOK, maintenanceResponse{Enabled: s.Maintenance()})
+}
@@ -0,0 +1,143 @@
+package api_test
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestMaintenanceMode(t *testing.T) {
+    env := setupTest(t, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    var logs bytes.Buffer
+    srv := api.NewServer(store.New(env.pool), "test-token", log.New(&logs, "", 0), api.WithAdminToken("admin-token"))
+    routes := srv.Routes()
+
+    do := func(handler http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+        req := httptest.NewRequest(method, path, strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer "+token)
+        rec := httptest.NewRecorder()
+        handler.ServeHTTP(rec, req)
+        return rec
+    }
+
+    if rec := do(routes, http.MethodPut, "/v1/admin/maintenance", "admin-token", `{"enabled":true}`); rec.Code != http.StatusOK {
+        t.Fatalf("enable: expected %d, got %d", http.StatusOK, rec.Code)
+    }
+    if !srv.Maintenance() || !strings.Contains(logs.String(), `"event":"maintenance_entered"`) {
+        t.Fatalf("expected maintenance mode to be entered and logged, logs: %s", logs.String())
+    }
+
+    rec := do(routes, http.MethodPost, "/v1/withdrawals", "test-token", `{}`)
+    if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "60" {
+        t.Fatalf("write: expected %d with Retry-After 60, got %d %q", http.StatusServiceUnavailable, rec.Code, rec.Header().Get("Retry-After"))
+    }
+    if !strings.Contains(rec.Body.String(), `"code":"maintenance"`) || !strings.Contains(rec.Body.String(), `"retry_after_seconds":60`) {
+        t.Fatalf("write: unexpected body %s", rec.Body.String())
+    }
+    // Callers are authenticated before the mode is revealed.
+    if rec := do(routes, http.MethodPost, "/v1/withdrawals", "wrong-token", `{}`); rec.Code != http.StatusUnauthorized {
+        t.Fatalf("unauthenticated write: expected %d, got %d", http.StatusUnauthorized, rec.Code)
+    }
+    // Reads are still served; this one fails validation before touching the store.
+    if rec := do(routes, http.MethodGet, "/v1/withdrawals?direction=sideways", "test-token", ""); rec.Code != http.StatusBadRequest {
+        t.Fatalf("read: expected %d, got %d", http.StatusBadRequest, rec.Code)
+    }
+
+    // The other replica, behind env.server, reads the mode from the database.
+    resp := env.doRequestWithHeaders(t, http.MethodGet, "/v1/admin/maintenance", "", map[string]string{"Authorization": "Bearer admin-token"})
+    var got struct {
+        Enabled bool `json:"enabled"`
+    }
+    err := json.NewDecoder(resp.Body).Decode(&got)
+    resp.Body.Close()
+    if err != nil || !got.Enabled {
+        t.Fatalf("expected the other replica to report maintenance mode, got %+v (%v)", got, err)
+    }
+    resp = env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{}`)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusServiceUnavailable {
+        t.Fatalf("write to the other replica: expected %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+    }
+
+    resp = env.doRequestWithHeaders(t, http.MethodPut, "/v1/admin/maintenance", `{"enabled":false}`, map[string]string{"Authorization": "Bearer admin-token"})
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("disable on the other replica: expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    if err := srv.RefreshMaintenance(context.Background()); err != nil || srv.Maintenance() {
+        t.Fatalf("expected the refresh to pick up the disabled mode, got %t (%v)", srv.Maintenance(), err)
+    }
+    if rec := do(routes, http.MethodPost, "/v1/withdrawals", "test-token", `{`); rec.Code != http.StatusBadRequest {
+        t.Fatalf("write after maintenance: expected %d, got %d", http.StatusBadRequest, rec.Code)
+    }
+    // Setting the mode it is already in changes and audits nothing.
+    if rec := do(routes, http.MethodPut, "/v1/admin/maintenance", "admin-token", `{"enabled":false}`); rec.Code != http.StatusOK {
+        t.Fatalf("disable again: expected %d, got %d", http.StatusOK, rec.Code)
+    }
+    if strings.Contains(logs.String(), `"event":"maintenance_exited"`) {
+        t.Fatalf("expected no exit logged by a replica that changed nothing, logs: %s", logs.String())
+    }
+
+    rows, err := env.pool.Query(context.Background(), "SELECT actor, summary FROM audit_log WHERE resource_type = 'maintenance' ORDER BY id")
+    if err != nil {
+        t.Fatalf("query audit: %v", err)
+    }
+    var entries []string
+    for rows.Next() {
+        var actor, summary string
+        if err := rows.Scan(&actor, &summary); err != nil {
+            t.Fatalf("scan audit: %v", err)
+        }
+        entries = append(entries, actor+" "+summary)
+    }
+    rows.Close()
+    if len(entries) != 2 || entries[0] != `admin {"enabled":true}` || entries[1] != `admin {"enabled":false}` {
+        t.Fatalf("expected the two changes audited, got %q", entries)
+    }
+
+    if rec := do(routes, http.MethodPut, "/v1/admin/maintenance", "admin-token", `{}`); rec.Code != http.StatusBadRequest {
+        t.Fatalf("missing enabled: expected %d, got %d", http.StatusBadRequest, rec.Code)
+    }
+}
+
+func TestMaintenanceRetryAfter(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    srv := api.NewServer(store.New(env.pool), "test-token", nil, api.WithMaintenanceRetryAfter(89500*time.Millisecond))
+    if err := srv.SetMaintenance(context.Background(), true, "test"); err != nil {
+        t.Fatalf("enable: %v", err)
+    }
+
+    req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals", strings.NewReader(`{}`))
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    var body struct {
+        Code              string `json:"code"`
+        RetryAfterSeconds int    `json:"retry_after_seconds"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+        t.Fatalf("decode body: %v", err)
+    }
+    // The wait is rounded up, and the header and body agree.
+    if rec.Code != http.StatusServiceUnavailable || body.Code != "maintenance" || body.RetryAfterSeconds != 90 {
+        t.Fatalf("expected 503 maintenance with retry_after_seconds 90, got %d %s", rec.Code, rec.Body)
+    }
+    if got := rec.Header().Get("Retry-After"); got != strconv.Itoa(body.RetryAfterSeconds) {
+        t.Fatalf("expected Retry-After %d, got %q", body.RetryAfterSeconds, got)
+    }
+}
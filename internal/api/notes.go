@@ -0,0 +1,134 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+    "unicode/utf8"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "task.hh/internal/store"
+)
+
+type createNoteRequest struct {
+    Text string `json:"text"`
+}
+
+type noteResponse struct {
+    ID           int64     `json:"id"`
+    WithdrawalID int64     `json:"withdrawal_id"`
+    Author       string    `json:"author"`
+    Text         string    `json:"text"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+type notesResponse struct {
+    Notes []noteResponse `json:"notes"`
+}
+
+func toNoteResponse(n store.WithdrawalNote) noteResponse {
+    return noteResponse{
+        ID:           n.ID,
+        WithdrawalID: n.WithdrawalID,
+        Author:       n.Author,
+        Text:         n.Text,
+        CreatedAt:    n.CreatedAt,
+    }
+}
+
+func (s *Server) handleWithdrawalNotes(w http.ResponseWriter, r *http.Request, id int64) {
+    setSpanAttributes(r, attribute.Int64("withdrawal_id", id))
+    switch r.Method {
+    case http.MethodGet:
+        s.listWithdrawalNotes(w, r, id)
+    case http.MethodPost:
+        s.addWithdrawalNote(w, r, id)
+    default:
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+    }
+}
+
+func (s *Server) listWithdrawalNotes(w http.ResponseWriter, r *http.Request, id int64) {
+    notes, err := s.store.ListWithdrawalNotes(r.Context(), id)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            writeError(w, http.StatusNotFound, "not_found")
+            return
+        }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
+        s.logger.Printf("list withdrawal notes error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := notesResponse{Notes: make([]noteResponse, 0, len(notes))}
+    for _, n := range notes {
+        resp.Notes = append(resp.Notes, toNoteResponse(n))
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) addWithdrawalNote(w http.ResponseWriter, r *http.Request, id int64) {
+    author, err := s.operatorFromRequest(r)
+    if err != nil {
+        reason := "invalid_operator"
+        if errors.Is(err, errOperatorRequired) {
+            reason = "operator_required"
+        }
+        writeError(w, http.StatusBadRequest, reason)
+        return
+    }
+
+    var req createNoteRequest
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&req); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if err := dec.Decode(&struct{}{}); err != io.EOF {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    text := strings.TrimSpace(req.Text)
+    if text == "" || utf8.RuneCountInString(text) > store.MaxNoteLength {
+        writeError(w, http.StatusBadRequest, "invalid_note")
+        return
+    }
+
+    ctx := withAudit(r, "withdrawal.note", "withdrawal", func(note store.WithdrawalNote) (string, map[string]any) {
+        return strconv.FormatInt(note.WithdrawalID, 10), map[string]any{
+            "note_id":  note.ID,
+            "operator": note.Author,
+        }
+    })
+    note, err := s.store.AddWithdrawalNote(ctx, id, author, text)
+    if err != nil {
+        if errors.Is(err, store.ErrNotFound) {
+            writeError(w, http.StatusNotFound, "not_found")
+            return
+        }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
+        s.logger.Printf("add withdrawal note error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    s.logEvent("withdrawal_note_added", map[string]any{
+        "withdrawal_id": id,
+        "note_id":       note.ID,
+        "author":        author,
+    })
+    writeJSON(w, http.StatusCreated, toNoteResponse(note))
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+    "log/slog"
+    "regexp"
+    "time"
+)
+
+// defaultIdempotencyKeyPattern accepts 1 to 128 printable ASCII characters.
+var defaultIdempotencyKeyPattern = regexp.MustCompile(`^[ -~]{1,128}$`)
+
+type Option func(*Server)
+
+// WithAuthKeys replaces the single auth token with named keys (name to
+// token). The key name is reported in X-Auth-Key-Name and logs.
+func WithAuthKeys(keys map[string]string) Option {
+    return func(s *Server) {
+        tokens := &authTokens{keys: make(map[string]string, len(keys))}
+        for name, token := range keys {
+            tokens.keys[name] = token
+        }
+        s.tokens.Store(tokens)
+    }
+}
+
+// WithAdminToken enables the /v1/admin endpoints for the given token.
+func WithAdminToken(token string) Option {
+    return func(s *Server) {
+        s.adminToken = token
+    }
+}
+
+// WithDebugBodyLogger logs the masked request body of every request that
+// fails with a 4xx or 5xx status to logger at debug level.
+func WithDebugBodyLogger(logger *slog.Logger) Option {
+    return func(s *Server) {
+        s.bodyLogger = logger
+    }
+}
+
+// WithIdempotencyKeyPattern replaces the pattern idempotency keys must match
+// after trimming. It can only narrow what the store accepts: keys that are
+// empty, longer than store.MaxIdempotencyKeyLength or not printable ASCII
+// are rejected regardless of the pattern. A nil pattern keeps the default.
+func WithIdempotencyKeyPattern(pattern *regexp.Regexp) Option {
+    return func(s *Server) {
+        if pattern == nil {
+            pattern = defaultIdempotencyKeyPattern
+        }
+        s.idempotencyKeyPattern = pattern
+    }
+}
+
+// WithLogRedaction replaces the event fields whose values are hashed before
+// they are logged, in events and in debug bodies alike. Stored values and
+// responses are not affected.
+func WithLogRedaction(fields ...string) Option {
+    return func(s *Server) {
+        s.logRedactFields = make(map[string]bool, len(fields))
+        for _, f := range fields {
+            s.logRedactFields[f] = true
+        }
+        s.logRedactPattern = redactedFieldPattern(s.logRedactFields)
+    }
+}
+
+// WithConfirmByCreatingKey lets a withdrawal be confirmed only with the API
+// key that created it; other keys get 403 forbidden. Withdrawals created
+// before keys were recorded can still be confirmed by any key.
+func WithConfirmByCreatingKey(enabled bool) Option {
+    return func(s *Server) {
+        s.confirmByCreatingKey = enabled
+    }
+}
+
+// WithMaintenanceRetryAfter sets the Retry-After of writes rejected during
+// maintenance, rounded up to whole seconds. Zero or less keeps the default of
+// a minute.
+func WithMaintenanceRetryAfter(d time.Duration) Option {
+    return func(s *Server) {
+        if d > 0 {
+            s.maintenanceRetryAfter = d
+        }
+    }
+}
+
+// WithOperatorRequired makes state-changing withdrawal endpoints reject
+// requests without an X-Operator header instead of falling back to the key
+// name.
+func WithOperatorRequired(required bool) Option {
+    return func(s *Server) {
+        s.operatorRequired = required
+    }
+}
+
+// WithReplayStatusOK makes a create that replays an existing withdrawal
+// answer 200 instead of 201. Replays always carry Idempotency-Replayed: true.
+func WithReplayStatusOK(enabled bool) Option {
+    return func(s *Server) {
+        s.replayStatusOK = enabled
+    }
+}
+
+// WithResponseSigningKeys signs the JSON responses to requests authenticated
+// by a key name in keys with that name's secret, in the X-Signature header.
+func WithResponseSigningKeys(keys map[string]string) Option {
+    return func(s *Server) {
+        s.signingKeys = make(map[string][]byte, len(keys))
+        for name, key := range keys {
+            s.signingKeys[name] = []byte(key)
+        }
+    }
+}
+
+// WithTenantSecret makes the service multi-tenant: requests with an API token
+// must also carry an X-Tenant-Token JWT signed with secret (HS256), and only
+// see the users and withdrawals of the tenant in its tid claim. Admin
+// endpoints stay unscoped.
+func WithTenantSecret(secret []byte) Option {
+    return func(s *Server) {
+        s.tenantSecret = secret
+    }
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "task.hh/internal/store"
+)
+
+const adminUsersPath = "/v1/admin/users/"
+
+type overdraftRequest struct {
+    OverdraftLimit *Integer `json:"overdraft_limit"`
+}
+
+// handleAdminUserOverdraft sets how far below zero withdrawals may take a
+// user's balance: PUT /v1/admin/users/{id}/overdraft.
+func (s *Server) handleAdminUserOverdraft(w http.ResponseWriter, r *http.Request) {
+    parts := strings.Split(strings.TrimPrefix(r.URL.Path, adminUsersPath), "/")
+    if len(parts) != 2 || parts[1] != "overdraft" {
+        writeError(w, http.StatusNotFound, "not_found")
+        return
+    }
+    if r.Method != http.MethodPut {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+    id, err := strconv.ParseInt(parts[0], 10, 64)
+    if err != nil || id <= 0 {
+        writeError(w, http.StatusBadRequest, "invalid_id")
+        return
+    }
+
+    var req overdraftRequest
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&req); err != nil {
+        writeError(w, http.StatusBadRequest, decodeErrorCode(err))
+        return
+    }
+    if err := dec.Decode(&struct{}{}); err != io.EOF {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if req.OverdraftLimit == nil {
+        writeError(w, http.StatusBadRequest, "invalid_overdraft_limit")
+        return
+    }
+
+    ctx := withAudit(r, "user.set_overdraft", "user", func(user store.User) (string, map[string]any) {
+        return strconv.FormatInt(user.ID, 10), map[string]any{"overdraft_limit": user.OverdraftLimit}
+    })
+    user, err := s.store.SetOverdraftLimit(ctx, id, int64(*req.OverdraftLimit))
+    if err != nil {
+        switch {
+        case errors.Is(err, store.ErrInvalidOverdraftLimit):
+            writeError(w, http.StatusBadRequest, "invalid_overdraft_limit")
+        case errors.Is(err, store.ErrUserNotFound):
+            writeError(w, http.StatusNotFound, "user_not_found")
+        case errors.Is(err, store.ErrOverdraftInUse):
+            writeError(w, http.StatusConflict, "overdraft_in_use")
+        default:
+            s.logger.Printf("set overdraft limit error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+        }
+        return
+    }
+
+    s.logEvent("user_overdraft_updated", map[string]any{
+        "user_id":         user.ID,
+        "overdraft_limit": user.OverdraftLimit,
+    })
+    writeJSON(w, http.StatusOK, toUserResponse(user))
+}
@@ -0,0 +1,93 @@
+package api_test
+
+import (
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestAdminUserOverdraft(t *testing.T) {
+    env := setupTest(t, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 100)
+    admin := map[string]string{"Authorization": "Bearer admin-token"}
+    do := func(method, path, body string, headers map[string]string) (int, string) {
+        t.Helper()
+        resp := env.doRequestWithHeaders(t, method, path, body, headers)
+        defer resp.Body.Close()
+        b, err := io.ReadAll(resp.Body)
+        if err != nil {
+            t.Fatalf("read body: %v", err)
+        }
+        return resp.StatusCode, string(b)
+    }
+
+    withdraw := func(amount, key string) (int, string) {
+        return do(http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":`+amount+`,"currency":"USDT","destination":"addr","idempotency_key":"`+key+`"}`, nil)
+    }
+    if code, body := withdraw("150", "k1"); code != http.StatusConflict || strings.Contains(body, "overdraft_limit") {
+        t.Fatalf("before the overdraft: expected %d without overdraft_limit, got %d %s", http.StatusConflict, code, body)
+    }
+
+    if code, _ := do(http.MethodPut, "/v1/admin/users/1/overdraft", `{"overdraft_limit":50}`, nil); code != http.StatusUnauthorized {
+        t.Fatalf("api token: expected %d, got %d", http.StatusUnauthorized, code)
+    }
+    if code, body := do(http.MethodPut, "/v1/admin/users/1/overdraft", `{"overdraft_limit":50}`, admin); code != http.StatusOK || !strings.Contains(body, `"overdraft_limit":50`) {
+        t.Fatalf("set: expected %d with the limit, got %d %s", http.StatusOK, code, body)
+    }
+
+    code, body := withdraw("151", "k2")
+    if code != http.StatusConflict || !strings.Contains(body, `"shortfall":1`) || !strings.Contains(body, `"overdraft_limit":50`) {
+        t.Fatalf("above the limit: expected %d with a shortfall of 1, got %d %s", http.StatusConflict, code, body)
+    }
+    if code, _ := withdraw("150", "k3"); code != http.StatusCreated {
+        t.Fatalf("at the limit: expected %d, got %d", http.StatusCreated, code)
+    }
+    if balance := getBalance(t, env.pool, 1); balance != -50 {
+        t.Fatalf("expected balance -50, got %d", balance)
+    }
+
+    if code, body := do(http.MethodPut, "/v1/admin/users/1/overdraft", `{"overdraft_limit":10}`, admin); code != http.StatusConflict || !strings.Contains(body, `"code":"overdraft_in_use"`) {
+        t.Fatalf("lower: expected %d overdraft_in_use, got %d %s", http.StatusConflict, code, body)
+    }
+    if code, _ := do(http.MethodPut, "/v1/admin/users/2/overdraft", `{"overdraft_limit":10}`, admin); code != http.StatusNotFound {
+        t.Fatalf("unknown user: expected %d, got %d", http.StatusNotFound, code)
+    }
+}
+
+func TestAdminUserOverdraftInvalidRequest(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    for _, tt := range []struct {
+        method string
+        path   string
+        body   string
+        want   int
+        code   string
+    }{
+        {http.MethodPut, "/v1/admin/users/1/overdraft", `{}`, http.StatusBadRequest, "invalid_overdraft_limit"},
+        {http.MethodPut, "/v1/admin/users/1/overdraft", `{"overdraft_limit":-1}`, http.StatusBadRequest, "invalid_overdraft_limit"},
+        {http.MethodPut, "/v1/admin/users/1/overdraft", `{"overdraft_limit":1.5}`, http.StatusBadRequest, "amount_not_integer"},
+        {http.MethodPut, "/v1/admin/users/1/overdraft", `{"overdraft_limit":9223372036854775808}`, http.StatusBadRequest, "amount_out_of_range"},
+        {http.MethodPut, "/v1/admin/users/1/overdraft", `{"overdraft_limit":1,"extra":1}`, http.StatusBadRequest, "invalid_request"},
+        {http.MethodPut, "/v1/admin/users/abc/overdraft", `{"overdraft_limit":1}`, http.StatusBadRequest, "invalid_id"},
+        {http.MethodGet, "/v1/admin/users/1/overdraft", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+        {http.MethodPut, "/v1/admin/users/1", `{"overdraft_limit":1}`, http.StatusNotFound, "not_found"},
+    } {
+        req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer admin-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != tt.want || !strings.Contains(rec.Body.String(), `"code":"`+tt.code+`"`) {
+            t.Fatalf("%s %s %s: expected %d %s, got %d %s", tt.method, tt.path, tt.body, tt.want, tt.code, rec.Code, rec.Body)
+        }
+    }
+}
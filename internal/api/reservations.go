@@ -0,0 +1,45 @@
+package api
+
+import (
+    "context"
+    "time"
+)
+
+const reservationSweepBatch = 100
+
+// RunReservationSweeper releases expired withdrawal holds every interval
+// until ctx is done.
+func (s *Server) RunReservationSweeper(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            s.sweepReservations(ctx)
+        }
+    }
+}
+
+func (s *Server) sweepReservations(ctx context.Context) {
+    for {
+        expired, err := s.store.ReleaseExpiredReservations(ctx, reservationSweepBatch)
+        if err != nil {
+            s.logger.Printf("release expired reservations error: %v", err)
+            return
+        }
+        for _, w := range expired {
+            s.logEvent("withdrawal_expired", map[string]any{
+                "withdrawal_id": w.ID,
+                "user_id":       w.UserID,
+                "amount":        w.Amount,
+                "fee":           w.Fee,
+            })
+        }
+        if len(expired) < reservationSweepBatch {
+            return
+        }
+    }
+}
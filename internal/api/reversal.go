@@ -0,0 +1,118 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "task.hh/internal/store"
+)
+
+type reverseWithdrawalRequest struct {
+    Reason string `json:"reason"`
+}
+
+// withdrawalAuthMiddleware authenticates /v1/withdrawals/{id}/... requests:
+// reversal moves money back and needs the admin token, everything else an
+// API token.
+func (s *Server) withdrawalAuthMiddleware(next http.Handler) http.Handler {
+    admin := s.adminMiddleware(next)
+    api := s.authMiddleware(next)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if strings.HasSuffix(r.URL.Path, "/reverse") {
+            admin.ServeHTTP(w, r)
+            return
+        }
+        api.ServeHTTP(w, r)
+    })
+}
+
+func (s *Server) handleReverseWithdrawal(w http.ResponseWriter, r *http.Request, id int64) {
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+    setSpanAttributes(r, attribute.Int64("withdrawal_id", id))
+
+    operator, err := s.operatorFromRequest(r)
+    if err != nil {
+        failure := "invalid_operator"
+        if errors.Is(err, errOperatorRequired) {
+            failure = "operator_required"
+        }
+        s.logEvent("withdrawal_reverse_failed", map[string]any{
+            "withdrawal_id": id,
+            "reason":        failure,
+        })
+        writeError(w, http.StatusBadRequest, failure)
+        return
+    }
+
+    var req reverseWithdrawalRequest
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&req); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if err := dec.Decode(&struct{}{}); err != io.EOF {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    reason := strings.TrimSpace(req.Reason)
+    if reason == "" || utf8.RuneCountInString(reason) > store.MaxReversalReasonLength {
+        writeError(w, http.StatusBadRequest, "invalid_reason")
+        return
+    }
+
+    ctx := withAudit(r, "withdrawal.reverse", "withdrawal", func(reversed store.Withdrawal) (string, map[string]any) {
+        return strconv.FormatInt(reversed.ID, 10), map[string]any{
+            "user_id":  reversed.UserID,
+            "amount":   reversed.Amount,
+            "currency": reversed.Currency,
+            "reason":   reason,
+            "operator": operator,
+        }
+    })
+    withdrawal, err := s.store.ReverseWithdrawal(ctx, id, reason)
+    if err != nil {
+        failure := "internal_error"
+        switch {
+        case errors.Is(err, store.ErrNotFound):
+            failure = "not_found"
+            writeError(w, http.StatusNotFound, "not_found")
+        case errors.Is(err, store.ErrInvalidStatus), errors.Is(err, store.ErrAlreadyRefunded):
+            failure = "invalid_status"
+            resp := errorResponse{Code: failure}
+            if current, err := s.store.GetWithdrawal(r.Context(), id); err == nil {
+                resp.CurrentStatus = current.Status
+            }
+            writeErrorResponse(w, http.StatusConflict, resp)
+        default:
+            s.logger.Printf("reverse withdrawal error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+        }
+        s.logEvent("withdrawal_reverse_failed", map[string]any{
+            "withdrawal_id": id,
+            "reason":        failure,
+            "operator":      operator,
+        })
+        return
+    }
+
+    setSpanAttributes(r, attribute.Int64("user_id", withdrawal.UserID))
+    s.logEvent("withdrawal_reversed", map[string]any{
+        "withdrawal_id":   withdrawal.ID,
+        "user_id":         withdrawal.UserID,
+        "amount":          withdrawal.Amount,
+        "reversal_reason": reason,
+        "operator":        operator,
+    })
+    writeJSON(w, http.StatusOK, toWithdrawalResponse(withdrawal))
+}
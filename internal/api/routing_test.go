@@ -0,0 +1,93 @@
+package api
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "task.hh/internal/store"
+)
+
+// newUnreachableServer returns a server whose store points at a closed port,
+// so requests that get past routing fail fast with a 500 instead of needing a
+// database.
+func newUnreachableServer(t testing.TB) *Server {
+    t.Helper()
+
+    pool, err := pgxpool.New(context.Background(), "postgres://u:p@127.0.0.1:1/db?connect_timeout=1")
+    if err != nil {
+        t.Fatalf("pool: %v", err)
+    }
+    t.Cleanup(pool.Close)
+    return NewServer(store.New(pool), "test-token", nil)
+}
+
+func serveWithdrawalPath(s *Server, method, path string) *httptest.ResponseRecorder {
+    r := httptest.NewRequest(method, "/", nil)
+    r.URL.Path = "/v1/withdrawals/" + path
+    rec := httptest.NewRecorder()
+    s.handleWithdrawalByID(rec, r)
+    return rec
+}
+
+func TestHandleWithdrawalByIDPaths(t *testing.T) {
+    s := newUnreachableServer(t)
+
+    tests := []struct {
+        method string
+        path   string
+        status int
+    }{
+        {http.MethodGet, "", http.StatusNotFound},
+        {http.MethodGet, "/", http.StatusNotFound},
+        {http.MethodPost, "//confirm", http.StatusNotFound},
+        {http.MethodPost, "/confirm", http.StatusBadRequest},
+        {http.MethodPost, "abc/confirm/extra", http.StatusNotFound},
+        {http.MethodPost, "1/confirm/", http.StatusNotFound},
+        {http.MethodPost, "abc/confirm", http.StatusBadRequest},
+        {http.MethodGet, "1/unknown", http.StatusNotFound},
+        {http.MethodGet, "1/", http.StatusNotFound},
+        {http.MethodPost, "1", http.StatusMethodNotAllowed},
+        {http.MethodDelete, "1/notes", http.StatusMethodNotAllowed},
+        {http.MethodPost, "1/notes", http.StatusBadRequest},
+        {http.MethodGet, "0", http.StatusBadRequest},
+        {http.MethodGet, "-1", http.StatusBadRequest},
+        {http.MethodGet, "9223372036854775808", http.StatusBadRequest},
+        {http.MethodGet, strings.Repeat("9", 10000), http.StatusBadRequest},
+        {http.MethodPost, strings.Repeat("1", 10000) + "/confirm", http.StatusBadRequest},
+        {http.MethodGet, "1", http.StatusInternalServerError},
+    }
+    for _, tt := range tests {
+        rec := serveWithdrawalPath(s, tt.method, tt.path)
+        if rec.Code != tt.status {
+            name := tt.path
+            if len(name) > 32 {
+                name = name[:32] + "..."
+            }
+            t.Fatalf("%s %q: expected %d, got %d", tt.method, name, tt.status, rec.Code)
+        }
+    }
+}
+
+func FuzzHandleWithdrawalByID(f *testing.F) {
+    s := newUnreachableServer(f)
+
+    for _, path := range []string{"", "/", "//confirm", "abc/confirm/extra", "stale", "1", "1/age", "1/touch", "1/confirm", "1/notes", "1/confirm/", strings.Repeat("9", 1000)} {
+        f.Add(path, false)
+        f.Add(path, true)
+    }
+    f.Fuzz(func(t *testing.T, path string, post bool) {
+        method := http.MethodGet
+        if post {
+            method = http.MethodPost
+        }
+        rec := serveWithdrawalPath(s, method, path)
+        if rec.Code < 200 || rec.Code > 599 {
+            t.Fatalf("%s %q: unexpected status %d", method, path, rec.Code)
+        }
+    })
+}
@@ -1,17 +1,50 @@
 package api
 
 import (
+    "context"
     "crypto/subtle"
+    "log/slog"
     "net/http"
+    "regexp"
     "strings"
+    "sync"
+    "sync/atomic"
+    "time"
 
     "task.hh/internal/store"
 )
 
 type Server struct {
-    store     *store.Store
-    authToken string
-    logger    Logger
+    store      *store.Store
+    tokens     atomic.Pointer[authTokens]
+    tokenMu    sync.Mutex
+    logger     Logger
+    currencies []CurrencyConfig
+
+    replayStatusOK bool
+    adminToken     string
+
+    operatorRequired     bool
+    confirmByCreatingKey bool
+    bodyLogger           *slog.Logger
+    touchThrottle        *idThrottle
+
+    idempotencyKeyPattern *regexp.Regexp
+    maintenance           atomic.Bool
+    maintenanceRetryAfter time.Duration
+    logRedactFields       map[string]bool
+    logRedactPattern      *regexp.Regexp
+    tenantSecret          []byte
+    signingKeys           map[string][]byte
+    authFailures          *authFailureLog
+
+    baseCtx    context.Context
+    cancelBase context.CancelFunc
+
+    mu       sync.Mutex
+    draining bool
+    wg       sync.WaitGroup
+    inFlight atomic.Int64
 }
 
 type Logger interface {
@@ -22,33 +55,95 @@ type nopLogger struct{}
 
 func (nopLogger) Printf(string, ...any) {}
 
-func NewServer(st *store.Store, authToken string, logger Logger) *Server {
+func NewServer(st *store.Store, authToken string, logger Logger, opts ...Option) *Server {
     if logger == nil {
         logger = nopLogger{}
     }
-    return &Server{
-        store:     st,
-        authToken: authToken,
-        logger:    logger,
+    baseCtx, cancelBase := context.WithCancel(context.Background())
+    s := &Server{
+        store:                 st,
+        logger:                logger,
+        currencies:            currencyRegistry(st.SupportedCurrencies()),
+        touchThrottle:         newIDThrottle(touchInterval),
+        authFailures:          newAuthFailureLog(authFailureLogSize),
+        idempotencyKeyPattern: defaultIdempotencyKeyPattern,
+        maintenanceRetryAfter: defaultMaintenanceRetryAfter,
+        baseCtx:               baseCtx,
+        cancelBase:            cancelBase,
     }
+    if authToken != "" {
+        s.tokens.Store(&authTokens{keys: map[string]string{defaultKeyName: authToken}})
+    }
+    WithLogRedaction(defaultLogRedactFields...)(s)
+    for _, opt := range opts {
+        opt(s)
+    }
+    return s
 }
 
 func (s *Server) Routes() http.Handler {
     mux := http.NewServeMux()
+    mux.HandleFunc("/v1/currencies", s.handleCurrencies)
     mux.Handle("/v1/users", s.authMiddleware(http.HandlerFunc(s.handleUsers)))
+    mux.Handle("/v1/users/", s.authMiddleware(http.HandlerFunc(s.handleUserByID)))
+    mux.Handle("/v1/users:batch", s.authMiddleware(http.HandlerFunc(s.handleCreateUsersBatch)))
+    mux.Handle("/v1/stats/withdrawals", s.authMiddleware(http.HandlerFunc(s.handleWithdrawalStats)))
+    mux.Handle("/v1/stats/time-series", s.authMiddleware(http.HandlerFunc(s.handleWithdrawalTimeSeries)))
     mux.Handle("/v1/withdrawals", s.authMiddleware(http.HandlerFunc(s.handleWithdrawals)))
-    mux.Handle("/v1/withdrawals/", s.authMiddleware(http.HandlerFunc(s.handleWithdrawalByID)))
-    return mux
+    mux.Handle("/v1/withdrawals/", s.withdrawalAuthMiddleware(http.HandlerFunc(s.handleWithdrawalByID)))
+    mux.Handle("/v1/admin/audit", s.adminMiddleware(http.HandlerFunc(s.handleAdminAudit)))
+    mux.Handle("/v1/admin/withdrawals", s.adminMiddleware(http.HandlerFunc(s.handleAdminWithdrawals)))
+    mux.Handle(stuckWithdrawalsPath, s.adminMiddleware(http.HandlerFunc(s.handleAdminStuckWithdrawals)))
+    mux.Handle("/v1/admin/ledger", s.adminMiddleware(http.HandlerFunc(s.handleAdminLedger)))
+    mux.Handle(ledgerArchivePath, s.adminMiddleware(http.HandlerFunc(s.handleAdminArchiveLedger)))
+    mux.Handle("/v1/admin/auth-failures", s.adminMiddleware(http.HandlerFunc(s.handleAdminAuthFailures)))
+    mux.Handle(maintenancePath, s.adminMiddleware(http.HandlerFunc(s.handleAdminMaintenance)))
+    mux.Handle(blacklistPath, s.adminMiddleware(http.HandlerFunc(s.handleAdminBlacklist)))
+    mux.Handle(blacklistPath+"/", s.adminMiddleware(http.HandlerFunc(s.handleAdminBlacklistAddress)))
+    mux.Handle(adminUsersPath, s.adminMiddleware(http.HandlerFunc(s.handleAdminUserOverdraft)))
+
+    var handler http.Handler = s.amountFormatMiddleware(mux)
+    if s.bodyLogger != nil {
+        handler = s.debugBodyMiddleware(handler)
+    }
+
+    root := http.NewServeMux()
+    root.HandleFunc("/readyz", s.handleReady)
+    root.HandleFunc("/version", s.handleVersion)
+    root.Handle("/", s.tracingMiddleware(requestIDMiddleware(s.inFlightMiddleware(handler))))
+    return root
 }
 
 func (s *Server) authMiddleware(next http.Handler) http.Handler {
+    scoped := s.tenantMiddleware(s.maintenanceMiddleware(next))
     return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
         token := extractBearerToken(r.Header.Get("Authorization"))
-        if !secureCompare(token, s.authToken) {
+        name, ok := s.authenticate(token)
+        if !ok {
+            s.recordAuthFailure(r, token)
+            writeError(w, http.StatusUnauthorized, "unauthorized")
+            return
+        }
+        w.Header().Set("X-Auth-Key-Name", name)
+        scoped.ServeHTTP(s.withResponseSigning(w, name), r.WithContext(withActor(r.Context(), name)))
+    })
+}
+
+// adminMiddleware guards admin endpoints with the admin token. They are
+// disabled when no admin token is configured.
+func (s *Server) adminMiddleware(next http.Handler) http.Handler {
+    next = s.maintenanceMiddleware(next)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if s.adminToken == "" {
+            writeError(w, http.StatusNotFound, "not_found")
+            return
+        }
+        token := extractBearerToken(r.Header.Get("Authorization"))
+        if token == "" || !secureCompare(token, s.adminToken) {
             writeError(w, http.StatusUnauthorized, "unauthorized")
             return
         }
-        next.ServeHTTP(w, r)
+        next.ServeHTTP(w, r.WithContext(withActor(r.Context(), "admin")))
     })
 }
 
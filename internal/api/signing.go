@@ -0,0 +1,39 @@
+package api
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+)
+
+// signatureHeader carries the HMAC-SHA256 of the response body, as
+// "sha256=<hex>", for partners with a response signing key.
+const signatureHeader = "X-Signature"
+
+// signingWriter marks a response whose JSON body writeJSON signs with key.
+type signingWriter struct {
+    http.ResponseWriter
+    key []byte
+}
+
+func (w *signingWriter) Unwrap() http.ResponseWriter {
+    return w.ResponseWriter
+}
+
+// withResponseSigning wraps w when the key name that authenticated the
+// request has a response signing key.
+func (s *Server) withResponseSigning(w http.ResponseWriter, keyName string) http.ResponseWriter {
+    key, ok := s.signingKeys[keyName]
+    if !ok {
+        return w
+    }
+    return &signingWriter{ResponseWriter: w, key: key}
+}
+
+// signBody returns the X-Signature value of body under key.
+func signBody(key, body []byte) string {
+    mac := hmac.New(sha256.New, key)
+    mac.Write(body)
+    return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,56 @@
+package api_test
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestResponseSignature(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "", log.New(io.Discard, "", 0),
+        api.WithAuthKeys(map[string]string{"partner": "partner-token", "ops": "ops-token"}),
+        api.WithResponseSigningKeys(map[string]string{"partner": "partner-secret"}),
+    )
+    ts := httptest.NewServer(srv.Routes())
+    defer ts.Close()
+
+    get := func(token, path string) (*http.Response, []byte) {
+        req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+        if err != nil {
+            t.Fatalf("new request: %v", err)
+        }
+        req.Header.Set("Authorization", "Bearer "+token)
+        resp, err := http.DefaultClient.Do(req)
+        if err != nil {
+            t.Fatalf("do request: %v", err)
+        }
+        defer resp.Body.Close()
+        body, err := io.ReadAll(resp.Body)
+        if err != nil {
+            t.Fatalf("read body: %v", err)
+        }
+        return resp, body
+    }
+
+    for _, path := range []string{"/v1/users/abc", "/v1/withdrawals?sort=nope"} {
+        resp, body := get("partner-token", path)
+        mac := hmac.New(sha256.New, []byte("partner-secret"))
+        mac.Write(body)
+        want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+        if got := resp.Header.Get("X-Signature"); got != want {
+            t.Fatalf("%s: expected signature %s over %q, got %q", path, want, body, got)
+        }
+
+        if resp, _ := get("ops-token", path); resp.Header.Get("X-Signature") != "" {
+            t.Fatalf("%s: expected no signature for a key without a signing secret", path)
+        }
+    }
+}
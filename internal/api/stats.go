@@ -0,0 +1,241 @@
+package api
+
+import (
+    "errors"
+    "fmt"
+    "net/http"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+type withdrawalStatsRow struct {
+    Status   string      `json:"status,omitempty"`
+    Currency string      `json:"currency"`
+    Day      string      `json:"day,omitempty"`
+    Count    int64       `json:"count"`
+    Amount   minorAmount `json:"amount"`
+}
+
+type withdrawalStatsResponse struct {
+    From    time.Time            `json:"from"`
+    To      time.Time            `json:"to"`
+    GroupBy []string             `json:"group_by"`
+    Groups  []withdrawalStatsRow `json:"groups"`
+}
+
+func (s *Server) handleWithdrawalStats(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    q, err := parseWithdrawalStatsQuery(r.URL.Query())
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        return
+    }
+
+    rows, err := s.store.WithdrawalStats(r.Context(), q)
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidFilter) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+            return
+        }
+        s.logger.Printf("withdrawal stats error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := withdrawalStatsResponse{
+        From:    q.From,
+        To:      q.To,
+        GroupBy: q.GroupBy,
+        Groups:  make([]withdrawalStatsRow, 0, len(rows)),
+    }
+    for _, row := range rows {
+        resp.Groups = append(resp.Groups, withdrawalStatsRow{
+            Status:   row.Status,
+            Currency: row.Currency,
+            Day:      row.Day,
+            Count:    row.Count,
+            Amount:   amountIn(row.Amount, row.Currency),
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+type timeSeriesBucket struct {
+    BucketStart time.Time   `json:"bucket_start"`
+    Currency    string      `json:"currency"`
+    Count       int64       `json:"count"`
+    TotalAmount minorAmount `json:"total_amount"`
+}
+
+type timeSeriesResponse struct {
+    UserID        int64              `json:"user_id"`
+    From          time.Time          `json:"from"`
+    To            time.Time          `json:"to"`
+    BucketMinutes int                `json:"bucket_minutes"`
+    Buckets       []timeSeriesBucket `json:"buckets"`
+}
+
+func (s *Server) handleWithdrawalTimeSeries(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    resp, err := parseTimeSeriesQuery(r.URL.Query())
+    if err != nil {
+        writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+        return
+    }
+
+    buckets, err := s.store.GetWithdrawalTimeSeries(r.Context(), resp.UserID, resp.From, resp.To, resp.BucketMinutes)
+    if err != nil {
+        if errors.Is(err, store.ErrInvalidFilter) {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", err.Error())
+            return
+        }
+        s.logger.Printf("withdrawal time series error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp.Buckets = make([]timeSeriesBucket, 0, len(buckets))
+    for _, b := range buckets {
+        resp.Buckets = append(resp.Buckets, timeSeriesBucket{
+            BucketStart: b.BucketStart,
+            Currency:    b.Currency,
+            Count:       b.Count,
+            TotalAmount: amountIn(b.TotalAmount, b.Currency),
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// parseTimeSeriesQuery reads user_id, from, to and bucket_minutes (default
+// 60). Range limits are left to the store.
+func parseTimeSeriesQuery(v url.Values) (timeSeriesResponse, error) {
+    q := timeSeriesResponse{BucketMinutes: 60}
+    var err error
+    if q.UserID, err = strconv.ParseInt(v.Get("user_id"), 10, 64); err != nil || q.UserID <= 0 {
+        return q, fmt.Errorf("invalid user_id %q", v.Get("user_id"))
+    }
+    if q.From, err = time.Parse(time.RFC3339, v.Get("from")); err != nil {
+        return q, fmt.Errorf("invalid from %q", v.Get("from"))
+    }
+    if q.To, err = time.Parse(time.RFC3339, v.Get("to")); err != nil {
+        return q, fmt.Errorf("invalid to %q", v.Get("to"))
+    }
+    q.From, q.To = q.From.UTC(), q.To.UTC()
+    if raw := v.Get("bucket_minutes"); raw != "" {
+        if q.BucketMinutes, err = strconv.Atoi(raw); err != nil {
+            return q, fmt.Errorf("invalid bucket_minutes %q", raw)
+        }
+    }
+    return q, nil
+}
+
+func parseWithdrawalStatsQuery(v url.Values) (store.WithdrawalStatsQuery, error) {
+    var q store.WithdrawalStatsQuery
+    var err error
+    if q.From, err = time.Parse(time.RFC3339, v.Get("from")); err != nil {
+        return q, fmt.Errorf("invalid from %q", v.Get("from"))
+    }
+    if q.To, err = time.Parse(time.RFC3339, v.Get("to")); err != nil {
+        return q, fmt.Errorf("invalid to %q", v.Get("to"))
+    }
+    q.From, q.To = q.From.UTC(), q.To.UTC()
+    q.GroupBy = []string{}
+    if raw := v.Get("group_by"); raw != "" {
+        for _, g := range strings.Split(raw, ",") {
+            q.GroupBy = append(q.GroupBy, strings.TrimSpace(g))
+        }
+    }
+    if raw := v.Get("tz"); raw != "" {
+        if q.TZOffset, err = parseTZOffset(raw); err != nil {
+            return q, err
+        }
+    }
+    return q, nil
+}
+
+// parseTZOffset accepts offsets such as "+03:00", "-05:30" and "Z". An
+// unescaped "+" arrives as a space and is accepted as well.
+func parseTZOffset(raw string) (time.Duration, error) {
+    t, err := time.Parse("Z07:00", strings.Replace(raw, " ", "+", 1))
+    if err != nil {
+        return 0, fmt.Errorf("invalid tz %q", raw)
+    }
+    _, offset := t.Zone()
+    if offset < -14*3600 || offset > 14*3600 {
+        return 0, fmt.Errorf("invalid tz %q", raw)
+    }
+    return time.Duration(offset) * time.Second, nil
+}
+
+type recipientSummaryResponse struct {
+    Destination string      `json:"destination"`
+    Currency    string      `json:"currency"`
+    Count       int64       `json:"count"`
+    TotalAmount minorAmount `json:"total_amount"`
+}
+
+type topRecipientsResponse struct {
+    UserID     int64                      `json:"user_id"`
+    Recipients []recipientSummaryResponse `json:"recipients"`
+}
+
+// handleTopRecipients lists the destinations that received the most from a
+// user. limit defaults to store.DefaultTopRecipients and is capped at
+// store.MaxTopRecipients rather than rejected.
+func (s *Server) handleTopRecipients(w http.ResponseWriter, r *http.Request, userID int64) {
+    limit := store.DefaultTopRecipients
+    if raw := r.URL.Query().Get("limit"); raw != "" {
+        n, err := strconv.Atoi(raw)
+        if err != nil || n <= 0 {
+            writeErrorMessage(w, http.StatusBadRequest, "invalid_filter", fmt.Sprintf("invalid limit %q", raw))
+            return
+        }
+        limit = n
+    }
+
+    if _, err := s.store.GetUser(r.Context(), userID); err != nil {
+        if errors.Is(err, store.ErrUserNotFound) {
+            writeError(w, http.StatusNotFound, "user_not_found")
+            return
+        }
+        if errors.Is(err, store.ErrTenantMismatch) {
+            writeError(w, http.StatusForbidden, "forbidden")
+            return
+        }
+        s.logger.Printf("get user error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+    recipients, err := s.store.TopWithdrawalRecipients(r.Context(), userID, limit)
+    if err != nil {
+        s.logger.Printf("top recipients error: %v", err)
+        writeError(w, http.StatusInternalServerError, "internal_error")
+        return
+    }
+
+    resp := topRecipientsResponse{
+        UserID:     userID,
+        Recipients: make([]recipientSummaryResponse, 0, len(recipients)),
+    }
+    for _, rs := range recipients {
+        resp.Recipients = append(resp.Recipients, recipientSummaryResponse{
+            Destination: rs.Destination,
+            Currency:    rs.Currency,
+            Count:       rs.Count,
+            TotalAmount: amountIn(rs.TotalAmount, rs.Currency),
+        })
+    }
+    writeJSON(w, http.StatusOK, resp)
+}
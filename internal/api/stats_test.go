@@ -0,0 +1,96 @@
+package api_test
+
+import (
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestWithdrawalStatsInvalidQuery(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    queries := []string{
+        "",
+        "from=2026-01-01T00:00:00Z",
+        "from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z",
+        "from=2026-01-01T00:00:00Z&to=2026-04-05T00:00:00Z",
+        "from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z&group_by=user",
+        "from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z&group_by=day,day",
+        "from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z&group_by=day&tz=Moscow",
+        "from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z&group_by=day&tz=%2B15:00",
+    }
+    for _, query := range queries {
+        req := httptest.NewRequest(http.MethodGet, "/v1/stats/withdrawals?"+query, nil)
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%q: expected %d, got %d", query, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
+
+func TestWithdrawalTimeSeriesInvalidQuery(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    queries := []string{
+        "from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z",
+        "user_id=x&from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z",
+        "user_id=1&to=2026-01-02T00:00:00Z",
+        "user_id=1&from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z",
+        "user_id=1&from=2026-01-01T00:00:00Z&to=2026-04-02T00:00:00Z",
+        "user_id=1&from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z&bucket_minutes=0",
+        "user_id=1&from=2026-01-01T00:00:00Z&to=2026-01-02T00:00:00Z&bucket_minutes=hour",
+    }
+    for _, query := range queries {
+        req := httptest.NewRequest(http.MethodGet, "/v1/stats/time-series?"+query, nil)
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%q: expected %d, got %d", query, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
+
+func TestTopRecipientsInvalidLimit(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, query := range []string{"limit=0", "limit=-1", "limit=ten"} {
+        req := httptest.NewRequest(http.MethodGet, "/v1/users/1/top-recipients?"+query, nil)
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%q: expected %d, got %d", query, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
+
+func TestLedgerSummaryInvalidQuery(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    queries := []string{
+        "from=yesterday",
+        "to=2026-01-01",
+        "from=2026-01-02T00:00:00Z&to=2026-01-01T00:00:00Z",
+    }
+    for _, query := range queries {
+        req := httptest.NewRequest(http.MethodGet, "/v1/users/1/ledger/summary?"+query, nil)
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%q: expected %d, got %d", query, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "strings"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+// tenantTokenHeader carries the tenant JWT next to the API token.
+const tenantTokenHeader = "X-Tenant-Token"
+
+var errInvalidTenantToken = errors.New("invalid tenant token")
+
+// tenantMiddleware scopes the request to the tenant named by the tid claim of
+// an HS256 JWT in X-Tenant-Token. Without a tenant secret the service is
+// single-tenant and the middleware does nothing.
+func (s *Server) tenantMiddleware(next http.Handler) http.Handler {
+    if len(s.tenantSecret) == 0 {
+        return next
+    }
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        tenant, err := parseTenantToken(r.Header.Get(tenantTokenHeader), s.tenantSecret, time.Now())
+        if err != nil {
+            writeError(w, http.StatusUnauthorized, "invalid_tenant_token")
+            return
+        }
+        next.ServeHTTP(w, r.WithContext(store.WithTenant(r.Context(), tenant)))
+    })
+}
+
+// parseTenantToken verifies an HS256 JWT signed with secret and returns its
+// tid claim, which must be a positive integer. exp and nbf are checked
+// against now when present.
+func parseTenantToken(token string, secret []byte, now time.Time) (store.TenantID, error) {
+    parts := strings.Split(strings.TrimSpace(token), ".")
+    if len(parts) != 3 {
+        return 0, errInvalidTenantToken
+    }
+
+    var header struct {
+        Alg string `json:"alg"`
+    }
+    if err := decodeTokenPart(parts[0], &header); err != nil || header.Alg != "HS256" {
+        return 0, errInvalidTenantToken
+    }
+    sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+    if err != nil {
+        return 0, errInvalidTenantToken
+    }
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(parts[0] + "." + parts[1]))
+    if !hmac.Equal(sig, mac.Sum(nil)) {
+        return 0, errInvalidTenantToken
+    }
+
+    var claims struct {
+        TID *int64 `json:"tid"`
+        Exp *int64 `json:"exp"`
+        Nbf *int64 `json:"nbf"`
+    }
+    if err := decodeTokenPart(parts[1], &claims); err != nil {
+        return 0, errInvalidTenantToken
+    }
+    if claims.TID == nil || *claims.TID <= 0 {
+        return 0, errInvalidTenantToken
+    }
+    if claims.Exp != nil && now.Unix() >= *claims.Exp {
+        return 0, errInvalidTenantToken
+    }
+    if claims.Nbf != nil && now.Unix() < *claims.Nbf {
+        return 0, errInvalidTenantToken
+    }
+    return store.TenantID(*claims.TID), nil
+}
+
+func decodeTokenPart(part string, v any) error {
+    data, err := base64.RawURLEncoding.DecodeString(part)
+    if err != nil {
+        return err
+    }
+    return json.Unmarshal(data, v)
+}
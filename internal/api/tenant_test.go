@@ -0,0 +1,195 @@
+package api_test
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+var tenantSecret = []byte("tenant-secret")
+
+func signTenantToken(t *testing.T, secret []byte, header, claims map[string]any) string {
+    t.Helper()
+
+    enc := func(v any) string {
+        data, err := json.Marshal(v)
+        if err != nil {
+            t.Fatalf("marshal token part: %v", err)
+        }
+        return base64.RawURLEncoding.EncodeToString(data)
+    }
+    signed := enc(header) + "." + enc(claims)
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(signed))
+    return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func tenantToken(t *testing.T, tid int64) string {
+    t.Helper()
+
+    return signTenantToken(t, tenantSecret, map[string]any{"alg": "HS256", "typ": "JWT"}, map[string]any{"tid": tid})
+}
+
+func TestTenantMiddleware(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithTenantSecret(tenantSecret))
+    handler := srv.Routes()
+
+    hs256 := map[string]any{"alg": "HS256"}
+    tests := []struct {
+        name   string
+        token  string
+        status int
+    }{
+        {"missing", "", http.StatusUnauthorized},
+        {"malformed", "not-a-jwt", http.StatusUnauthorized},
+        {"bad signature", signTenantToken(t, []byte("other"), hs256, map[string]any{"tid": 1}), http.StatusUnauthorized},
+        {"alg none", signTenantToken(t, tenantSecret, map[string]any{"alg": "none"}, map[string]any{"tid": 1}), http.StatusUnauthorized},
+        {"missing tid", signTenantToken(t, tenantSecret, hs256, map[string]any{"sub": "x"}), http.StatusUnauthorized},
+        {"zero tid", signTenantToken(t, tenantSecret, hs256, map[string]any{"tid": 0}), http.StatusUnauthorized},
+        {"fractional tid", signTenantToken(t, tenantSecret, hs256, map[string]any{"tid": 1.5}), http.StatusUnauthorized},
+        {"expired", signTenantToken(t, tenantSecret, hs256, map[string]any{"tid": 1, "exp": time.Now().Add(-time.Minute).Unix()}), http.StatusUnauthorized},
+        {"not yet valid", signTenantToken(t, tenantSecret, hs256, map[string]any{"tid": 1, "nbf": time.Now().Add(time.Hour).Unix()}), http.StatusUnauthorized},
+        // A valid token reaches the handler, which rejects the id before
+        // touching the store.
+        {"valid", tenantToken(t, 1), http.StatusBadRequest},
+        {"valid with exp", signTenantToken(t, tenantSecret, hs256, map[string]any{"tid": 2, "exp": time.Now().Add(time.Hour).Unix()}), http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest(http.MethodGet, "/v1/users/abc", nil)
+            req.Header.Set("Authorization", "Bearer test-token")
+            if tt.token != "" {
+                req.Header.Set("X-Tenant-Token", tt.token)
+            }
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+            if rec.Code != tt.status {
+                t.Fatalf("expected %d, got %d: %s", tt.status, rec.Code, rec.Body)
+            }
+        })
+    }
+}
+
+func TestTenantMiddlewareDisabled(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/users/abc", nil)
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+    if rec.Code != http.StatusBadRequest {
+        t.Fatalf("expected %d without a tenant secret, got %d", http.StatusBadRequest, rec.Code)
+    }
+}
+
+func TestTenantIsolation(t *testing.T) {
+    env := setupTest(t, api.WithTenantSecret(tenantSecret))
+    defer env.close()
+
+    tenant1 := map[string]string{"X-Tenant-Token": tenantToken(t, 1)}
+    tenant2 := map[string]string{"X-Tenant-Token": tenantToken(t, 2)}
+
+    resp := env.doRequestWithHeaders(t, http.MethodPost, "/v1/users", `{"id":1,"balance":1000}`, tenant1)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("expected %d, got %d", http.StatusCreated, resp.StatusCode)
+    }
+    resp = env.doRequestWithHeaders(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`, tenant1)
+    var created withdrawalResponse
+    if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("expected %d, got %d", http.StatusCreated, resp.StatusCode)
+    }
+
+    resp = env.doRequestWithHeaders(t, http.MethodGet, "/v1/users/1", "", tenant1)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("tenant 1: expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+
+    for _, path := range []string{"/v1/users/1", fmt.Sprintf("/v1/withdrawals/%d", created.ID)} {
+        resp = env.doRequestWithHeaders(t, http.MethodGet, path, "", tenant2)
+        var body errorEnvelope
+        if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+            t.Fatalf("decode response: %v", err)
+        }
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusForbidden || body.Code != "forbidden" {
+            t.Fatalf("tenant 2 %s: expected 403 forbidden, got %d %q", path, resp.StatusCode, body.Code)
+        }
+    }
+
+    resp = env.doRequestWithHeaders(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k2"}`, tenant2)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusForbidden {
+        t.Fatalf("tenant 2 create: expected %d, got %d", http.StatusForbidden, resp.StatusCode)
+    }
+
+    resp = env.doRequestWithHeaders(t, http.MethodGet, "/v1/withdrawals?user_id=1", "", tenant2)
+    var list struct {
+        Withdrawals []withdrawalResponse `json:"withdrawals"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK || len(list.Withdrawals) != 0 {
+        t.Fatalf("tenant 2 list: expected no withdrawals, got %d %+v", resp.StatusCode, list.Withdrawals)
+    }
+}
+
+func TestConfirmByCreatingKey(t *testing.T) {
+    env := setupTest(t, api.WithConfirmByCreatingKey(true), api.WithAuthKeys(map[string]string{
+        "partner-a": "token-a",
+        "partner-b": "token-b",
+    }))
+    defer env.close()
+
+    keyA := map[string]string{"Authorization": "Bearer token-a"}
+    keyB := map[string]string{"Authorization": "Bearer token-b"}
+
+    seedUser(t, env.pool, 1, 1000)
+    resp := env.doRequestWithHeaders(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`, keyA)
+    var created struct {
+        ID           int64  `json:"id"`
+        CreatedByKey string `json:"created_by_key"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated || created.CreatedByKey != "partner-a" {
+        t.Fatalf("expected %d with created_by_key partner-a, got %d %+v", http.StatusCreated, resp.StatusCode, created)
+    }
+
+    path := fmt.Sprintf("/v1/withdrawals/%d/confirm", created.ID)
+    resp = env.doRequestWithHeaders(t, http.MethodPost, path, "", keyB)
+    var body errorEnvelope
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusForbidden || body.Code != "forbidden" {
+        t.Fatalf("key b: expected 403 forbidden, got %d %q", resp.StatusCode, body.Code)
+    }
+
+    resp = env.doRequestWithHeaders(t, http.MethodPost, path, "", keyA)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("key a: expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+}
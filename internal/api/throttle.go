@@ -0,0 +1,52 @@
+package api
+
+import (
+    "sync"
+    "time"
+)
+
+const touchInterval = time.Minute
+
+// idThrottle allows one call per id per interval. It is in-memory, so each
+// replica enforces the limit on its own.
+type idThrottle struct {
+    interval time.Duration
+    now      func() time.Time
+
+    mu        sync.Mutex
+    last      map[int64]time.Time
+    lastSweep time.Time
+}
+
+func newIDThrottle(interval time.Duration) *idThrottle {
+    return &idThrottle{
+        interval: interval,
+        now:      time.Now,
+        last:     make(map[int64]time.Time),
+    }
+}
+
+// allow reports whether a call for id may proceed and, if not, how long the
+// caller should wait.
+func (t *idThrottle) allow(id int64) (bool, time.Duration) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+
+    now := t.now()
+    if now.Sub(t.lastSweep) >= t.interval {
+        for k, at := range t.last {
+            if now.Sub(at) >= t.interval {
+                delete(t.last, k)
+            }
+        }
+        t.lastSweep = now
+    }
+
+    if at, ok := t.last[id]; ok {
+        if wait := t.interval - now.Sub(at); wait > 0 {
+            return false, wait
+        }
+    }
+    t.last[id] = now
+    return true, 0
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+    "net/http"
+    "strconv"
+    "strings"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("task.hh/internal/api")
+
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+    r.status = status
+    r.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses.
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+    return r.ResponseWriter
+}
+
+// tracingMiddleware starts a server span per request, continuing the trace
+// from an incoming traceparent header when present.
+func (s *Server) tracingMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+        route := routePattern(r.URL.Path)
+        ctx, span := tracer.Start(ctx, r.Method+" "+route,
+            trace.WithSpanKind(trace.SpanKindServer),
+            trace.WithAttributes(
+                attribute.String("http.request.method", r.Method),
+                attribute.String("http.route", route),
+            ),
+        )
+        defer span.End()
+
+        rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+        next.ServeHTTP(rec, r.WithContext(ctx))
+
+        span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+        if rec.status >= http.StatusInternalServerError {
+            span.SetStatus(codes.Error, http.StatusText(rec.status))
+        }
+    })
+}
+
+// routePattern replaces numeric path segments with {id} so span names stay
+// low-cardinality.
+func routePattern(path string) string {
+    parts := strings.Split(path, "/")
+    for i, p := range parts {
+        if _, err := strconv.ParseInt(p, 10, 64); err == nil {
+            parts[i] = "{id}"
+        }
+    }
+    return strings.Join(parts, "/")
+}
+
+func setSpanAttributes(r *http.Request, attrs ...attribute.KeyValue) {
+    trace.SpanFromContext(r.Context()).SetAttributes(attrs...)
+}
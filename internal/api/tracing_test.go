@@ -0,0 +1,49 @@
+package api_test
+
+import (
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/propagation"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+    "go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
+)
+
+func TestTracingPropagatesTraceparent(t *testing.T) {
+    recorder := tracetest.NewSpanRecorder()
+    otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+    otel.SetTextMapPropagator(propagation.TraceContext{})
+
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/currencies", nil)
+    req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    if rec.Code != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+    }
+
+    spans := recorder.Ended()
+    if len(spans) != 1 {
+        t.Fatalf("expected 1 span, got %d", len(spans))
+    }
+    span := spans[0]
+    if got := span.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+        t.Fatalf("expected trace id from traceparent, got %s", got)
+    }
+    if got := span.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+        t.Fatalf("expected parent span id from traceparent, got %s", got)
+    }
+    if span.Name() != "GET /v1/currencies" {
+        t.Fatalf("unexpected span name %q", span.Name())
+    }
+}
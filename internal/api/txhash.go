@@ -0,0 +1,90 @@
+package api
+
+import (
+    "encoding/json"
+    "errors"
+    "io"
+    "net/http"
+    "strconv"
+    "strings"
+
+    "go.opentelemetry.io/otel/attribute"
+
+    "task.hh/internal/store"
+)
+
+type recordTxHashRequest struct {
+    TxHash string `json:"tx_hash"`
+}
+
+// handleRecordTxHash records the on-chain transaction of a confirmed
+// withdrawal: POST /v1/withdrawals/{id}/tx-hash.
+func (s *Server) handleRecordTxHash(w http.ResponseWriter, r *http.Request, id int64) {
+    if r.Method != http.MethodPost {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+    setSpanAttributes(r, attribute.Int64("withdrawal_id", id))
+
+    var req recordTxHashRequest
+    dec := json.NewDecoder(r.Body)
+    dec.DisallowUnknownFields()
+    if err := dec.Decode(&req); err != nil {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    if err := dec.Decode(&struct{}{}); err != io.EOF {
+        writeError(w, http.StatusBadRequest, "invalid_request")
+        return
+    }
+    txHash := strings.TrimSpace(req.TxHash)
+
+    ctx := withAudit(r, "withdrawal.record_tx_hash", "withdrawal", func(recorded store.Withdrawal) (string, map[string]any) {
+        return strconv.FormatInt(recorded.ID, 10), map[string]any{
+            "user_id": recorded.UserID,
+            "tx_hash": txHash,
+        }
+    })
+    withdrawal, err := s.store.RecordExternalTxHash(ctx, id, txHash)
+    if err != nil {
+        failure := "internal_error"
+        switch {
+        case errors.Is(err, store.ErrInvalidTxHash):
+            failure = "invalid_tx_hash"
+            writeError(w, http.StatusBadRequest, failure)
+        case errors.Is(err, store.ErrNotFound):
+            failure = "not_found"
+            writeError(w, http.StatusNotFound, failure)
+        case errors.Is(err, store.ErrTenantMismatch):
+            failure = "forbidden"
+            writeError(w, http.StatusForbidden, failure)
+        case errors.Is(err, store.ErrTxHashConflict):
+            failure = "tx_hash_conflict"
+            writeError(w, http.StatusConflict, failure)
+        case errors.Is(err, store.ErrInvalidStatus):
+            failure = "invalid_status"
+            resp := errorResponse{Code: failure}
+            if current, err := s.store.GetWithdrawal(r.Context(), id); err == nil {
+                resp.CurrentStatus = current.Status
+            }
+            writeErrorResponse(w, http.StatusConflict, resp)
+        default:
+            s.logger.Printf("record tx hash error: %v", err)
+            writeError(w, http.StatusInternalServerError, "internal_error")
+        }
+        s.logEvent("withdrawal_tx_hash_failed", map[string]any{
+            "withdrawal_id": id,
+            "reason":        failure,
+        })
+        return
+    }
+
+    setSpanAttributes(r, attribute.Int64("user_id", withdrawal.UserID))
+    s.logEvent("withdrawal_tx_hash_recorded", map[string]any{
+        "withdrawal_id": withdrawal.ID,
+        "user_id":       withdrawal.UserID,
+        "tx_hash":       txHash,
+    })
+    w.Header().Set("ETag", withdrawalETag(withdrawal))
+    writeJSON(w, http.StatusOK, toWithdrawalResponse(withdrawal))
+}
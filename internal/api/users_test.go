@@ -1,14 +1,34 @@
 package api_test
 
 import (
+    "context"
     "encoding/json"
+    "fmt"
+    "io"
+    "log"
     "net/http"
+    "net/http/httptest"
+    "strings"
     "testing"
+
+    "task.hh/internal/api"
+    "task.hh/internal/store"
 )
 
 type userResponse struct {
-    ID      int64 `json:"id"`
-    Balance int64 `json:"balance"`
+    ID         int64   `json:"id"`
+    Balance    int64   `json:"balance"`
+    Tier       string  `json:"tier"`
+    ExternalID *string `json:"external_id"`
+    Stats      *struct {
+        WithdrawalCount int64 `json:"withdrawal_count"`
+        TotalWithdrawn  int64 `json:"total_withdrawn"`
+        PendingAmount   int64 `json:"pending_amount"`
+        ByStatus        map[string]struct {
+            Count  int64 `json:"count"`
+            Amount int64 `json:"amount"`
+        } `json:"by_status"`
+    } `json:"stats"`
 }
 
 func TestCreateUserSuccess(t *testing.T) {
@@ -55,3 +75,387 @@ func TestCreateUserConflict(t *testing.T) {
         t.Fatalf("expected balance 100, got %d", balance)
     }
 }
+
+func TestUserExternalID(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/users", `{"id":1,"balance":1000,"external_id":"crm-42"}`)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("expected %d, got %d", http.StatusCreated, resp.StatusCode)
+    }
+
+    dup := env.doRequest(t, http.MethodPost, "/v1/users", `{"id":2,"balance":1000,"external_id":"crm-42"}`)
+    body, _ := io.ReadAll(dup.Body)
+    dup.Body.Close()
+    if dup.StatusCode != http.StatusConflict || !strings.Contains(string(body), "external_id_exists") {
+        t.Fatalf("expected 409 external_id_exists, got %d %s", dup.StatusCode, body)
+    }
+
+    lookup := env.doRequest(t, http.MethodGet, "/v1/users?external_id=crm-42", "")
+    defer lookup.Body.Close()
+    if lookup.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, lookup.StatusCode)
+    }
+    var got userResponse
+    if err := json.NewDecoder(lookup.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.ID != 1 || got.ExternalID == nil || *got.ExternalID != "crm-42" {
+        t.Fatalf("unexpected user: %+v", got)
+    }
+
+    missing := env.doRequest(t, http.MethodGet, "/v1/users?external_id=crm-43", "")
+    missing.Body.Close()
+    if missing.StatusCode != http.StatusNotFound {
+        t.Fatalf("expected %d, got %d", http.StatusNotFound, missing.StatusCode)
+    }
+}
+
+func TestUserExternalIDInvalid(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    requests := []struct {
+        method string
+        path   string
+        body   string
+    }{
+        {http.MethodGet, "/v1/users", ""},
+        {http.MethodPost, "/v1/users", `{"id":1,"balance":0,"external_id":""}`},
+        {http.MethodPost, "/v1/users", `{"id":1,"balance":0,"external_id":" crm-42"}`},
+        {http.MethodPost, "/v1/users", `{"id":1,"balance":0,"external_id":"` + strings.Repeat("x", 129) + `"}`},
+    }
+    for _, tt := range requests {
+        req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%s %s: expected %d, got %d", tt.method, tt.body, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
+
+func TestUpdateUserTier(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 100)
+
+    for _, tier := range []string{"premium", "enterprise", "standard"} {
+        resp := env.doRequest(t, http.MethodPut, "/v1/users/1/tier", `{"tier":"`+tier+`"}`)
+
+        if resp.StatusCode != http.StatusOK {
+            resp.Body.Close()
+            t.Fatalf("tier %s: expected %d, got %d", tier, http.StatusOK, resp.StatusCode)
+        }
+
+        var got userResponse
+        if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+            resp.Body.Close()
+            t.Fatalf("decode response: %v", err)
+        }
+        resp.Body.Close()
+
+        if got.ID != 1 || got.Tier != tier || got.Balance != 100 {
+            t.Fatalf("unexpected response: id=%d tier=%s balance=%d", got.ID, got.Tier, got.Balance)
+        }
+    }
+}
+
+func TestUpdateUserTierUserNotFound(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    resp := env.doRequest(t, http.MethodPut, "/v1/users/42/tier", `{"tier":"premium"}`)
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusNotFound {
+        t.Fatalf("expected %d, got %d", http.StatusNotFound, resp.StatusCode)
+    }
+}
+
+func TestUpdateUserTierInvalid(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, body := range []string{`{"tier":"gold"}`, `{"tier":""}`, `{"tier":"Premium"}`} {
+        req := httptest.NewRequest(http.MethodPut, "/v1/users/1/tier", strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%s: expected %d, got %d", body, http.StatusBadRequest, rec.Code)
+        }
+        if !strings.Contains(rec.Body.String(), "invalid_tier") {
+            t.Fatalf("%s: expected invalid_tier, got %s", body, rec.Body.String())
+        }
+    }
+}
+
+func TestGetUser(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+
+    resp := env.doRequest(t, http.MethodGet, "/v1/users/1", "")
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+
+    var got userResponse
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.ID != 1 || got.Balance != 900 || got.Stats != nil {
+        t.Fatalf("unexpected response: %+v", got)
+    }
+}
+
+func TestGetUserWithStats(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    first := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    createWithdrawal(t, env, `{"user_id":1,"amount":200,"currency":"USDT","destination":"addr","idempotency_key":"k2"}`)
+
+    confirm := env.doRequest(t, http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/confirm", first.ID), "")
+    confirm.Body.Close()
+
+    resp := env.doRequest(t, http.MethodGet, "/v1/users/1?include=stats", "")
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+
+    var got userResponse
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.Stats == nil {
+        t.Fatalf("expected stats in response")
+    }
+    if got.Stats.WithdrawalCount != 2 || got.Stats.TotalWithdrawn != 100 || got.Stats.PendingAmount != 200 {
+        t.Fatalf("unexpected stats: %+v", *got.Stats)
+    }
+    if got.Stats.ByStatus["pending"].Count != 1 {
+        t.Fatalf("unexpected pending stats: %+v", got.Stats.ByStatus)
+    }
+}
+
+func TestGetLedgerSummary(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    seedUser(t, env.pool, 2, 1000)
+    createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    createWithdrawal(t, env, `{"user_id":1,"amount":50,"currency":"USDT","destination":"addr","idempotency_key":"k2"}`)
+
+    for _, tc := range []struct {
+        path   string
+        status int
+        want   string
+    }{
+        {"/v1/users/1/ledger/summary", http.StatusOK, `{"user_id":1,"count":2,"debits":150,"credits":0,"net":-150}`},
+        {"/v1/users/2/ledger/summary", http.StatusOK, `{"user_id":2,"count":0,"debits":0,"credits":0,"net":0}`},
+        {"/v1/users/1/ledger/summary?to=2000-01-01T00:00:00Z", http.StatusOK, `{"user_id":1,"count":0,"debits":0,"credits":0,"net":0}`},
+        {"/v1/users/3/ledger/summary", http.StatusNotFound, ""},
+        {"/v1/users/1/fee-summary", http.StatusOK, `{"user_id":1,"fees":[]}`},
+        {"/v1/users/3/fee-summary", http.StatusNotFound, ""},
+    } {
+        resp := env.doRequest(t, http.MethodGet, tc.path, "")
+        body, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if resp.StatusCode != tc.status {
+            t.Fatalf("%s: expected %d, got %d", tc.path, tc.status, resp.StatusCode)
+        }
+        if tc.want != "" && strings.TrimSpace(string(body)) != tc.want {
+            t.Fatalf("%s: unexpected body %s", tc.path, body)
+        }
+    }
+}
+
+func TestGetUserInvalidInclude(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/users/1?include=ledger", nil)
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    if rec.Code != http.StatusBadRequest {
+        t.Fatalf("expected %d, got %d", http.StatusBadRequest, rec.Code)
+    }
+}
+
+func TestCreateUsersBatch(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 2, 50)
+
+    body := `[{"id":1,"balance":100},{"id":2,"balance":200},{"id":3,"balance":-1},{"id":1,"balance":300},{"id":4,"balance":0}]`
+    resp := env.doRequest(t, http.MethodPost, "/v1/users:batch", body)
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+
+    var got struct {
+        Created int `json:"created"`
+        Failed  int `json:"failed"`
+        Results []struct {
+            ID     int64  `json:"id"`
+            Status string `json:"status"`
+            Error  string `json:"error"`
+        } `json:"results"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.Created != 2 || got.Failed != 3 || len(got.Results) != 5 {
+        t.Fatalf("unexpected summary: %+v", got)
+    }
+    want := []string{"created", "user_exists", "invalid_request", "user_exists", "created"}
+    for i, res := range got.Results {
+        outcome := res.Status
+        if res.Status == "error" {
+            outcome = res.Error
+        }
+        if outcome != want[i] {
+            t.Fatalf("result %d (id %d): expected %s, got %s", i, res.ID, want[i], outcome)
+        }
+    }
+
+    if balance := getBalance(t, env.pool, 1); balance != 100 {
+        t.Fatalf("expected balance 100, got %d", balance)
+    }
+    if balance := getBalance(t, env.pool, 2); balance != 50 {
+        t.Fatalf("expected existing balance 50 to be untouched, got %d", balance)
+    }
+}
+
+func TestCreateUsersBatchAtomic(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 2, 50)
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/users:batch?atomic=true", `[{"id":1,"balance":100},{"id":2,"balance":200},{"id":3,"balance":300}]`)
+    var failed struct {
+        Code    string `json:"code"`
+        Details struct {
+            Index int   `json:"index"`
+            ID    int64 `json:"id"`
+        } `json:"details"`
+    }
+    err := json.NewDecoder(resp.Body).Decode(&failed)
+    resp.Body.Close()
+    if err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if resp.StatusCode != http.StatusConflict || failed.Code != "user_exists" || failed.Details.Index != 1 || failed.Details.ID != 2 {
+        t.Fatalf("expected 409 user_exists for item 1, got %d %+v", resp.StatusCode, failed)
+    }
+    var count int
+    if err := env.pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+        t.Fatalf("count users: %v", err)
+    }
+    if count != 1 {
+        t.Fatalf("expected the failed batch to create no users, found %d", count)
+    }
+
+    resp = env.doRequest(t, http.MethodPost, "/v1/users:batch?atomic=true", `[{"id":1,"balance":100},{"id":3,"balance":300}]`)
+    var created struct {
+        Created int `json:"created"`
+        Failed  int `json:"failed"`
+    }
+    err = json.NewDecoder(resp.Body).Decode(&created)
+    resp.Body.Close()
+    if err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if resp.StatusCode != http.StatusOK || created.Created != 2 || created.Failed != 0 {
+        t.Fatalf("expected both users created, got %d %+v", resp.StatusCode, created)
+    }
+    if balance := getBalance(t, env.pool, 3); balance != 300 {
+        t.Fatalf("expected balance 300, got %d", balance)
+    }
+}
+
+func TestCreateUsersBatchAtomicInvalidItem(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodPost, "/v1/users:batch?atomic=true", strings.NewReader(`[{"id":1,"balance":1},{"id":2,"balance":-1}]`))
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"details":{"index":1,"id":2}`) {
+        t.Fatalf("expected 400 naming item 1, got %d %s", rec.Code, rec.Body.String())
+    }
+
+    req = httptest.NewRequest(http.MethodPost, "/v1/users:batch?atomic=maybe", strings.NewReader(`[{"id":1,"balance":1}]`))
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec = httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    if rec.Code != http.StatusBadRequest {
+        t.Fatalf("expected 400 for an invalid atomic flag, got %d", rec.Code)
+    }
+}
+
+func TestCreateUserMalformedNumbers(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, tt := range []struct {
+        path string
+        body string
+        code string
+    }{
+        {"/v1/users", `{"id":1,"balance":200.0}`, "amount_not_integer"},
+        {"/v1/users", `{"id":1,"balance":200.5}`, "amount_not_integer"},
+        {"/v1/users", `{"id":1,"balance":1e3}`, "amount_not_integer"},
+        {"/v1/users", `{"id":1,"balance":9223372036854775808}`, "amount_out_of_range"},
+        {"/v1/users", `{"id":1,"balance":"200"}`, "invalid_request"},
+        {"/v1/users", `{"id":1.5,"balance":200}`, "amount_not_integer"},
+        {"/v1/users", `{"id":9223372036854775808,"balance":200}`, "amount_out_of_range"},
+        {"/v1/users:batch", `[{"id":1,"balance":200},{"id":2,"balance":200.5}]`, "amount_not_integer"},
+        {"/v1/users:batch", `[{"id":1,"balance":9223372036854775808}]`, "amount_out_of_range"},
+    } {
+        req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"`+tt.code+`"`) {
+            t.Fatalf("%s %s: expected 400 %s, got %d %s", tt.path, tt.body, tt.code, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestCreateUsersBatchSize(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    tooMany := "[" + strings.TrimSuffix(strings.Repeat(`{"id":1,"balance":1},`, 1001), ",") + "]"
+    for _, body := range []string{"[]", tooMany} {
+        req := httptest.NewRequest(http.MethodPost, "/v1/users:batch", strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_batch_size") {
+            t.Fatalf("expected 400 invalid_batch_size, got %d %s", rec.Code, rec.Body.String())
+        }
+    }
+}
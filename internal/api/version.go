@@ -0,0 +1,48 @@
+package api
+
+import (
+    "net/http"
+
+    "task.hh/internal/buildinfo"
+)
+
+type versionResponse struct {
+    Commit     string             `json:"commit"`
+    BuildTime  string             `json:"build_time"`
+    GoVersion  string             `json:"go_version"`
+    Currencies []currencyResponse `json:"currencies"`
+    Features   map[string]bool    `json:"features"`
+}
+
+// features reports the optional behaviours of the server and its store.
+// Secrets are never included, only whether they are configured.
+func (s *Server) features() map[string]bool {
+    features := s.store.Features()
+    features["admin_endpoints"] = s.adminToken != ""
+    features["multi_tenant"] = len(s.tenantSecret) > 0
+    features["response_signing"] = len(s.signingKeys) > 0
+    features["operator_required"] = s.operatorRequired
+    features["confirm_by_creating_key"] = s.confirmByCreatingKey
+    features["replay_status_ok"] = s.replayStatusOK
+    features["debug_log_bodies"] = s.bodyLogger != nil
+    features["maintenance"] = s.maintenance.Load()
+    return features
+}
+
+// handleVersion tells ops which build is running and with what effective
+// configuration. It needs no authentication.
+func (s *Server) handleVersion(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        writeError(w, http.StatusMethodNotAllowed, "method_not_allowed")
+        return
+    }
+
+    info := buildinfo.Get()
+    writeJSON(w, http.StatusOK, versionResponse{
+        Commit:     info.Commit,
+        BuildTime:  info.BuildTime,
+        GoVersion:  info.GoVersion,
+        Currencies: s.currencyResponses(),
+        Features:   s.features(),
+    })
+}
@@ -0,0 +1,73 @@
+package api_test
+
+import (
+    "encoding/json"
+    "io"
+    "log"
+    "net/http"
+    "net/http/httptest"
+    "runtime"
+    "testing"
+    "time"
+
+    "task.hh/internal/api"
+    "task.hh/internal/buildinfo"
+    "task.hh/internal/store"
+)
+
+func TestVersion(t *testing.T) {
+    commit, buildTime := buildinfo.Commit, buildinfo.BuildTime
+    buildinfo.Commit, buildinfo.BuildTime = "0123abc", "2026-01-02T03:04:05Z"
+    defer func() {
+        buildinfo.Commit, buildinfo.BuildTime = commit, buildTime
+    }()
+
+    st := store.New(nil, store.WithReservationTTL(time.Minute))
+    srv := api.NewServer(st, "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+
+    // No Authorization header: the endpoint is public.
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/version", nil))
+    if rec.Code != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+    }
+
+    var got struct {
+        Commit     *string `json:"commit"`
+        BuildTime  *string `json:"build_time"`
+        GoVersion  *string `json:"go_version"`
+        Currencies []struct {
+            Code string `json:"code"`
+        } `json:"currencies"`
+        Features map[string]bool `json:"features"`
+    }
+    if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.Commit == nil || *got.Commit != "0123abc" || got.BuildTime == nil || *got.BuildTime != "2026-01-02T03:04:05Z" {
+        t.Fatalf("expected the linked commit and build time, got %+v", got)
+    }
+    if got.GoVersion == nil || *got.GoVersion != runtime.Version() {
+        t.Fatalf("expected go_version %s, got %v", runtime.Version(), got.GoVersion)
+    }
+    if len(got.Currencies) != 1 || got.Currencies[0].Code != "USDT" {
+        t.Fatalf("expected the configured currencies, got %+v", got.Currencies)
+    }
+    for flag, want := range map[string]bool{
+        "admin_endpoints":    true,
+        "reservation_expiry": true,
+        "multi_tenant":       false,
+        "withdrawal_fees":    false,
+        "maintenance":        false,
+    } {
+        if enabled, ok := got.Features[flag]; !ok || enabled != want {
+            t.Fatalf("expected feature %s = %v, got %+v", flag, want, got.Features)
+        }
+    }
+
+    rec = httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/version", nil))
+    if rec.Code != http.StatusMethodNotAllowed {
+        t.Fatalf("POST: expected %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+    }
+}
@@ -10,6 +10,8 @@ import (
     "net/http/httptest"
     "os"
     "path/filepath"
+    "regexp"
+    "strconv"
     "strings"
     "sync"
     "testing"
@@ -19,6 +21,7 @@ import (
 
     "task.hh/internal/api"
     "task.hh/internal/store"
+    "task.hh/internal/testutil/fixtures"
 )
 
 type testEnv struct {
@@ -29,37 +32,50 @@ type testEnv struct {
 }
 
 type withdrawalResponse struct {
-    ID             int64  `json:"id"`
-    UserID         int64  `json:"user_id"`
-    Amount         int64  `json:"amount"`
-    Currency       string `json:"currency"`
-    Destination    string `json:"destination"`
-    Status         string `json:"status"`
-    IdempotencyKey string `json:"idempotency_key"`
+    ID               int64      `json:"id"`
+    UserID           int64      `json:"user_id"`
+    Amount           int64      `json:"amount"`
+    Currency         string     `json:"currency"`
+    Destination      string     `json:"destination"`
+    Status           string     `json:"status"`
+    IdempotencyKey   string     `json:"idempotency_key"`
+    ResultingBalance *int64     `json:"resulting_balance"`
+    ConfirmedAt      *time.Time `json:"confirmed_at"`
+    NoteCount        int        `json:"note_count"`
+    Version          int64      `json:"version"`
+    ExternalTxHash   *string    `json:"external_tx_hash"`
 }
 
-func setupTest(t *testing.T) *testEnv {
+func setupTest(t *testing.T, opts ...api.Option) *testEnv {
     t.Helper()
 
-    dbURL := os.Getenv("DATABASE_URL")
-    if dbURL == "" {
-        t.Skip("DATABASE_URL is not set")
+    return setupTestWithStore(t, nil, opts...)
+}
+
+// setupTestWithStore is setupTest with extra options for the store behind
+// the server.
+func setupTestWithStore(t *testing.T, storeOpts []store.Option, opts ...api.Option) *testEnv {
+    t.Helper()
+
+    if testDatabaseURL == "" {
+        t.Skip("DATABASE_URL is not set and no Docker host is available")
     }
 
     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
 
-    pool, err := pgxpool.New(ctx, dbURL)
+    pool, err := pgxpool.New(ctx, testDatabaseURL)
     if err != nil {
         t.Fatalf("db connection: %v", err)
     }
-    defer pool.Close()
 
     applySchema(t, pool)
     resetDB(t, pool)
 
     authToken := "test-token"
-    srv := api.NewServer(store.New(pool), authToken, log.New(io.Discard, "", 0))
+    // The balance audit is on by default in deployments, so it is here too.
+    st := store.New(pool, append([]store.Option{store.WithAuditSink(store.PostgresAuditSink{})}, storeOpts...)...)
+    srv := api.NewServer(st, authToken, log.New(io.Discard, "", 0), opts...)
     ts := httptest.NewServer(srv.Routes())
 
     return &testEnv{
@@ -78,12 +94,21 @@ func (e *testEnv) close() {
 func (e *testEnv) doRequest(t *testing.T, method, path, body string) *http.Response {
     t.Helper()
 
+    return e.doRequestWithHeaders(t, method, path, body, nil)
+}
+
+func (e *testEnv) doRequestWithHeaders(t *testing.T, method, path, body string, headers map[string]string) *http.Response {
+    t.Helper()
+
     req, err := http.NewRequest(method, e.server.URL+path, strings.NewReader(body))
     if err != nil {
         t.Fatalf("new request: %v", err)
     }
     req.Header.Set("Authorization", "Bearer "+e.authToken)
     req.Header.Set("Content-Type", "application/json")
+    for k, v := range headers {
+        req.Header.Set(k, v)
+    }
 
     resp, err := e.client.Do(req)
     if err != nil {
@@ -96,9 +121,9 @@ func TestCreateWithdrawalSuccess(t *testing.T) {
     env := setupTest(t)
     defer env.close()
 
-    seedUser(t, env.pool, 1, 1000)
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
 
-    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":200,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", fixtures.WithdrawalRequest().Set("amount", 200).JSON())
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusCreated {
@@ -113,15 +138,18 @@ func TestCreateWithdrawalSuccess(t *testing.T) {
     if got.Status != store.StatusPending {
         t.Fatalf("expected status %s, got %s", store.StatusPending, got.Status)
     }
+    if got.ResultingBalance == nil || *got.ResultingBalance != 800 {
+        t.Fatalf("expected resulting_balance 800, got %v", got.ResultingBalance)
+    }
 
     balance := getBalance(t, env.pool, 1)
     if balance != 800 {
         t.Fatalf("expected balance 800, got %d", balance)
     }
 
-    count, sum := getLedgerSummary(t, env.pool, 1)
-    if count != 1 || sum != 200 {
-        t.Fatalf("expected ledger count 1 and sum 200, got %d and %d", count, sum)
+    ledger := getLedgerSummary(t, env.pool, 1)
+    if ledger.Count != 1 || ledger.Debits != 200 {
+        t.Fatalf("expected ledger count 1 and debits 200, got %d and %d", ledger.Count, ledger.Debits)
     }
 }
 
@@ -129,9 +157,9 @@ func TestCreateWithdrawalInsufficientBalance(t *testing.T) {
     env := setupTest(t)
     defer env.close()
 
-    seedUser(t, env.pool, 1, 100)
+    fixtures.User(t, env.pool).WithBalance(100).Create()
 
-    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":200,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", fixtures.WithdrawalRequest().Set("amount", 200).JSON())
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusConflict {
@@ -148,9 +176,8 @@ func TestCreateWithdrawalInsufficientBalance(t *testing.T) {
         t.Fatalf("expected 0 withdrawals, got %d", count)
     }
 
-    ledgerCount, _ := getLedgerSummary(t, env.pool, 1)
-    if ledgerCount != 0 {
-        t.Fatalf("expected 0 ledger entries, got %d", ledgerCount)
+    if ledger := getLedgerSummary(t, env.pool, 1); ledger.Count != 0 {
+        t.Fatalf("expected 0 ledger entries, got %d", ledger.Count)
     }
 }
 
@@ -158,9 +185,9 @@ func TestCreateWithdrawalIdempotency(t *testing.T) {
     env := setupTest(t)
     defer env.close()
 
-    seedUser(t, env.pool, 1, 1000)
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
 
-    body := `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`
+    body := fixtures.WithdrawalRequest().JSON()
 
     resp1 := env.doRequest(t, http.MethodPost, "/v1/withdrawals", body)
     defer resp1.Body.Close()
@@ -180,6 +207,9 @@ func TestCreateWithdrawalIdempotency(t *testing.T) {
     if resp2.StatusCode != http.StatusCreated {
         t.Fatalf("expected %d, got %d", http.StatusCreated, resp2.StatusCode)
     }
+    if resp1.Header.Get("Idempotency-Replayed") != "" || resp2.Header.Get("Idempotency-Replayed") != "true" {
+        t.Fatalf("expected only the replay to carry Idempotency-Replayed")
+    }
 
     var second withdrawalResponse
     if err := json.NewDecoder(resp2.Body).Decode(&second); err != nil {
@@ -189,6 +219,9 @@ func TestCreateWithdrawalIdempotency(t *testing.T) {
     if first.ID != second.ID {
         t.Fatalf("expected same withdrawal id, got %d and %d", first.ID, second.ID)
     }
+    if second.ResultingBalance == nil || *second.ResultingBalance != 900 {
+        t.Fatalf("expected replay resulting_balance 900, got %v", second.ResultingBalance)
+    }
 
     balance := getBalance(t, env.pool, 1)
     if balance != 900 {
@@ -200,9 +233,38 @@ func TestCreateWithdrawalIdempotency(t *testing.T) {
         t.Fatalf("expected 1 withdrawal, got %d", count)
     }
 
-    ledgerCount, sum := getLedgerSummary(t, env.pool, 1)
-    if ledgerCount != 1 || sum != 100 {
-        t.Fatalf("expected ledger count 1 and sum 100, got %d and %d", ledgerCount, sum)
+    ledger := getLedgerSummary(t, env.pool, 1)
+    if ledger.Count != 1 || ledger.Debits != 100 {
+        t.Fatalf("expected ledger count 1 and debits 100, got %d and %d", ledger.Count, ledger.Debits)
+    }
+}
+
+func TestCreateWithdrawalReplayStatusOK(t *testing.T) {
+    env := setupTest(t, api.WithReplayStatusOK(true))
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+
+    body := fixtures.WithdrawalRequest().JSON()
+
+    first := createWithdrawal(t, env, body)
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", body)
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    if resp.Header.Get("Idempotency-Replayed") != "true" {
+        t.Fatalf("expected Idempotency-Replayed: true")
+    }
+
+    var second withdrawalResponse
+    if err := json.NewDecoder(resp.Body).Decode(&second); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if first.ID != second.ID {
+        t.Fatalf("expected same withdrawal id, got %d and %d", first.ID, second.ID)
     }
 }
 
@@ -210,18 +272,30 @@ func TestCreateWithdrawalIdempotencyConflict(t *testing.T) {
     env := setupTest(t)
     defer env.close()
 
-    seedUser(t, env.pool, 1, 1000)
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
 
-    resp1 := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
-    resp1.Body.Close()
+    first := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
 
-    resp2 := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":200,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    resp2 := env.doRequest(t, http.MethodPost, "/v1/withdrawals", fixtures.WithdrawalRequest().Set("amount", 200).JSON())
     defer resp2.Body.Close()
 
     if resp2.StatusCode != http.StatusUnprocessableEntity {
         t.Fatalf("expected %d, got %d", http.StatusUnprocessableEntity, resp2.StatusCode)
     }
 
+    var conflict struct {
+        Code                 string `json:"code"`
+        ExistingWithdrawalID int64  `json:"existing_withdrawal_id"`
+        ExistingAmount       int64  `json:"existing_amount"`
+        ExistingCurrency     string `json:"existing_currency"`
+    }
+    if err := json.NewDecoder(resp2.Body).Decode(&conflict); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if conflict.Code != "idempotency_conflict" || conflict.ExistingWithdrawalID != first.ID || conflict.ExistingAmount != 100 || conflict.ExistingCurrency != "USDT" {
+        t.Fatalf("unexpected conflict body: %+v", conflict)
+    }
+
     balance := getBalance(t, env.pool, 1)
     if balance != 900 {
         t.Fatalf("expected balance 900, got %d", balance)
@@ -233,13 +307,31 @@ func TestCreateWithdrawalIdempotencyConflict(t *testing.T) {
     }
 }
 
+func TestCreateWithdrawalIdempotencyConflictDecimal(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals?amount_format=decimal", fixtures.WithdrawalRequest().Set("amount", 200).JSON())
+    body, _ := io.ReadAll(resp.Body)
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusUnprocessableEntity {
+        t.Fatalf("expected %d, got %d", http.StatusUnprocessableEntity, resp.StatusCode)
+    }
+    if !strings.Contains(string(body), `"existing_amount":"0.000100"`) {
+        t.Fatalf("expected the existing amount as a decimal string, got %s", body)
+    }
+}
+
 func TestCreateWithdrawalInvalidAmount(t *testing.T) {
     env := setupTest(t)
     defer env.close()
 
-    seedUser(t, env.pool, 1, 1000)
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
 
-    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":0,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", fixtures.WithdrawalRequest().Set("amount", 0).JSON())
     defer resp.Body.Close()
 
     if resp.StatusCode != http.StatusBadRequest {
@@ -252,11 +344,47 @@ func TestCreateWithdrawalInvalidAmount(t *testing.T) {
     }
 }
 
+func TestCreateWithdrawalMalformedAmount(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, tt := range []struct {
+        field string
+        value string
+        code  string
+    }{
+        {"amount", "200.5", "amount_not_integer"},
+        {"amount", "200.0", "amount_not_integer"},
+        {"amount", `"200.0000001"`, "amount_not_integer"},
+        {"amount", "200.5e1", "amount_not_integer"},
+        {"amount", "1e3", "amount_not_integer"},
+        {"amount", "2E+2", "amount_not_integer"},
+        {"amount", "9223372036854775808", "amount_out_of_range"},
+        {"amount", `"92233720368547758.08"`, "amount_out_of_range"},
+        {"amount", `"two hundred"`, "invalid_amount"},
+        {"amount", "null", "invalid_amount"},
+        {"user_id", "1.0", "amount_not_integer"},
+        {"user_id", "1.5", "amount_not_integer"},
+        {"user_id", "1e3", "amount_not_integer"},
+        {"user_id", "9223372036854775808", "amount_out_of_range"},
+        {"user_id", `"1"`, "invalid_request"},
+    } {
+        body := fixtures.WithdrawalRequest().Set(tt.field, json.RawMessage(tt.value)).JSON()
+        req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals", strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), `"code":"`+tt.code+`"`) {
+            t.Fatalf("%s %s: expected 400 %s, got %d %s", tt.field, tt.value, tt.code, rec.Code, rec.Body.String())
+        }
+    }
+}
+
 func TestConcurrentWithdrawals(t *testing.T) {
     env := setupTest(t)
     defer env.close()
 
-    seedUser(t, env.pool, 1, 100)
+    fixtures.User(t, env.pool).WithBalance(100).Create()
 
     type result struct {
         status int
@@ -319,13 +447,96 @@ func TestConcurrentWithdrawals(t *testing.T) {
     }
 }
 
+func TestConcurrentWithdrawalsSameIdempotencyKey(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(10000).Create()
+
+    const workers = 20
+
+    type result struct {
+        status int
+        id     int64
+        err    error
+    }
+
+    var wg sync.WaitGroup
+    results := make(chan result, workers)
+
+    for i := 0; i < workers; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            amount := 100
+            if i%4 == 0 {
+                amount = 200
+            }
+            body := fmt.Sprintf(`{"user_id":1,"amount":%d,"currency":"USDT","destination":"addr","idempotency_key":"shared"}`, amount)
+            req, err := http.NewRequest(http.MethodPost, env.server.URL+"/v1/withdrawals", strings.NewReader(body))
+            if err != nil {
+                results <- result{err: err}
+                return
+            }
+            req.Header.Set("Authorization", "Bearer "+env.authToken)
+            req.Header.Set("Content-Type", "application/json")
+
+            resp, err := env.client.Do(req)
+            if err != nil {
+                results <- result{err: err}
+                return
+            }
+            defer resp.Body.Close()
+
+            var got withdrawalResponse
+            if resp.StatusCode == http.StatusCreated {
+                if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+                    results <- result{err: err}
+                    return
+                }
+            }
+            results <- result{status: resp.StatusCode, id: got.ID}
+        }(i)
+    }
+
+    wg.Wait()
+    close(results)
+
+    ids := make(map[int64]struct{})
+    for res := range results {
+        if res.err != nil {
+            t.Fatalf("request error: %v", res.err)
+        }
+        switch res.status {
+        case http.StatusCreated:
+            ids[res.id] = struct{}{}
+        case http.StatusUnprocessableEntity:
+        default:
+            t.Fatalf("unexpected status: %d", res.status)
+        }
+    }
+
+    if len(ids) != 1 {
+        t.Fatalf("expected all successful requests to share one withdrawal, got %d ids", len(ids))
+    }
+
+    count := getWithdrawalCount(t, env.pool, 1)
+    if count != 1 {
+        t.Fatalf("expected 1 withdrawal, got %d", count)
+    }
+
+    if ledger := getLedgerSummary(t, env.pool, 1); ledger.Count != 1 {
+        t.Fatalf("expected 1 ledger entry, got %d", ledger.Count)
+    }
+}
+
 func TestConfirmWithdrawalSuccess(t *testing.T) {
     env := setupTest(t)
     defer env.close()
 
-    seedUser(t, env.pool, 1, 1000)
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
 
-    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", fixtures.WithdrawalRequest().JSON())
     if resp.StatusCode != http.StatusCreated {
         resp.Body.Close()
         t.Fatalf("expected %d, got %d", http.StatusCreated, resp.StatusCode)
@@ -337,6 +548,9 @@ func TestConfirmWithdrawalSuccess(t *testing.T) {
         t.Fatalf("decode response: %v", err)
     }
     resp.Body.Close()
+    if created.ConfirmedAt != nil {
+        t.Fatalf("expected confirmed_at to be null for a new withdrawal, got %v", created.ConfirmedAt)
+    }
 
     confirm := env.doRequest(t, http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/confirm", created.ID), "")
     defer confirm.Body.Close()
@@ -353,15 +567,52 @@ func TestConfirmWithdrawalSuccess(t *testing.T) {
     if confirmed.Status != store.StatusConfirmed {
         t.Fatalf("expected status %s, got %s", store.StatusConfirmed, confirmed.Status)
     }
+    if confirmed.ConfirmedAt == nil {
+        t.Fatalf("expected confirmed_at to be set after confirmation")
+    }
+}
+
+func TestBalanceAuditPerWithdrawalAndConfirm(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().Set("amount", 300).JSON())
+
+    confirm := env.doRequest(t, http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/confirm", created.ID), "")
+    confirm.Body.Close()
+    if confirm.StatusCode != http.StatusOK {
+        t.Fatalf("confirm: expected %d, got %d", http.StatusOK, confirm.StatusCode)
+    }
+
+    changes, err := store.New(env.pool).ListBalanceChanges(context.Background(), created.ID)
+    if err != nil {
+        t.Fatalf("list balance changes: %v", err)
+    }
+    if len(changes) != 2 {
+        t.Fatalf("expected an audit row for the create and the confirm, got %+v", changes)
+    }
+    for i, want := range []struct {
+        operation     string
+        before, after int64
+    }{
+        {store.OperationWithdrawalCreate, 1000, 700},
+        {store.OperationWithdrawalConfirm, 700, 700},
+    } {
+        c := changes[i]
+        if c.Actor != "default" || c.Operation != want.operation || c.Amount != 300 || c.BalanceBefore != want.before || c.BalanceAfter != want.after {
+            t.Fatalf("change %d: expected %s by default %d -> %d, got %+v", i, want.operation, want.before, want.after, c)
+        }
+    }
 }
 
 func TestConfirmWithdrawalIdempotent(t *testing.T) {
     env := setupTest(t)
     defer env.close()
 
-    seedUser(t, env.pool, 1, 1000)
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
 
-    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", fixtures.WithdrawalRequest().JSON())
     if resp.StatusCode != http.StatusCreated {
         resp.Body.Close()
         t.Fatalf("expected %d, got %d", http.StatusCreated, resp.StatusCode)
@@ -404,59 +655,1289 @@ func TestConfirmWithdrawalNotFound(t *testing.T) {
     }
 }
 
-func seedUser(t *testing.T, pool *pgxpool.Pool, id int64, balance int64) {
-    t.Helper()
+func TestGetWithdrawalNotModified(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
 
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d", created.ID)
 
-    _, err := pool.Exec(ctx, "INSERT INTO users (id, balance) VALUES ($1, $2)", id, balance)
-    if err != nil {
-        t.Fatalf("seed user: %v", err)
+    first := env.doRequest(t, http.MethodGet, path, "")
+    first.Body.Close()
+    if first.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, first.StatusCode)
+    }
+    etag := first.Header.Get("ETag")
+    if etag == "" {
+        t.Fatalf("expected ETag header")
+    }
+
+    second := env.doRequestWithHeaders(t, http.MethodGet, path, "", map[string]string{"If-None-Match": etag})
+    defer second.Body.Close()
+
+    if second.StatusCode != http.StatusNotModified {
+        t.Fatalf("expected %d, got %d", http.StatusNotModified, second.StatusCode)
+    }
+    body, _ := io.ReadAll(second.Body)
+    if len(body) != 0 {
+        t.Fatalf("expected empty body, got %q", body)
     }
 }
 
-func getBalance(t *testing.T, pool *pgxpool.Pool, id int64) int64 {
-    t.Helper()
+func TestGetWithdrawalETagChangesWithStatus(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
 
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d", created.ID)
 
-    var balance int64
-    err := pool.QueryRow(ctx, "SELECT balance FROM users WHERE id = $1", id).Scan(&balance)
-    if err != nil {
-        t.Fatalf("get balance: %v", err)
+    first := env.doRequest(t, http.MethodGet, path, "")
+    first.Body.Close()
+    etag := first.Header.Get("ETag")
+
+    confirm := env.doRequest(t, http.MethodPost, path+"/confirm", "")
+    confirm.Body.Close()
+
+    second := env.doRequestWithHeaders(t, http.MethodGet, path, "", map[string]string{"If-None-Match": etag})
+    defer second.Body.Close()
+
+    if second.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, second.StatusCode)
+    }
+    if got := second.Header.Get("ETag"); got == "" || got == etag {
+        t.Fatalf("expected a new ETag, got %q (was %q)", got, etag)
+    }
+
+    var got withdrawalResponse
+    if err := json.NewDecoder(second.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.Status != store.StatusConfirmed {
+        t.Fatalf("expected status %s, got %s", store.StatusConfirmed, got.Status)
     }
-    return balance
 }
 
-func getWithdrawalCount(t *testing.T, pool *pgxpool.Pool, userID int64) int {
-    t.Helper()
+func TestGetWithdrawalIncludeLedger(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
 
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d", created.ID)
 
-    var count int
-    err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM withdrawals WHERE user_id = $1", userID).Scan(&count)
-    if err != nil {
-        t.Fatalf("get withdrawal count: %v", err)
+    type ledgerResponse struct {
+        LedgerEntries *[]struct {
+            Amount    int64  `json:"amount"`
+            Direction string `json:"direction"`
+        } `json:"ledger_entries"`
+    }
+
+    plain := env.doRequest(t, http.MethodGet, path, "")
+    var got ledgerResponse
+    if err := json.NewDecoder(plain.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    plain.Body.Close()
+    if got.LedgerEntries != nil {
+        t.Fatalf("expected no ledger_entries without include")
+    }
+
+    withLedger := env.doRequest(t, http.MethodGet, path+"?include=ledger", "")
+    defer withLedger.Body.Close()
+    if withLedger.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, withLedger.StatusCode)
+    }
+    if withLedger.Header.Get("ETag") == plain.Header.Get("ETag") {
+        t.Fatalf("expected the ledger variant to have its own ETag")
+    }
+    got = ledgerResponse{}
+    if err := json.NewDecoder(withLedger.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.LedgerEntries == nil || len(*got.LedgerEntries) != 1 {
+        t.Fatalf("expected one ledger entry, got %+v", got.LedgerEntries)
+    }
+    if e := (*got.LedgerEntries)[0]; e.Amount != 100 || e.Direction != store.DirectionDebit {
+        t.Fatalf("unexpected ledger entry: %+v", e)
     }
-    return count
 }
 
-func getLedgerSummary(t *testing.T, pool *pgxpool.Pool, userID int64) (int, int64) {
-    t.Helper()
+func TestGetWithdrawalEmbedUser(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
 
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d?embed=user", created.ID)
 
-    var count int
-    var sum int64
-    err := pool.QueryRow(ctx, "SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM ledger_entries WHERE user_id = $1", userID).Scan(&count, &sum)
+    resp := env.doRequest(t, http.MethodGet, path, "")
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    var got struct {
+        ID   int64 `json:"id"`
+        User *struct {
+            Balance int64  `json:"balance"`
+            Tier    string `json:"tier"`
+        } `json:"user"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.ID != created.ID || got.User == nil || got.User.Balance != 900 || got.User.Tier != "standard" {
+        t.Fatalf("unexpected response: %+v %+v", got, got.User)
+    }
+
+    // A balance change does not touch the withdrawal but must change the tag.
+    if _, err := env.pool.Exec(context.Background(), "UPDATE users SET balance = balance + 1 WHERE id = 1"); err != nil {
+        t.Fatalf("update balance: %v", err)
+    }
+    again := env.doRequestWithHeaders(t, http.MethodGet, path, "", map[string]string{"If-None-Match": resp.Header.Get("ETag")})
+    again.Body.Close()
+    if again.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d after a balance change, got %d", http.StatusOK, again.StatusCode)
+    }
+}
+
+func TestGetWithdrawalInvalidInclude(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    tests := []struct {
+        query   string
+        code    string
+        message string
+    }{
+        {"include=stats", "invalid_include", "include supports only ledger"},
+        {"include=ledger,history", "invalid_include", "include supports only ledger"},
+        {"include=,", "invalid_include", "include supports only ledger"},
+        {"embed=ledger", "invalid_embed", "embed supports only user"},
+        {"embed=user,", "invalid_embed", "embed supports only user"},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodGet, "/v1/withdrawals/1?"+tt.query, nil)
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        body := rec.Body.String()
+        if rec.Code != http.StatusBadRequest || !strings.Contains(body, tt.code) || !strings.Contains(body, tt.message) {
+            t.Fatalf("%s: expected 400 %s %q, got %d %s", tt.query, tt.code, tt.message, rec.Code, body)
+        }
+    }
+}
+
+func TestGetWithdrawalETagChangesWithNotes(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d", created.ID)
+
+    first := env.doRequest(t, http.MethodGet, path, "")
+    first.Body.Close()
+    etag := first.Header.Get("ETag")
+
+    note := env.doRequestWithHeaders(t, http.MethodPost, path+"/notes", `{"text":"hello"}`, map[string]string{"X-Operator": "alice"})
+    note.Body.Close()
+    if note.StatusCode != http.StatusCreated {
+        t.Fatalf("add note: expected %d, got %d", http.StatusCreated, note.StatusCode)
+    }
+
+    second := env.doRequestWithHeaders(t, http.MethodGet, path, "", map[string]string{"If-None-Match": etag})
+    second.Body.Close()
+    if second.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, second.StatusCode)
+    }
+    if got := second.Header.Get("ETag"); got == etag {
+        t.Fatalf("expected ETag to change with a note, got %q", got)
+    }
+}
+
+func TestConfirmWithdrawalIfMatch(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d", created.ID)
+    if created.Version != 1 {
+        t.Fatalf("expected a new withdrawal at version 1, got %d", created.Version)
+    }
+
+    get := env.doRequest(t, http.MethodGet, path, "")
+    get.Body.Close()
+    etag := get.Header.Get("ETag")
+
+    // Another operator adds a note, so the ETag seen above is stale.
+    note := env.doRequestWithHeaders(t, http.MethodPost, path+"/notes", `{"text":"hello"}`, map[string]string{"X-Operator": "bob"})
+    note.Body.Close()
+
+    stale := env.doRequestWithHeaders(t, http.MethodPost, path+"/confirm", "", map[string]string{"If-Match": etag})
+    var conflict struct {
+        Code          string             `json:"code"`
+        CurrentStatus string             `json:"current_status"`
+        Details       withdrawalResponse `json:"details"`
+    }
+    if err := json.NewDecoder(stale.Body).Decode(&conflict); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    stale.Body.Close()
+    if stale.StatusCode != http.StatusPreconditionFailed || conflict.Code != "version_conflict" {
+        t.Fatalf("expected 412 version_conflict, got %d %q", stale.StatusCode, conflict.Code)
+    }
+    if conflict.Details.Version != 2 || conflict.Details.Status != store.StatusPending || stale.Header.Get("ETag") != `"2"` {
+        t.Fatalf("expected the current withdrawal at version 2, got %+v (ETag %q)", conflict.Details, stale.Header.Get("ETag"))
+    }
+
+    body := env.doRequest(t, http.MethodPost, path+"/confirm", `{"expected_version":1}`)
+    body.Body.Close()
+    if body.StatusCode != http.StatusPreconditionFailed {
+        t.Fatalf("expected_version: expected %d, got %d", http.StatusPreconditionFailed, body.StatusCode)
+    }
+
+    // The tag of a GET variant names the same version.
+    variant := env.doRequest(t, http.MethodGet, path+"?include=ledger&embed=user", "")
+    variant.Body.Close()
+    fresh := env.doRequestWithHeaders(t, http.MethodPost, path+"/confirm", "", map[string]string{"If-Match": variant.Header.Get("ETag")})
+    var confirmed withdrawalResponse
+    if err := json.NewDecoder(fresh.Body).Decode(&confirmed); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    fresh.Body.Close()
+    if fresh.StatusCode != http.StatusOK || confirmed.Status != store.StatusConfirmed || confirmed.Version != 3 {
+        t.Fatalf("expected confirmation at version 3, got %d %+v", fresh.StatusCode, confirmed)
+    }
+}
+
+func TestCreateWithdrawalDecimalAmount(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(20000000).Create()
+    // A decimal string is in whole USDT, which has six places.
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().Set("amount", "10.50").JSON())
+    if created.Amount != 10500000 {
+        t.Fatalf("expected 10.50 USDT as 10500000 minor units, got %d", created.Amount)
+    }
+    created = createWithdrawal(t, env, fixtures.WithdrawalRequest().Set("amount", 200).Set("idempotency_key", "k2").JSON())
+    if created.Amount != 200 {
+        t.Fatalf("expected an integer taken as minor units, got %d", created.Amount)
+    }
+}
+
+func TestWithdrawalAmountFormat(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(20000000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().Set("amount", 12500000).JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d?include=ledger", created.ID)
+
+    tests := []struct {
+        format string
+        want   []string
+    }{
+        {"", []string{`"amount":12500000`}},
+        {"minor", []string{`"amount":12500000`}},
+        {"decimal", []string{`"amount":"12.500000"`, `"fee":"0.000000"`, `"ledger_entries":[{"id":`}},
+    }
+    for _, tt := range tests {
+        resp := env.doRequest(t, http.MethodGet, path+"&amount_format="+tt.format, "")
+        body, _ := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK {
+            t.Fatalf("%q: expected %d, got %d", tt.format, http.StatusOK, resp.StatusCode)
+        }
+        for _, want := range tt.want {
+            if !strings.Contains(string(body), want) {
+                t.Fatalf("%q: expected %s in %s", tt.format, want, body)
+            }
+        }
+        if tt.format == "decimal" && strings.Contains(string(body), `"amount":12500000`) {
+            t.Fatalf("expected every amount as a decimal string, got %s", body)
+        }
+    }
+}
+
+func TestConfirmWithdrawalInvalidVersion(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+    handler := srv.Routes()
+
+    tests := []struct {
+        name    string
+        ifMatch string
+        body    string
+        code    string
+    }{
+        {"unquoted", "2", "", "invalid_version"},
+        {"weak", `W/"2"`, "", "invalid_version"},
+        {"list", `"1", "2"`, "", "invalid_version"},
+        {"zero", `"0"`, "", "invalid_version"},
+        {"unknown variant", `"2:history"`, "", "invalid_version"},
+        {"zero body", "", `{"expected_version":0}`, "invalid_version"},
+        {"disagreeing", `"1"`, `{"expected_version":2}`, "invalid_version"},
+        {"malformed body", "", `{`, "invalid_request"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals/1/confirm", strings.NewReader(tt.body))
+            req.Header.Set("Authorization", "Bearer test-token")
+            if tt.ifMatch != "" {
+                req.Header.Set("If-Match", tt.ifMatch)
+            }
+            rec := httptest.NewRecorder()
+            handler.ServeHTTP(rec, req)
+
+            var body errorEnvelope
+            if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+                t.Fatalf("decode response: %v", err)
+            }
+            if rec.Code != http.StatusBadRequest || body.Code != tt.code {
+                t.Fatalf("expected 400 %s, got %d %q", tt.code, rec.Code, body.Code)
+            }
+        })
+    }
+}
+
+func TestGetWithdrawalsByIDs(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    first := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    second := createWithdrawal(t, env, fixtures.WithdrawalRequest().Set("amount", 200).Set("idempotency_key", "k2").JSON())
+
+    resp := env.doRequest(t, http.MethodGet, fmt.Sprintf("/v1/withdrawals?ids=%d,999,%d,%d", second.ID, first.ID, second.ID), "")
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+
+    var got struct {
+        Withdrawals []withdrawalResponse `json:"withdrawals"`
+        MissingIDs  []int64              `json:"missing_ids"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if len(got.Withdrawals) != 2 {
+        t.Fatalf("expected 2 withdrawals, got %d", len(got.Withdrawals))
+    }
+    if got.Withdrawals[0].ID != second.ID || got.Withdrawals[1].ID != first.ID {
+        t.Fatalf("expected requested order, got %+v", got.Withdrawals)
+    }
+    if len(got.MissingIDs) != 1 || got.MissingIDs[0] != 999 {
+        t.Fatalf("expected missing_ids [999], got %v", got.MissingIDs)
+    }
+}
+
+func TestGetWithdrawalsByIDsTooMany(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    ids := make([]string, 501)
+    for i := range ids {
+        ids[i] = fmt.Sprint(i + 1)
+    }
+
+    resp := env.doRequest(t, http.MethodGet, "/v1/withdrawals?ids="+strings.Join(ids, ","), "")
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusBadRequest {
+        t.Fatalf("expected %d, got %d", http.StatusBadRequest, resp.StatusCode)
+    }
+}
+
+func TestListWithdrawalsPagination(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    for i := 1; i <= 3; i++ {
+        createWithdrawal(t, env, fmt.Sprintf(`{"user_id":1,"amount":10,"currency":"USDT","destination":"addr","idempotency_key":"k%d"}`, i))
+    }
+
+    var page struct {
+        Withdrawals []withdrawalResponse `json:"withdrawals"`
+        NextCursor  int64                `json:"next_cursor"`
+    }
+    resp := env.doRequest(t, http.MethodGet, "/v1/withdrawals?user_id=1&direction=desc&limit=2", "")
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if len(page.Withdrawals) != 2 || page.Withdrawals[0].ID != 3 || page.NextCursor != 2 {
+        t.Fatalf("unexpected first page: %+v", page)
+    }
+
+    resp2 := env.doRequest(t, http.MethodGet, fmt.Sprintf("/v1/withdrawals?user_id=1&direction=desc&limit=2&before=%d", page.NextCursor), "")
+    defer resp2.Body.Close()
+    page.NextCursor = 0
+    if err := json.NewDecoder(resp2.Body).Decode(&page); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if len(page.Withdrawals) != 1 || page.Withdrawals[0].ID != 1 || page.NextCursor != 0 {
+        t.Fatalf("unexpected last page: %+v", page)
+    }
+}
+
+func TestListWithdrawalsAmountBounds(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(10000).Create()
+    for i, amount := range []int{500, 1000, 2500} {
+        createWithdrawal(t, env, fmt.Sprintf(`{"user_id":1,"amount":%d,"currency":"USDT","destination":"addr","idempotency_key":"k%d"}`, amount, i))
+    }
+
+    for _, tt := range []struct {
+        query string
+        want  []int64
+    }{
+        {"amount_gte=1000", []int64{2, 3}},
+        {"amount_lte=1000", []int64{1, 2}},
+        {"amount_gte=600&amount_lte=2500", []int64{2, 3}},
+        {"amount_gte=0&amount_lte=0", nil},
+        {"min_amount=1000&amount_lte=1000", []int64{2}},
+        {"min_amount=1000&amount_gte=1000", []int64{2, 3}},
+    } {
+        resp := env.doRequest(t, http.MethodGet, "/v1/withdrawals?user_id=1&"+tt.query, "")
+        var page struct {
+            Withdrawals []withdrawalResponse `json:"withdrawals"`
+        }
+        err := json.NewDecoder(resp.Body).Decode(&page)
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusOK || err != nil {
+            t.Fatalf("%s: expected %d, got %d, %v", tt.query, http.StatusOK, resp.StatusCode, err)
+        }
+        var got []int64
+        for _, w := range page.Withdrawals {
+            got = append(got, w.ID)
+        }
+        if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Fatalf("%s: expected %v, got %v", tt.query, tt.want, got)
+        }
+    }
+
+    resp := env.doRequest(t, http.MethodGet, "/v1/withdrawals?user_id=1&amount_gte=1000&amount_lte=999", "")
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusBadRequest {
+        t.Fatalf("expected %d for amount_lte below amount_gte, got %d", http.StatusBadRequest, resp.StatusCode)
+    }
+}
+
+func TestCreateWithdrawalInvalidIdempotencyKey(t *testing.T) {
+    tests := []struct {
+        name string
+        opts []api.Option
+        key  string
+    }{
+        {"blank", nil, "   "},
+        {"too long", nil, strings.Repeat("k", 129)},
+        {"non-ascii", nil, "ключ-1"},
+        {"control character", nil, `k\u00071`},
+        {"custom pattern", []api.Option{api.WithIdempotencyKeyPattern(regexp.MustCompile(`^[a-f0-9-]{36}$`))}, "order-42"},
+    }
+    for _, tt := range tests {
+        srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), tt.opts...)
+        body := `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"` + tt.key + `"}`
+        req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals", strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_idempotency_key") {
+            t.Fatalf("%s: expected 400 invalid_idempotency_key, got %d %s", tt.name, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestCreateWithdrawalNilIdempotencyKeyPattern(t *testing.T) {
+    env := setupTest(t, api.WithIdempotencyKeyPattern(nil))
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    created := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`)
+    if created.IdempotencyKey != "k1" {
+        t.Fatalf("unexpected withdrawal: %+v", created)
+    }
+}
+
+func TestGetWithdrawalByKey(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    seedUser(t, env.pool, 2, 1000)
+    created := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"Key-1"}`)
+
+    for _, tt := range []struct {
+        path string
+        want int
+    }{
+        {"/v1/users/1/withdrawals/by-key?idempotency_key=Key-1", http.StatusOK},
+        {"/v1/users/1/withdrawals/by-key?idempotency_key=%20Key-1%20", http.StatusOK},
+        // Keys are compared byte for byte unless canonical keys are on.
+        {"/v1/users/1/withdrawals/by-key?idempotency_key=key-1", http.StatusNotFound},
+        {"/v1/users/2/withdrawals/by-key?idempotency_key=Key-1", http.StatusNotFound},
+        {"/v1/users/1/withdrawals/by-key?idempotency_key=", http.StatusBadRequest},
+        // Keys a create would reject are rejected the same way.
+        {"/v1/users/1/withdrawals/by-key?idempotency_key=" + strings.Repeat("k", store.MaxIdempotencyKeyLength+1), http.StatusBadRequest},
+        {"/v1/users/1/withdrawals/by-key?idempotency_key=key%091", http.StatusBadRequest},
+        {"/v1/users/1/withdrawals/by-key?idempotency_key=key%C3%A9", http.StatusBadRequest},
+    } {
+        resp := env.doRequest(t, http.MethodGet, tt.path, "")
+        body, err := io.ReadAll(resp.Body)
+        resp.Body.Close()
+        if err != nil {
+            t.Fatalf("%s: read response: %v", tt.path, err)
+        }
+        var got withdrawalResponse
+        if err := json.Unmarshal(body, &got); err != nil {
+            t.Fatalf("%s: decode response: %v", tt.path, err)
+        }
+        if resp.StatusCode != tt.want {
+            t.Fatalf("%s: expected %d, got %d", tt.path, tt.want, resp.StatusCode)
+        }
+        if tt.want == http.StatusBadRequest && !strings.Contains(string(body), `"code":"invalid_idempotency_key"`) {
+            t.Fatalf("%s: expected invalid_idempotency_key, got %s", tt.path, body)
+        }
+        if tt.want == http.StatusOK && (got.ID != created.ID || got.IdempotencyKey != "Key-1") {
+            t.Fatalf("%s: expected withdrawal %d, got %+v", tt.path, created.ID, got)
+        }
+    }
+}
+
+func TestCanonicalIdempotencyKeys(t *testing.T) {
+    env := setupTestWithStore(t, []store.Option{store.WithCanonicalIdempotencyKeys(true)})
+    defer env.close()
+
+    seedUser(t, env.pool, 1, 1000)
+    created := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"Key-1"}`)
+    if created.IdempotencyKey != "key-1" {
+        t.Fatalf("expected the canonical key in the response, got %q", created.IdempotencyKey)
+    }
+    replayed := createWithdrawal(t, env, `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"KEY-1"}`)
+    if replayed.ID != created.ID {
+        t.Fatalf("expected KEY-1 to replay withdrawal %d, got %d", created.ID, replayed.ID)
+    }
+    if balance := getBalance(t, env.pool, 1); balance != 900 {
+        t.Fatalf("expected one debit, got balance %d", balance)
+    }
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", `{"user_id":1,"amount":200,"currency":"USDT","destination":"addr","idempotency_key":"kEY-1"}`)
+    var conflict struct {
+        Code                   string `json:"code"`
+        ExistingWithdrawalID   int64  `json:"existing_withdrawal_id"`
+        ExistingIdempotencyKey string `json:"existing_idempotency_key"`
+    }
+    err := json.NewDecoder(resp.Body).Decode(&conflict)
+    resp.Body.Close()
+    if err != nil {
+        t.Fatalf("decode conflict: %v", err)
+    }
+    if resp.StatusCode != http.StatusUnprocessableEntity || conflict.ExistingWithdrawalID != created.ID || conflict.ExistingIdempotencyKey != "key-1" {
+        t.Fatalf("expected 422 naming key-1 of withdrawal %d, got %d %+v", created.ID, resp.StatusCode, conflict)
+    }
+
+    resp = env.doRequest(t, http.MethodGet, "/v1/users/1/withdrawals/by-key?idempotency_key=KEY-1", "")
+    var found withdrawalResponse
+    err = json.NewDecoder(resp.Body).Decode(&found)
+    resp.Body.Close()
+    if err != nil {
+        t.Fatalf("decode lookup: %v", err)
+    }
+    if resp.StatusCode != http.StatusOK || found.ID != created.ID {
+        t.Fatalf("expected the lookup by KEY-1 to find withdrawal %d, got %d %+v", created.ID, resp.StatusCode, found)
+    }
+}
+
+func TestCreateWithdrawalFieldErrors(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    type fieldError struct {
+        Field  string `json:"field"`
+        Reason string `json:"reason"`
+    }
+    tests := []struct {
+        name   string
+        body   string
+        code   string
+        fields []fieldError
+    }{
+        {
+            "destination with a space",
+            `{"user_id":1,"amount":100,"currency":"USDT","destination":"a b","idempotency_key":"k1"}`,
+            "invalid_request",
+            []fieldError{{"destination", "invalid_characters"}},
+        },
+        {
+            "destination with a control character",
+            `{"user_id":1,"amount":100,"currency":"USDT","destination":"a\u0007b","idempotency_key":"k1"}`,
+            "invalid_request",
+            []fieldError{{"destination", "invalid_characters"}},
+        },
+        {
+            "long destination",
+            `{"user_id":1,"amount":100,"currency":"USDT","destination":"` + strings.Repeat("a", 257) + `","idempotency_key":"k1"}`,
+            "invalid_request",
+            []fieldError{{"destination", "too_long"}},
+        },
+        {
+            "padded currency",
+            `{"user_id":1,"amount":100,"currency":" USDT","destination":"addr","idempotency_key":"k1"}`,
+            "invalid_request",
+            []fieldError{{"currency", "invalid_format"}},
+        },
+        {
+            "unknown currency",
+            `{"user_id":1,"amount":100,"currency":"BTC","destination":"addr","idempotency_key":"k1"}`,
+            "unsupported_currency",
+            []fieldError{{"currency", "unsupported"}},
+        },
+        {
+            "key only",
+            `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"` + strings.Repeat("k", 129) + `"}`,
+            "invalid_idempotency_key",
+            []fieldError{{"idempotency_key", "too_long"}},
+        },
+        {
+            "every field",
+            `{"user_id":0,"amount":0,"currency":"","destination":" ","idempotency_key":""}`,
+            "invalid_request",
+            []fieldError{{"idempotency_key", "required"}, {"destination", "required"}, {"currency", "required"}, {"user_id", "not_positive"}, {"amount", "not_positive"}},
+        },
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals", strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        var resp struct {
+            Code    string `json:"code"`
+            Details struct {
+                Fields []fieldError `json:"fields"`
+            } `json:"details"`
+        }
+        if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+            t.Fatalf("%s: decode response: %v", tt.name, err)
+        }
+        if rec.Code != http.StatusBadRequest || resp.Code != tt.code || fmt.Sprint(resp.Details.Fields) != fmt.Sprint(tt.fields) {
+            t.Fatalf("%s: expected 400 %s with %v, got %d %s", tt.name, tt.code, tt.fields, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestCreateWithdrawalUnsupportedCurrency(t *testing.T) {
+    srv := api.NewServer(store.New(nil, store.WithSupportedCurrencies("usdt", "eur")), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals", strings.NewReader(`{"user_id":1,"amount":100,"currency":"btc","destination":"addr","idempotency_key":"k1"}`))
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    var resp struct {
+        Code    string `json:"code"`
+        Details struct {
+            SupportedCurrencies []string `json:"supported_currencies"`
+        } `json:"details"`
+    }
+    if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if rec.Code != http.StatusBadRequest || resp.Code != "unsupported_currency" || fmt.Sprint(resp.Details.SupportedCurrencies) != "[USDT EUR]" {
+        t.Fatalf("expected 400 unsupported_currency listing USDT and EUR, got %d %s", rec.Code, rec.Body.String())
+    }
+
+    rec = httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/currencies", nil))
+    if body := rec.Body.String(); !strings.Contains(body, `"code":"USDT","exponent":6`) || !strings.Contains(body, `"code":"EUR"`) {
+        t.Fatalf("expected the supported currencies in the registry, got %s", body)
+    }
+}
+
+func TestListWithdrawalsInvalidFilter(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, query := range []string{"direction=sideways", "limit=0", "limit=1000", "after=x", "updated_after=yesterday", "page_cursor=abc", "sort=amount&after=1", "sort=amount&page_cursor=abc", "with_count=maybe", "min_amount=10&max_amount=5", "min_amount=-1", "max_amount=x", "amount_gte=-1", "amount_lte=x", "amount_gte=10&amount_lte=5", "min_amount=10&amount_lte=5", "amount_gte=10&max_amount=5", "min_amount=10&amount_gte=20"} {
+        req := httptest.NewRequest(http.MethodGet, "/v1/withdrawals?"+query, nil)
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%s: expected %d, got %d", query, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
+
+func TestListWithdrawalsInvalidSort(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    req := httptest.NewRequest(http.MethodGet, "/v1/withdrawals?sort=destination", nil)
+    req.Header.Set("Authorization", "Bearer test-token")
+    rec := httptest.NewRecorder()
+    srv.Routes().ServeHTTP(rec, req)
+
+    if rec.Code != http.StatusBadRequest {
+        t.Fatalf("expected %d, got %d", http.StatusBadRequest, rec.Code)
+    }
+    var body struct {
+        Code    string `json:"code"`
+        Details struct {
+            Allowed []string `json:"allowed"`
+        } `json:"details"`
+    }
+    if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+        t.Fatalf("decode: %v", err)
+    }
+    if body.Code != "invalid_sort" || len(body.Details.Allowed) != len(store.SortKeys) {
+        t.Fatalf("unexpected body: %+v", body)
+    }
+}
+
+func TestConfirmWithdrawalOperator(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithOperatorRequired(true))
+
+    tests := []struct {
+        operator string
+        code     string
+    }{
+        {"", "operator_required"},
+        {" \t ", "operator_required"},
+        {strings.Repeat("a", 65), "invalid_operator"},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals/1/confirm", nil)
+        req.Header.Set("Authorization", "Bearer test-token")
+        if tt.operator != "" {
+            req.Header.Set("X-Operator", tt.operator)
+        }
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), tt.code) {
+            t.Fatalf("%q: expected 400 %s, got %d %s", tt.operator, tt.code, rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestWithdrawalAgeAndStale(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    old := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    createWithdrawal(t, env, fixtures.WithdrawalRequest().Set("idempotency_key", "k2").JSON())
+    if _, err := env.pool.Exec(context.Background(), "UPDATE withdrawals SET created_at = now() - INTERVAL '2 hours', updated_at = now() - INTERVAL '2 hours' WHERE id = $1", old.ID); err != nil {
+        t.Fatalf("backdate withdrawal: %v", err)
+    }
+
+    resp := env.doRequest(t, http.MethodGet, fmt.Sprintf("/v1/withdrawals/%d/age", old.ID), "")
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    var age struct {
+        AgeMinutes float64 `json:"age_minutes"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&age); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if age.AgeMinutes < 120 || age.AgeMinutes > 121 {
+        t.Fatalf("expected age of about 120 minutes, got %f", age.AgeMinutes)
+    }
+
+    stale := env.doRequest(t, http.MethodGet, "/v1/withdrawals/stale?older_than_minutes=60", "")
+    defer stale.Body.Close()
+    if stale.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, stale.StatusCode)
+    }
+    var got struct {
+        Withdrawals []withdrawalResponse `json:"withdrawals"`
+    }
+    if err := json.NewDecoder(stale.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if len(got.Withdrawals) != 1 || got.Withdrawals[0].ID != old.ID {
+        t.Fatalf("unexpected stale withdrawals: %+v", got.Withdrawals)
+    }
+}
+
+func TestTouchWithdrawal(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d/touch", created.ID)
+
+    first := env.doRequest(t, http.MethodPost, path, "")
+    first.Body.Close()
+    if first.StatusCode != http.StatusNoContent {
+        t.Fatalf("expected %d, got %d", http.StatusNoContent, first.StatusCode)
+    }
+
+    second := env.doRequest(t, http.MethodPost, path, "")
+    defer second.Body.Close()
+    var throttled struct {
+        Code              string `json:"code"`
+        RetryAfterSeconds int    `json:"retry_after_seconds"`
+    }
+    if err := json.NewDecoder(second.Body).Decode(&throttled); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if second.StatusCode != http.StatusTooManyRequests || throttled.Code != "rate_limited" || throttled.RetryAfterSeconds < 1 || throttled.RetryAfterSeconds > 60 {
+        t.Fatalf("expected 429 rate_limited with retry_after_seconds up to 60, got %d %+v", second.StatusCode, throttled)
+    }
+    if got := second.Header.Get("Retry-After"); got != strconv.Itoa(throttled.RetryAfterSeconds) {
+        t.Fatalf("expected Retry-After %d, got %q", throttled.RetryAfterSeconds, got)
+    }
+
+    missing := env.doRequest(t, http.MethodPost, "/v1/withdrawals/999/touch", "")
+    missing.Body.Close()
+    if missing.StatusCode != http.StatusNotFound {
+        t.Fatalf("expected %d, got %d", http.StatusNotFound, missing.StatusCode)
+    }
+
+    // Touches that fail the lookup leave the withdrawal's slot free.
+    other := createWithdrawal(t, env, fixtures.WithdrawalRequest().Set("idempotency_key", "k2").JSON())
+    if _, err := env.pool.Exec(context.Background(), "UPDATE withdrawals SET status = 'confirmed' WHERE id = $1", other.ID); err != nil {
+        t.Fatalf("confirm withdrawal: %v", err)
+    }
+    otherPath := fmt.Sprintf("/v1/withdrawals/%d/touch", other.ID)
+    confirmed := env.doRequest(t, http.MethodPost, otherPath, "")
+    confirmed.Body.Close()
+    if confirmed.StatusCode != http.StatusNotFound {
+        t.Fatalf("expected %d for a confirmed withdrawal, got %d", http.StatusNotFound, confirmed.StatusCode)
+    }
+    if _, err := env.pool.Exec(context.Background(), "UPDATE withdrawals SET status = 'pending' WHERE id = $1", other.ID); err != nil {
+        t.Fatalf("reopen withdrawal: %v", err)
+    }
+    reopened := env.doRequest(t, http.MethodPost, otherPath, "")
+    reopened.Body.Close()
+    if reopened.StatusCode != http.StatusNoContent {
+        t.Fatalf("expected %d once the withdrawal is pending, got %d", http.StatusNoContent, reopened.StatusCode)
+    }
+}
+
+func TestReverseWithdrawal(t *testing.T) {
+    env := setupTest(t, api.WithAdminToken("admin-token"))
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d/reverse", created.ID)
+    admin := map[string]string{"Authorization": "Bearer admin-token"}
+
+    pending := env.doRequestWithHeaders(t, http.MethodPost, path, `{"reason":"recall"}`, admin)
+    pending.Body.Close()
+    if pending.StatusCode != http.StatusConflict {
+        t.Fatalf("expected %d for a pending withdrawal, got %d", http.StatusConflict, pending.StatusCode)
+    }
+
+    confirm := env.doRequest(t, http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/confirm", created.ID), "")
+    confirm.Body.Close()
+
+    resp := env.doRequestWithHeaders(t, http.MethodPost, path, `{"reason":"recall"}`, admin)
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    var got withdrawalResponse
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if got.Status != store.StatusReversed {
+        t.Fatalf("expected status %s, got %s", store.StatusReversed, got.Status)
+    }
+    if balance := getBalance(t, env.pool, 1); balance != 1000 {
+        t.Fatalf("expected balance 1000 after reversal, got %d", balance)
+    }
+
+    again := env.doRequestWithHeaders(t, http.MethodPost, path, `{"reason":"recall"}`, admin)
+    again.Body.Close()
+    if again.StatusCode != http.StatusConflict {
+        t.Fatalf("expected %d for a reversed withdrawal, got %d", http.StatusConflict, again.StatusCode)
+    }
+}
+
+func TestGetWithdrawalInEachStatus(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    for _, w := range []store.Withdrawal{
+        fixtures.Withdrawal(t, env.pool).Pending().Create(),
+        fixtures.Withdrawal(t, env.pool).Amount(200).Confirmed().Create(),
+        fixtures.Withdrawal(t, env.pool).Amount(300).Reversed("chargeback").Create(),
+    } {
+        resp := env.doRequest(t, http.MethodGet, fmt.Sprintf("/v1/withdrawals/%d", w.ID), "")
+        var got withdrawalResponse
+        if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+            t.Fatalf("decode response: %v", err)
+        }
+        resp.Body.Close()
+        if got.Status != w.Status || got.Amount != w.Amount {
+            t.Fatalf("withdrawal %d: expected %s %d, got %s %d", w.ID, w.Status, w.Amount, got.Status, got.Amount)
+        }
+    }
+    if balance := getBalance(t, env.pool, 1); balance != 700 {
+        t.Fatalf("expected balance 700, got %d", balance)
+    }
+}
+
+func TestRecordTxHash(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    confirmed := fixtures.Withdrawal(t, env.pool).Confirmed().Create()
+    pending := fixtures.Withdrawal(t, env.pool).Pending().Create()
+
+    record := func(id int64, body string) (int, withdrawalResponse, string) {
+        t.Helper()
+        resp := env.doRequest(t, http.MethodPost, fmt.Sprintf("/v1/withdrawals/%d/tx-hash", id), body)
+        defer resp.Body.Close()
+        raw, err := io.ReadAll(resp.Body)
+        if err != nil {
+            t.Fatalf("read body: %v", err)
+        }
+        var got withdrawalResponse
+        if resp.StatusCode == http.StatusOK {
+            if err := json.Unmarshal(raw, &got); err != nil {
+                t.Fatalf("decode response: %v", err)
+            }
+        }
+        return resp.StatusCode, got, string(raw)
+    }
+
+    code, got, body := record(confirmed.ID, `{"tx_hash":" 0xabc "}`)
+    if code != http.StatusOK || got.ExternalTxHash == nil || *got.ExternalTxHash != "0xabc" {
+        t.Fatalf("record: expected %d with the hash, got %d %s", http.StatusOK, code, body)
+    }
+    if code, again, body := record(confirmed.ID, `{"tx_hash":"0xabc"}`); code != http.StatusOK || again.Version != got.Version {
+        t.Fatalf("record again: expected %d at version %d, got %d %s", http.StatusOK, got.Version, code, body)
+    }
+    if code, _, body := record(confirmed.ID, `{"tx_hash":"0xdef"}`); code != http.StatusConflict || !strings.Contains(body, `"code":"tx_hash_conflict"`) {
+        t.Fatalf("another hash: expected %d tx_hash_conflict, got %d %s", http.StatusConflict, code, body)
+    }
+    if code, _, body := record(pending.ID, `{"tx_hash":"0xabc"}`); code != http.StatusConflict || !strings.Contains(body, `"current_status":"pending"`) {
+        t.Fatalf("pending withdrawal: expected %d invalid_status, got %d %s", http.StatusConflict, code, body)
+    }
+    if code, _, _ := record(42, `{"tx_hash":"0xabc"}`); code != http.StatusNotFound {
+        t.Fatalf("missing withdrawal: expected %d, got %d", http.StatusNotFound, code)
+    }
+
+    resp := env.doRequest(t, http.MethodGet, fmt.Sprintf("/v1/withdrawals/%d", confirmed.ID), "")
+    defer resp.Body.Close()
+    var read withdrawalResponse
+    if err := json.NewDecoder(resp.Body).Decode(&read); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if read.ExternalTxHash == nil || *read.ExternalTxHash != "0xabc" {
+        t.Fatalf("expected the hash on the withdrawal, got %+v", read)
+    }
+}
+
+func TestRecordTxHashInvalidRequest(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, tt := range []struct {
+        method string
+        body   string
+        want   int
+        code   string
+    }{
+        {http.MethodPost, `{}`, http.StatusBadRequest, "invalid_tx_hash"},
+        {http.MethodPost, `{"tx_hash":"   "}`, http.StatusBadRequest, "invalid_tx_hash"},
+        {http.MethodPost, `{"tx_hash":"` + strings.Repeat("a", 129) + `"}`, http.StatusBadRequest, "invalid_tx_hash"},
+        {http.MethodPost, `{"tx_hash":"0xabc","extra":1}`, http.StatusBadRequest, "invalid_request"},
+        {http.MethodGet, "", http.StatusMethodNotAllowed, "method_not_allowed"},
+    } {
+        req := httptest.NewRequest(tt.method, "/v1/withdrawals/1/tx-hash", strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != tt.want || !strings.Contains(rec.Body.String(), `"code":"`+tt.code+`"`) {
+            t.Fatalf("%s %s: expected %d %s, got %d %s", tt.method, tt.body, tt.want, tt.code, rec.Code, rec.Body)
+        }
+    }
+}
+
+func TestReverseWithdrawalRequiresAdmin(t *testing.T) {
+    disabled := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+    enabled := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"))
+    operatorRequired := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0), api.WithAdminToken("admin-token"), api.WithOperatorRequired(true))
+
+    tests := []struct {
+        name   string
+        srv    *api.Server
+        token  string
+        body   string
+        status int
+    }{
+        {"disabled", disabled, "test-token", `{"reason":"recall"}`, http.StatusNotFound},
+        {"api token", enabled, "test-token", `{"reason":"recall"}`, http.StatusUnauthorized},
+        {"no reason", enabled, "admin-token", `{}`, http.StatusBadRequest},
+        {"blank reason", enabled, "admin-token", `{"reason":"  "}`, http.StatusBadRequest},
+        {"long reason", enabled, "admin-token", fmt.Sprintf(`{"reason":%q}`, strings.Repeat("a", 501)), http.StatusBadRequest},
+        {"no operator", operatorRequired, "admin-token", `{"reason":"recall"}`, http.StatusBadRequest},
+    }
+    for _, tt := range tests {
+        req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals/1/reverse", strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer "+tt.token)
+        rec := httptest.NewRecorder()
+        tt.srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != tt.status {
+            t.Fatalf("%s: expected %d, got %d", tt.name, tt.status, rec.Code)
+        }
+    }
+}
+
+func TestWithdrawalNotes(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    created := createWithdrawal(t, env, fixtures.WithdrawalRequest().JSON())
+    path := fmt.Sprintf("/v1/withdrawals/%d/notes", created.ID)
+
+    for _, text := range []string{"customer contacted", "waiting on KYC"} {
+        resp := env.doRequestWithHeaders(t, http.MethodPost, path, fmt.Sprintf(`{"text":%q}`, text), map[string]string{"X-Operator": "alice"})
+        resp.Body.Close()
+        if resp.StatusCode != http.StatusCreated {
+            t.Fatalf("expected %d, got %d", http.StatusCreated, resp.StatusCode)
+        }
+    }
+
+    resp := env.doRequest(t, http.MethodGet, path, "")
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        t.Fatalf("expected %d, got %d", http.StatusOK, resp.StatusCode)
+    }
+    var got struct {
+        Notes []struct {
+            Author string `json:"author"`
+            Text   string `json:"text"`
+        } `json:"notes"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if len(got.Notes) != 2 || got.Notes[0].Text != "customer contacted" || got.Notes[1].Author != "alice" {
+        t.Fatalf("unexpected notes: %+v", got.Notes)
+    }
+
+    withdrawal := env.doRequest(t, http.MethodGet, fmt.Sprintf("/v1/withdrawals/%d", created.ID), "")
+    defer withdrawal.Body.Close()
+    var current withdrawalResponse
+    if err := json.NewDecoder(withdrawal.Body).Decode(&current); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    if current.NoteCount != 2 {
+        t.Fatalf("expected note_count 2, got %d", current.NoteCount)
+    }
+
+    for _, method := range []string{http.MethodGet, http.MethodPost} {
+        missing := env.doRequest(t, method, "/v1/withdrawals/999/notes", `{"text":"hello"}`)
+        missing.Body.Close()
+        if missing.StatusCode != http.StatusNotFound {
+            t.Fatalf("%s: expected %d, got %d", method, http.StatusNotFound, missing.StatusCode)
+        }
+    }
+}
+
+func TestAddWithdrawalNoteInvalidText(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, body := range []string{`{}`, `{"text":"  "}`, fmt.Sprintf(`{"text":%q}`, strings.Repeat("ы", 2001))} {
+        req := httptest.NewRequest(http.MethodPost, "/v1/withdrawals/1/notes", strings.NewReader(body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_note") {
+            t.Fatalf("expected 400 invalid_note, got %d %s", rec.Code, rec.Body.String())
+        }
+    }
+}
+
+func TestStaleWithdrawalsInvalidQuery(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, query := range []string{
+        "", "older_than_minutes=", "older_than_minutes=-1", "older_than_minutes=soon", "older_than_minutes=Inf",
+        "older_than_minutes=60&limit=0", "older_than_minutes=60&limit=many", "older_than_minutes=60&limit=501",
+    } {
+        req := httptest.NewRequest(http.MethodGet, "/v1/withdrawals/stale?"+query, nil)
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != http.StatusBadRequest {
+            t.Fatalf("%s: expected %d, got %d", query, http.StatusBadRequest, rec.Code)
+        }
+    }
+}
+
+func TestCreateWithdrawalForUserPath(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    fixtures.User(t, env.pool).WithID(2).WithBalance(1000).Create()
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/users/2/withdrawals", fixtures.WithdrawalRequest().Without("user_id").JSON())
+    var created withdrawalResponse
+    if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated || created.UserID != 2 {
+        t.Fatalf("expected %d for user 2, got %d %+v", http.StatusCreated, resp.StatusCode, created)
+    }
+
+    // A matching user_id in the body is accepted, here as a replay.
+    resp = env.doRequest(t, http.MethodPost, "/v1/users/2/withdrawals", fixtures.WithdrawalRequest().Set("user_id", 2).JSON())
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusCreated || resp.Header.Get("Idempotency-Replayed") != "true" {
+        t.Fatalf("expected a replay, got %d", resp.StatusCode)
+    }
+
+    resp = env.doRequest(t, http.MethodPost, "/v1/users/2/withdrawals", fixtures.WithdrawalRequest().Set("idempotency_key", "k2").JSON())
+    var body errorEnvelope
+    if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    resp.Body.Close()
+    if resp.StatusCode != http.StatusBadRequest || body.Code != "user_id_mismatch" {
+        t.Fatalf("expected 400 user_id_mismatch, got %d %q", resp.StatusCode, body.Code)
+    }
+    if balance := getBalance(t, env.pool, 1); balance != 1000 {
+        t.Fatalf("expected user 1 untouched, got balance %d", balance)
+    }
+    if balance := getBalance(t, env.pool, 2); balance != 900 {
+        t.Fatalf("expected balance 900 for user 2, got %d", balance)
+    }
+}
+
+func TestCreateWithdrawalForUserPathInvalid(t *testing.T) {
+    srv := api.NewServer(store.New(nil), "test-token", log.New(io.Discard, "", 0))
+
+    for _, tt := range []struct {
+        method string
+        path   string
+        body   string
+        want   int
+        code   string
+    }{
+        {http.MethodPost, "/v1/users/1/withdrawals", fixtures.WithdrawalRequest().Set("user_id", 2).JSON(), http.StatusBadRequest, "user_id_mismatch"},
+        {http.MethodPost, "/v1/users/abc/withdrawals", fixtures.WithdrawalRequest().JSON(), http.StatusBadRequest, "invalid_id"},
+        {http.MethodPost, "/v1/users/1/withdrawals", fixtures.WithdrawalRequest().Set("amount", 0).JSON(), http.StatusBadRequest, "invalid_request"},
+        {http.MethodGet, "/v1/users/1/withdrawals", "", http.StatusMethodNotAllowed, "method_not_allowed"},
+    } {
+        req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+        req.Header.Set("Authorization", "Bearer test-token")
+        rec := httptest.NewRecorder()
+        srv.Routes().ServeHTTP(rec, req)
+
+        if rec.Code != tt.want || !strings.Contains(rec.Body.String(), `"code":"`+tt.code+`"`) {
+            t.Fatalf("%s %s %s: expected %d %s, got %d %s", tt.method, tt.path, tt.body, tt.want, tt.code, rec.Code, rec.Body)
+        }
+    }
+}
+
+func TestWithdrawalResponseShape(t *testing.T) {
+    env := setupTest(t)
+    defer env.close()
+
+    fixtures.User(t, env.pool).WithBalance(1000).Create()
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", fixtures.WithdrawalRequest().JSON())
+    defer resp.Body.Close()
+    body, err := io.ReadAll(resp.Body)
+    if err != nil {
+        t.Fatalf("read body: %v", err)
+    }
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("expected %d, got %d: %s", http.StatusCreated, resp.StatusCode, body)
+    }
+    fixtures.AssertShape(t, "withdrawal_created", body)
+}
+
+func createWithdrawal(t *testing.T, env *testEnv, body string) withdrawalResponse {
+    t.Helper()
+
+    resp := env.doRequest(t, http.MethodPost, "/v1/withdrawals", body)
+    defer resp.Body.Close()
+
+    if resp.StatusCode != http.StatusCreated {
+        t.Fatalf("create withdrawal: expected %d, got %d", http.StatusCreated, resp.StatusCode)
+    }
+
+    var created withdrawalResponse
+    if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+        t.Fatalf("decode response: %v", err)
+    }
+    return created
+}
+
+func seedUser(t *testing.T, pool *pgxpool.Pool, id int64, balance int64) {
+    t.Helper()
+
+    fixtures.User(t, pool).WithID(id).WithBalance(balance).Create()
+}
+
+func getBalance(t *testing.T, pool *pgxpool.Pool, id int64) int64 {
+    t.Helper()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    var balance int64
+    err := pool.QueryRow(ctx, "SELECT balance FROM users WHERE id = $1", id).Scan(&balance)
+    if err != nil {
+        t.Fatalf("get balance: %v", err)
+    }
+    return balance
+}
+
+func getWithdrawalCount(t *testing.T, pool *pgxpool.Pool, userID int64) int {
+    t.Helper()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    var count int
+    err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM withdrawals WHERE user_id = $1", userID).Scan(&count)
+    if err != nil {
+        t.Fatalf("get withdrawal count: %v", err)
+    }
+    return count
+}
+
+func getLedgerSummary(t *testing.T, pool *pgxpool.Pool, userID int64) store.LedgerSummary {
+    t.Helper()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    summary, err := store.New(pool).GetLedgerSummary(ctx, userID, store.LedgerSummaryFilter{})
     if err != nil {
         t.Fatalf("get ledger summary: %v", err)
     }
-    return count, sum
+    return summary
 }
 
 func applySchema(t *testing.T, pool *pgxpool.Pool) {
@@ -485,7 +1966,7 @@ func resetDB(t *testing.T, pool *pgxpool.Pool) {
     ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
     defer cancel()
 
-    if _, err := pool.Exec(ctx, "TRUNCATE ledger_entries, withdrawals, users RESTART IDENTITY"); err != nil {
+    if _, err := pool.Exec(ctx, "TRUNCATE audit_log, balance_audit, ledger_entries, ledger_entries_archive, withdrawal_notes, withdrawals, users, blacklisted_destinations, maintenance_mode RESTART IDENTITY"); err != nil {
         t.Fatalf("reset db: %v", err)
     }
 }
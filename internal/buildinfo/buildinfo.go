@@ -0,0 +1,50 @@
+// Package buildinfo identifies the running build. Commit and BuildTime are
+// set at link time:
+//
+//	go build -ldflags "-X task.hh/internal/buildinfo.Commit=$(git rev-parse HEAD) -X task.hh/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/api
+//
+// Without the flags they fall back to the VCS stamp the go command embeds
+// when building from a git checkout, BuildTime then being the commit time.
+package buildinfo
+
+import (
+    "runtime"
+    "runtime/debug"
+)
+
+// Unknown is reported for a value neither the linker nor the VCS stamp set.
+const Unknown = "unknown"
+
+var (
+    Commit    string
+    BuildTime string
+)
+
+// Info describes the running build.
+type Info struct {
+    Commit    string
+    BuildTime string
+    GoVersion string
+}
+
+// Get returns the build info of the running binary.
+func Get() Info {
+    info := Info{Commit: Commit, BuildTime: BuildTime, GoVersion: runtime.Version()}
+    if bi, ok := debug.ReadBuildInfo(); ok {
+        for _, s := range bi.Settings {
+            switch {
+            case s.Key == "vcs.revision" && info.Commit == "":
+                info.Commit = s.Value
+            case s.Key == "vcs.time" && info.BuildTime == "":
+                info.BuildTime = s.Value
+            }
+        }
+    }
+    if info.Commit == "" {
+        info.Commit = Unknown
+    }
+    if info.BuildTime == "" {
+        info.BuildTime = Unknown
+    }
+    return info
+}
@@ -0,0 +1,25 @@
+package buildinfo
+
+import (
+    "runtime"
+    "testing"
+)
+
+func TestGet(t *testing.T) {
+    commit, buildTime := Commit, BuildTime
+    defer func() {
+        Commit, BuildTime = commit, buildTime
+    }()
+
+    Commit, BuildTime = "", ""
+    info := Get()
+    // Test binaries carry no VCS stamp.
+    if info.Commit != Unknown || info.BuildTime != Unknown || info.GoVersion != runtime.Version() {
+        t.Fatalf("expected unknown commit and build time, got %+v", info)
+    }
+
+    Commit, BuildTime = "0123abc", "2026-01-02T03:04:05Z"
+    if info := Get(); info.Commit != "0123abc" || info.BuildTime != "2026-01-02T03:04:05Z" {
+        t.Fatalf("expected the linked values, got %+v", info)
+    }
+}
@@ -0,0 +1,616 @@
+// Package config loads service configuration from, in order of precedence,
+// command-line flags, environment variables, a YAML config file and defaults.
+package config
+
+import (
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "os"
+    "regexp"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+
+    "gopkg.in/yaml.v3"
+
+    "task.hh/internal/store"
+)
+
+type Config struct {
+    DatabaseURL string
+    DBMaxConns  int32
+    DBMinConns  int32
+
+    DBConnectTimeout time.Duration
+
+    AuthToken             string
+    AuthTokenFile         string
+    AuthTokenPollInterval time.Duration
+    AuthKeys              map[string]string
+    AdminToken            string
+    WebhookSecret         string
+    TenantJWTSecret       string
+    ResponseSigningKeys   map[string]string
+
+    Port              string
+    ReadHeaderTimeout time.Duration
+    ReadTimeout       time.Duration
+    WriteTimeout      time.Duration
+    IdleTimeout       time.Duration
+    MaxHeaderBytes    int
+    H2C               bool
+    ShutdownTimeout   time.Duration
+
+    MaintenanceRetryAfter   time.Duration
+    MaintenancePollInterval time.Duration
+
+    MaxPendingWithdrawals    int
+    WithdrawalFees           map[string]store.FeePolicy
+    FeeExemptTiers           []string
+    SupportedCurrencies      []string
+    ReservationTTL           time.Duration
+    ReservationSweepInterval time.Duration
+    OpeningLedgerEntries     bool
+    SettlementLedgerEntries  bool
+    BalanceAudit             bool
+    ReplayStatusOK           bool
+    OperatorRequired         bool
+    ConfirmByCreatingKey     bool
+    DebugLogBodies           bool
+    IdempotencyKeyPattern    *regexp.Regexp
+    CanonicalIdempotencyKeys bool
+    IdempotencyCacheSize     int
+    IdempotencyCacheTTL      time.Duration
+    ListCountCap             int
+    LogRedactFields          []string
+
+    SMTPHost        string
+    SMTPPort        string
+    SMTPFrom        string
+    SummaryEmailTo  string
+    SummarySendHour int
+
+    PrintConfig bool
+
+    values  map[string]string
+    sources map[string]string
+}
+
+// option describes one setting. The env var is the upper-cased key and the
+// flag is the key with dashes, e.g. shutdown_timeout, SHUTDOWN_TIMEOUT and
+// -shutdown-timeout.
+type option struct {
+    key    string
+    def    string
+    secret bool
+    usage  string
+}
+
+var options = []option{
+    {key: "database_url", secret: true, usage: "PostgreSQL connection string"},
+    {key: "database_url_file", usage: "file containing database_url"},
+    {key: "db_host", def: "localhost", usage: "database host, used when database_url is empty"},
+    {key: "db_port", def: "5432", usage: "database port"},
+    {key: "db_user", usage: "database user"},
+    {key: "db_password", secret: true, usage: "database password"},
+    {key: "db_name", usage: "database name"},
+    {key: "db_sslmode", def: "disable", usage: "database sslmode"},
+    {key: "db_max_conns", def: "0", usage: "maximum pool connections, 0 for the driver default"},
+    {key: "db_min_conns", def: "0", usage: "minimum idle pool connections"},
+    {key: "db_connect_timeout", def: "30s", usage: "how long to retry the database at startup"},
+    {key: "auth_token", secret: true, usage: "API token, reported as key name \"default\""},
+    {key: "auth_token_file", usage: "file containing auth_token, re-read on SIGHUP"},
+    {key: "auth_token_poll_interval", def: "0s", usage: "how often to check auth_token_file for changes, 0 to disable"},
+    {key: "auth_tokens", secret: true, usage: "named API keys as name=token,name2=token2"},
+    {key: "auth_tokens_file", usage: "file containing auth_tokens"},
+    {key: "admin_token", secret: true, usage: "admin API token"},
+    {key: "admin_token_file", usage: "file containing admin_token"},
+    {key: "webhook_secret", secret: true, usage: "webhook signing secret"},
+    {key: "webhook_secret_file", usage: "file containing webhook_secret"},
+    {key: "response_signing_keys", secret: true, usage: "per-key response signing secrets as name=secret, keyed by auth key name"},
+    {key: "response_signing_keys_file", usage: "file containing response_signing_keys"},
+    {key: "tenant_jwt_secret", secret: true, usage: "HS256 secret of X-Tenant-Token JWTs, empty for a single-tenant service"},
+    {key: "tenant_jwt_secret_file", usage: "file containing tenant_jwt_secret"},
+    {key: "port", def: "8080", usage: "HTTP listen port"},
+    {key: "read_header_timeout", def: "5s", usage: "HTTP read header timeout"},
+    {key: "read_timeout", def: "15s", usage: "HTTP timeout for reading a whole request, 0 for none"},
+    {key: "write_timeout", def: "60s", usage: "HTTP timeout for writing a response, including NDJSON streams, 0 for none"},
+    {key: "idle_timeout", def: "120s", usage: "how long keep-alive connections may stay idle, 0 to use read_timeout"},
+    {key: "max_header_bytes", def: "65536", usage: "maximum size of request headers"},
+    {key: "h2c", def: "false", usage: "serve HTTP/2 without TLS (h2c) alongside HTTP/1.1, for internal mesh traffic"},
+    {key: "shutdown_timeout", def: "15s", usage: "how long to wait for in-flight requests on shutdown"},
+    {key: "maintenance_retry_after", def: "60s", usage: "Retry-After of writes rejected in maintenance mode"},
+    {key: "maintenance_poll_interval", def: "5s", usage: "how often the maintenance mode set through any replica is read from the database"},
+    {key: "max_pending_withdrawals", def: "0", usage: "pending withdrawals allowed per user, 0 for no limit"},
+    {key: "withdrawal_fees", usage: "per-currency fees as USDT=50:half_up"},
+    {key: "fee_exempt_tiers", usage: "comma-separated user tiers charged no withdrawal fee, e.g. premium,enterprise"},
+    {key: "supported_currencies", def: "USDT", usage: "comma-separated currency codes withdrawals may be made in, case-insensitive"},
+    {key: "reservation_ttl", def: "0s", usage: "how long a pending withdrawal holds funds, 0 to hold until confirmed"},
+    {key: "reservation_sweep_interval", def: "30s", usage: "how often expired holds are released"},
+    {key: "opening_ledger_entries", def: "false", usage: "record a new user's positive balance as a credit ledger entry"},
+    {key: "settlement_ledger_entries", def: "false", usage: "record a settlement ledger entry, which moves no money, when a withdrawal is confirmed"},
+    {key: "balance_audit", def: "true", usage: "record every balance change with its actor in the balance_audit table"},
+    {key: "replay_status_ok", def: "false", usage: "answer idempotent replays with 200 instead of 201"},
+    {key: "operator_required", def: "false", usage: "require an X-Operator header when confirming withdrawals"},
+    {key: "confirm_by_creating_key", def: "false", usage: "only let the API key that created a withdrawal confirm it"},
+    {key: "debug_log_bodies", def: "false", usage: "log masked request bodies of failed requests at debug level"},
+    {key: "log_redact_fields", def: "destination,idempotency_key", usage: "comma-separated fields hashed in event logs and debug bodies; an empty flag or file value logs them as is, an empty environment variable is ignored like any other"},
+    {key: "list_count_cap", def: "10000", usage: "rows at which with_count totals stop counting, 0 for no cap"},
+    {key: "idempotency_key_pattern", def: `^[ -~]{1,128}$`, usage: "regular expression idempotency keys must match after trimming"},
+    {key: "canonical_idempotency_keys", def: "false", usage: "lowercase and NFC-normalize idempotency keys before storing and looking them up"},
+    {key: "idempotency_cache_size", def: "0", usage: "withdrawals cached in memory by idempotency key to answer retries, 0 to disable"},
+    {key: "idempotency_cache_ttl", def: "10m", usage: "how long a withdrawal stays in the idempotency cache"},
+    {key: "smtp_host", usage: "SMTP relay for the daily summary email, empty to disable it"},
+    {key: "smtp_port", def: "25", usage: "SMTP relay port"},
+    {key: "smtp_from", usage: "sender address of the daily summary email"},
+    {key: "summary_email_to", usage: "comma-separated recipients of the daily summary email"},
+    {key: "summary_send_hour", def: "8", usage: "UTC hour (0-23) after which the daily summary is sent"},
+}
+
+// secretFiles maps secrets to the option holding a path to read them from.
+var secretFiles = map[string]string{
+    "database_url":          "database_url_file",
+    "auth_token":            "auth_token_file",
+    "auth_tokens":           "auth_tokens_file",
+    "admin_token":           "admin_token_file",
+    "webhook_secret":        "webhook_secret_file",
+    "tenant_jwt_secret":     "tenant_jwt_secret_file",
+    "response_signing_keys": "response_signing_keys_file",
+}
+
+func envName(key string) string {
+    return strings.ToUpper(key)
+}
+
+func flagName(key string) string {
+    return strings.ReplaceAll(key, "_", "-")
+}
+
+// Load resolves the configuration from args (without the program name),
+// the environment and the file passed via -config.
+func Load(args []string, getenv func(string) string) (Config, error) {
+    fs := flag.NewFlagSet("api", flag.ContinueOnError)
+    fs.SetOutput(io.Discard)
+    configPath := fs.String("config", "", "path to a YAML config file")
+    printConfig := fs.Bool("print-config", false, "print the effective configuration with secrets masked and exit")
+    flagValues := make(map[string]*string, len(options))
+    for _, opt := range options {
+        flagValues[opt.key] = fs.String(flagName(opt.key), "", opt.usage)
+    }
+    if err := fs.Parse(args); err != nil {
+        return Config{}, err
+    }
+
+    l := &loader{values: map[string]string{}, sources: map[string]string{}}
+    for _, opt := range options {
+        if opt.def != "" {
+            l.set(opt.key, opt.def, "default")
+        }
+    }
+
+    path := strings.TrimSpace(*configPath)
+    if path == "" {
+        path = strings.TrimSpace(getenv("CONFIG_FILE"))
+    }
+    if path != "" {
+        if err := l.loadFile(path); err != nil {
+            return Config{}, err
+        }
+    }
+
+    for _, opt := range options {
+        if v := strings.TrimSpace(getenv(envName(opt.key))); v != "" {
+            l.set(opt.key, v, "env "+envName(opt.key))
+        }
+    }
+
+    fs.Visit(func(f *flag.Flag) {
+        for _, opt := range options {
+            if flagName(opt.key) == f.Name {
+                l.set(opt.key, strings.TrimSpace(*flagValues[opt.key]), "flag -"+f.Name)
+            }
+        }
+    })
+
+    cfg, err := l.build()
+    if err != nil {
+        return Config{}, err
+    }
+    cfg.PrintConfig = *printConfig
+    return cfg, nil
+}
+
+// Print writes the effective configuration with its sources. Secrets are
+// masked.
+func (c Config) Print(w io.Writer) {
+    for _, opt := range options {
+        value, ok := c.values[opt.key]
+        if !ok {
+            continue
+        }
+        if opt.secret && value != "" {
+            value = "****"
+        }
+        fmt.Fprintf(w, "%s = %q (%s)\n", opt.key, value, c.sources[opt.key])
+    }
+}
+
+type loader struct {
+    values  map[string]string
+    sources map[string]string
+}
+
+func (l *loader) set(key, value, source string) {
+    l.values[key] = value
+    l.sources[key] = source
+}
+
+func (l *loader) loadFile(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return fmt.Errorf("read config file: %w", err)
+    }
+    var raw map[string]any
+    if err := yaml.Unmarshal(data, &raw); err != nil {
+        return fmt.Errorf("parse config file %s: %w", path, err)
+    }
+    known := make(map[string]bool, len(options))
+    for _, opt := range options {
+        known[opt.key] = true
+    }
+    for key, value := range raw {
+        if !known[key] {
+            return fmt.Errorf("unknown key %q in config file %s", key, path)
+        }
+        switch v := value.(type) {
+        case nil:
+            continue
+        case map[string]any, []any:
+            return fmt.Errorf("%s: expected a scalar value (source: file %s)", key, path)
+        default:
+            l.set(key, strings.TrimSpace(fmt.Sprint(v)), "file "+path)
+        }
+    }
+    return nil
+}
+
+func (l *loader) invalid(key string, err error) error {
+    return fmt.Errorf("%s: %v (source: %s)", key, err, l.sources[key])
+}
+
+func (l *loader) str(key string) string {
+    return l.values[key]
+}
+
+// duration parses key. An empty value means 0 where 0 is allowed; elsewhere
+// it is rejected like "0s" rather than overriding the default with 0.
+func (l *loader) duration(key string, allowZero bool) (time.Duration, error) {
+    raw := l.values[key]
+    if raw == "" && allowZero {
+        return 0, nil
+    }
+    d, err := time.ParseDuration(raw)
+    if err != nil || d < 0 || (d == 0 && !allowZero) {
+        return 0, l.invalid(key, fmt.Errorf("invalid duration %q", raw))
+    }
+    return d, nil
+}
+
+func (l *loader) nonNegativeInt(key string) (int, error) {
+    raw := l.values[key]
+    if raw == "" {
+        return 0, nil
+    }
+    n, err := strconv.Atoi(raw)
+    if err != nil || n < 0 {
+        return 0, l.invalid(key, fmt.Errorf("invalid non-negative integer %q", raw))
+    }
+    return n, nil
+}
+
+func (l *loader) boolean(key string) (bool, error) {
+    raw := l.values[key]
+    if raw == "" {
+        return false, nil
+    }
+    b, err := strconv.ParseBool(raw)
+    if err != nil {
+        return false, l.invalid(key, fmt.Errorf("invalid boolean %q", raw))
+    }
+    return b, nil
+}
+
+// secret resolves key from its value or its *_file companion.
+func (l *loader) secret(key string) (string, error) {
+    fileKey := secretFiles[key]
+    value := l.values[key]
+    path := l.values[fileKey]
+    if path == "" {
+        return value, nil
+    }
+    if value != "" {
+        return "", fmt.Errorf("%s and %s are mutually exclusive (sources: %s, %s)", key, fileKey, l.sources[key], l.sources[fileKey])
+    }
+    value, err := ReadSecretFile(path)
+    if err != nil {
+        return "", l.invalid(fileKey, err)
+    }
+    return value, nil
+}
+
+func (l *loader) build() (Config, error) {
+    cfg := Config{
+        AuthTokenFile: l.str("auth_token_file"),
+        Port:          l.str("port"),
+        values:        l.values,
+        sources:       l.sources,
+    }
+    var err error
+
+    if cfg.DatabaseURL, err = l.secret("database_url"); err != nil {
+        return Config{}, err
+    }
+    if cfg.DatabaseURL == "" {
+        user, password, name := l.str("db_user"), l.str("db_password"), l.str("db_name")
+        if user == "" || password == "" || name == "" {
+            return Config{}, errors.New("database_url or db_user/db_password/db_name are required")
+        }
+        cfg.DatabaseURL = fmt.Sprintf(
+            "host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+            l.str("db_host"),
+            l.str("db_port"),
+            user,
+            password,
+            name,
+            l.str("db_sslmode"),
+        )
+    }
+
+    maxConns, err := l.nonNegativeInt("db_max_conns")
+    if err != nil {
+        return Config{}, err
+    }
+    minConns, err := l.nonNegativeInt("db_min_conns")
+    if err != nil {
+        return Config{}, err
+    }
+    if maxConns > 0 && minConns > maxConns {
+        return Config{}, l.invalid("db_min_conns", fmt.Errorf("must not exceed db_max_conns %d", maxConns))
+    }
+    cfg.DBMaxConns, cfg.DBMinConns = int32(maxConns), int32(minConns)
+    if cfg.DBConnectTimeout, err = l.duration("db_connect_timeout", false); err != nil {
+        return Config{}, err
+    }
+
+    if cfg.AuthToken, err = l.secret("auth_token"); err != nil {
+        return Config{}, err
+    }
+    rawKeys, err := l.secret("auth_tokens")
+    if err != nil {
+        return Config{}, err
+    }
+    if cfg.AuthKeys, err = ParseAuthKeys(rawKeys); err != nil {
+        return Config{}, l.invalid("auth_tokens", err)
+    }
+    if cfg.AuthToken == "" && len(cfg.AuthKeys) == 0 {
+        return Config{}, errors.New("auth_token, auth_token_file or auth_tokens is required")
+    }
+    if cfg.AuthTokenPollInterval, err = l.duration("auth_token_poll_interval", true); err != nil {
+        return Config{}, err
+    }
+    if cfg.AdminToken, err = l.secret("admin_token"); err != nil {
+        return Config{}, err
+    }
+    if cfg.WebhookSecret, err = l.secret("webhook_secret"); err != nil {
+        return Config{}, err
+    }
+    if cfg.TenantJWTSecret, err = l.secret("tenant_jwt_secret"); err != nil {
+        return Config{}, err
+    }
+    rawSigningKeys, err := l.secret("response_signing_keys")
+    if err != nil {
+        return Config{}, err
+    }
+    if cfg.ResponseSigningKeys, err = ParseAuthKeys(rawSigningKeys); err != nil {
+        return Config{}, l.invalid("response_signing_keys", err)
+    }
+    for name := range cfg.ResponseSigningKeys {
+        _, named := cfg.AuthKeys[name]
+        if !named && !(name == "default" && cfg.AuthToken != "") {
+            return Config{}, l.invalid("response_signing_keys", fmt.Errorf("unknown auth key name %q", name))
+        }
+    }
+
+    if cfg.Port == "" {
+        return Config{}, l.invalid("port", errors.New("must not be empty"))
+    }
+    if cfg.ReadHeaderTimeout, err = l.duration("read_header_timeout", false); err != nil {
+        return Config{}, err
+    }
+    if cfg.ReadTimeout, err = l.duration("read_timeout", true); err != nil {
+        return Config{}, err
+    }
+    if cfg.WriteTimeout, err = l.duration("write_timeout", true); err != nil {
+        return Config{}, err
+    }
+    if cfg.IdleTimeout, err = l.duration("idle_timeout", true); err != nil {
+        return Config{}, err
+    }
+    if cfg.MaxHeaderBytes, err = l.nonNegativeInt("max_header_bytes"); err != nil {
+        return Config{}, err
+    }
+    if cfg.MaxHeaderBytes == 0 {
+        return Config{}, l.invalid("max_header_bytes", errors.New("must be positive"))
+    }
+    if cfg.H2C, err = l.boolean("h2c"); err != nil {
+        return Config{}, err
+    }
+    if cfg.ShutdownTimeout, err = l.duration("shutdown_timeout", false); err != nil {
+        return Config{}, err
+    }
+    if cfg.MaintenanceRetryAfter, err = l.duration("maintenance_retry_after", false); err != nil {
+        return Config{}, err
+    }
+    if cfg.MaintenancePollInterval, err = l.duration("maintenance_poll_interval", false); err != nil {
+        return Config{}, err
+    }
+
+    if cfg.MaxPendingWithdrawals, err = l.nonNegativeInt("max_pending_withdrawals"); err != nil {
+        return Config{}, err
+    }
+    if cfg.WithdrawalFees, err = ParseFeePolicies(l.str("withdrawal_fees")); err != nil {
+        return Config{}, l.invalid("withdrawal_fees", err)
+    }
+    cfg.FeeExemptTiers = splitList(l.str("fee_exempt_tiers"))
+    for _, tier := range cfg.FeeExemptTiers {
+        if !slices.Contains(store.Tiers, tier) {
+            return Config{}, l.invalid("fee_exempt_tiers", fmt.Errorf("unknown tier %q", tier))
+        }
+    }
+    cfg.SupportedCurrencies = splitList(strings.ToUpper(l.str("supported_currencies")))
+    if len(cfg.SupportedCurrencies) == 0 {
+        return Config{}, l.invalid("supported_currencies", errors.New("at least one currency is required"))
+    }
+    for _, code := range cfg.SupportedCurrencies {
+        if !store.ValidCurrencyCode(code) {
+            return Config{}, l.invalid("supported_currencies", fmt.Errorf("invalid currency code %q", code))
+        }
+    }
+    if cfg.ReservationTTL, err = l.duration("reservation_ttl", true); err != nil {
+        return Config{}, err
+    }
+    if cfg.ReservationSweepInterval, err = l.duration("reservation_sweep_interval", false); err != nil {
+        return Config{}, err
+    }
+    if cfg.OpeningLedgerEntries, err = l.boolean("opening_ledger_entries"); err != nil {
+        return Config{}, err
+    }
+    if cfg.SettlementLedgerEntries, err = l.boolean("settlement_ledger_entries"); err != nil {
+        return Config{}, err
+    }
+    if cfg.BalanceAudit, err = l.boolean("balance_audit"); err != nil {
+        return Config{}, err
+    }
+    if cfg.ReplayStatusOK, err = l.boolean("replay_status_ok"); err != nil {
+        return Config{}, err
+    }
+    if cfg.OperatorRequired, err = l.boolean("operator_required"); err != nil {
+        return Config{}, err
+    }
+    if cfg.ConfirmByCreatingKey, err = l.boolean("confirm_by_creating_key"); err != nil {
+        return Config{}, err
+    }
+    if cfg.DebugLogBodies, err = l.boolean("debug_log_bodies"); err != nil {
+        return Config{}, err
+    }
+    cfg.LogRedactFields = splitList(l.str("log_redact_fields"))
+    if cfg.ListCountCap, err = l.nonNegativeInt("list_count_cap"); err != nil {
+        return Config{}, err
+    }
+    if cfg.IdempotencyKeyPattern, err = regexp.Compile(l.str("idempotency_key_pattern")); err != nil {
+        return Config{}, l.invalid("idempotency_key_pattern", err)
+    }
+    if cfg.CanonicalIdempotencyKeys, err = l.boolean("canonical_idempotency_keys"); err != nil {
+        return Config{}, err
+    }
+    if cfg.IdempotencyCacheSize, err = l.nonNegativeInt("idempotency_cache_size"); err != nil {
+        return Config{}, err
+    }
+    if cfg.IdempotencyCacheTTL, err = l.duration("idempotency_cache_ttl", false); err != nil {
+        return Config{}, err
+    }
+
+    cfg.SMTPHost = l.str("smtp_host")
+    cfg.SMTPPort = l.str("smtp_port")
+    cfg.SMTPFrom = l.str("smtp_from")
+    cfg.SummaryEmailTo = l.str("summary_email_to")
+    if cfg.SummarySendHour, err = l.nonNegativeInt("summary_send_hour"); err != nil {
+        return Config{}, err
+    }
+    if cfg.SummarySendHour > 23 {
+        return Config{}, l.invalid("summary_send_hour", fmt.Errorf("must be between 0 and 23"))
+    }
+    if cfg.SMTPHost != "" && (cfg.SMTPFrom == "" || cfg.SummaryEmailTo == "") {
+        return Config{}, errors.New("smtp_host requires smtp_from and summary_email_to")
+    }
+
+    return cfg, nil
+}
+
+func ReadSecretFile(path string) (string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "", fmt.Errorf("read %s: %w", path, err)
+    }
+    value := strings.TrimSpace(string(data))
+    if value == "" {
+        return "", fmt.Errorf("%s is empty", path)
+    }
+    return value, nil
+}
+
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(raw string) []string {
+    items := []string{}
+    for _, item := range strings.Split(raw, ",") {
+        if item = strings.TrimSpace(item); item != "" {
+            items = append(items, item)
+        }
+    }
+    return items
+}
+
+// ParseAuthKeys parses "name=token,name2=token2" into named API keys.
+func ParseAuthKeys(raw string) (map[string]string, error) {
+    keys := make(map[string]string)
+    raw = strings.TrimSpace(raw)
+    if raw == "" {
+        return keys, nil
+    }
+    for _, item := range strings.Split(raw, ",") {
+        name, token, ok := strings.Cut(strings.TrimSpace(item), "=")
+        name = strings.TrimSpace(name)
+        token = strings.TrimSpace(token)
+        if !ok || name == "" || token == "" {
+            return nil, errors.New("invalid entry, expected name=token")
+        }
+        if _, dup := keys[name]; dup {
+            return nil, fmt.Errorf("duplicate key name %q", name)
+        }
+        keys[name] = token
+    }
+    return keys, nil
+}
+
+// ParseFeePolicies parses "USDT=50:half_up,BTC=10:ceil" into per-currency
+// fee policies (basis points and rounding mode).
+func ParseFeePolicies(raw string) (map[string]store.FeePolicy, error) {
+    policies := make(map[string]store.FeePolicy)
+    raw = strings.TrimSpace(raw)
+    if raw == "" {
+        return policies, nil
+    }
+    for _, item := range strings.Split(raw, ",") {
+        code, spec, ok := strings.Cut(strings.TrimSpace(item), "=")
+        if !ok {
+            return nil, fmt.Errorf("invalid entry %q", item)
+        }
+        bps, mode, ok := strings.Cut(spec, ":")
+        if !ok {
+            mode = string(store.RoundHalfUp)
+        }
+        n, err := strconv.ParseInt(bps, 10, 64)
+        if err != nil {
+            return nil, fmt.Errorf("invalid entry %q", item)
+        }
+        policy := store.FeePolicy{BasisPoints: n, Rounding: store.RoundingMode(mode)}
+        if err := policy.Validate(); err != nil {
+            return nil, fmt.Errorf("invalid entry %q: %w", item, err)
+        }
+        policies[strings.ToUpper(strings.TrimSpace(code))] = policy
+    }
+    return policies, nil
+}
@@ -0,0 +1,352 @@
+package config
+
+import (
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+func envFrom(m map[string]string) func(string) string {
+    return func(name string) string {
+        return m[name]
+    }
+}
+
+func writeFile(t *testing.T, name, content string) string {
+    t.Helper()
+
+    path := filepath.Join(t.TempDir(), name)
+    if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+        t.Fatalf("write %s: %v", name, err)
+    }
+    return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+    cfg, err := Load(nil, envFrom(map[string]string{
+        "DATABASE_URL": "postgres://env",
+        "AUTH_TOKEN":   "env-token",
+    }))
+    if err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if cfg.DatabaseURL != "postgres://env" || cfg.AuthToken != "env-token" {
+        t.Fatalf("unexpected config: %+v", cfg)
+    }
+    if cfg.Port != "8080" || cfg.ShutdownTimeout != 15*time.Second || cfg.ReadHeaderTimeout != 5*time.Second {
+        t.Fatalf("unexpected defaults: port=%s shutdown=%s header=%s", cfg.Port, cfg.ShutdownTimeout, cfg.ReadHeaderTimeout)
+    }
+    if cfg.ReadTimeout != 15*time.Second || cfg.WriteTimeout != time.Minute || cfg.IdleTimeout != 2*time.Minute || cfg.MaxHeaderBytes != 64<<10 || cfg.H2C {
+        t.Fatalf("unexpected HTTP defaults: read=%s write=%s idle=%s header bytes=%d h2c=%t", cfg.ReadTimeout, cfg.WriteTimeout, cfg.IdleTimeout, cfg.MaxHeaderBytes, cfg.H2C)
+    }
+    if cfg.MaintenanceRetryAfter != time.Minute || cfg.MaintenancePollInterval != 5*time.Second {
+        t.Fatalf("unexpected maintenance defaults: retry after %s, poll interval %s", cfg.MaintenanceRetryAfter, cfg.MaintenancePollInterval)
+    }
+    if cfg.CanonicalIdempotencyKeys {
+        t.Fatalf("expected idempotency keys compared byte for byte by default")
+    }
+    if cfg.SettlementLedgerEntries {
+        t.Fatalf("expected no settlement ledger entries by default")
+    }
+    if cfg.DebugLogBodies {
+        t.Fatalf("expected request bodies not logged by default")
+    }
+    if !reflect.DeepEqual(cfg.SupportedCurrencies, []string{"USDT"}) {
+        t.Fatalf("expected only USDT supported by default, got %v", cfg.SupportedCurrencies)
+    }
+}
+
+func TestLoadLogRedactFields(t *testing.T) {
+    env := map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "env-token"}
+    cfg, err := Load(nil, envFrom(env))
+    if err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if !reflect.DeepEqual(cfg.LogRedactFields, []string{"destination", "idempotency_key"}) {
+        t.Fatalf("unexpected default redact fields: %v", cfg.LogRedactFields)
+    }
+
+    cfg, err = Load(nil, envFrom(map[string]string{
+        "DATABASE_URL":      "postgres://env",
+        "AUTH_TOKEN":        "env-token",
+        "LOG_REDACT_FIELDS": " destination , ,user_id",
+    }))
+    if err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if !reflect.DeepEqual(cfg.LogRedactFields, []string{"destination", "user_id"}) {
+        t.Fatalf("unexpected redact fields: %v", cfg.LogRedactFields)
+    }
+
+    cfg, err = Load([]string{"-log-redact-fields="}, envFrom(env))
+    if err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if len(cfg.LogRedactFields) != 0 {
+        t.Fatalf("expected redaction disabled, got %v", cfg.LogRedactFields)
+    }
+}
+
+func TestLoadPrecedence(t *testing.T) {
+    file := writeFile(t, "config.yaml", "database_url: postgres://file\nauth_token: file-token\nport: 7000\nshutdown_timeout: 20s\nmax_pending_withdrawals: 3\n")
+
+    cfg, err := Load(
+        []string{"-config", file, "-port", "9000"},
+        envFrom(map[string]string{"PORT": "8000", "SHUTDOWN_TIMEOUT": "25s"}),
+    )
+    if err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if cfg.Port != "9000" {
+        t.Fatalf("expected flag to win, got port %s", cfg.Port)
+    }
+    if cfg.ShutdownTimeout != 25*time.Second {
+        t.Fatalf("expected env to beat file, got %s", cfg.ShutdownTimeout)
+    }
+    if cfg.MaxPendingWithdrawals != 3 || cfg.DatabaseURL != "postgres://file" {
+        t.Fatalf("expected file values, got %+v", cfg)
+    }
+    if cfg.ReadHeaderTimeout != 5*time.Second {
+        t.Fatalf("expected default read header timeout, got %s", cfg.ReadHeaderTimeout)
+    }
+}
+
+func TestLoadSecretsFromFiles(t *testing.T) {
+    cfg, err := Load(nil, envFrom(map[string]string{
+        "DATABASE_URL_FILE":      writeFile(t, "db", "postgres://file\n"),
+        "AUTH_TOKEN_FILE":        writeFile(t, "token", "file-token\n\n"),
+        "ADMIN_TOKEN_FILE":       writeFile(t, "admin", "admin-token\n"),
+        "WEBHOOK_SECRET_FILE":    writeFile(t, "webhook", "webhook-secret\n"),
+        "TENANT_JWT_SECRET_FILE": writeFile(t, "tenant", "tenant-secret\n"),
+    }))
+    if err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if cfg.DatabaseURL != "postgres://file" || cfg.AuthToken != "file-token" {
+        t.Fatalf("unexpected secrets: db=%q token=%q", cfg.DatabaseURL, cfg.AuthToken)
+    }
+    if cfg.AdminToken != "admin-token" || cfg.WebhookSecret != "webhook-secret" {
+        t.Fatalf("unexpected secrets: admin=%q webhook=%q", cfg.AdminToken, cfg.WebhookSecret)
+    }
+    if cfg.TenantJWTSecret != "tenant-secret" {
+        t.Fatalf("unexpected tenant secret: %q", cfg.TenantJWTSecret)
+    }
+    if cfg.AuthTokenFile == "" {
+        t.Fatalf("expected auth token file path to be kept for reloads")
+    }
+}
+
+func TestLoadErrors(t *testing.T) {
+    missing := filepath.Join(t.TempDir(), "missing")
+    base := map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t"}
+
+    tests := []struct {
+        name    string
+        args    []string
+        env     map[string]string
+        wantErr string
+    }{
+        {
+            name:    "env and file both set",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "AUTH_TOKEN_FILE": "/dev/null"},
+            wantErr: "auth_token and auth_token_file are mutually exclusive (sources: env AUTH_TOKEN, env AUTH_TOKEN_FILE)",
+        },
+        {
+            name:    "unreadable file",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN_FILE": missing},
+            wantErr: missing,
+        },
+        {
+            name:    "empty file",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "ADMIN_TOKEN_FILE": "/dev/null"},
+            wantErr: "/dev/null is empty",
+        },
+        {
+            name:    "unknown fee exempt tier",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "FEE_EXEMPT_TIERS": "premium,gold"},
+            wantErr: `fee_exempt_tiers: unknown tier "gold" (source: env FEE_EXEMPT_TIERS)`,
+        },
+        {
+            name:    "invalid supported currency",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "SUPPORTED_CURRENCIES": "usdt,$eur"},
+            wantErr: `supported_currencies: invalid currency code "$EUR" (source: env SUPPORTED_CURRENCIES)`,
+        },
+        {
+            name:    "signing key for unknown auth key",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKENS": "billing=t", "RESPONSE_SIGNING_KEYS": "partner=s"},
+            wantErr: `response_signing_keys: unknown auth key name "partner" (source: env RESPONSE_SIGNING_KEYS)`,
+        },
+        {
+            name:    "missing auth token",
+            env:     map[string]string{"DATABASE_URL": "postgres://env"},
+            wantErr: "auth_token, auth_token_file or auth_tokens is required",
+        },
+        {
+            name:    "missing database",
+            env:     map[string]string{"AUTH_TOKEN": "t"},
+            wantErr: "database_url or db_user/db_password/db_name are required",
+        },
+        {
+            name:    "invalid env duration names key and source",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "SHUTDOWN_TIMEOUT": "soon"},
+            wantErr: `shutdown_timeout: invalid duration "soon" (source: env SHUTDOWN_TIMEOUT)`,
+        },
+        {
+            name:    "invalid flag names key and source",
+            args:    []string{"-max-pending-withdrawals", "-1"},
+            env:     base,
+            wantErr: "max_pending_withdrawals: invalid non-negative integer \"-1\" (source: flag -max-pending-withdrawals)",
+        },
+        {
+            name:    "invalid fees",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "WITHDRAWAL_FEES": "USDT=x"},
+            wantErr: "withdrawal_fees: invalid entry \"USDT=x\" (source: env WITHDRAWAL_FEES)",
+        },
+        {
+            name:    "invalid boolean",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "REPLAY_STATUS_OK": "maybe"},
+            wantErr: "replay_status_ok: invalid boolean \"maybe\" (source: env REPLAY_STATUS_OK)",
+        },
+        {
+            name:    "negative list count cap",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "LIST_COUNT_CAP": "-1"},
+            wantErr: "list_count_cap:",
+        },
+        {
+            name:    "invalid idempotency key pattern",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "IDEMPOTENCY_KEY_PATTERN": "^[a-z"},
+            wantErr: "idempotency_key_pattern: error parsing regexp",
+        },
+        {
+            name:    "zero idempotency cache ttl",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "IDEMPOTENCY_CACHE_TTL": "0s"},
+            wantErr: `idempotency_cache_ttl: invalid duration "0s" (source: env IDEMPOTENCY_CACHE_TTL)`,
+        },
+        {
+            name:    "zero maintenance retry after",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "MAINTENANCE_RETRY_AFTER": "0s"},
+            wantErr: `maintenance_retry_after: invalid duration "0s" (source: env MAINTENANCE_RETRY_AFTER)`,
+        },
+        {
+            name:    "empty interval flag",
+            args:    []string{"-maintenance-poll-interval="},
+            env:     base,
+            wantErr: `maintenance_poll_interval: invalid duration "" (source: flag -maintenance-poll-interval)`,
+        },
+        {
+            name:    "summary hour out of range",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "SUMMARY_SEND_HOUR": "24"},
+            wantErr: "summary_send_hour: must be between 0 and 23 (source: env SUMMARY_SEND_HOUR)",
+        },
+        {
+            name:    "smtp without recipients",
+            env:     map[string]string{"DATABASE_URL": "postgres://env", "AUTH_TOKEN": "t", "SMTP_HOST": "mail", "SMTP_FROM": "a@b"},
+            wantErr: "smtp_host requires smtp_from and summary_email_to",
+        },
+        {
+            name:    "unknown flag",
+            args:    []string{"-nope"},
+            env:     base,
+            wantErr: "flag provided but not defined: -nope",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            _, err := Load(tt.args, envFrom(tt.env))
+            if err == nil {
+                t.Fatalf("expected error containing %q", tt.wantErr)
+            }
+            if !strings.Contains(err.Error(), tt.wantErr) {
+                t.Fatalf("expected error containing %q, got %q", tt.wantErr, err.Error())
+            }
+        })
+    }
+}
+
+func TestLoadFileErrors(t *testing.T) {
+    unknown := writeFile(t, "unknown.yaml", "database_url: x\nauth_token: t\nnope: 1\n")
+    if _, err := Load([]string{"-config", unknown}, envFrom(nil)); err == nil || !strings.Contains(err.Error(), `unknown key "nope"`) {
+        t.Fatalf("expected unknown key error, got %v", err)
+    }
+
+    invalid := writeFile(t, "invalid.yaml", "database_url: x\nauth_token: t\nread_header_timeout: fast\n")
+    _, err := Load([]string{"-config", invalid}, envFrom(nil))
+    if err == nil || !strings.Contains(err.Error(), "read_header_timeout") || !strings.Contains(err.Error(), "source: file "+invalid) {
+        t.Fatalf("expected error naming key and file, got %v", err)
+    }
+
+    // An empty interval would start a ticker with a zero period.
+    empty := writeFile(t, "empty.yaml", "database_url: x\nauth_token: t\nreservation_sweep_interval: \"\"\n")
+    _, err = Load([]string{"-config", empty}, envFrom(nil))
+    if err == nil || !strings.Contains(err.Error(), `reservation_sweep_interval: invalid duration ""`) {
+        t.Fatalf("expected an empty interval rejected, got %v", err)
+    }
+}
+
+func TestPrintConfigMasksSecrets(t *testing.T) {
+    cfg, err := Load([]string{"-print-config"}, envFrom(map[string]string{
+        "DATABASE_URL": "postgres://user:hunter2@db/app",
+        "AUTH_TOKEN":   "super-secret",
+    }))
+    if err != nil {
+        t.Fatalf("load: %v", err)
+    }
+    if !cfg.PrintConfig {
+        t.Fatalf("expected PrintConfig to be set")
+    }
+
+    var out strings.Builder
+    cfg.Print(&out)
+    printed := out.String()
+
+    if strings.Contains(printed, "hunter2") || strings.Contains(printed, "super-secret") {
+        t.Fatalf("secrets leaked in printed config:\n%s", printed)
+    }
+    if !strings.Contains(printed, `auth_token = "****" (env AUTH_TOKEN)`) {
+        t.Fatalf("expected masked auth_token with source, got:\n%s", printed)
+    }
+    if !strings.Contains(printed, `port = "8080" (default)`) {
+        t.Fatalf("expected default port with source, got:\n%s", printed)
+    }
+}
+
+func TestParseAuthKeys(t *testing.T) {
+    keys, err := ParseAuthKeys("billing=tok1, ops = tok2")
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+    if len(keys) != 2 || keys["billing"] != "tok1" || keys["ops"] != "tok2" {
+        t.Fatalf("unexpected keys: %v", keys)
+    }
+
+    for _, raw := range []string{"billing", "=tok", "billing=", "a=1,a=2"} {
+        if _, err := ParseAuthKeys(raw); err == nil {
+            t.Fatalf("expected error for %q", raw)
+        }
+    }
+}
+
+func TestParseFeePolicies(t *testing.T) {
+    policies, err := ParseFeePolicies("USDT=50:ceil, btc=10")
+    if err != nil {
+        t.Fatalf("parse: %v", err)
+    }
+    if got := policies["USDT"]; got.BasisPoints != 50 || got.Rounding != store.RoundCeil {
+        t.Fatalf("unexpected USDT policy: %+v", got)
+    }
+    if got := policies["BTC"]; got.BasisPoints != 10 || got.Rounding != store.RoundHalfUp {
+        t.Fatalf("unexpected BTC policy: %+v", got)
+    }
+
+    for _, raw := range []string{"USDT", "USDT=x", "USDT=50:bankers", "USDT=20000"} {
+        if _, err := ParseFeePolicies(raw); err == nil {
+            t.Fatalf("expected error for %q", raw)
+        }
+    }
+}
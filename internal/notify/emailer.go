@@ -0,0 +1,127 @@
+// Package notify sends operational notifications such as the daily
+// withdrawal summary email.
+package notify
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "net"
+    "net/smtp"
+    "strings"
+    "time"
+)
+
+type Mailer interface {
+    SendSummary(ctx context.Context, to, subject, body string) error
+}
+
+// SMTPMailer delivers plain-text mail through an SMTP relay without
+// authentication.
+type SMTPMailer struct {
+    addr     string
+    from     string
+    sendMail func(ctx context.Context, addr, from string, to []string, msg []byte) error
+}
+
+func NewSMTPMailer(host, port, from string) *SMTPMailer {
+    return &SMTPMailer{
+        addr:     net.JoinHostPort(host, port),
+        from:     from,
+        sendMail: sendMail,
+    }
+}
+
+// SendSummary sends body to the comma-separated recipients in to.
+func (m *SMTPMailer) SendSummary(ctx context.Context, to, subject, body string) error {
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    recipients := splitRecipients(to)
+    if len(recipients) == 0 {
+        return fmt.Errorf("no recipients")
+    }
+    return m.sendMail(ctx, m.addr, m.from, recipients, buildMessage(m.from, recipients, subject, body, time.Now()))
+}
+
+// sendMail is smtp.SendMail without authentication, bounded by ctx: the dial
+// honours it and the connection is closed once ctx is done.
+func sendMail(ctx context.Context, addr, from string, to []string, msg []byte) error {
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "tcp", addr)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    stop := context.AfterFunc(ctx, func() {
+        conn.Close()
+    })
+    defer stop()
+
+    if err := deliver(conn, addr, from, to, msg); err != nil {
+        if ctxErr := ctx.Err(); ctxErr != nil {
+            return ctxErr
+        }
+        return err
+    }
+    return nil
+}
+
+func deliver(conn net.Conn, addr, from string, to []string, msg []byte) error {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        return err
+    }
+    c, err := smtp.NewClient(conn, host)
+    if err != nil {
+        return err
+    }
+    defer c.Close()
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+            return err
+        }
+    }
+    if err := c.Mail(from); err != nil {
+        return err
+    }
+    for _, rcpt := range to {
+        if err := c.Rcpt(rcpt); err != nil {
+            return err
+        }
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if _, err := w.Write(msg); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return c.Quit()
+}
+
+func splitRecipients(to string) []string {
+    var recipients []string
+    for _, r := range strings.Split(to, ",") {
+        if r = strings.TrimSpace(r); r != "" {
+            recipients = append(recipients, r)
+        }
+    }
+    return recipients
+}
+
+func buildMessage(from string, to []string, subject, body string, date time.Time) []byte {
+    var b strings.Builder
+    fmt.Fprintf(&b, "From: %s\r\n", from)
+    fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+    fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+    fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+    b.WriteString("MIME-Version: 1.0\r\n")
+    b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+    b.WriteString("\r\n")
+    b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+    return []byte(b.String())
+}
@@ -0,0 +1,119 @@
+package notify
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+type StatsSource interface {
+    WithdrawalStats(ctx context.Context, q store.WithdrawalStatsQuery) ([]store.WithdrawalStatsRow, error)
+}
+
+// SentLog records which days' summaries were sent, across replicas.
+type SentLog interface {
+    SendDailySummaryOnce(ctx context.Context, day time.Time, send func(ctx context.Context) error) (bool, error)
+}
+
+type Logger interface {
+    Printf(format string, v ...any)
+}
+
+// DailySummary mails the previous UTC day's confirmed withdrawal totals once
+// a day, at the first check after SendHour (UTC). Replicas sharing Sent send
+// each day's summary once between them; without it each one sends its own.
+type DailySummary struct {
+    Stats    StatsSource
+    Mailer   Mailer
+    Sent     SentLog
+    To       string
+    SendHour int
+    Logger   Logger
+
+    now      func() time.Time
+    lastSent time.Time
+}
+
+// Run checks every interval whether the summary is due until ctx is done.
+func (d *DailySummary) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        d.tick(ctx)
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+func (d *DailySummary) tick(ctx context.Context) {
+    now := d.clock().UTC()
+    today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+    if now.Hour() < d.SendHour || !d.lastSent.Before(today) {
+        return
+    }
+    day := today.AddDate(0, 0, -1)
+    send := func(ctx context.Context) error {
+        return d.Send(ctx, day)
+    }
+    var err error
+    if d.Sent != nil {
+        _, err = d.Sent.SendDailySummaryOnce(ctx, day, send)
+    } else {
+        err = send(ctx)
+    }
+    if err != nil {
+        d.Logger.Printf("daily summary error: %v", err)
+        return
+    }
+    d.lastSent = today
+}
+
+// Send mails the summary of withdrawals confirmed in the UTC day starting at
+// day. Those reversed since are left out with the other non-confirmed rows.
+func (d *DailySummary) Send(ctx context.Context, day time.Time) error {
+    rows, err := d.Stats.WithdrawalStats(ctx, store.WithdrawalStatsQuery{
+        From:           day,
+        To:             day.AddDate(0, 0, 1),
+        GroupBy:        []string{store.GroupByStatus, store.GroupByCurrency},
+        ByConfirmation: true,
+    })
+    if err != nil {
+        return err
+    }
+    date := day.Format("2006-01-02")
+    subject := fmt.Sprintf("Withdrawal summary for %s", date)
+    return d.Mailer.SendSummary(ctx, d.To, subject, FormatSummary(date, rows))
+}
+
+// FormatSummary renders the confirmed rows of a status/currency breakdown as
+// a plain-text email body.
+func FormatSummary(date string, rows []store.WithdrawalStatsRow) string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Confirmed withdrawals for %s (UTC)\n\n", date)
+    confirmed := 0
+    for _, row := range rows {
+        if row.Status != store.StatusConfirmed {
+            continue
+        }
+        fmt.Fprintf(&b, "%s: %d withdrawals, total %d\n", row.Currency, row.Count, row.Amount)
+        confirmed++
+    }
+    if confirmed == 0 {
+        b.WriteString("No confirmed withdrawals.\n")
+    }
+    return b.String()
+}
+
+func (d *DailySummary) clock() time.Time {
+    if d.now != nil {
+        return d.now()
+    }
+    return time.Now()
+}
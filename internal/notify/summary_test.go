@@ -0,0 +1,218 @@
+package notify
+
+import (
+    "context"
+    "errors"
+    "io"
+    "log"
+    "net"
+    "strings"
+    "testing"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+type sentMail struct {
+    to, subject, body string
+}
+
+type mockMailer struct {
+    sent []sentMail
+    err  error
+}
+
+func (m *mockMailer) SendSummary(_ context.Context, to, subject, body string) error {
+    if m.err != nil {
+        return m.err
+    }
+    m.sent = append(m.sent, sentMail{to: to, subject: subject, body: body})
+    return nil
+}
+
+type fakeStats struct {
+    queries []store.WithdrawalStatsQuery
+    rows    []store.WithdrawalStatsRow
+}
+
+func (f *fakeStats) WithdrawalStats(_ context.Context, q store.WithdrawalStatsQuery) ([]store.WithdrawalStatsRow, error) {
+    f.queries = append(f.queries, q)
+    return f.rows, nil
+}
+
+type fakeSentLog struct {
+    sent map[time.Time]bool
+}
+
+func (f *fakeSentLog) SendDailySummaryOnce(ctx context.Context, day time.Time, send func(ctx context.Context) error) (bool, error) {
+    if f.sent[day] {
+        return false, nil
+    }
+    if err := send(ctx); err != nil {
+        return false, err
+    }
+    f.sent[day] = true
+    return true, nil
+}
+
+func TestDailySummarySendsOncePerDay(t *testing.T) {
+    stats := &fakeStats{rows: []store.WithdrawalStatsRow{
+        {Status: store.StatusConfirmed, Currency: "USDT", Count: 3, Amount: 150},
+        {Status: store.StatusPending, Currency: "USDT", Count: 1, Amount: 10},
+    }}
+    mailer := &mockMailer{}
+    now := time.Date(2026, 1, 2, 7, 0, 0, 0, time.UTC)
+    d := &DailySummary{
+        Stats:    stats,
+        Mailer:   mailer,
+        To:       "finance@example.com",
+        SendHour: 8,
+        Logger:   log.New(io.Discard, "", 0),
+        now:      func() time.Time { return now },
+    }
+    ctx := context.Background()
+
+    d.tick(ctx)
+    if len(mailer.sent) != 0 {
+        t.Fatalf("expected no mail before the send hour, got %d", len(mailer.sent))
+    }
+
+    now = now.Add(65 * time.Minute)
+    d.tick(ctx)
+    now = now.Add(time.Hour)
+    d.tick(ctx)
+    if len(mailer.sent) != 1 {
+        t.Fatalf("expected exactly one mail on the first day, got %d", len(mailer.sent))
+    }
+    got := mailer.sent[0]
+    if got.to != "finance@example.com" || got.subject != "Withdrawal summary for 2026-01-01" {
+        t.Fatalf("unexpected mail: %+v", got)
+    }
+    if !strings.Contains(got.body, "USDT: 3 withdrawals, total 150") || strings.Contains(got.body, "total 10\n") {
+        t.Fatalf("unexpected body: %q", got.body)
+    }
+    q := stats.queries[0]
+    if !q.From.Equal(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)) || !q.To.Equal(time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)) {
+        t.Fatalf("unexpected range: %s - %s", q.From, q.To)
+    }
+    if !q.ByConfirmation {
+        t.Fatalf("expected withdrawals selected by confirmation time")
+    }
+
+    now = now.Add(23 * time.Hour)
+    d.tick(ctx)
+    if len(mailer.sent) != 2 || mailer.sent[1].subject != "Withdrawal summary for 2026-01-02" {
+        t.Fatalf("expected the next day's mail, got %+v", mailer.sent)
+    }
+}
+
+func TestDailySummaryRetriesAfterFailure(t *testing.T) {
+    mailer := &mockMailer{err: errors.New("relay down")}
+    now := time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC)
+    d := &DailySummary{
+        Stats:    &fakeStats{},
+        Mailer:   mailer,
+        SendHour: 8,
+        Logger:   log.New(io.Discard, "", 0),
+        now:      func() time.Time { return now },
+    }
+
+    d.tick(context.Background())
+    mailer.err = nil
+    d.tick(context.Background())
+
+    if len(mailer.sent) != 1 || !strings.Contains(mailer.sent[0].body, "No confirmed withdrawals.") {
+        t.Fatalf("expected a retried mail with an empty summary, got %+v", mailer.sent)
+    }
+}
+
+func TestDailySummarySentByAnotherReplica(t *testing.T) {
+    day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    sent := &fakeSentLog{sent: map[time.Time]bool{day: true}}
+    mailer := &mockMailer{}
+    now := time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC)
+    newSummary := func() *DailySummary {
+        return &DailySummary{
+            Stats:    &fakeStats{},
+            Mailer:   mailer,
+            Sent:     sent,
+            SendHour: 8,
+            Logger:   log.New(io.Discard, "", 0),
+            now:      func() time.Time { return now },
+        }
+    }
+
+    newSummary().tick(context.Background())
+    if len(mailer.sent) != 0 {
+        t.Fatalf("expected no mail for a day already sent, got %+v", mailer.sent)
+    }
+
+    now = now.AddDate(0, 0, 1)
+    first, second := newSummary(), newSummary()
+    first.tick(context.Background())
+    second.tick(context.Background())
+    if len(mailer.sent) != 1 {
+        t.Fatalf("expected one mail between the replicas, got %+v", mailer.sent)
+    }
+}
+
+func TestSMTPMailer(t *testing.T) {
+    m := NewSMTPMailer("smtp.example.com", "25", "noreply@example.com")
+    var gotAddr, gotFrom string
+    var gotTo []string
+    var gotMsg []byte
+    m.sendMail = func(_ context.Context, addr, from string, to []string, msg []byte) error {
+        gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, msg
+        return nil
+    }
+
+    if err := m.SendSummary(context.Background(), "a@example.com, b@example.com", "Summary", "line 1\nline 2\n"); err != nil {
+        t.Fatalf("send: %v", err)
+    }
+    if gotAddr != "smtp.example.com:25" || gotFrom != "noreply@example.com" || len(gotTo) != 2 || gotTo[1] != "b@example.com" {
+        t.Fatalf("unexpected envelope: %s %s %v", gotAddr, gotFrom, gotTo)
+    }
+    msg := string(gotMsg)
+    if !strings.Contains(msg, "Subject: Summary\r\n") || !strings.HasSuffix(msg, "\r\n\r\nline 1\r\nline 2\r\n") {
+        t.Fatalf("unexpected message: %q", msg)
+    }
+
+    if err := m.SendSummary(context.Background(), " , ", "Summary", "body"); err == nil {
+        t.Fatalf("expected error without recipients")
+    }
+}
+
+func TestSMTPMailerStopsWithContext(t *testing.T) {
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatalf("listen: %v", err)
+    }
+    defer ln.Close()
+    go func() {
+        // Accept without ever greeting, like a relay that hangs.
+        conn, err := ln.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        _, _ = io.Copy(io.Discard, conn)
+    }()
+
+    host, port, _ := net.SplitHostPort(ln.Addr().String())
+    m := NewSMTPMailer(host, port, "noreply@example.com")
+    ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+    defer cancel()
+
+    done := make(chan error, 1)
+    go func() {
+        done <- m.SendSummary(ctx, "a@example.com", "Summary", "body")
+    }()
+    select {
+    case err := <-done:
+        if !errors.Is(err, context.DeadlineExceeded) {
+            t.Fatalf("expected the context deadline, got %v", err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatalf("send did not stop with its context")
+    }
+}
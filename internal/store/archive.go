@@ -0,0 +1,81 @@
+package store
+
+import (
+    "context"
+    "fmt"
+)
+
+const ledgerEntryColumns = "id, user_id, withdrawal_id, amount, currency, direction, reason, created_at"
+
+// allLedgerEntries reads live and archived entries as one table, for the
+// readers whose answer must not change when ArchiveLedgerEntries runs.
+const allLedgerEntries = `(
+    SELECT ` + ledgerEntryColumns + ` FROM ledger_entries
+    UNION ALL
+    SELECT ` + ledgerEntryColumns + ` FROM ledger_entries_archive
+)`
+
+// ArchiveLedgerEntries moves ledger entries created more than olderThanDays
+// days ago to ledger_entries_archive and returns how many were moved;
+// olderThanDays must be at least 1. Only settled history moves: entries
+// without a withdrawal, and the entries of a withdrawal in a terminal status
+// once all of them are past the cutoff, so a withdrawal's entries always
+// move together and a live withdrawal keeps its debit, credit and
+// settlement in ledger_entries, where their unique indexes see them.
+// Archived entries keep their ids and still count in the withdrawal's ledger,
+// the totals and the summaries; only the admin export lists live entries
+// alone.
+func (s *Store) ArchiveLedgerEntries(ctx context.Context, olderThanDays int) (int64, error) {
+    if olderThanDays < 1 {
+        return 0, fmt.Errorf("%w: older than %d days, at least 1", ErrInvalidRetention, olderThanDays)
+    }
+
+    var terminal []string
+    for _, status := range Statuses {
+        if IsTerminal(status) {
+            terminal = append(terminal, status)
+        }
+    }
+
+    var archived int64
+    now := s.now()
+    err := s.withAuditTx(ctx, func(q querier) error {
+        // One statement, so the copy and the delete see the same rows: an
+        // old entry committed meanwhile waits for the next run rather than
+        // being deleted uncopied.
+        tag, err := q.Exec(ctx, `
+            WITH archived AS (
+                INSERT INTO ledger_entries_archive (`+ledgerEntryColumns+`)
+                SELECT `+ledgerEntryColumns+`
+                FROM ledger_entries e
+                WHERE e.created_at < $1::timestamptz - INTERVAL '1 day' * $2
+                  AND (
+                      e.withdrawal_id IS NULL
+                      OR (
+                          EXISTS (
+                              SELECT 1 FROM withdrawals w
+                              WHERE w.id = e.withdrawal_id AND w.status = ANY($3)
+                          )
+                          AND NOT EXISTS (
+                              SELECT 1 FROM ledger_entries n
+                              WHERE n.withdrawal_id = e.withdrawal_id
+                                AND n.created_at >= $1::timestamptz - INTERVAL '1 day' * $2
+                          )
+                      )
+                  )
+                RETURNING id
+            )
+            DELETE FROM ledger_entries
+            WHERE id IN (SELECT id FROM archived)
+        `, now, olderThanDays, terminal)
+        if err != nil {
+            return err
+        }
+        archived = tag.RowsAffected()
+        return recordAudit(ctx, q, archived, now)
+    })
+    if err != nil {
+        return 0, err
+    }
+    return archived, nil
+}
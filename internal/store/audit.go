@@ -0,0 +1,291 @@
+package store
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// AuditEntry records one mutating API call. The entries of each resource,
+// identified by ResourceType and ResourceID, form a hash chain: Hash covers
+// the entry's fields and PrevHash, the Hash of the resource's entry before
+// it, so editing or deleting a row breaks every later hash of the resource
+// (see VerifyAuditLog).
+type AuditEntry struct {
+    ID           int64
+    Actor        string
+    Action       string
+    ResourceType string
+    ResourceID   string
+    RequestID    string
+    Summary      map[string]any
+    CreatedAt    time.Time
+    PrevHash     string
+    Hash         string
+}
+
+type AuditFilter struct {
+    Actor        string
+    ResourceType string
+    ResourceID   string
+    From         *time.Time
+    To           *time.Time
+    Limit        int
+}
+
+const auditColumns = "id, actor, action, resource_type, resource_id, request_id, summary, created_at, prev_hash, hash"
+
+// auditLockKey, together with a hash of the resource, serializes the audit
+// writers of one resource so each entry chains to its latest. Writers of
+// different resources do not wait for each other.
+const auditLockKey = 7_215_001
+
+type auditKey struct{}
+
+// auditFunc builds the audit entry for the result of an operation, reporting
+// false for a result of another type.
+type auditFunc func(result any) (AuditEntry, bool)
+
+// WithAudit makes the operation run with the returned context record the
+// entry build returns for what it changed, in the operation's transaction:
+// the entry is committed with the change, and an entry that cannot be
+// written rolls the change back. Operations that change nothing, such as a
+// replayed create, record nothing. T is the operation's result, e.g. User
+// for CreateUser and UpdateUserTier, Withdrawal for the withdrawal
+// operations, []User for the batch creates, the address for the blacklist,
+// the number of entries moved for ArchiveLedgerEntries and the new mode for
+// SetMaintenance.
+func WithAudit[T any](ctx context.Context, build func(T) AuditEntry) context.Context {
+    return context.WithValue(ctx, auditKey{}, auditFunc(func(result any) (AuditEntry, bool) {
+        r, ok := result.(T)
+        if !ok {
+            return AuditEntry{}, false
+        }
+        return build(r), true
+    }))
+}
+
+func auditing(ctx context.Context) bool {
+    _, ok := ctx.Value(auditKey{}).(auditFunc)
+    return ok
+}
+
+// withAuditTx runs fn, an operation that needs no transaction of its own, on
+// the pool, or in a transaction when ctx records an audit entry.
+func (s *Store) withAuditTx(ctx context.Context, fn func(q querier) error) error {
+    if !auditing(ctx) {
+        return fn(s.pool)
+    }
+    return s.WithTx(ctx, func(tx pgx.Tx) error {
+        return fn(tx)
+    })
+}
+
+// recordAudit writes the audit entry ctx builds for result within the
+// operation's transaction q, if ctx records one.
+func recordAudit(ctx context.Context, q querier, result any, now time.Time) error {
+    build, ok := ctx.Value(auditKey{}).(auditFunc)
+    if !ok {
+        return nil
+    }
+    e, ok := build(result)
+    if !ok {
+        return fmt.Errorf("audit entry for a %T result", result)
+    }
+    _, err := insertAuditEntry(ctx, q, e, now)
+    return err
+}
+
+func scanAuditEntry(row pgx.Row) (AuditEntry, error) {
+    var e AuditEntry
+    var summary string
+    err := row.Scan(
+        &e.ID,
+        &e.Actor,
+        &e.Action,
+        &e.ResourceType,
+        &e.ResourceID,
+        &e.RequestID,
+        &summary,
+        &e.CreatedAt,
+        &e.PrevHash,
+        &e.Hash,
+    )
+    if err != nil {
+        return AuditEntry{}, err
+    }
+    if err := json.Unmarshal([]byte(summary), &e.Summary); err != nil {
+        return AuditEntry{}, fmt.Errorf("decode audit summary %d: %w", e.ID, err)
+    }
+    return e, nil
+}
+
+// InsertAuditEntry appends e to the audit log in its own transaction, for
+// calls that change nothing in the database. Operations record their entries
+// through WithAudit instead.
+func (s *Store) InsertAuditEntry(ctx context.Context, e AuditEntry) (AuditEntry, error) {
+    tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+    if err != nil {
+        return AuditEntry{}, err
+    }
+    defer func() {
+        _ = tx.Rollback(ctx)
+    }()
+
+    inserted, err := insertAuditEntry(ctx, tx, e, s.now())
+    if err != nil {
+        return AuditEntry{}, err
+    }
+    if err := tx.Commit(ctx); err != nil {
+        return AuditEntry{}, err
+    }
+    return inserted, nil
+}
+
+// insertAuditEntry appends e at now within the transaction q, chained to the
+// latest entry of its resource. now must already be truncated to
+// microseconds, as Store.now does, so the hash can be recomputed from the
+// stored row.
+func insertAuditEntry(ctx context.Context, q querier, e AuditEntry, now time.Time) (AuditEntry, error) {
+    if _, err := q.Exec(ctx, "SELECT pg_advisory_xact_lock($1, hashtext($2))", auditLockKey, e.ResourceType+":"+e.ResourceID); err != nil {
+        return AuditEntry{}, err
+    }
+    err := q.QueryRow(ctx, `
+        SELECT hash FROM audit_log
+        WHERE resource_type = $1 AND resource_id = $2
+        ORDER BY id DESC
+        LIMIT 1
+    `, e.ResourceType, e.ResourceID).Scan(&e.PrevHash)
+    if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+        return AuditEntry{}, err
+    }
+
+    if e.Summary == nil {
+        e.Summary = map[string]any{}
+    }
+    summary, err := json.Marshal(e.Summary)
+    if err != nil {
+        return AuditEntry{}, err
+    }
+    e.CreatedAt = now
+    e.Hash = auditHash(e, summary)
+
+    return scanAuditEntry(q.QueryRow(ctx, `
+        INSERT INTO audit_log (actor, action, resource_type, resource_id, request_id, summary, created_at, prev_hash, hash)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        RETURNING `+auditColumns,
+        e.Actor, e.Action, e.ResourceType, e.ResourceID, e.RequestID, string(summary), e.CreatedAt, e.PrevHash, e.Hash,
+    ))
+}
+
+func auditHash(e AuditEntry, summary []byte) string {
+    h := sha256.New()
+    for _, field := range []string{
+        e.PrevHash,
+        e.Actor,
+        e.Action,
+        e.ResourceType,
+        e.ResourceID,
+        e.RequestID,
+        string(summary),
+        e.CreatedAt.UTC().Format(time.RFC3339Nano),
+    } {
+        fmt.Fprintf(h, "%d:%s\n", len(field), field)
+    }
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+// ListAuditEntries returns matching entries, newest first.
+func (s *Store) ListAuditEntries(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+    if f.Limit < 0 || f.Limit > MaxListLimit {
+        return nil, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxListLimit)
+    }
+
+    var conds []string
+    var args []any
+    add := func(cond string, arg any) {
+        args = append(args, arg)
+        conds = append(conds, fmt.Sprintf(cond, len(args)))
+    }
+    if f.Actor != "" {
+        add("actor = $%d", f.Actor)
+    }
+    if f.ResourceType != "" {
+        add("resource_type = $%d", f.ResourceType)
+    }
+    if f.ResourceID != "" {
+        add("resource_id = $%d", f.ResourceID)
+    }
+    if f.From != nil {
+        add("created_at >= $%d", *f.From)
+    }
+    if f.To != nil {
+        add("created_at < $%d", *f.To)
+    }
+
+    query := "SELECT " + auditColumns + " FROM audit_log"
+    if len(conds) > 0 {
+        query += " WHERE " + strings.Join(conds, " AND ")
+    }
+    limit := f.Limit
+    if limit == 0 {
+        limit = DefaultListLimit
+    }
+    args = append(args, limit)
+    query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+    rows, err := s.pool.Query(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    entries := []AuditEntry{}
+    for rows.Next() {
+        e, err := scanAuditEntry(rows)
+        if err != nil {
+            return nil, err
+        }
+        entries = append(entries, e)
+    }
+    return entries, rows.Err()
+}
+
+// VerifyAuditLog walks the chain of every resource and returns the id of the
+// first entry whose hash does not match, or 0 if the log is intact.
+func (s *Store) VerifyAuditLog(ctx context.Context) (int64, error) {
+    rows, err := s.pool.Query(ctx, `
+        SELECT id, actor, action, resource_type, resource_id, request_id, summary, created_at, prev_hash, hash
+        FROM audit_log
+        ORDER BY resource_type, resource_id, id
+    `)
+    if err != nil {
+        return 0, err
+    }
+    defer rows.Close()
+
+    var broken int64
+    var resourceType, resourceID, prev string
+    for rows.Next() {
+        var e AuditEntry
+        var summary string
+        if err := rows.Scan(&e.ID, &e.Actor, &e.Action, &e.ResourceType, &e.ResourceID, &e.RequestID, &summary, &e.CreatedAt, &e.PrevHash, &e.Hash); err != nil {
+            return 0, err
+        }
+        if e.ResourceType != resourceType || e.ResourceID != resourceID {
+            resourceType, resourceID, prev = e.ResourceType, e.ResourceID, ""
+        }
+        if (e.PrevHash != prev || auditHash(e, []byte(summary)) != e.Hash) && (broken == 0 || e.ID < broken) {
+            broken = e.ID
+        }
+        prev = e.Hash
+    }
+    return broken, rows.Err()
+}
@@ -0,0 +1,297 @@
+package store
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
+)
+
+// withdrawalInsertColumns are the parameters of each VALUES tuple
+// CreateWithdrawalBatch inserts; the last one sets both created_at and
+// updated_at.
+const withdrawalInsertColumns = 11
+
+// MaxWithdrawalBatch bounds CreateWithdrawalBatch, keeping the INSERT well
+// under the 65535 parameters a statement can bind.
+const MaxWithdrawalBatch = 1000
+
+// CreateWithdrawalBatch creates every withdrawal in inputs or none, in one
+// transaction and a fixed number of round trips regardless of its size. The
+// checks match CreateWithdrawal, applied to each user's items in input
+// order: the first item that fails rolls the batch back and is reported as a
+// *BatchItemError; an input NormalizeWithdrawalInput rejects fails before
+// the transaction starts. Unlike CreateWithdrawal it does not replay: an idempotency key that
+// is already used, or repeated within the batch, fails its item with
+// ErrIdempotencyConflict. An audit sink adds a round trip per
+// withdrawal to record it.
+func (s *Store) CreateWithdrawalBatch(ctx context.Context, inputs []CreateWithdrawalInput) (created []Withdrawal, err error) {
+    ctx, span := tracer.Start(ctx, "store.CreateWithdrawalBatch", trace.WithAttributes(
+        attribute.Int("batch_size", len(inputs)),
+    ))
+    defer func() {
+        endSpan(span, err)
+    }()
+
+    if len(inputs) == 0 {
+        return []Withdrawal{}, nil
+    }
+    if len(inputs) > MaxWithdrawalBatch {
+        return nil, fmt.Errorf("%w: %d withdrawals, at most %d", ErrBatchTooLarge, len(inputs), MaxWithdrawalBatch)
+    }
+    normalized := make([]CreateWithdrawalInput, len(inputs))
+    for i, input := range inputs {
+        if normalized[i], err = s.NormalizeWithdrawalInput(input); err != nil {
+            return nil, &BatchItemError{Index: i, Err: err}
+        }
+    }
+    inputs = normalized
+    err = s.WithTx(ctx, func(tx pgx.Tx) error {
+        var err error
+        created, err = s.createWithdrawalBatchTx(ctx, tx, inputs)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+    return created, nil
+}
+
+func (s *Store) createWithdrawalBatchTx(ctx context.Context, tx pgx.Tx, inputs []CreateWithdrawalInput) ([]Withdrawal, error) {
+    now := s.now()
+    userIDs := make([]int64, 0, len(inputs))
+    seenUsers := map[int64]bool{}
+    keys := make([]string, len(inputs))
+    keyUsers := make([]int64, len(inputs))
+    destinations := make([]string, len(inputs))
+    for i, input := range inputs {
+        if !seenUsers[input.UserID] {
+            seenUsers[input.UserID] = true
+            userIDs = append(userIDs, input.UserID)
+        }
+        keys[i] = input.IdempotencyKey
+        keyUsers[i] = input.UserID
+        destinations[i] = input.Destination
+    }
+
+    // Users are locked in id order so concurrent batches cannot deadlock.
+    balances := make(map[int64]int64, len(userIDs))
+    overdrafts := make(map[int64]int64, len(userIDs))
+    tiers := make(map[int64]string, len(userIDs))
+    tenants := make(map[int64]TenantID, len(userIDs))
+    rows, err := tx.Query(ctx, "SELECT id, balance, overdraft_limit, tier, tenant_id FROM users WHERE id = ANY($1) ORDER BY id FOR UPDATE", userIDs)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var id, balance, overdraft int64
+        var tier string
+        var tenant TenantID
+        if err := rows.Scan(&id, &balance, &overdraft, &tier, &tenant); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        balances[id] = balance
+        overdrafts[id] = overdraft
+        tiers[id] = tier
+        tenants[id] = tenant
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    rows, err = tx.Query(ctx, `
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE (user_id, idempotency_key) IN (SELECT * FROM unnest($1::bigint[], $2::text[]))
+    `, keyUsers, keys)
+    if err != nil {
+        return nil, err
+    }
+    existing, err := collectWithdrawals(rows)
+    if err != nil {
+        return nil, err
+    }
+    used := make(map[batchKey]Withdrawal, len(existing))
+    for _, w := range existing {
+        used[batchKey{w.UserID, w.IdempotencyKey}] = w
+    }
+
+    blocked := map[string]bool{}
+    rows, err = tx.Query(ctx, "SELECT address FROM blacklisted_destinations WHERE address = ANY($1)", destinations)
+    if err != nil {
+        return nil, err
+    }
+    for rows.Next() {
+        var address string
+        if err := rows.Scan(&address); err != nil {
+            rows.Close()
+            return nil, err
+        }
+        blocked[address] = true
+    }
+    rows.Close()
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+
+    pending := map[int64]int{}
+    if s.maxPendingWithdrawals > 0 {
+        rows, err := tx.Query(ctx, `
+            SELECT user_id, COUNT(*)
+            FROM withdrawals
+            WHERE user_id = ANY($1) AND status = $2
+            GROUP BY user_id
+        `, userIDs, StatusPending)
+        if err != nil {
+            return nil, err
+        }
+        for rows.Next() {
+            var id int64
+            var n int
+            if err := rows.Scan(&id, &n); err != nil {
+                rows.Close()
+                return nil, err
+            }
+            pending[id] = n
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return nil, err
+        }
+    }
+
+    fees := make([]int64, len(inputs))
+    debits := map[int64]int64{}
+    for i, input := range inputs {
+        balance, ok := balances[input.UserID]
+        if !ok {
+            return nil, &BatchItemError{Index: i, Err: ErrUserNotFound}
+        }
+        if err := checkTenant(ctx, tenants[input.UserID]); err != nil {
+            return nil, &BatchItemError{Index: i, Err: err}
+        }
+        key := batchKey{input.UserID, input.IdempotencyKey}
+        if w, ok := used[key]; ok {
+            return nil, &BatchItemError{Index: i, Err: &IdempotencyConflictError{Existing: w}}
+        }
+        used[key] = Withdrawal{}
+        if blocked[input.Destination] {
+            return nil, &BatchItemError{Index: i, Err: ErrDestinationBlacklisted}
+        }
+
+        fees[i] = s.withdrawalFee(tiers[input.UserID], input.Currency, input.Amount)
+        remaining := balance - debits[input.UserID]
+        overdraft := overdrafts[input.UserID]
+        if available := remaining + overdraft; available < input.Amount || available-input.Amount < fees[i] {
+            return nil, &BatchItemError{Index: i, Err: &InsufficientBalanceError{Balance: remaining, Overdraft: overdraft, Requested: input.Amount + fees[i]}}
+        }
+        if s.maxPendingWithdrawals > 0 && pending[input.UserID] >= s.maxPendingWithdrawals {
+            return nil, &BatchItemError{Index: i, Err: ErrTooManyPending}
+        }
+        debits[input.UserID] += input.Amount + fees[i]
+        pending[input.UserID]++
+    }
+
+    created, err := insertWithdrawals(ctx, tx, inputs, fees, s.reservedUntil(now), tenants, now)
+    if err != nil {
+        return nil, err
+    }
+
+    debitIDs := make([]int64, 0, len(debits))
+    debitTotals := make([]int64, 0, len(debits))
+    for id, total := range debits {
+        debitIDs = append(debitIDs, id)
+        debitTotals = append(debitTotals, total)
+    }
+    _, err = tx.Exec(ctx, `
+        UPDATE users u SET balance = u.balance - d.total, updated_at = $3
+        FROM unnest($1::bigint[], $2::bigint[]) AS d(id, total)
+        WHERE u.id = d.id
+    `, debitIDs, debitTotals, now)
+    if err != nil {
+        return nil, err
+    }
+
+    var entryUsers, entryWithdrawals, entryAmounts []int64
+    var entryCurrencies, entryDirections []string
+    addEntry := func(w Withdrawal, amount int64, direction string) {
+        entryUsers = append(entryUsers, w.UserID)
+        entryWithdrawals = append(entryWithdrawals, w.ID)
+        entryAmounts = append(entryAmounts, amount)
+        entryCurrencies = append(entryCurrencies, w.Currency)
+        entryDirections = append(entryDirections, direction)
+    }
+    for _, w := range created {
+        addEntry(w, w.Amount, DirectionDebit)
+        if w.Fee > 0 {
+            addEntry(w, w.Fee, DirectionFee)
+        }
+    }
+    _, err = tx.Exec(ctx, `
+        INSERT INTO ledger_entries (user_id, withdrawal_id, amount, currency, direction, created_at)
+        SELECT e.*, $6::timestamptz FROM unnest($1::bigint[], $2::bigint[], $3::bigint[], $4::text[], $5::text[]) AS e
+    `, entryUsers, entryWithdrawals, entryAmounts, entryCurrencies, entryDirections, now)
+    if err != nil {
+        return nil, err
+    }
+
+    for _, w := range created {
+        before := balances[w.UserID]
+        balances[w.UserID] = before - w.Amount - w.Fee
+        if err := s.recordBalanceChange(ctx, tx, OperationWithdrawalCreate, w, before, balances[w.UserID], now); err != nil {
+            return nil, err
+        }
+    }
+    return created, nil
+}
+
+type batchKey struct {
+    userID int64
+    key    string
+}
+
+// insertWithdrawals inserts all inputs with one multi-row INSERT and returns
+// the rows in input order. A key committed concurrently since it was checked
+// fails its item with ErrIdempotencyConflict.
+func insertWithdrawals(ctx context.Context, tx pgx.Tx, inputs []CreateWithdrawalInput, fees []int64, reservedUntil *time.Time, tenants map[int64]TenantID, now time.Time) ([]Withdrawal, error) {
+    values := make([]string, len(inputs))
+    args := make([]any, 0, len(inputs)*withdrawalInsertColumns)
+    for i, input := range inputs {
+        n := i * withdrawalInsertColumns
+        values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10, n+11, n+11)
+        args = append(args, input.UserID, input.Amount, fees[i], input.Currency, input.Destination, StatusPending, input.IdempotencyKey, reservedUntil, tenants[input.UserID], input.CreatedByKey, now)
+    }
+
+    rows, err := tx.Query(ctx, `
+        INSERT INTO withdrawals (user_id, amount, fee, currency, destination, status, idempotency_key, reserved_until, tenant_id, created_by_key, created_at, updated_at)
+        VALUES `+strings.Join(values, ", ")+`
+        ON CONFLICT (user_id, idempotency_key) DO NOTHING
+        RETURNING `+withdrawalColumns, args...)
+    if err != nil {
+        return nil, err
+    }
+    inserted, err := collectWithdrawals(rows)
+    if err != nil {
+        return nil, err
+    }
+
+    byKey := make(map[batchKey]Withdrawal, len(inserted))
+    for _, w := range inserted {
+        byKey[batchKey{w.UserID, w.IdempotencyKey}] = w
+    }
+    created := make([]Withdrawal, len(inputs))
+    for i, input := range inputs {
+        w, ok := byKey[batchKey{input.UserID, input.IdempotencyKey}]
+        if !ok {
+            return nil, &BatchItemError{Index: i, Err: ErrIdempotencyConflict}
+        }
+        created[i] = w
+    }
+    return created, nil
+}
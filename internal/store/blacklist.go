@@ -0,0 +1,59 @@
+package store
+
+import (
+    "context"
+)
+
+// IsDestinationBlacklisted reports whether withdrawals to destination are
+// blocked. The address must match exactly.
+func (s *Store) IsDestinationBlacklisted(ctx context.Context, destination string) (bool, error) {
+    return isDestinationBlacklisted(ctx, s.pool, destination)
+}
+
+func isDestinationBlacklisted(ctx context.Context, q querier, destination string) (bool, error) {
+    var blocked bool
+    err := q.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM blacklisted_destinations WHERE address = $1)", destination).Scan(&blocked)
+    return blocked, err
+}
+
+// BlacklistDestination blocks withdrawals to address. It reports whether
+// the address was added, false meaning it was already blocked. Withdrawals
+// created before are not affected.
+func (s *Store) BlacklistDestination(ctx context.Context, address string) (bool, error) {
+    var added bool
+    now := s.now()
+    err := s.withAuditTx(ctx, func(q querier) error {
+        tag, err := q.Exec(ctx, `
+            INSERT INTO blacklisted_destinations (address, created_at)
+            VALUES ($1, $2)
+            ON CONFLICT (address) DO NOTHING
+        `, address, now)
+        if err != nil {
+            return err
+        }
+        if added = tag.RowsAffected() == 1; !added {
+            return nil
+        }
+        return recordAudit(ctx, q, address, now)
+    })
+    if err != nil {
+        return false, err
+    }
+    return added, nil
+}
+
+// UnblacklistDestination allows withdrawals to address again. It returns
+// ErrNotFound when the address is not blocked.
+func (s *Store) UnblacklistDestination(ctx context.Context, address string) error {
+    now := s.now()
+    return s.withAuditTx(ctx, func(q querier) error {
+        tag, err := q.Exec(ctx, "DELETE FROM blacklisted_destinations WHERE address = $1", address)
+        if err != nil {
+            return err
+        }
+        if tag.RowsAffected() == 0 {
+            return ErrNotFound
+        }
+        return recordAudit(ctx, q, address, now)
+    })
+}
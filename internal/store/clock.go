@@ -0,0 +1,30 @@
+package store
+
+import "time"
+
+// Clock tells the store the current time. Every timestamp the store writes
+// or compares against comes from it and is passed to SQL as a parameter,
+// rather than read from the database's now(), so tests can move time
+// forward with a fake clock.
+type Clock interface {
+    Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// WithClock replaces the real clock. A nil clock is ignored.
+func WithClock(c Clock) Option {
+    return func(s *Store) {
+        if c != nil {
+            s.clock = c
+        }
+    }
+}
+
+// now is the current time as Postgres stores it: UTC, in microseconds, so a
+// written timestamp reads back equal to the value the store kept.
+func (s *Store) now() time.Time {
+    return s.clock.Now().UTC().Truncate(time.Microsecond)
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+    "context"
+    "strconv"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// DefaultCountCap is the number of rows a total count stops at unless
+// WithCountCap says otherwise.
+const DefaultCountCap = 10000
+
+// WithCountCap bounds the total counts returned with list pages. Counting
+// stops once n rows match; zero counts every row.
+func WithCountCap(n int64) Option {
+    return func(s *Store) {
+        s.countCap = n
+    }
+}
+
+// Total is the number of withdrawals matching a list filter across all
+// pages. When Capped is set counting stopped at the store's cap and Count is
+// a lower bound.
+type Total struct {
+    Count  int64
+    Capped bool
+}
+
+// withdrawalPage is a page query together with the filter conditions it was
+// built from, without the cursor, so the same rows can be counted.
+type withdrawalPage struct {
+    sql       string
+    args      []any
+    where     string
+    whereArgs []any
+}
+
+// listWithTotal runs the page query and a count of every row matching the
+// filter in one read-only repeatable-read transaction, so both see the same
+// snapshot.
+func (s *Store) listWithTotal(ctx context.Context, p withdrawalPage) ([]Withdrawal, Total, error) {
+    tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+    if err != nil {
+        return nil, Total{}, err
+    }
+    defer func() {
+        _ = tx.Rollback(ctx)
+    }()
+
+    rows, err := tx.Query(ctx, p.sql, p.args...)
+    if err != nil {
+        return nil, Total{}, err
+    }
+    withdrawals, err := collectWithdrawals(rows)
+    if err != nil {
+        return nil, Total{}, err
+    }
+
+    query := "SELECT 1 FROM withdrawals"
+    if p.where != "" {
+        query += " WHERE " + p.where
+    }
+    args := p.whereArgs
+    if s.countCap > 0 {
+        // Counting one row past the cap tells a capped total from an exact one.
+        args = append(append([]any{}, args...), s.countCap+1)
+        query += " LIMIT $" + strconv.Itoa(len(args))
+    }
+    var total Total
+    if err := tx.QueryRow(ctx, "SELECT COUNT(*) FROM ("+query+") matched", args...).Scan(&total.Count); err != nil {
+        return nil, Total{}, err
+    }
+    if s.countCap > 0 && total.Count > s.countCap {
+        total = Total{Count: s.countCap, Capped: true}
+    }
+    return withdrawals, total, tx.Commit(ctx)
+}
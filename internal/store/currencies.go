@@ -0,0 +1,69 @@
+package store
+
+import (
+    "context"
+    "errors"
+    "slices"
+    "strings"
+)
+
+// WithSupportedCurrencies limits withdrawals to the given currency codes,
+// which are compared case-insensitively and stored uppercase. Without it only
+// BalanceCurrency is supported. The database accepts only the codes in its
+// currencies table, which RegisterCurrencies fills.
+func WithSupportedCurrencies(codes ...string) Option {
+    return func(s *Store) {
+        s.supportedCurrencies = nil
+        for _, code := range codes {
+            code = strings.ToUpper(strings.TrimSpace(code))
+            if code != "" && !slices.Contains(s.supportedCurrencies, code) {
+                s.supportedCurrencies = append(s.supportedCurrencies, code)
+            }
+        }
+    }
+}
+
+// SupportedCurrencies returns the codes withdrawals may be made in, in the
+// order they were configured.
+func (s *Store) SupportedCurrencies() []string {
+    return slices.Clone(s.supportedCurrencies)
+}
+
+// SupportedCurrency returns code in its stored, uppercase form and whether
+// withdrawals may be made in it. Everything that accepts a currency checks it
+// here.
+func (s *Store) SupportedCurrency(code string) (string, bool) {
+    code = strings.ToUpper(code)
+    return code, slices.Contains(s.supportedCurrencies, code)
+}
+
+// checkSupportedCurrency adds an unsupported currency to the fields err
+// rejects in's. A currency already rejected for its format is left alone.
+func (s *Store) checkSupportedCurrency(in CreateWithdrawalInput, err error) error {
+    if checkCurrency(in.Currency) != "" {
+        return err
+    }
+    if _, ok := s.SupportedCurrency(in.Currency); ok {
+        return err
+    }
+    unsupported := FieldError{Field: "currency", Reason: ReasonUnsupported}
+    var invalid *InvalidInputError
+    if errors.As(err, &invalid) {
+        invalid.Fields = append(invalid.Fields, unsupported)
+        return err
+    }
+    return &InvalidInputError{Fields: []FieldError{unsupported}}
+}
+
+// RegisterCurrencies adds the supported currencies to the currencies table,
+// which withdrawals and ledger entries reference, so the database accepts
+// them too. Codes no longer supported stay in the table for the rows that
+// use them.
+func (s *Store) RegisterCurrencies(ctx context.Context) error {
+    _, err := s.pool.Exec(ctx, `
+        INSERT INTO currencies (code)
+        SELECT unnest($1::text[])
+        ON CONFLICT (code) DO NOTHING
+    `, s.supportedCurrencies)
+    return err
+}
@@ -1,12 +1,104 @@
 package store
 
-import "errors"
+import (
+    "errors"
+    "fmt"
+)
 
 var (
-    ErrInsufficientBalance = errors.New("insufficient balance")
-    ErrIdempotencyConflict = errors.New("idempotency conflict")
-    ErrNotFound            = errors.New("not found")
-    ErrUserNotFound        = errors.New("user not found")
-    ErrUserExists          = errors.New("user exists")
-    ErrInvalidStatus       = errors.New("invalid status")
+    ErrInsufficientBalance    = errors.New("insufficient balance")
+    ErrIdempotencyConflict    = errors.New("idempotency conflict")
+    ErrNotFound               = errors.New("not found")
+    ErrUserNotFound           = errors.New("user not found")
+    ErrUserExists             = errors.New("user exists")
+    ErrInvalidStatus          = errors.New("invalid status")
+    ErrTooManyPending         = errors.New("too many pending withdrawals")
+    ErrReservationExpired     = errors.New("reservation expired")
+    ErrSchemaMissing          = errors.New("schema missing")
+    ErrInvalidTier            = errors.New("invalid tier")
+    ErrInvalidFilter          = errors.New("invalid filter")
+    ErrExternalIDExists       = errors.New("external id exists")
+    ErrBatchTooLarge          = errors.New("batch too large")
+    ErrTenantMismatch         = errors.New("resource belongs to another tenant")
+    ErrAlreadyRefunded        = errors.New("withdrawal already refunded")
+    ErrVersionConflict        = errors.New("version conflict")
+    ErrDestinationBlacklisted = errors.New("destination blacklisted")
+    ErrOriginKeyMismatch      = errors.New("withdrawal was created by another key")
+    ErrInvalidOverdraftLimit  = errors.New("invalid overdraft limit")
+    ErrOverdraftInUse         = errors.New("balance is below the new overdraft limit")
+    ErrInvalidTxHash          = errors.New("invalid transaction hash")
+    ErrTxHashConflict         = errors.New("withdrawal has another transaction hash")
+    ErrInvalidInput           = errors.New("invalid input")
+    ErrInvalidRetention       = errors.New("invalid retention")
 )
+
+// InsufficientBalanceError is returned when the balance, plus the user's
+// overdraft limit, does not cover the amount plus fee. It matches
+// ErrInsufficientBalance.
+type InsufficientBalanceError struct {
+    Balance   int64
+    Overdraft int64
+    Requested int64
+}
+
+func (e *InsufficientBalanceError) Error() string {
+    if e.Overdraft > 0 {
+        return fmt.Sprintf("%v: balance %d with overdraft %d is less than requested %d", ErrInsufficientBalance, e.Balance, e.Overdraft, e.Requested)
+    }
+    return fmt.Sprintf("%v: balance %d is less than requested %d", ErrInsufficientBalance, e.Balance, e.Requested)
+}
+
+func (e *InsufficientBalanceError) Unwrap() error {
+    return ErrInsufficientBalance
+}
+
+// Shortfall is how much the balance would have to grow for the withdrawal to
+// succeed.
+func (e *InsufficientBalanceError) Shortfall() int64 {
+    return e.Requested - e.Balance - e.Overdraft
+}
+
+// IdempotencyConflictError is returned when an idempotency key was already
+// used with a different payload. Existing carries the key as stored, in
+// canonical form under WithCanonicalIdempotencyKeys. It matches
+// ErrIdempotencyConflict.
+type IdempotencyConflictError struct {
+    Existing Withdrawal
+}
+
+func (e *IdempotencyConflictError) Error() string {
+    return fmt.Sprintf("%v on key %q with withdrawal %d", ErrIdempotencyConflict, e.Existing.IdempotencyKey, e.Existing.ID)
+}
+
+func (e *IdempotencyConflictError) Unwrap() error {
+    return ErrIdempotencyConflict
+}
+
+// VersionConflictError is returned when a withdrawal was updated since the
+// version the caller expected. It matches ErrVersionConflict.
+type VersionConflictError struct {
+    Current Withdrawal
+}
+
+func (e *VersionConflictError) Error() string {
+    return fmt.Sprintf("%v: withdrawal %d is at version %d", ErrVersionConflict, e.Current.ID, e.Current.Version)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+    return ErrVersionConflict
+}
+
+// BatchItemError reports the item that failed an all-or-nothing batch. It
+// matches the item's own error.
+type BatchItemError struct {
+    Index int
+    Err   error
+}
+
+func (e *BatchItemError) Error() string {
+    return fmt.Sprintf("batch item %d: %v", e.Index, e.Err)
+}
+
+func (e *BatchItemError) Unwrap() error {
+    return e.Err
+}
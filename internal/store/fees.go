@@ -0,0 +1,86 @@
+package store
+
+import (
+    "fmt"
+    "math/bits"
+)
+
+type RoundingMode string
+
+const (
+    RoundFloor  RoundingMode = "floor"
+    RoundCeil   RoundingMode = "ceil"
+    RoundHalfUp RoundingMode = "half_up"
+)
+
+const basisPointsDenominator = 10000
+
+// FeePolicy is a percentage fee in basis points, rounded to whole minor units.
+type FeePolicy struct {
+    BasisPoints int64
+    Rounding    RoundingMode
+}
+
+func (p FeePolicy) Validate() error {
+    if p.BasisPoints < 0 || p.BasisPoints > basisPointsDenominator {
+        return fmt.Errorf("fee basis points %d out of range", p.BasisPoints)
+    }
+    switch p.Rounding {
+    case RoundFloor, RoundCeil, RoundHalfUp:
+        return nil
+    default:
+        return fmt.Errorf("unknown rounding mode %q", p.Rounding)
+    }
+}
+
+// Fee returns the fee for amount minor units. The product is computed in 128
+// bits so large amounts cannot overflow.
+func (p FeePolicy) Fee(amount int64) int64 {
+    if amount <= 0 || p.BasisPoints <= 0 {
+        return 0
+    }
+    hi, lo := bits.Mul64(uint64(amount), uint64(p.BasisPoints))
+    quo, rem := bits.Div64(hi, lo, basisPointsDenominator)
+    fee := int64(quo)
+
+    switch p.Rounding {
+    case RoundCeil:
+        if rem > 0 {
+            fee++
+        }
+    case RoundHalfUp:
+        if rem*2 >= basisPointsDenominator {
+            fee++
+        }
+    }
+    return fee
+}
+
+// WithFeePolicies sets the withdrawal fee per currency code. Currencies
+// without a policy are charged no fee.
+func WithFeePolicies(policies map[string]FeePolicy) Option {
+    return func(s *Store) {
+        s.feePolicies = policies
+    }
+}
+
+// WithFeeExemptTiers waives the withdrawal fee for users in tiers.
+func WithFeeExemptTiers(tiers ...string) Option {
+    return func(s *Store) {
+        s.feeExemptTiers = make(map[string]bool, len(tiers))
+        for _, t := range tiers {
+            s.feeExemptTiers[t] = true
+        }
+    }
+}
+
+func (s *Store) withdrawalFee(tier, currency string, amount int64) int64 {
+    if s.feeExemptTiers[tier] {
+        return 0
+    }
+    policy, ok := s.feePolicies[currency]
+    if !ok {
+        return 0
+    }
+    return policy.Fee(amount)
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+    "math"
+    "testing"
+)
+
+func TestFeePolicyRounding(t *testing.T) {
+    tests := []struct {
+        name     string
+        policy   FeePolicy
+        amount   int64
+        expected int64
+    }{
+        {name: "floor one unit", policy: FeePolicy{BasisPoints: 50, Rounding: RoundFloor}, amount: 1, expected: 0},
+        {name: "ceil one unit", policy: FeePolicy{BasisPoints: 50, Rounding: RoundCeil}, amount: 1, expected: 1},
+        {name: "half up one unit", policy: FeePolicy{BasisPoints: 50, Rounding: RoundHalfUp}, amount: 1, expected: 0},
+        {name: "floor exact half", policy: FeePolicy{BasisPoints: 50, Rounding: RoundFloor}, amount: 100, expected: 0},
+        {name: "ceil exact half", policy: FeePolicy{BasisPoints: 50, Rounding: RoundCeil}, amount: 100, expected: 1},
+        {name: "half up exact half", policy: FeePolicy{BasisPoints: 50, Rounding: RoundHalfUp}, amount: 100, expected: 1},
+        {name: "half up below half", policy: FeePolicy{BasisPoints: 50, Rounding: RoundHalfUp}, amount: 99, expected: 0},
+        {name: "no remainder", policy: FeePolicy{BasisPoints: 50, Rounding: RoundCeil}, amount: 200, expected: 1},
+        {name: "zero rate", policy: FeePolicy{BasisPoints: 0, Rounding: RoundCeil}, amount: 1000, expected: 0},
+        {name: "large amount", policy: FeePolicy{BasisPoints: 10000, Rounding: RoundFloor}, amount: math.MaxInt64, expected: math.MaxInt64},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := tt.policy.Fee(tt.amount); got != tt.expected {
+                t.Fatalf("expected fee %d, got %d", tt.expected, got)
+            }
+        })
+    }
+}
+
+func TestFeePolicyValidate(t *testing.T) {
+    if err := (FeePolicy{BasisPoints: 50, Rounding: RoundHalfUp}).Validate(); err != nil {
+        t.Fatalf("expected valid policy, got %v", err)
+    }
+    if err := (FeePolicy{BasisPoints: 10001, Rounding: RoundFloor}).Validate(); err == nil {
+        t.Fatalf("expected error for out of range basis points")
+    }
+    if err := (FeePolicy{BasisPoints: 50, Rounding: "bankers"}).Validate(); err == nil {
+        t.Fatalf("expected error for unknown rounding mode")
+    }
+}
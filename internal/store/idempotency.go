@@ -0,0 +1,114 @@
+package store
+
+import (
+    "context"
+    "errors"
+    "strings"
+
+    "github.com/jackc/pgx/v5"
+    "golang.org/x/text/unicode/norm"
+)
+
+// idempotencyScope is the key namespace of one operation type. Each scope
+// reads keys from its own table, unique on (user_id, idempotency_key), so the
+// same key reused for a different operation never replays the wrong record.
+type idempotencyScope struct {
+    table   string
+    columns string
+}
+
+var withdrawalScope = idempotencyScope{table: "withdrawals", columns: withdrawalColumns}
+
+// checkIdempotency looks up the record previously created under key. It must
+// run in the transaction that holds the user's row lock, so a concurrent
+// request with the same key cannot slip in between the check and the insert.
+// The row scans to pgx.ErrNoRows when the key is new; comparing payloads is
+// left to the caller.
+func checkIdempotency(ctx context.Context, tx pgx.Tx, scope idempotencyScope, userID int64, key string) pgx.Row {
+    return tx.QueryRow(ctx, `
+        SELECT `+scope.columns+`
+        FROM `+scope.table+`
+        WHERE user_id = $1 AND idempotency_key = $2
+    `, userID, key)
+}
+
+// withdrawalExistsByIdempotency reports whether a withdrawal was created
+// under key, without locking anything. Its answer can be stale by the time it
+// is used, so it only picks a path; the decision is made under the lock.
+func withdrawalExistsByIdempotency(ctx context.Context, q querier, userID int64, key string) (bool, error) {
+    var exists bool
+    err := q.QueryRow(ctx, `
+        SELECT EXISTS (SELECT 1 FROM withdrawals WHERE user_id = $1 AND idempotency_key = $2)
+    `, userID, key).Scan(&exists)
+    return exists, err
+}
+
+// WithCanonicalIdempotencyKeys stores and looks up withdrawal idempotency
+// keys in their canonical form, so keys a client derives from user input,
+// such as "Key-1" and "key-1", name the same withdrawal. Without it keys are
+// compared byte for byte. Rows created before it was turned on keep the key
+// they were stored with, and a key that is not canonical no longer finds
+// them.
+func WithCanonicalIdempotencyKeys(enabled bool) Option {
+    return func(s *Store) {
+        s.canonicalIdempotencyKeys = enabled
+    }
+}
+
+// CanonicalIdempotencyKey is key lowercased and in Unicode normalization
+// form C.
+func CanonicalIdempotencyKey(key string) string {
+    return norm.NFC.String(strings.ToLower(key))
+}
+
+// NormalizeWithdrawalInput is in.Normalize, with the idempotency key first
+// put in canonical form if WithCanonicalIdempotencyKeys is on, that also
+// rejects a currency that is not supported. Every method creating
+// withdrawals applies it; callers that validate input up front use it to see
+// the key that will be stored.
+func (s *Store) NormalizeWithdrawalInput(in CreateWithdrawalInput) (CreateWithdrawalInput, error) {
+    if s.canonicalIdempotencyKeys {
+        in.IdempotencyKey = CanonicalIdempotencyKey(in.IdempotencyKey)
+    }
+    in, err := in.Normalize()
+    return in, s.checkSupportedCurrency(in, err)
+}
+
+// NormalizeIdempotencyKey puts key in the form NormalizeWithdrawalInput
+// stores it in and checks it the same way, for callers looking a withdrawal
+// up by key. Violations are returned as an *InvalidInputError.
+func (s *Store) NormalizeIdempotencyKey(key string) (string, error) {
+    if s.canonicalIdempotencyKeys {
+        key = CanonicalIdempotencyKey(key)
+    }
+    key = strings.TrimSpace(key)
+    if reason := checkIdempotencyKey(key); reason != "" {
+        return key, &InvalidInputError{Fields: []FieldError{{Field: "idempotency_key", Reason: reason}}}
+    }
+    return key, nil
+}
+
+// GetWithdrawalByIdempotencyKey returns the user's withdrawal created under
+// key, which is trimmed and, with WithCanonicalIdempotencyKeys, put in
+// canonical form as on creation. It returns ErrNotFound when there is none.
+func (s *Store) GetWithdrawalByIdempotencyKey(ctx context.Context, userID int64, key string) (Withdrawal, error) {
+    key = strings.TrimSpace(key)
+    if s.canonicalIdempotencyKeys {
+        key = CanonicalIdempotencyKey(key)
+    }
+    w, err := scanWithdrawal(s.pool.QueryRow(ctx, `
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE user_id = $1 AND idempotency_key = $2
+    `, userID, key))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return Withdrawal{}, ErrNotFound
+        }
+        return Withdrawal{}, err
+    }
+    if err := checkTenant(ctx, w.TenantID); err != nil {
+        return Withdrawal{}, err
+    }
+    return w, nil
+}
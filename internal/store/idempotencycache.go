@@ -0,0 +1,100 @@
+package store
+
+import (
+    "context"
+    "sync"
+    "time"
+)
+
+// WithIdempotencyCache remembers up to size withdrawals by user and
+// idempotency key for ttl, so that retries of a create skip the locking
+// transaction. A replay still reads the withdrawal and balance once, since
+// both change after creation; a key reused with another payload is rejected
+// without touching the database. The cache is per process. Zero size or ttl
+// disables it.
+func WithIdempotencyCache(size int, ttl time.Duration) Option {
+    return func(s *Store) {
+        if size <= 0 || ttl <= 0 {
+            s.idempotencyCache = nil
+            return
+        }
+        s.idempotencyCache = newIdempotencyCache(size, ttl)
+    }
+}
+
+type idempotencyCacheKey struct {
+    userID int64
+    key    string
+}
+
+type idempotencyCacheEntry struct {
+    withdrawal Withdrawal
+    expiresAt  time.Time
+}
+
+// idempotencyCache is a bounded map evicting the oldest entry first. Only
+// the fields a withdrawal never changes after insert (id, owner, key, amount,
+// fee, currency, destination, tenant) may be relied upon from a cached
+// entry; withdrawals are never deleted, so an entry cannot point at a row
+// that no longer exists.
+type idempotencyCache struct {
+    size int
+    ttl  time.Duration
+
+    mu      sync.Mutex
+    entries map[idempotencyCacheKey]idempotencyCacheEntry
+    order   []idempotencyCacheKey
+}
+
+func newIdempotencyCache(size int, ttl time.Duration) *idempotencyCache {
+    return &idempotencyCache{
+        size:    size,
+        ttl:     ttl,
+        entries: make(map[idempotencyCacheKey]idempotencyCacheEntry, size),
+    }
+}
+
+func (c *idempotencyCache) get(userID int64, key string, now time.Time) (Withdrawal, bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    entry, ok := c.entries[idempotencyCacheKey{userID, key}]
+    if !ok || !now.Before(entry.expiresAt) {
+        return Withdrawal{}, false
+    }
+    return entry.withdrawal, true
+}
+
+// put stores w, which must be committed, at now.
+func (c *idempotencyCache) put(w Withdrawal, now time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    k := idempotencyCacheKey{w.UserID, w.IdempotencyKey}
+    if _, ok := c.entries[k]; !ok {
+        for len(c.order) >= c.size {
+            delete(c.entries, c.order[0])
+            c.order = c.order[1:]
+        }
+        c.order = append(c.order, k)
+    }
+    w.Replayed = false
+    c.entries[k] = idempotencyCacheEntry{withdrawal: w, expiresAt: now.Add(c.ttl)}
+}
+
+// replayCached answers a create whose key is in the cache. It matches the
+// transactional path: a different payload is an IdempotencyConflictError, and
+// a replay returns the withdrawal and balance as they are now.
+func (s *Store) replayCached(ctx context.Context, cached Withdrawal, input CreateWithdrawalInput) (CreateWithdrawalResult, error) {
+    if err := checkTenant(ctx, cached.TenantID); err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+    if !samePayload(cached, input) {
+        return CreateWithdrawalResult{}, &IdempotencyConflictError{Existing: cached}
+    }
+    current, err := s.GetWithdrawalWithUser(ctx, cached.ID)
+    if err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+    return replayWithdrawal(current.Withdrawal, input, current.UserBalance)
+}
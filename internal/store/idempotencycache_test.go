@@ -0,0 +1,41 @@
+package store
+
+import (
+    "testing"
+    "time"
+)
+
+func TestIdempotencyCache(t *testing.T) {
+    now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    c := newIdempotencyCache(2, time.Minute)
+
+    c.put(Withdrawal{ID: 1, UserID: 1, IdempotencyKey: "k1"}, now)
+    c.put(Withdrawal{ID: 2, UserID: 2, IdempotencyKey: "k1", Replayed: true}, now)
+
+    if w, ok := c.get(1, "k1", now); !ok || w.ID != 1 {
+        t.Fatalf("expected withdrawal 1, got %+v ok=%t", w, ok)
+    }
+    if w, ok := c.get(2, "k1", now); !ok || w.ID != 2 || w.Replayed {
+        t.Fatalf("expected withdrawal 2 without the replay flag, got %+v ok=%t", w, ok)
+    }
+    if _, ok := c.get(1, "k2", now); ok {
+        t.Fatal("expected a miss for an unknown key")
+    }
+
+    // Re-putting a key keeps its place; a third key evicts the oldest.
+    c.put(Withdrawal{ID: 1, UserID: 1, IdempotencyKey: "k1"}, now)
+    c.put(Withdrawal{ID: 3, UserID: 3, IdempotencyKey: "k1"}, now)
+    if _, ok := c.get(1, "k1", now); ok {
+        t.Fatal("expected the oldest entry to be evicted")
+    }
+    if _, ok := c.get(3, "k1", now); !ok {
+        t.Fatal("expected the newest entry to be cached")
+    }
+    if len(c.entries) != 2 || len(c.order) != 2 {
+        t.Fatalf("expected 2 entries, got %d (order %d)", len(c.entries), len(c.order))
+    }
+
+    if _, ok := c.get(3, "k1", now.Add(time.Minute)); ok {
+        t.Fatal("expected the entry to expire after the ttl")
+    }
+}
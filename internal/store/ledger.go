@@ -0,0 +1,402 @@
+package store
+
+import (
+    "context"
+    "encoding/base64"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// GetWithdrawalWithLedger returns the withdrawal and its ledger entries,
+// archived ones included, oldest first. Both statements are sent in one
+// batch, so it costs a single round trip.
+func (s *Store) GetWithdrawalWithLedger(ctx context.Context, id int64) (Withdrawal, []LedgerEntry, error) {
+    batch := &pgx.Batch{}
+    batch.Queue(`
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE id = $1
+    `, id)
+    batch.Queue(`
+        SELECT `+ledgerEntryColumns+`
+        FROM `+allLedgerEntries+` e
+        WHERE withdrawal_id = $1
+        ORDER BY id
+    `, id)
+
+    results := s.pool.SendBatch(ctx, batch)
+    defer results.Close()
+
+    w, err := scanWithdrawal(results.QueryRow())
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return Withdrawal{}, nil, ErrNotFound
+        }
+        return Withdrawal{}, nil, err
+    }
+    if err := checkTenant(ctx, w.TenantID); err != nil {
+        return Withdrawal{}, nil, err
+    }
+
+    rows, err := results.Query()
+    if err != nil {
+        return Withdrawal{}, nil, err
+    }
+    defer rows.Close()
+
+    entries := []LedgerEntry{}
+    for rows.Next() {
+        var e LedgerEntry
+        if err := rows.Scan(&e.ID, &e.UserID, &e.WithdrawalID, &e.Amount, &e.Currency, &e.Direction, &e.Reason, &e.CreatedAt); err != nil {
+            return Withdrawal{}, nil, err
+        }
+        entries = append(entries, e)
+    }
+    if err := rows.Err(); err != nil {
+        return Withdrawal{}, nil, err
+    }
+    return w, entries, nil
+}
+
+// SumLedgerByDirection returns the user's ledger totals, archived entries
+// included. Fee entries reduce the balance just like debits, so they are
+// counted in debitTotal; settlement entries move no money and are in neither
+// total.
+func (s *Store) SumLedgerByDirection(ctx context.Context, userID int64) (debitTotal, creditTotal int64, err error) {
+    if err := authorizeUser(ctx, s.pool, userID); err != nil {
+        return 0, 0, err
+    }
+    rows, err := s.pool.Query(ctx, `
+        SELECT direction, SUM(amount)
+        FROM `+allLedgerEntries+` e
+        WHERE user_id = $1
+        GROUP BY direction
+    `, userID)
+    if err != nil {
+        return 0, 0, err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var direction string
+        var sum int64
+        if err := rows.Scan(&direction, &sum); err != nil {
+            return 0, 0, err
+        }
+        switch direction {
+        case DirectionDebit, DirectionFee:
+            debitTotal += sum
+        case DirectionCredit:
+            creditTotal += sum
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return 0, 0, err
+    }
+    return debitTotal, creditTotal, nil
+}
+
+// LedgerSummaryFilter bounds the entries of a ledger summary by created_at
+// as [From, To). Both bounds are optional.
+type LedgerSummaryFilter struct {
+    From *time.Time
+    To   *time.Time
+}
+
+// LedgerSummary aggregates a user's ledger entries. Debits include fees, as
+// both reduce the balance; Net is Credits minus Debits. Count includes
+// settlement entries, which are in neither sum.
+type LedgerSummary struct {
+    Count   int64
+    Debits  int64
+    Credits int64
+    Net     int64
+}
+
+// GetLedgerSummary aggregates the user's ledger entries matching f, archived
+// ones included, in one query. An unknown user returns ErrUserNotFound; a
+// user without entries gets a zero summary.
+func (s *Store) GetLedgerSummary(ctx context.Context, userID int64, f LedgerSummaryFilter) (LedgerSummary, error) {
+    if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+        return LedgerSummary{}, fmt.Errorf("%w: from must be before to", ErrInvalidFilter)
+    }
+
+    var sum LedgerSummary
+    var owner TenantID
+    err := s.pool.QueryRow(ctx, `
+        SELECT u.tenant_id,
+               COUNT(e.id),
+               COALESCE(SUM(e.amount) FILTER (WHERE e.direction IN ($4, $5)), 0),
+               COALESCE(SUM(e.amount) FILTER (WHERE e.direction = $6), 0)
+        FROM users u
+        LEFT JOIN `+allLedgerEntries+` e ON e.user_id = u.id
+            AND ($2::timestamptz IS NULL OR e.created_at >= $2)
+            AND ($3::timestamptz IS NULL OR e.created_at < $3)
+        WHERE u.id = $1
+        GROUP BY u.tenant_id
+    `, userID, f.From, f.To, DirectionDebit, DirectionFee, DirectionCredit).Scan(&owner, &sum.Count, &sum.Debits, &sum.Credits)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return LedgerSummary{}, ErrUserNotFound
+        }
+        return LedgerSummary{}, err
+    }
+    if err := checkTenant(ctx, owner); err != nil {
+        return LedgerSummary{}, err
+    }
+    sum.Net = sum.Credits - sum.Debits
+    return sum, nil
+}
+
+// FeeSummary is what a user has paid in withdrawal fees in one currency.
+// Fees of expired withdrawals were credited back with the hold and are not
+// counted.
+type FeeSummary struct {
+    Currency string
+    Count    int64
+    Total    int64
+}
+
+// GetFeeSummary totals the user's fee ledger entries per currency, archived
+// ones included, ordered by currency. An unknown user returns ErrUserNotFound; a user who paid no fees
+// gets an empty list.
+func (s *Store) GetFeeSummary(ctx context.Context, userID int64) ([]FeeSummary, error) {
+    rows, err := s.pool.Query(ctx, `
+        SELECT u.tenant_id, e.currency, COUNT(e.id), COALESCE(SUM(e.amount), 0)
+        FROM users u
+        LEFT JOIN `+allLedgerEntries+` e ON e.user_id = u.id AND e.direction = $2
+            AND NOT EXISTS (SELECT 1 FROM withdrawals w WHERE w.id = e.withdrawal_id AND w.status = $3)
+        WHERE u.id = $1
+        GROUP BY u.tenant_id, e.currency
+        ORDER BY e.currency
+    `, userID, DirectionFee, StatusExpired)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    sums := []FeeSummary{}
+    found := false
+    var owner TenantID
+    for rows.Next() {
+        var currency *string
+        var sum FeeSummary
+        if err := rows.Scan(&owner, &currency, &sum.Count, &sum.Total); err != nil {
+            return nil, err
+        }
+        found = true
+        // A user without fees comes back as a single row with no currency.
+        if currency == nil {
+            continue
+        }
+        sum.Currency = *currency
+        sums = append(sums, sum)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    if !found {
+        return nil, ErrUserNotFound
+    }
+    if err := checkTenant(ctx, owner); err != nil {
+        return nil, err
+    }
+    return sums, nil
+}
+
+// LedgerFilter selects ledger entries across all users, oldest first. From
+// and To bound created_at as [From, To). Pages are keyed on (created_at, id):
+// pass the cursor of the last entry seen as After to fetch the next page.
+type LedgerFilter struct {
+    Direction    string
+    Currency     string
+    UserID       int64
+    WithdrawalID int64
+    From         *time.Time
+    To           *time.Time
+    After        *LedgerCursor
+    Limit        int
+}
+
+// LedgerCursor is the position of a ledger entry in created_at, id order.
+type LedgerCursor struct {
+    CreatedAt time.Time
+    ID        int64
+}
+
+// String encodes the cursor for use in URLs.
+func (c LedgerCursor) String() string {
+    raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+    return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseLedgerCursor decodes a cursor produced by LedgerCursor.String.
+func ParseLedgerCursor(s string) (LedgerCursor, error) {
+    invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidFilter)
+    raw, err := base64.RawURLEncoding.DecodeString(s)
+    if err != nil {
+        return LedgerCursor{}, invalid
+    }
+    micros, id, ok := strings.Cut(string(raw), ":")
+    if !ok {
+        return LedgerCursor{}, invalid
+    }
+    us, err := strconv.ParseInt(micros, 10, 64)
+    if err != nil {
+        return LedgerCursor{}, invalid
+    }
+    c := LedgerCursor{CreatedAt: time.UnixMicro(us).UTC()}
+    if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil || c.ID <= 0 {
+        return LedgerCursor{}, invalid
+    }
+    return c, nil
+}
+
+func (f LedgerFilter) Validate() error {
+    switch f.Direction {
+    case "", DirectionDebit, DirectionCredit, DirectionFee, DirectionSettlement:
+    default:
+        return fmt.Errorf("%w: direction %q", ErrInvalidFilter, f.Direction)
+    }
+    if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+        return fmt.Errorf("%w: from must be before to", ErrInvalidFilter)
+    }
+    if f.Limit < 0 || f.Limit > MaxListLimit {
+        return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxListLimit)
+    }
+    if f.UserID < 0 || f.WithdrawalID < 0 {
+        return fmt.Errorf("%w: ids must be positive", ErrInvalidFilter)
+    }
+    return nil
+}
+
+// query builds the entry query. A limit of zero selects every matching entry.
+func (f LedgerFilter) query(limit int) (string, []any) {
+    var conds []string
+    var args []any
+    add := func(cond string, arg any) {
+        args = append(args, arg)
+        conds = append(conds, fmt.Sprintf(cond, len(args)))
+    }
+    if f.Direction != "" {
+        add("direction = $%d", f.Direction)
+    }
+    if f.Currency != "" {
+        add("currency = $%d", f.Currency)
+    }
+    if f.UserID != 0 {
+        add("user_id = $%d", f.UserID)
+    }
+    if f.WithdrawalID != 0 {
+        add("withdrawal_id = $%d", f.WithdrawalID)
+    }
+    if f.From != nil {
+        add("created_at >= $%d", *f.From)
+    }
+    if f.To != nil {
+        add("created_at < $%d", *f.To)
+    }
+    if f.After != nil {
+        args = append(args, f.After.CreatedAt, f.After.ID)
+        conds = append(conds, fmt.Sprintf("(created_at, id) > ($%d, $%d)", len(args)-1, len(args)))
+    }
+
+    query := "SELECT id, user_id, COALESCE(withdrawal_id, 0), amount, currency, direction, reason, created_at FROM ledger_entries"
+    if len(conds) > 0 {
+        query += " WHERE " + strings.Join(conds, " AND ")
+    }
+    query += " ORDER BY created_at, id"
+    if limit > 0 {
+        args = append(args, limit)
+        query += fmt.Sprintf(" LIMIT $%d", len(args))
+    }
+    return query, args
+}
+
+// ListLedgerEntriesAdmin returns one page of ledger entries across all users.
+// Archived entries are not listed.
+func (s *Store) ListLedgerEntriesAdmin(ctx context.Context, f LedgerFilter) ([]LedgerEntry, error) {
+    if err := f.Validate(); err != nil {
+        return nil, err
+    }
+    limit := f.Limit
+    if limit == 0 {
+        limit = DefaultListLimit
+    }
+
+    entries := []LedgerEntry{}
+    err := s.queryLedgerEntries(ctx, f, limit, func(e LedgerEntry) error {
+        entries = append(entries, e)
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return entries, nil
+}
+
+// ledgerStreamBatch is how many entries StreamLedgerEntriesAdmin reads per
+// query.
+const ledgerStreamBatch = MaxListLimit
+
+// StreamLedgerEntriesAdmin calls fn for every ledger entry matching f, in
+// page order, ignoring f.Limit. Entries are read in keyset pages of
+// ledgerStreamBatch, and the connection goes back to the pool before fn sees
+// a page, so a slow consumer neither holds a connection nor has the whole
+// range in memory. Entries committed behind the last page read are included.
+func (s *Store) StreamLedgerEntriesAdmin(ctx context.Context, f LedgerFilter, fn func(LedgerEntry) error) error {
+    if err := f.Validate(); err != nil {
+        return err
+    }
+    page := make([]LedgerEntry, 0, ledgerStreamBatch)
+    for {
+        page = page[:0]
+        err := s.queryLedgerEntries(ctx, f, ledgerStreamBatch, func(e LedgerEntry) error {
+            page = append(page, e)
+            return nil
+        })
+        if err != nil {
+            return err
+        }
+        for _, e := range page {
+            if err := fn(e); err != nil {
+                return err
+            }
+        }
+        if len(page) < ledgerStreamBatch {
+            return nil
+        }
+        cursor := page[len(page)-1].Cursor()
+        f.After = &cursor
+    }
+}
+
+func (s *Store) queryLedgerEntries(ctx context.Context, f LedgerFilter, limit int, fn func(LedgerEntry) error) error {
+    query, args := f.query(limit)
+    rows, err := s.pool.Query(ctx, query, args...)
+    if err != nil {
+        return err
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var e LedgerEntry
+        if err := rows.Scan(&e.ID, &e.UserID, &e.WithdrawalID, &e.Amount, &e.Currency, &e.Direction, &e.Reason, &e.CreatedAt); err != nil {
+            return err
+        }
+        if err := fn(e); err != nil {
+            return err
+        }
+    }
+    return rows.Err()
+}
+
+// Cursor returns the position of e for LedgerFilter.After.
+func (e LedgerEntry) Cursor() LedgerCursor {
+    return LedgerCursor{CreatedAt: e.CreatedAt, ID: e.ID}
+}
@@ -0,0 +1,319 @@
+package store
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+const (
+    DirectionAsc  = "asc"
+    DirectionDesc = "desc"
+)
+
+const (
+    DefaultListLimit = 50
+    MaxListLimit     = 500
+)
+
+// listLimit returns limit, or DefaultListLimit for 0. Limits outside 1 to
+// MaxListLimit are ErrInvalidFilter.
+func listLimit(limit int) (int, error) {
+    if limit < 0 || limit > MaxListLimit {
+        return 0, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxListLimit)
+    }
+    if limit == 0 {
+        return DefaultListLimit, nil
+    }
+    return limit, nil
+}
+
+// ListWithdrawalsFilter selects a page of withdrawals. Pages are keyed on id
+// rather than offsets, so concurrent inserts never shift rows between pages:
+// iterate forward with Direction "asc" and After set to the last id seen, or
+// backward with Direction "desc" and Before set to the last id seen.
+//
+// Sort orders by one of SortKeys instead, with id as the tiebreaker; such
+// pages are keyed on (sort value, id) and continued with Cursor, taken from
+// EncodeSortCursor on the last row seen. Sort cannot be combined with After,
+// Before or Direction; SortBy builds it from a field and an order.
+//
+// MinAmount and MaxAmount bound amount inclusively when set; a zero bound is
+// a bound. The API's amount_gte and amount_lte query parameters are aliases
+// that land on MinAmount and MaxAmount.
+type ListWithdrawalsFilter struct {
+    UserID       int64
+    Status       string
+    Destination  string
+    MinAmount    *int64
+    MaxAmount    *int64
+    UpdatedAfter *time.Time
+    After        int64
+    Before       int64
+    Direction    string
+    Sort         string
+    Cursor       string
+    Limit        int
+}
+
+// SortKeys lists the accepted Sort values. A leading "-" sorts descending.
+var SortKeys = []string{"created_at", "-created_at", "amount", "-amount", "status", "-status"}
+
+// sortColumns maps sort keys to the columns they order by. Only columns from
+// this table ever reach the ORDER BY clause.
+var sortColumns = map[string]string{
+    "created_at": "created_at",
+    "amount":     "amount",
+    "status":     "status",
+}
+
+// SortBy spells a Sort as separate parts, for callers that hold the field and
+// the order apart: Field is a sort column, one of created_at, amount or
+// status, and Order is "asc" (the default) or "desc".
+type SortBy struct {
+    Field string
+    Order string
+}
+
+// Key returns the Sort value b stands for, or ErrInvalidFilter for a field or
+// order outside the lists above. Only whitelisted columns ever reach a query.
+func (b SortBy) Key() (string, error) {
+    if _, ok := sortColumns[b.Field]; !ok {
+        return "", fmt.Errorf("%w: sort field %q", ErrInvalidFilter, b.Field)
+    }
+    switch b.Order {
+    case "", DirectionAsc:
+        return b.Field, nil
+    case DirectionDesc:
+        return "-" + b.Field, nil
+    }
+    return "", fmt.Errorf("%w: sort order %q", ErrInvalidFilter, b.Order)
+}
+
+// parseSort splits a sort value into its column and direction.
+func parseSort(sort string) (column string, desc bool, ok bool) {
+    key := strings.TrimPrefix(sort, "-")
+    column, ok = sortColumns[key]
+    return column, key != sort, ok
+}
+
+// ValidSort reports whether sort is one of SortKeys.
+func ValidSort(sort string) bool {
+    _, _, ok := parseSort(sort)
+    return ok
+}
+
+// sortCursor is the decoded form of a sorted-page cursor. Sort is kept so a
+// cursor from one ordering cannot be replayed against another.
+type sortCursor struct {
+    Sort  string `json:"s"`
+    Value string `json:"v"`
+    ID    int64  `json:"id"`
+}
+
+// EncodeSortCursor returns the cursor that continues a page sorted by sort
+// after w.
+func EncodeSortCursor(sort string, w Withdrawal) string {
+    c := sortCursor{Sort: sort, ID: w.ID}
+    switch column, _, _ := parseSort(sort); column {
+    case "created_at":
+        c.Value = w.CreatedAt.UTC().Format(time.RFC3339Nano)
+    case "amount":
+        c.Value = strconv.FormatInt(w.Amount, 10)
+    case "status":
+        c.Value = w.Status
+    }
+    raw, _ := json.Marshal(c)
+    return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeSortCursor returns the sort value and id encoded in cursor, typed
+// for comparison against column.
+func decodeSortCursor(cursor, sort, column string) (any, int64, error) {
+    invalid := fmt.Errorf("%w: invalid cursor", ErrInvalidFilter)
+    raw, err := base64.RawURLEncoding.DecodeString(cursor)
+    if err != nil {
+        return nil, 0, invalid
+    }
+    var c sortCursor
+    if err := json.Unmarshal(raw, &c); err != nil || c.ID <= 0 {
+        return nil, 0, invalid
+    }
+    if c.Sort != sort {
+        return nil, 0, fmt.Errorf("%w: cursor was issued for sort %q", ErrInvalidFilter, c.Sort)
+    }
+    switch column {
+    case "created_at":
+        t, err := time.Parse(time.RFC3339Nano, c.Value)
+        if err != nil {
+            return nil, 0, invalid
+        }
+        return t, c.ID, nil
+    case "amount":
+        n, err := strconv.ParseInt(c.Value, 10, 64)
+        if err != nil {
+            return nil, 0, invalid
+        }
+        return n, c.ID, nil
+    default:
+        return c.Value, c.ID, nil
+    }
+}
+
+func (f ListWithdrawalsFilter) Validate() error {
+    switch f.Direction {
+    case "", DirectionAsc, DirectionDesc:
+    default:
+        return fmt.Errorf("%w: direction %q", ErrInvalidFilter, f.Direction)
+    }
+    if f.Limit < 0 || f.Limit > MaxListLimit {
+        return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxListLimit)
+    }
+    if f.After < 0 || f.Before < 0 {
+        return fmt.Errorf("%w: cursor must be positive", ErrInvalidFilter)
+    }
+    if err := validateAmountBounds(f.MinAmount, f.MaxAmount); err != nil {
+        return err
+    }
+    if f.Sort != "" {
+        if !ValidSort(f.Sort) {
+            return fmt.Errorf("%w: sort %q", ErrInvalidFilter, f.Sort)
+        }
+        if f.After > 0 || f.Before > 0 || f.Direction != "" {
+            return fmt.Errorf("%w: sort cannot be combined with after, before or direction", ErrInvalidFilter)
+        }
+    } else if f.Cursor != "" {
+        return fmt.Errorf("%w: cursor requires sort", ErrInvalidFilter)
+    }
+    return nil
+}
+
+// validateAmountBounds checks the inclusive amount bounds of a filter; nil
+// leaves a bound open.
+func validateAmountBounds(min, max *int64) error {
+    if (min != nil && *min < 0) || (max != nil && *max < 0) {
+        return fmt.Errorf("%w: amount bounds must not be negative", ErrInvalidFilter)
+    }
+    if min != nil && max != nil && *min > *max {
+        return fmt.Errorf("%w: min_amount %d exceeds max_amount %d", ErrInvalidFilter, *min, *max)
+    }
+    return nil
+}
+
+func (s *Store) ListWithdrawals(ctx context.Context, f ListWithdrawalsFilter) ([]Withdrawal, error) {
+    p, err := f.page(ctx)
+    if err != nil {
+        return nil, err
+    }
+    rows, err := s.pool.Query(ctx, p.sql, p.args...)
+    if err != nil {
+        return nil, err
+    }
+    return collectWithdrawals(rows)
+}
+
+// WithdrawalsByDestination lists withdrawals sent to destination across all
+// users, for compliance lookups of a wallet address. The match is exact and
+// case-sensitive, as addresses are. f narrows and pages the result as for
+// ListWithdrawals; its own Destination is ignored.
+func (s *Store) WithdrawalsByDestination(ctx context.Context, destination string, f ListWithdrawalsFilter) ([]Withdrawal, error) {
+    if destination == "" {
+        return nil, fmt.Errorf("%w: destination is required", ErrInvalidFilter)
+    }
+    f.Destination = destination
+    return s.ListWithdrawals(ctx, f)
+}
+
+// ListWithdrawalsWithTotal is ListWithdrawals that also counts every
+// withdrawal matching the filter, ignoring the cursor.
+func (s *Store) ListWithdrawalsWithTotal(ctx context.Context, f ListWithdrawalsFilter) ([]Withdrawal, Total, error) {
+    p, err := f.page(ctx)
+    if err != nil {
+        return nil, Total{}, err
+    }
+    return s.listWithTotal(ctx, p)
+}
+
+func (f ListWithdrawalsFilter) page(ctx context.Context) (withdrawalPage, error) {
+    if err := f.Validate(); err != nil {
+        return withdrawalPage{}, err
+    }
+
+    var conds []string
+    var args []any
+    add := func(cond string, arg any) {
+        args = append(args, arg)
+        conds = append(conds, fmt.Sprintf(cond, len(args)))
+    }
+    if tenant, ok := TenantFromContext(ctx); ok {
+        add("tenant_id = $%d", int64(tenant))
+    }
+    if f.UserID != 0 {
+        add("user_id = $%d", f.UserID)
+    }
+    if f.Status != "" {
+        add("status = $%d", f.Status)
+    }
+    if f.Destination != "" {
+        add("destination = $%d", f.Destination)
+    }
+    if f.MinAmount != nil {
+        add("amount >= $%d", *f.MinAmount)
+    }
+    if f.MaxAmount != nil {
+        add("amount <= $%d", *f.MaxAmount)
+    }
+    if f.UpdatedAfter != nil {
+        add("updated_at > $%d", *f.UpdatedAfter)
+    }
+    p := withdrawalPage{
+        where:     strings.Join(conds, " AND "),
+        whereArgs: append([]any{}, args...),
+    }
+    if f.After > 0 {
+        add("id > $%d", f.After)
+    }
+    if f.Before > 0 {
+        add("id < $%d", f.Before)
+    }
+
+    order := "ASC"
+    if f.Direction == DirectionDesc {
+        order = "DESC"
+    }
+    orderBy := "id " + order
+    if f.Sort != "" {
+        column, desc, _ := parseSort(f.Sort)
+        order = "ASC"
+        cmp := ">"
+        if desc {
+            order, cmp = "DESC", "<"
+        }
+        if f.Cursor != "" {
+            value, id, err := decodeSortCursor(f.Cursor, f.Sort, column)
+            if err != nil {
+                return withdrawalPage{}, err
+            }
+            args = append(args, value, id)
+            conds = append(conds, fmt.Sprintf("(%s, id) %s ($%d, $%d)", column, cmp, len(args)-1, len(args)))
+        }
+        orderBy = fmt.Sprintf("%s %s, id %s", column, order, order)
+    }
+
+    query := "SELECT " + withdrawalColumns + " FROM withdrawals"
+    if len(conds) > 0 {
+        query += " WHERE " + strings.Join(conds, " AND ")
+    }
+    limit := f.Limit
+    if limit == 0 {
+        limit = DefaultListLimit
+    }
+    args = append(args, limit)
+    p.sql = query + fmt.Sprintf(" ORDER BY %s LIMIT $%d", orderBy, len(args))
+    p.args = args
+    return p, nil
+}
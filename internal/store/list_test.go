@@ -0,0 +1,42 @@
+package store
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestSortByKey(t *testing.T) {
+    tests := []struct {
+        by   SortBy
+        want string
+    }{
+        {SortBy{Field: "amount"}, "amount"},
+        {SortBy{Field: "amount", Order: "asc"}, "amount"},
+        {SortBy{Field: "amount", Order: "desc"}, "-amount"},
+        {SortBy{Field: "created_at", Order: "asc"}, "created_at"},
+        {SortBy{Field: "created_at", Order: "desc"}, "-created_at"},
+        {SortBy{Field: "status", Order: "desc"}, "-status"},
+    }
+    for _, tt := range tests {
+        got, err := tt.by.Key()
+        if err != nil || got != tt.want {
+            t.Fatalf("%+v: expected %q, got %q, %v", tt.by, tt.want, got, err)
+        }
+        if !ValidSort(got) {
+            t.Fatalf("%+v: key %q is not a valid sort", tt.by, got)
+        }
+    }
+
+    for _, by := range []SortBy{
+        {},
+        {Field: "id"},
+        {Field: "destination"},
+        {Field: "amount; DROP TABLE withdrawals"},
+        {Field: "-amount"},
+        {Field: "amount", Order: "sideways"},
+    } {
+        if _, err := by.Key(); !errors.Is(err, ErrInvalidFilter) {
+            t.Fatalf("%+v: expected ErrInvalidFilter, got %v", by, err)
+        }
+    }
+}
@@ -0,0 +1,18 @@
+package store_test
+
+import (
+    "os"
+    "testing"
+
+    "task.hh/internal/testutil"
+)
+
+// testDatabaseURL points integration tests at a database of their own on
+// the Postgres server from DATABASE_URL when set, otherwise on a container
+// started once per test binary. It stays empty only when neither is
+// available.
+var testDatabaseURL string
+
+func TestMain(m *testing.M) {
+    os.Exit(testutil.RunWithDatabase(m, "store_test", &testDatabaseURL))
+}
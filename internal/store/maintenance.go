@@ -0,0 +1,51 @@
+package store
+
+import (
+    "context"
+    "errors"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// Maintenance reports whether maintenance mode is on. The mode is kept in the
+// database, so every replica sharing it sees the same one.
+func (s *Store) Maintenance(ctx context.Context) (bool, error) {
+    var enabled bool
+    err := s.pool.QueryRow(ctx, "SELECT enabled FROM maintenance_mode").Scan(&enabled)
+    if errors.Is(err, pgx.ErrNoRows) {
+        return false, nil
+    }
+    return enabled, err
+}
+
+// SetMaintenance turns maintenance mode on or off and reports whether that
+// changed it. Only a change is audited, with the new mode as the result.
+func (s *Store) SetMaintenance(ctx context.Context, enabled bool) (bool, error) {
+    var changed bool
+    now := s.now()
+    err := s.withAuditTx(ctx, func(q querier) error {
+        // Until it is first set the mode is off, as Maintenance reports.
+        if _, err := q.Exec(ctx, `
+            INSERT INTO maintenance_mode (id, enabled, updated_at)
+            VALUES (TRUE, FALSE, $1)
+            ON CONFLICT (id) DO NOTHING
+        `, now); err != nil {
+            return err
+        }
+        tag, err := q.Exec(ctx, `
+            UPDATE maintenance_mode SET enabled = $1, updated_at = $2
+            WHERE enabled <> $1
+        `, enabled, now)
+        if err != nil {
+            return err
+        }
+        if changed = tag.RowsAffected() == 1; !changed {
+            return nil
+        }
+        return recordAudit(ctx, q, enabled, now)
+    })
+    if err != nil {
+        return false, err
+    }
+    return changed, nil
+}
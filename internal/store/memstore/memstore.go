@@ -0,0 +1,179 @@
+// Package memstore is an in-memory stand-in for store.Store, for tests that
+// need a store but not Postgres. It covers the methods of storetest.Store and
+// behaves as store.Store does with its default options: no fees, no
+// reservation expiry, no pending limit and no destination blacklist. Tenant
+// and origin key scopes in the context are ignored.
+package memstore
+
+import (
+    "context"
+    "sync"
+    "time"
+
+    "task.hh/internal/store"
+)
+
+type idempotencyKey struct {
+    userID int64
+    key    string
+}
+
+// Store keeps users and withdrawals in maps behind one mutex, so every
+// method is atomic, as a transaction is in store.Store. It is safe for
+// concurrent use.
+type Store struct {
+    clock store.Clock
+
+    mu          sync.Mutex
+    users       map[int64]store.User
+    withdrawals map[int64]store.Withdrawal
+    keys        map[idempotencyKey]int64
+    nextID      int64
+}
+
+// New returns an empty store. A nil clock uses the real time.
+func New(clock store.Clock) *Store {
+    return &Store{
+        clock:       clock,
+        users:       map[int64]store.User{},
+        withdrawals: map[int64]store.Withdrawal{},
+        keys:        map[idempotencyKey]int64{},
+    }
+}
+
+// now matches the precision Postgres stores timestamps with.
+func (s *Store) now() time.Time {
+    t := time.Now()
+    if s.clock != nil {
+        t = s.clock.Now()
+    }
+    return t.UTC().Truncate(time.Microsecond)
+}
+
+func (s *Store) CreateUser(ctx context.Context, id int64, balance int64, externalID *string) (store.User, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if _, ok := s.users[id]; ok {
+        return store.User{}, store.ErrUserExists
+    }
+    if externalID != nil {
+        for _, u := range s.users {
+            if u.ExternalID != nil && *u.ExternalID == *externalID {
+                return store.User{}, store.ErrExternalIDExists
+            }
+        }
+        id := *externalID
+        externalID = &id
+    }
+    now := s.now()
+    u := store.User{
+        ID:         id,
+        Balance:    balance,
+        Tier:       store.TierStandard,
+        ExternalID: externalID,
+        CreatedAt:  now,
+        UpdatedAt:  now,
+    }
+    s.users[id] = u
+    return u, nil
+}
+
+func (s *Store) GetUser(ctx context.Context, id int64) (store.User, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    u, ok := s.users[id]
+    if !ok {
+        return store.User{}, store.ErrUserNotFound
+    }
+    return u, nil
+}
+
+func (s *Store) CreateWithdrawal(ctx context.Context, input store.CreateWithdrawalInput) (store.CreateWithdrawalResult, error) {
+    input, err := input.Normalize()
+    if err != nil {
+        return store.CreateWithdrawalResult{}, err
+    }
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    u, ok := s.users[input.UserID]
+    if !ok {
+        return store.CreateWithdrawalResult{}, store.ErrUserNotFound
+    }
+
+    key := idempotencyKey{input.UserID, input.IdempotencyKey}
+    if id, ok := s.keys[key]; ok {
+        existing := s.withdrawals[id]
+        if existing.Amount != input.Amount || existing.Currency != input.Currency || existing.Destination != input.Destination {
+            return store.CreateWithdrawalResult{}, &store.IdempotencyConflictError{Existing: existing}
+        }
+        existing.Replayed = true
+        return store.CreateWithdrawalResult{Withdrawal: existing, Balance: u.Balance}, nil
+    }
+
+    if u.Balance < input.Amount {
+        return store.CreateWithdrawalResult{}, &store.InsufficientBalanceError{Balance: u.Balance, Requested: input.Amount}
+    }
+
+    now := s.now()
+    s.nextID++
+    w := store.Withdrawal{
+        ID:             s.nextID,
+        UserID:         input.UserID,
+        Amount:         input.Amount,
+        Currency:       input.Currency,
+        Destination:    input.Destination,
+        Status:         store.StatusPending,
+        IdempotencyKey: input.IdempotencyKey,
+        CreatedAt:      now,
+        UpdatedAt:      now,
+        Version:        1,
+        CreatedByKey:   input.CreatedByKey,
+    }
+    s.withdrawals[w.ID] = w
+    s.keys[key] = w.ID
+    u.Balance -= input.Amount
+    u.UpdatedAt = now
+    s.users[u.ID] = u
+    return store.CreateWithdrawalResult{Withdrawal: w, Balance: u.Balance}, nil
+}
+
+func (s *Store) GetWithdrawal(ctx context.Context, id int64) (store.Withdrawal, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    w, ok := s.withdrawals[id]
+    if !ok {
+        return store.Withdrawal{}, store.ErrNotFound
+    }
+    return w, nil
+}
+
+// ConfirmWithdrawal confirms a pending withdrawal; confirming a confirmed one
+// returns it unchanged. Any other status is a *store.TransitionError.
+func (s *Store) ConfirmWithdrawal(ctx context.Context, id int64) (store.Withdrawal, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    w, ok := s.withdrawals[id]
+    if !ok {
+        return store.Withdrawal{}, store.ErrNotFound
+    }
+    if w.Status == store.StatusConfirmed {
+        return w, nil
+    }
+    if err := store.ValidateTransition(w.Status, store.StatusConfirmed); err != nil {
+        return store.Withdrawal{}, err
+    }
+
+    now := s.now()
+    w.Status = store.StatusConfirmed
+    w.ConfirmedAt = &now
+    w.UpdatedAt = now
+    w.Version++
+    s.withdrawals[id] = w
+    return w, nil
+}
@@ -0,0 +1,14 @@
+package memstore_test
+
+import (
+    "testing"
+
+    "task.hh/internal/store/memstore"
+    "task.hh/internal/store/storetest"
+)
+
+func TestConformance(t *testing.T) {
+    storetest.Run(t, "memstore", func(t *testing.T) storetest.Store {
+        return memstore.New(nil)
+    })
+}
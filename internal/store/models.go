@@ -3,21 +3,72 @@ package store
 import "time"
 
 const (
-    StatusPending   = "pending"
-    StatusConfirmed = "confirmed"
+    TierStandard   = "standard"
+    TierPremium    = "premium"
+    TierEnterprise = "enterprise"
 )
 
-const DirectionDebit = "debit"
+// Tiers lists the user tiers accepted by UpdateUserTier.
+var Tiers = []string{TierStandard, TierPremium, TierEnterprise}
+
+const (
+    DirectionDebit  = "debit"
+    DirectionCredit = "credit"
+    DirectionFee    = "fee"
+    // DirectionSettlement marks the confirmation of a withdrawal debited at
+    // creation and moves no money.
+    DirectionSettlement = "settlement"
+)
 
 type Withdrawal struct {
     ID             int64
     UserID         int64
     Amount         int64
+    Fee            int64
     Currency       string
     Destination    string
     Status         string
     IdempotencyKey string
+    ReservedUntil  *time.Time
     CreatedAt      time.Time
+    UpdatedAt      time.Time
+    // ConfirmedAt is set when the withdrawal is confirmed.
+    ConfirmedAt *time.Time
+    // NoteCount is the number of notes attached to the withdrawal.
+    NoteCount int
+    // ReversalReason is set when the withdrawal is reversed.
+    ReversalReason string
+    // TenantID is the owner's tenant.
+    TenantID TenantID
+    // Version starts at 1 and grows with every update of the row, for
+    // optimistic concurrency control.
+    Version int64
+    // CreatedByKey names the API key that created the withdrawal; it is
+    // empty for withdrawals created before keys were recorded.
+    CreatedByKey string
+    // ExternalTxHash is the on-chain transaction that paid the withdrawal
+    // out, once recorded with RecordExternalTxHash.
+    ExternalTxHash *string
+
+    // Replayed is set by CreateWithdrawal when the idempotency key matched an
+    // existing withdrawal and no new one was created. It is not stored.
+    Replayed bool
+}
+
+// WithdrawalWithUser is a withdrawal with the owner's current balance and
+// tier.
+type WithdrawalWithUser struct {
+    Withdrawal
+    UserBalance int64
+    UserTier    string
+}
+
+// CreateWithdrawalResult is the outcome of CreateWithdrawal. Balance is the
+// user's balance after the debit; for a replay no debit happens and it is the
+// balance at the time of the replay.
+type CreateWithdrawalResult struct {
+    Withdrawal
+    Balance int64
 }
 
 type CreateWithdrawalInput struct {
@@ -26,12 +77,23 @@ type CreateWithdrawalInput struct {
     Currency       string
     Destination    string
     IdempotencyKey string
+    // CreatedByKey is recorded on the withdrawal for WithOriginKey. It is
+    // not part of the idempotent payload: a replay by another key returns
+    // the withdrawal unchanged.
+    CreatedByKey string
 }
 
 type User struct {
-    ID        int64
-    Balance   int64
-    CreatedAt time.Time
+    ID      int64
+    Balance int64
+    Tier    string
+    // ExternalID is the caller's own id for the user, if one was given.
+    ExternalID *string
+    CreatedAt  time.Time
+    UpdatedAt  time.Time
+    TenantID   TenantID
+    // OverdraftLimit is how far below zero withdrawals may take the balance.
+    OverdraftLimit int64
 }
 
 type LedgerEntry struct {
@@ -41,5 +103,8 @@ type LedgerEntry struct {
     Amount       int64
     Currency     string
     Direction    string
-    CreatedAt    time.Time
+    // Reason is the refund reason of a credit entry for a withdrawal, empty
+    // for every other entry.
+    Reason    string
+    CreatedAt time.Time
 }
@@ -0,0 +1,114 @@
+package store
+
+import (
+    "context"
+    "errors"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// MaxNoteLength bounds the text of a withdrawal note, in characters.
+const MaxNoteLength = 2000
+
+// WithdrawalNote is a free-form remark left on a withdrawal by support.
+// Notes are append-only.
+type WithdrawalNote struct {
+    ID           int64
+    WithdrawalID int64
+    Author       string
+    Text         string
+    CreatedAt    time.Time
+}
+
+const noteColumns = "id, withdrawal_id, author, text, created_at"
+
+func scanWithdrawalNote(row pgx.Row) (WithdrawalNote, error) {
+    var n WithdrawalNote
+    err := row.Scan(&n.ID, &n.WithdrawalID, &n.Author, &n.Text, &n.CreatedAt)
+    return n, err
+}
+
+// AddWithdrawalNote appends a note to the withdrawal and bumps its
+// NoteCount in the same transaction. It returns ErrNotFound when the
+// withdrawal does not exist.
+func (s *Store) AddWithdrawalNote(ctx context.Context, withdrawalID int64, author, text string) (WithdrawalNote, error) {
+    var note WithdrawalNote
+    now := s.now()
+    err := s.WithTx(ctx, func(tx pgx.Tx) error {
+        if err := authorizeWithdrawal(ctx, tx, withdrawalID); err != nil {
+            return err
+        }
+        tag, err := tx.Exec(ctx, `
+            UPDATE withdrawals SET note_count = note_count + 1, version = version + 1
+            WHERE id = $1
+        `, withdrawalID)
+        if err != nil {
+            return err
+        }
+        if tag.RowsAffected() == 0 {
+            return ErrNotFound
+        }
+
+        note, err = scanWithdrawalNote(tx.QueryRow(ctx, `
+            INSERT INTO withdrawal_notes (withdrawal_id, author, text, created_at)
+            VALUES ($1, $2, $3, $4)
+            RETURNING `+noteColumns,
+            withdrawalID, author, text, now,
+        ))
+        if err != nil {
+            return err
+        }
+        return recordAudit(ctx, tx, note, now)
+    })
+    if err != nil {
+        return WithdrawalNote{}, err
+    }
+    return note, nil
+}
+
+// ListWithdrawalNotes returns the withdrawal's notes, oldest first. It
+// returns ErrNotFound when the withdrawal does not exist.
+func (s *Store) ListWithdrawalNotes(ctx context.Context, withdrawalID int64) ([]WithdrawalNote, error) {
+    batch := &pgx.Batch{}
+    batch.Queue("SELECT tenant_id FROM withdrawals WHERE id = $1", withdrawalID)
+    batch.Queue(`
+        SELECT `+noteColumns+`
+        FROM withdrawal_notes
+        WHERE withdrawal_id = $1
+        ORDER BY id
+    `, withdrawalID)
+
+    results := s.pool.SendBatch(ctx, batch)
+    defer results.Close()
+
+    var owner TenantID
+    if err := results.QueryRow().Scan(&owner); err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return nil, ErrNotFound
+        }
+        return nil, err
+    }
+    if err := checkTenant(ctx, owner); err != nil {
+        return nil, err
+    }
+
+    rows, err := results.Query()
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    notes := []WithdrawalNote{}
+    for rows.Next() {
+        n, err := scanWithdrawalNote(rows)
+        if err != nil {
+            return nil, err
+        }
+        notes = append(notes, n)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    return notes, nil
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+    "context"
+    "time"
+)
+
+// BalanceCurrency is the currency user balances are kept in.
+const BalanceCurrency = "USDT"
+
+// WithOpeningLedgerEntries makes user creation record a positive starting
+// balance as a credit ledger entry without a withdrawal, so that the ledger
+// accounts for the whole balance from the start.
+func WithOpeningLedgerEntries(enabled bool) Option {
+    return func(s *Store) {
+        s.openingLedgerEntries = enabled
+    }
+}
+
+// insertOpeningEntries writes the opening credit entry of every user with a
+// positive balance, dated now.
+func insertOpeningEntries(ctx context.Context, q querier, users []User, now time.Time) error {
+    ids := make([]int64, 0, len(users))
+    amounts := make([]int64, 0, len(users))
+    for _, u := range users {
+        if u.Balance > 0 {
+            ids = append(ids, u.ID)
+            amounts = append(amounts, u.Balance)
+        }
+    }
+    if len(ids) == 0 {
+        return nil
+    }
+    _, err := q.Exec(ctx, `
+        INSERT INTO ledger_entries (user_id, amount, currency, direction, created_at)
+        SELECT user_id, amount, $3, $4, $5::timestamptz FROM unnest($1::bigint[], $2::bigint[]) AS t(user_id, amount)
+    `, ids, amounts, BalanceCurrency, DirectionCredit, now)
+    return err
+}
@@ -0,0 +1,45 @@
+package store
+
+import (
+    "context"
+    "errors"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+// SetOverdraftLimit lets withdrawals take user id's balance down to -limit.
+// A negative limit is ErrInvalidOverdraftLimit, and a limit smaller than what
+// the user already owes is ErrOverdraftInUse.
+func (s *Store) SetOverdraftLimit(ctx context.Context, id int64, limit int64) (User, error) {
+    if limit < 0 {
+        return User{}, ErrInvalidOverdraftLimit
+    }
+    var u User
+    now := s.now()
+    err := s.withAuditTx(ctx, func(q querier) error {
+        if err := authorizeUser(ctx, q, id); err != nil {
+            return err
+        }
+        var err error
+        u, err = scanUser(q.QueryRow(ctx, `
+            UPDATE users SET overdraft_limit = $2, updated_at = $3
+            WHERE id = $1
+            RETURNING `+userColumns, id, limit, now))
+        if err != nil {
+            if errors.Is(err, pgx.ErrNoRows) {
+                return ErrUserNotFound
+            }
+            var pgErr *pgconn.PgError
+            if errors.As(err, &pgErr) && pgErr.ConstraintName == "users_balance_check" {
+                return ErrOverdraftInUse
+            }
+            return err
+        }
+        return recordAudit(ctx, q, u, now)
+    })
+    if err != nil {
+        return User{}, err
+    }
+    return u, nil
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+    "context"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// Refund reasons are recorded on the credit entry that puts a withdrawal's
+// money back. Cancellation and failure have no code path yet; they are
+// declared so that the schema accepts them once they do.
+const (
+    RefundReasonCancelled = "cancelled"
+    RefundReasonFailed    = "failed"
+    RefundReasonExpired   = "expired"
+    RefundReasonReversed  = "reversed"
+)
+
+// RefundReasons lists every refund reason the ledger accepts.
+var RefundReasons = []string{RefundReasonCancelled, RefundReasonFailed, RefundReasonExpired, RefundReasonReversed}
+
+// insertRefundEntry credits amount back for w with reason at now. The partial
+// unique index on (withdrawal_id, direction) allows one credit per
+// withdrawal, so a second refund returns ErrAlreadyRefunded even when two
+// code paths race past their status checks. The index does not cover
+// ledger_entries_archive, so a credit already archived is looked up first.
+func insertRefundEntry(ctx context.Context, tx pgx.Tx, w Withdrawal, amount int64, reason string, now time.Time) error {
+    var archived bool
+    err := tx.QueryRow(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM ledger_entries_archive
+            WHERE withdrawal_id = $1 AND direction = $2
+        )
+    `, w.ID, DirectionCredit).Scan(&archived)
+    if err != nil {
+        return err
+    }
+    if archived {
+        return ErrAlreadyRefunded
+    }
+    _, err = tx.Exec(ctx, `
+        INSERT INTO ledger_entries (user_id, withdrawal_id, amount, currency, direction, reason, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7)
+    `, w.UserID, w.ID, amount, w.Currency, DirectionCredit, reason, now)
+    if isUniqueViolation(err) {
+        return ErrAlreadyRefunded
+    }
+    return err
+}
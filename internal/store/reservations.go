@@ -0,0 +1,85 @@
+package store
+
+import (
+    "context"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// WithReservationTTL makes new withdrawals hold their funds only for ttl.
+// Holds that are not confirmed in time are released by
+// ReleaseExpiredReservations. Zero keeps holds forever.
+func WithReservationTTL(ttl time.Duration) Option {
+    return func(s *Store) {
+        s.reservationTTL = ttl
+    }
+}
+
+// reservedUntil is when a withdrawal created at now stops holding its funds,
+// or nil when holds do not expire.
+func (s *Store) reservedUntil(now time.Time) *time.Time {
+    if s.reservationTTL <= 0 {
+        return nil
+    }
+    until := now.Add(s.reservationTTL)
+    return &until
+}
+
+func reservationExpired(w Withdrawal, now time.Time) bool {
+    return w.ReservedUntil != nil && !now.Before(*w.ReservedUntil)
+}
+
+// ReleaseExpiredReservations expires up to limit pending withdrawals whose
+// hold has run out, crediting amount and fee back to the user. Rows locked by
+// a concurrent confirm are skipped and picked up on a later run.
+func (s *Store) ReleaseExpiredReservations(ctx context.Context, limit int) ([]Withdrawal, error) {
+    now := s.now()
+    tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+    if err != nil {
+        return nil, err
+    }
+    defer func() {
+        _ = tx.Rollback(ctx)
+    }()
+
+    rows, err := tx.Query(ctx, `
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE status = $1 AND reserved_until <= $2
+        ORDER BY reserved_until
+        LIMIT $3
+        FOR UPDATE SKIP LOCKED
+    `, StatusPending, now, limit)
+    if err != nil {
+        return nil, err
+    }
+    expired, err := collectWithdrawals(rows)
+    if err != nil {
+        return nil, err
+    }
+
+    for i := range expired {
+        w := &expired[i]
+        updated, err := transitionWithdrawal(ctx, tx, *w, StatusExpired, now, "")
+        if err != nil {
+            return nil, err
+        }
+        *w = updated
+        var balance int64
+        if err := tx.QueryRow(ctx, "UPDATE users SET balance = balance + $1, updated_at = $3 WHERE id = $2 RETURNING balance", w.Amount+w.Fee, w.UserID, now).Scan(&balance); err != nil {
+            return nil, err
+        }
+        if err := s.recordBalanceChange(ctx, tx, OperationWithdrawalExpire, *w, balance-w.Amount-w.Fee, balance, now); err != nil {
+            return nil, err
+        }
+        if err := insertRefundEntry(ctx, tx, *w, w.Amount+w.Fee, RefundReasonExpired, now); err != nil {
+            return nil, err
+        }
+    }
+
+    if err := tx.Commit(ctx); err != nil {
+        return nil, err
+    }
+    return expired, nil
+}
@@ -0,0 +1,50 @@
+package store
+
+import (
+    "context"
+    "errors"
+    "math/rand"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+const defaultMaxAttempts = 3
+
+var retryJitter = func() time.Duration {
+    return 5*time.Millisecond + time.Duration(rand.Int63n(int64(20*time.Millisecond)))
+}
+
+// retryOnSerializationFailure re-runs fn while it fails with SQLSTATE 40001,
+// which REPEATABLE READ and SERIALIZABLE transactions expect the caller to retry.
+// It stops waiting for the next attempt once ctx is done and returns ctx.Err().
+func retryOnSerializationFailure(ctx context.Context, fn func() error, maxAttempts int) error {
+    if maxAttempts <= 0 {
+        maxAttempts = defaultMaxAttempts
+    }
+    var err error
+    for attempt := 1; attempt <= maxAttempts; attempt++ {
+        err = fn()
+        if err == nil || !isSerializationFailure(err) {
+            return err
+        }
+        if attempt < maxAttempts {
+            timer := time.NewTimer(retryJitter())
+            select {
+            case <-ctx.Done():
+                timer.Stop()
+                return ctx.Err()
+            case <-timer.C:
+            }
+        }
+    }
+    return err
+}
+
+func isSerializationFailure(err error) bool {
+    var pgErr *pgconn.PgError
+    if !errors.As(err, &pgErr) {
+        return false
+    }
+    return pgErr.Code == "40001"
+}
@@ -0,0 +1,82 @@
+package store
+
+import (
+    "context"
+    "errors"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgconn"
+)
+
+// stubRetryJitter replaces retryJitter for the rest of t.
+func stubRetryJitter(t *testing.T, d time.Duration) {
+    saved := retryJitter
+    retryJitter = func() time.Duration { return d }
+    t.Cleanup(func() { retryJitter = saved })
+}
+
+func TestRetryOnSerializationFailure(t *testing.T) {
+    stubRetryJitter(t, 0)
+
+    serialization := &pgconn.PgError{Code: "40001"}
+
+    tests := []struct {
+        name        string
+        failures    int
+        failWith    error
+        maxAttempts int
+        wantCalls   int
+        wantErr     error
+    }{
+        {name: "success", failures: 0, maxAttempts: 3, wantCalls: 1},
+        {name: "recovers", failures: 2, failWith: serialization, maxAttempts: 3, wantCalls: 3},
+        {name: "exhausted", failures: 5, failWith: serialization, maxAttempts: 3, wantCalls: 3, wantErr: serialization},
+        {name: "default attempts", failures: 5, failWith: serialization, maxAttempts: 0, wantCalls: defaultMaxAttempts, wantErr: serialization},
+        {name: "other error", failures: 5, failWith: ErrInsufficientBalance, maxAttempts: 3, wantCalls: 1, wantErr: ErrInsufficientBalance},
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            calls := 0
+            err := retryOnSerializationFailure(context.Background(), func() error {
+                calls++
+                if calls <= tt.failures {
+                    return tt.failWith
+                }
+                return nil
+            }, tt.maxAttempts)
+
+            if calls != tt.wantCalls {
+                t.Fatalf("expected %d calls, got %d", tt.wantCalls, calls)
+            }
+            if !errors.Is(err, tt.wantErr) {
+                t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+            }
+        })
+    }
+}
+
+func TestRetryOnSerializationFailureStopsWithContext(t *testing.T) {
+    stubRetryJitter(t, time.Hour)
+
+    ctx, cancel := context.WithCancel(context.Background())
+    calls := 0
+    done := make(chan error, 1)
+    go func() {
+        done <- retryOnSerializationFailure(ctx, func() error {
+            calls++
+            return &pgconn.PgError{Code: "40001"}
+        }, 3)
+    }()
+    cancel()
+
+    select {
+    case err := <-done:
+        if !errors.Is(err, context.Canceled) || calls != 1 {
+            t.Fatalf("expected context.Canceled after 1 call, got %v after %d", err, calls)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatalf("retry kept sleeping after the context was cancelled")
+    }
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+    "context"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// MaxReversalReasonLength bounds the reason recorded with a reversal, in
+// characters.
+const MaxReversalReasonLength = 500
+
+// ReverseWithdrawal undoes a confirmed withdrawal after a chargeback or
+// recall: the amount is credited back with a credit ledger entry and the
+// withdrawal moves to StatusReversed with reason recorded. The fee is kept,
+// since the payout did happen. Withdrawals in any other status, including
+// already reversed ones, return ErrInvalidStatus; a missing one returns
+// ErrNotFound.
+func (s *Store) ReverseWithdrawal(ctx context.Context, id int64, reason string) (Withdrawal, error) {
+    var reversed Withdrawal
+    now := s.now()
+    err := s.WithTx(ctx, func(tx pgx.Tx) error {
+        w, err := lockWithdrawal(ctx, tx, id)
+        if err != nil {
+            return err
+        }
+        reversed, err = transitionWithdrawal(ctx, tx, w, StatusReversed, now, "reversal_reason = $5", reason)
+        if err != nil {
+            return err
+        }
+        var balance int64
+        if err := tx.QueryRow(ctx, "UPDATE users SET balance = balance + $1, updated_at = $3 WHERE id = $2 RETURNING balance", w.Amount, w.UserID, now).Scan(&balance); err != nil {
+            return err
+        }
+        if err := s.recordBalanceChange(ctx, tx, OperationWithdrawalReverse, reversed, balance-w.Amount, balance, now); err != nil {
+            return err
+        }
+        if err := insertRefundEntry(ctx, tx, w, w.Amount, RefundReasonReversed, now); err != nil {
+            return err
+        }
+        return recordAudit(ctx, tx, reversed, now)
+    })
+    if err != nil {
+        return Withdrawal{}, err
+    }
+    return reversed, nil
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// DestinationFilter selects withdrawals sent to one destination across all
+// users, newest first. From and To bound created_at as [From, To). Pages are
+// keyed on id: pass the last id seen as Before to fetch the next page.
+// MinAmount and MaxAmount bound amount inclusively when set; a zero bound is
+// a bound, as in ListWithdrawalsFilter.
+type DestinationFilter struct {
+    Destination string
+    From        *time.Time
+    To          *time.Time
+    MinAmount   *int64
+    MaxAmount   *int64
+    Before      int64
+    Limit       int
+}
+
+func (f DestinationFilter) Validate() error {
+    if f.Destination == "" {
+        return fmt.Errorf("%w: destination is required", ErrInvalidFilter)
+    }
+    if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+        return fmt.Errorf("%w: from must be before to", ErrInvalidFilter)
+    }
+    if f.Limit < 0 || f.Limit > MaxListLimit {
+        return fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidFilter, MaxListLimit)
+    }
+    if f.Before < 0 {
+        return fmt.Errorf("%w: cursor must be positive", ErrInvalidFilter)
+    }
+    return validateAmountBounds(f.MinAmount, f.MaxAmount)
+}
+
+func (s *Store) FindWithdrawalsByDestination(ctx context.Context, f DestinationFilter) ([]Withdrawal, error) {
+    p, err := f.page(ctx)
+    if err != nil {
+        return nil, err
+    }
+    rows, err := s.pool.Query(ctx, p.sql, p.args...)
+    if err != nil {
+        return nil, err
+    }
+    return collectWithdrawals(rows)
+}
+
+// FindWithdrawalsByDestinationWithTotal is FindWithdrawalsByDestination that
+// also counts every withdrawal matching the filter, ignoring the cursor.
+func (s *Store) FindWithdrawalsByDestinationWithTotal(ctx context.Context, f DestinationFilter) ([]Withdrawal, Total, error) {
+    p, err := f.page(ctx)
+    if err != nil {
+        return nil, Total{}, err
+    }
+    return s.listWithTotal(ctx, p)
+}
+
+func (f DestinationFilter) page(ctx context.Context) (withdrawalPage, error) {
+    if err := f.Validate(); err != nil {
+        return withdrawalPage{}, err
+    }
+
+    conds := []string{"destination = $1"}
+    args := []any{f.Destination}
+    add := func(cond string, arg any) {
+        args = append(args, arg)
+        conds = append(conds, fmt.Sprintf(cond, len(args)))
+    }
+    if tenant, ok := TenantFromContext(ctx); ok {
+        add("tenant_id = $%d", int64(tenant))
+    }
+    if f.From != nil {
+        add("created_at >= $%d", *f.From)
+    }
+    if f.To != nil {
+        add("created_at < $%d", *f.To)
+    }
+    if f.MinAmount != nil {
+        add("amount >= $%d", *f.MinAmount)
+    }
+    if f.MaxAmount != nil {
+        add("amount <= $%d", *f.MaxAmount)
+    }
+    p := withdrawalPage{
+        where:     strings.Join(conds, " AND "),
+        whereArgs: append([]any{}, args...),
+    }
+    if f.Before > 0 {
+        add("id < $%d", f.Before)
+    }
+    limit := f.Limit
+    if limit == 0 {
+        limit = DefaultListLimit
+    }
+    args = append(args, limit)
+
+    p.sql = fmt.Sprintf(`
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE %s
+        ORDER BY id DESC
+        LIMIT $%d
+    `, strings.Join(conds, " AND "), len(args))
+    p.args = args
+    return p, nil
+}
@@ -0,0 +1,24 @@
+package store
+
+import (
+    "context"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// WithSettlementLedgerEntries makes confirmation record a settlement ledger
+// entry for the withdrawal's amount. The debit at creation already moved the
+// money, so the entry is a marker that changes no balance; it lets
+// double-entry bookkeeping tell the reservation from the settlement.
+func WithSettlementLedgerEntries(enabled bool) Option {
+    return func(s *Store) {
+        s.settlementLedgerEntries = enabled
+    }
+}
+
+// insertSettlementEntry writes the settlement entry of w, confirmed now. A
+// withdrawal is settled once, which a unique index enforces.
+func insertSettlementEntry(ctx context.Context, tx pgx.Tx, w Withdrawal, now time.Time) error {
+    return insertLedgerEntry(ctx, tx, w.UserID, w.ID, w.Amount, w.Currency, DirectionSettlement, now)
+}
@@ -0,0 +1,298 @@
+package store
+
+import (
+    "context"
+    "fmt"
+    "slices"
+    "strconv"
+    "strings"
+    "time"
+)
+
+type StatusStats struct {
+    Count  int64
+    Amount int64
+}
+
+type UserStats struct {
+    ByStatus        map[string]StatusStats
+    WithdrawalCount int64
+    // TotalWithdrawn counts confirmed withdrawals only; expired ones returned
+    // their funds and pending ones are reported in PendingAmount.
+    TotalWithdrawn int64
+    PendingAmount  int64
+}
+
+// GetUserStats aggregates the user's withdrawals by status in one query.
+func (s *Store) GetUserStats(ctx context.Context, userID int64) (UserStats, error) {
+    rows, err := s.pool.Query(ctx, `
+        SELECT status, COUNT(*), COALESCE(SUM(amount), 0)
+        FROM withdrawals
+        WHERE user_id = $1 AND `+tenantFilter("", 2)+`
+        GROUP BY status
+    `, userID, tenantArg(ctx))
+    if err != nil {
+        return UserStats{}, err
+    }
+    defer rows.Close()
+
+    stats := UserStats{ByStatus: map[string]StatusStats{}}
+    for rows.Next() {
+        var status string
+        var st StatusStats
+        if err := rows.Scan(&status, &st.Count, &st.Amount); err != nil {
+            return UserStats{}, err
+        }
+        stats.ByStatus[status] = st
+        stats.WithdrawalCount += st.Count
+        switch status {
+        case StatusConfirmed:
+            stats.TotalWithdrawn += st.Amount
+        case StatusPending:
+            stats.PendingAmount += st.Amount
+        }
+    }
+    if err := rows.Err(); err != nil {
+        return UserStats{}, err
+    }
+    return stats, nil
+}
+
+const (
+    DefaultTopRecipients = 10
+    MaxTopRecipients     = 100
+)
+
+// RecipientSummary totals a user's confirmed withdrawals in one currency to
+// one destination.
+type RecipientSummary struct {
+    Destination string
+    Currency    string
+    Count       int64
+    TotalAmount int64
+}
+
+// TopWithdrawalRecipients returns the destinations that received the most
+// from the user, largest total first, for compliance review. Only confirmed
+// withdrawals count: expired and reversed ones never left, and pending ones
+// may not. A destination paid in several currencies gets a summary per
+// currency. A limit of 0 means DefaultTopRecipients; larger limits are capped
+// at MaxTopRecipients.
+func (s *Store) TopWithdrawalRecipients(ctx context.Context, userID int64, limit int) ([]RecipientSummary, error) {
+    if limit <= 0 {
+        limit = DefaultTopRecipients
+    }
+    if limit > MaxTopRecipients {
+        limit = MaxTopRecipients
+    }
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT destination, currency, COUNT(*), SUM(amount)
+        FROM withdrawals
+        WHERE user_id = $1 AND status = $3 AND `+tenantFilter("", 4)+`
+        GROUP BY destination, currency
+        ORDER BY SUM(amount) DESC, destination, currency
+        LIMIT $2
+    `, userID, limit, StatusConfirmed, tenantArg(ctx))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    recipients := []RecipientSummary{}
+    for rows.Next() {
+        var r RecipientSummary
+        if err := rows.Scan(&r.Destination, &r.Currency, &r.Count, &r.TotalAmount); err != nil {
+            return nil, err
+        }
+        recipients = append(recipients, r)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    return recipients, nil
+}
+
+const (
+    GroupByStatus   = "status"
+    GroupByCurrency = "currency"
+    GroupByDay      = "day"
+)
+
+// MaxStatsRange bounds the time window of WithdrawalStats.
+const MaxStatsRange = 92 * 24 * time.Hour
+
+type WithdrawalStatsQuery struct {
+    From    time.Time
+    To      time.Time
+    GroupBy []string
+    // TZOffset shifts day buckets from UTC, e.g. 3h buckets by UTC+03:00 days.
+    TZOffset time.Duration
+    // ByConfirmation selects and buckets withdrawals by confirmed_at instead
+    // of created_at, leaving out those never confirmed.
+    ByConfirmation bool
+}
+
+// WithdrawalStatsRow holds one group. Rows are always split by currency, as
+// amounts in different currencies do not add up; Status and Day are empty
+// unless listed in GroupBy.
+type WithdrawalStatsRow struct {
+    Status   string
+    Currency string
+    Day      string
+    Count    int64
+    Amount   int64
+}
+
+var statsGroupColumns = map[string]string{
+    GroupByStatus:   "status",
+    GroupByCurrency: "currency",
+    // %s is the time column and $3 the offset from UTC in seconds.
+    GroupByDay: "to_char((%s AT TIME ZONE 'UTC') + make_interval(secs => $3), 'YYYY-MM-DD')",
+}
+
+func (q WithdrawalStatsQuery) timeColumn() string {
+    if q.ByConfirmation {
+        return "confirmed_at"
+    }
+    return "created_at"
+}
+
+func (q WithdrawalStatsQuery) Validate() error {
+    if q.From.IsZero() || q.To.IsZero() || !q.To.After(q.From) {
+        return fmt.Errorf("%w: from must be before to", ErrInvalidFilter)
+    }
+    if q.To.Sub(q.From) > MaxStatsRange {
+        return fmt.Errorf("%w: range exceeds %s", ErrInvalidFilter, MaxStatsRange)
+    }
+    seen := map[string]bool{}
+    for _, g := range q.GroupBy {
+        if _, ok := statsGroupColumns[g]; !ok || seen[g] {
+            return fmt.Errorf("%w: group_by %q", ErrInvalidFilter, g)
+        }
+        seen[g] = true
+    }
+    return nil
+}
+
+// WithdrawalStats counts and sums withdrawals created in [From, To), or
+// confirmed in it with ByConfirmation, grouped by the requested keys and by
+// currency. Rows are ordered by the group keys in GroupBy order, then by
+// currency, so repeated calls over the same data return identical results.
+func (s *Store) WithdrawalStats(ctx context.Context, q WithdrawalStatsQuery) ([]WithdrawalStatsRow, error) {
+    if err := q.Validate(); err != nil {
+        return nil, err
+    }
+
+    groupBy := q.GroupBy
+    if !slices.Contains(groupBy, GroupByCurrency) {
+        groupBy = append(slices.Clip(groupBy), GroupByCurrency)
+    }
+    column := q.timeColumn()
+    args := []any{q.From, q.To}
+    exprs := make([]string, 0, len(groupBy))
+    positions := make([]string, 0, len(groupBy))
+    for i, g := range groupBy {
+        expr := statsGroupColumns[g]
+        if g == GroupByDay {
+            args = append(args, q.TZOffset.Seconds())
+            expr = fmt.Sprintf(expr, column)
+        }
+        exprs = append(exprs, expr+", ")
+        positions = append(positions, strconv.Itoa(i+1))
+    }
+    args = append(args, tenantArg(ctx))
+
+    query := `
+        SELECT ` + strings.Join(exprs, "") + `COUNT(*), COALESCE(SUM(amount), 0)
+        FROM withdrawals
+        WHERE ` + column + ` >= $1 AND ` + column + ` < $2 AND ` + tenantFilter("", len(args)) + `
+        GROUP BY ` + strings.Join(positions, ", ") + `
+        ORDER BY ` + strings.Join(positions, ", ")
+
+    rows, err := s.pool.Query(ctx, query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []WithdrawalStatsRow{}
+    for rows.Next() {
+        var row WithdrawalStatsRow
+        dest := make([]any, 0, len(groupBy)+2)
+        for _, g := range groupBy {
+            switch g {
+            case GroupByStatus:
+                dest = append(dest, &row.Status)
+            case GroupByCurrency:
+                dest = append(dest, &row.Currency)
+            case GroupByDay:
+                dest = append(dest, &row.Day)
+            }
+        }
+        dest = append(dest, &row.Count, &row.Amount)
+        if err := rows.Scan(dest...); err != nil {
+            return nil, err
+        }
+        result = append(result, row)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
+
+// MaxTimeSeriesRange bounds the created_at window of GetWithdrawalTimeSeries.
+const MaxTimeSeriesRange = 90 * 24 * time.Hour
+
+// TimeSeriesBucket holds the withdrawals in Currency created in
+// [BucketStart, BucketStart+bucket).
+type TimeSeriesBucket struct {
+    BucketStart time.Time
+    Currency    string
+    Count       int64
+    TotalAmount int64
+}
+
+// GetWithdrawalTimeSeries counts and sums the user's withdrawals created in
+// [from, to) in buckets of bucketMinutes, aligned to from, with a bucket per
+// currency. Only buckets with at least one withdrawal are returned, ordered
+// by BucketStart and then Currency.
+func (s *Store) GetWithdrawalTimeSeries(ctx context.Context, userID int64, from, to time.Time, bucketMinutes int) ([]TimeSeriesBucket, error) {
+    if from.IsZero() || to.IsZero() || !to.After(from) {
+        return nil, fmt.Errorf("%w: from must be before to", ErrInvalidFilter)
+    }
+    if to.Sub(from) > MaxTimeSeriesRange {
+        return nil, fmt.Errorf("%w: range exceeds %s", ErrInvalidFilter, MaxTimeSeriesRange)
+    }
+    if bucketMinutes <= 0 || time.Duration(bucketMinutes)*time.Minute > MaxTimeSeriesRange {
+        return nil, fmt.Errorf("%w: bucket_minutes must be between 1 and %d", ErrInvalidFilter, int(MaxTimeSeriesRange/time.Minute))
+    }
+
+    rows, err := s.pool.Query(ctx, `
+        SELECT date_bin(make_interval(mins => $4), created_at, $2) AS bucket,
+               currency, COUNT(*), COALESCE(SUM(amount), 0)
+        FROM withdrawals
+        WHERE user_id = $1 AND created_at >= $2 AND created_at < $3 AND `+tenantFilter("", 5)+`
+        GROUP BY bucket, currency
+        ORDER BY bucket, currency
+    `, userID, from, to, bucketMinutes, tenantArg(ctx))
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    result := []TimeSeriesBucket{}
+    for rows.Next() {
+        var b TimeSeriesBucket
+        if err := rows.Scan(&b.BucketStart, &b.Currency, &b.Count, &b.TotalAmount); err != nil {
+            return nil, err
+        }
+        b.BucketStart = b.BucketStart.UTC()
+        result = append(result, b)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    return result, nil
+}
@@ -0,0 +1,119 @@
+package store
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+const (
+    StatusPending   = "pending"
+    StatusConfirmed = "confirmed"
+    StatusExpired   = "expired"
+    // StatusReversed marks a confirmed withdrawal undone by
+    // ReverseWithdrawal.
+    StatusReversed = "reversed"
+)
+
+// Statuses lists every withdrawal status, in lifecycle order.
+var Statuses = []string{StatusPending, StatusConfirmed, StatusExpired, StatusReversed}
+
+// transitions is the single source of truth for withdrawal status changes:
+// a withdrawal may only move from a status to one listed for it. Statuses
+// without an entry are terminal.
+var transitions = map[string][]string{
+    StatusPending:   {StatusConfirmed, StatusExpired},
+    StatusConfirmed: {StatusReversed},
+}
+
+// TransitionError is returned when a withdrawal cannot move from From to To.
+// It matches ErrInvalidStatus.
+type TransitionError struct {
+    From string
+    To   string
+}
+
+func (e *TransitionError) Error() string {
+    return fmt.Sprintf("%v: %s -> %s", ErrInvalidStatus, e.From, e.To)
+}
+
+func (e *TransitionError) Unwrap() error {
+    return ErrInvalidStatus
+}
+
+// ValidateTransition reports whether a withdrawal in status from may move to
+// status to. Store methods call it before every status update.
+func ValidateTransition(from, to string) error {
+    for _, next := range transitions[from] {
+        if next == to {
+            return nil
+        }
+    }
+    return &TransitionError{From: from, To: to}
+}
+
+// IsTerminal reports whether no transition leaves status.
+func IsTerminal(status string) bool {
+    return len(transitions[status]) == 0
+}
+
+// Transitions returns a copy of the transition table, so that documentation
+// and tests can be checked against it.
+func Transitions() map[string][]string {
+    out := make(map[string][]string, len(transitions))
+    for from, to := range transitions {
+        out[from] = append([]string(nil), to...)
+    }
+    return out
+}
+
+// lockWithdrawal reads withdrawal id under a row lock held until tx ends.
+// Every status change starts here, so that it decides on the status as of the
+// lock rather than a snapshot taken before a concurrent transition committed.
+func lockWithdrawal(ctx context.Context, tx pgx.Tx, id int64) (Withdrawal, error) {
+    w, err := scanWithdrawal(tx.QueryRow(ctx, `
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE id = $1
+        FOR UPDATE
+    `, id))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return Withdrawal{}, ErrNotFound
+        }
+        return Withdrawal{}, err
+    }
+    if err := checkTenant(ctx, w.TenantID); err != nil {
+        return Withdrawal{}, err
+    }
+    return w, nil
+}
+
+// transitionWithdrawal moves w, read under lock, to status to at now and
+// bumps its version. set holds extra assignments for the UPDATE, which may
+// use now as $4, with args bound from $5. The update only applies while the
+// row is still at w.Version; if another update got there first it returns a
+// TransitionError from the status it left behind.
+func transitionWithdrawal(ctx context.Context, tx pgx.Tx, w Withdrawal, to string, now time.Time, set string, args ...any) (Withdrawal, error) {
+    if err := ValidateTransition(w.Status, to); err != nil {
+        return Withdrawal{}, err
+    }
+    if set != "" {
+        set = ", " + set
+    }
+    updated, err := scanWithdrawal(tx.QueryRow(ctx, `
+        UPDATE withdrawals SET status = $1, updated_at = $4, version = version + 1`+set+`
+        WHERE id = $2 AND version = $3
+        RETURNING `+withdrawalColumns, append([]any{to, w.ID, w.Version, now}, args...)...))
+    if errors.Is(err, pgx.ErrNoRows) {
+        var current string
+        if err := tx.QueryRow(ctx, "SELECT status FROM withdrawals WHERE id = $1", w.ID).Scan(&current); err != nil {
+            return Withdrawal{}, err
+        }
+        return Withdrawal{}, &TransitionError{From: current, To: to}
+    }
+    return updated, err
+}
@@ -0,0 +1,93 @@
+package store_test
+
+import (
+    "errors"
+    "os"
+    "path/filepath"
+    "regexp"
+    "slices"
+    "strings"
+    "testing"
+    "testing/quick"
+
+    "task.hh/internal/store"
+)
+
+func TestTransitionTableCoversStatuses(t *testing.T) {
+    table := store.Transitions()
+    for from, targets := range table {
+        if !slices.Contains(store.Statuses, from) {
+            t.Fatalf("transition from undeclared status %q", from)
+        }
+        for _, to := range targets {
+            if !slices.Contains(store.Statuses, to) {
+                t.Fatalf("transition %s -> undeclared status %q", from, to)
+            }
+        }
+    }
+    for _, status := range store.Statuses {
+        if store.IsTerminal(status) != (len(table[status]) == 0) {
+            t.Fatalf("IsTerminal(%q) disagrees with the transition table", status)
+        }
+    }
+    if store.IsTerminal(store.StatusPending) || !store.IsTerminal(store.StatusExpired) || !store.IsTerminal(store.StatusReversed) {
+        t.Fatalf("unexpected terminal statuses")
+    }
+}
+
+// TestTransitionSequences drives a withdrawal through arbitrary sequences of
+// requested status changes, as the API would with confirm, expiry and
+// reversal calls, and checks that only transitions from the table succeed.
+func TestTransitionSequences(t *testing.T) {
+    table := store.Transitions()
+    property := func(steps []uint8) bool {
+        status := store.StatusPending
+        for _, step := range steps {
+            to := store.Statuses[int(step)%len(store.Statuses)]
+            err := store.ValidateTransition(status, to)
+            allowed := slices.Contains(table[status], to)
+            if allowed != (err == nil) {
+                return false
+            }
+            if err != nil {
+                var te *store.TransitionError
+                if !errors.Is(err, store.ErrInvalidStatus) || !errors.As(err, &te) || te.From != status || te.To != to {
+                    return false
+                }
+                continue
+            }
+            status = to
+        }
+        return slices.Contains(store.Statuses, status)
+    }
+    if err := quick.Check(property, nil); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := store.ValidateTransition(store.StatusConfirmed, store.StatusConfirmed); err == nil {
+        t.Fatalf("expected a self-transition to be rejected")
+    }
+    if err := store.ValidateTransition("unknown", store.StatusConfirmed); err == nil {
+        t.Fatalf("expected a transition from an unknown status to be rejected")
+    }
+}
+
+func TestSchemaStatusesMatch(t *testing.T) {
+    data, err := os.ReadFile(filepath.Join("..", "..", "schema.sql"))
+    if err != nil {
+        t.Fatalf("read schema: %v", err)
+    }
+    checks := regexp.MustCompile(`CHECK \(status IN \(([^)]*)\)\)`).FindAllStringSubmatch(string(data), -1)
+    if len(checks) == 0 {
+        t.Fatalf("no status check found in schema.sql")
+    }
+    for _, check := range checks {
+        var statuses []string
+        for _, s := range strings.Split(check[1], ",") {
+            statuses = append(statuses, strings.Trim(strings.TrimSpace(s), "'"))
+        }
+        if !slices.Equal(statuses, store.Statuses) {
+            t.Fatalf("schema.sql allows %v, store declares %v", statuses, store.Statuses)
+        }
+    }
+}
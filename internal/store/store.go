@@ -3,33 +3,236 @@ package store
 import (
     "context"
     "errors"
+    "fmt"
+    "strings"
+    "time"
 
     "github.com/jackc/pgx/v5"
     "github.com/jackc/pgx/v5/pgconn"
     "github.com/jackc/pgx/v5/pgxpool"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/trace"
 )
 
 type Store struct {
-    pool *pgxpool.Pool
+    pool  *pgxpool.Pool
+    clock Clock
+
+    maxPendingWithdrawals    int
+    feePolicies              map[string]FeePolicy
+    feeExemptTiers           map[string]bool
+    reservationTTL           time.Duration
+    countCap                 int64
+    openingLedgerEntries     bool
+    idempotencyCache         *idempotencyCache
+    auditSink                AuditSink
+    canonicalIdempotencyKeys bool
+    supportedCurrencies      []string
+    settlementLedgerEntries  bool
+
+    // skipIdempotencyPrecheck makes createWithdrawal always look the key up
+    // under the user's row lock. Only benchmarks set it.
+    skipIdempotencyPrecheck bool
+}
+
+type Option func(*Store)
+
+// WithMaxPendingWithdrawals rejects new withdrawals for a user that already
+// has n pending ones. Zero disables the limit.
+func WithMaxPendingWithdrawals(n int) Option {
+    return func(s *Store) {
+        s.maxPendingWithdrawals = n
+    }
+}
+
+func New(pool *pgxpool.Pool, opts ...Option) *Store {
+    s := &Store{pool: pool, clock: realClock{}, countCap: DefaultCountCap, supportedCurrencies: []string{BalanceCurrency}}
+    for _, opt := range opts {
+        opt(s)
+    }
+    return s
+}
+
+// Features reports which optional behaviours the options turned on, for
+// checking a deployment's effective configuration.
+func (s *Store) Features() map[string]bool {
+    return map[string]bool{
+        "withdrawal_fees":            len(s.feePolicies) > 0,
+        "pending_limit":              s.maxPendingWithdrawals > 0,
+        "reservation_expiry":         s.reservationTTL > 0,
+        "opening_ledger_entries":     s.openingLedgerEntries,
+        "idempotency_cache":          s.idempotencyCache != nil,
+        "balance_audit":              s.auditSink != nil,
+        "canonical_idempotency_keys": s.canonicalIdempotencyKeys,
+        "settlement_ledger_entries":  s.settlementLedgerEntries,
+    }
+}
+
+// WithTx runs fn in a transaction, committing when fn returns nil. The
+// transaction is rolled back when fn returns an error or panics; the panic is
+// not recovered.
+func (s *Store) WithTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+    return withTx(ctx, s.pool, fn)
+}
+
+type txBeginner interface {
+    BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+// withTx is WithTx on db, which is the pool or a connection acquired from it.
+func withTx(ctx context.Context, db txBeginner, fn func(tx pgx.Tx) error) error {
+    tx, err := db.BeginTx(ctx, pgx.TxOptions{})
+    if err != nil {
+        return err
+    }
+    defer func() {
+        _ = tx.Rollback(ctx)
+    }()
+
+    if err := fn(tx); err != nil {
+        return err
+    }
+    return tx.Commit(ctx)
 }
 
-func New(pool *pgxpool.Pool) *Store {
-    return &Store{pool: pool}
+type querier interface {
+    Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+    Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+    QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
-func (s *Store) CreateUser(ctx context.Context, id int64, balance int64) (User, error) {
+const withdrawalColumns = "id, user_id, amount, fee, currency, destination, status, idempotency_key, reserved_until, created_at, updated_at, confirmed_at, note_count, reversal_reason, tenant_id, version, created_by_key, external_tx_hash"
+
+// prefixColumns qualifies each of the comma-separated columns with alias,
+// for queries that join tables sharing column names.
+func prefixColumns(alias, columns string) string {
+    cols := strings.Split(columns, ", ")
+    for i, c := range cols {
+        cols[i] = alias + "." + c
+    }
+    return strings.Join(cols, ", ")
+}
+
+func scanWithdrawal(row pgx.Row) (Withdrawal, error) {
+    var w Withdrawal
+    err := row.Scan(withdrawalDest(&w)...)
+    return w, err
+}
+
+// withdrawalDest lists the scan targets for withdrawalColumns, so queries
+// selecting more columns can append their own.
+func withdrawalDest(w *Withdrawal) []any {
+    return []any{
+        &w.ID,
+        &w.UserID,
+        &w.Amount,
+        &w.Fee,
+        &w.Currency,
+        &w.Destination,
+        &w.Status,
+        &w.IdempotencyKey,
+        &w.ReservedUntil,
+        &w.CreatedAt,
+        &w.UpdatedAt,
+        &w.ConfirmedAt,
+        &w.NoteCount,
+        &w.ReversalReason,
+        &w.TenantID,
+        &w.Version,
+        &w.CreatedByKey,
+        &w.ExternalTxHash,
+    }
+}
+
+const userColumns = "id, balance, tier, external_id, created_at, updated_at, tenant_id, overdraft_limit"
+
+func scanUser(row pgx.Row) (User, error) {
     var u User
-    err := s.pool.QueryRow(ctx, `
-        INSERT INTO users (id, balance)
-        VALUES ($1, $2)
-        RETURNING id, balance, created_at
-    `, id, balance).Scan(
+    err := row.Scan(
         &u.ID,
         &u.Balance,
+        &u.Tier,
+        &u.ExternalID,
         &u.CreatedAt,
+        &u.UpdatedAt,
+        &u.TenantID,
+        &u.OverdraftLimit,
     )
+    return u, err
+}
+
+func collectWithdrawals(rows pgx.Rows) ([]Withdrawal, error) {
+    defer rows.Close()
+
+    withdrawals := []Withdrawal{}
+    for rows.Next() {
+        w, err := scanWithdrawal(rows)
+        if err != nil {
+            return nil, err
+        }
+        withdrawals = append(withdrawals, w)
+    }
+    return withdrawals, rows.Err()
+}
+
+var requiredTables = []string{"users", "currencies", "withdrawals", "ledger_entries", "audit_log", "blacklisted_destinations", "balance_audit", "ledger_entries_archive", "withdrawal_notes", "daily_summaries", "maintenance_mode"}
+
+// CheckSchema verifies that the tables the store queries exist, so a missing
+// migration fails at startup instead of on the first request.
+func (s *Store) CheckSchema(ctx context.Context) error {
+    for _, table := range requiredTables {
+        rows, err := s.pool.Query(ctx, "SELECT 1 FROM "+table+" LIMIT 0")
+        if err != nil {
+            return fmt.Errorf("%w: table %s: %v", ErrSchemaMissing, table, err)
+        }
+        rows.Close()
+        if err := rows.Err(); err != nil {
+            return fmt.Errorf("%w: table %s: %v", ErrSchemaMissing, table, err)
+        }
+    }
+    return nil
+}
+
+// CreateUser inserts a user. externalID is optional; it returns
+// ErrExternalIDExists when another user already has it. With
+// WithOpeningLedgerEntries a positive balance is also recorded as a credit
+// ledger entry in the same transaction.
+func (s *Store) CreateUser(ctx context.Context, id int64, balance int64, externalID *string) (User, error) {
+    now := s.now()
+    opening := s.openingLedgerEntries && balance > 0
+    if !opening && !auditing(ctx) {
+        return createUser(ctx, s.pool, id, balance, externalID, now)
+    }
+    var u User
+    err := s.WithTx(ctx, func(tx pgx.Tx) error {
+        var err error
+        if u, err = createUser(ctx, tx, id, balance, externalID, now); err != nil {
+            return err
+        }
+        if opening {
+            if err := insertOpeningEntries(ctx, tx, []User{u}, now); err != nil {
+                return err
+            }
+        }
+        return recordAudit(ctx, tx, u, now)
+    })
+    if err != nil {
+        return User{}, err
+    }
+    return u, nil
+}
+
+func createUser(ctx context.Context, q querier, id int64, balance int64, externalID *string, now time.Time) (User, error) {
+    u, err := scanUser(q.QueryRow(ctx, `
+        INSERT INTO users (id, balance, external_id, tenant_id, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $5)
+        RETURNING `+userColumns, id, balance, externalID, tenantOf(ctx), now))
     if err != nil {
         if isUniqueViolation(err) {
+            var pgErr *pgconn.PgError
+            if errors.As(err, &pgErr) && pgErr.ConstraintName == "idx_users_external_id" {
+                return User{}, ErrExternalIDExists
+            }
             return User{}, ErrUserExists
         }
         return User{}, err
@@ -37,201 +240,685 @@ func (s *Store) CreateUser(ctx context.Context, id int64, balance int64) (User,
     return u, nil
 }
 
-func (s *Store) CreateWithdrawal(ctx context.Context, input CreateWithdrawalInput) (Withdrawal, error) {
-    tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+type NewUser struct {
+    ID      int64
+    Balance int64
+}
+
+type CreateUserResult struct {
+    User User
+    Err  error
+}
+
+// CreateUsers inserts users in a single statement and transaction. Ids that
+// already exist, or repeat an earlier item in the batch, get ErrUserExists in
+// their result without affecting the rest. Results follow the input order.
+func (s *Store) CreateUsers(ctx context.Context, users []NewUser) ([]CreateUserResult, error) {
+    if !s.openingLedgerEntries && !auditing(ctx) {
+        return createUsers(ctx, s.pool, users, false, s.now())
+    }
+    var results []CreateUserResult
+    err := s.WithTx(ctx, func(tx pgx.Tx) error {
+        var err error
+        results, err = createUsers(ctx, tx, users, s.openingLedgerEntries, s.now())
+        return err
+    })
     if err != nil {
-        return Withdrawal{}, err
+        return nil, err
     }
-    defer func() {
-        _ = tx.Rollback(ctx)
-    }()
+    return results, nil
+}
+
+// CreateUsersAtomic creates every user or none. The first item that
+// CreateUsers would have rejected rolls the batch back and is reported as a
+// *BatchItemError. Inserted rows stay locked until the whole batch commits,
+// so concurrent writers to the same ids wait longer than with CreateUsers.
+func (s *Store) CreateUsersAtomic(ctx context.Context, users []NewUser) ([]User, error) {
+    var created []User
+    err := s.WithTx(ctx, func(tx pgx.Tx) error {
+        results, err := createUsers(ctx, tx, users, s.openingLedgerEntries, s.now())
+        if err != nil {
+            return err
+        }
+        created = make([]User, 0, len(results))
+        for i, res := range results {
+            if res.Err != nil {
+                return &BatchItemError{Index: i, Err: res.Err}
+            }
+            created = append(created, res.User)
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return created, nil
+}
+
+// createUsers inserts users created at now, writing opening ledger entries
+// for the created ones when opening is set. The created ones are audited
+// together, if any were.
+func createUsers(ctx context.Context, q querier, users []NewUser, opening bool, now time.Time) ([]CreateUserResult, error) {
+    ids := make([]int64, len(users))
+    balances := make([]int64, len(users))
+    for i, u := range users {
+        ids[i] = u.ID
+        balances[i] = u.Balance
+    }
+
+    rows, err := q.Query(ctx, `
+        INSERT INTO users (id, balance, tenant_id, created_at, updated_at)
+        SELECT id, balance, $3::bigint, $4::timestamptz, $4::timestamptz FROM unnest($1::bigint[], $2::bigint[]) AS t(id, balance)
+        ON CONFLICT (id) DO NOTHING
+        RETURNING `+userColumns, ids, balances, tenantOf(ctx), now)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+
+    created := make(map[int64]User, len(users))
+    inserted := make([]User, 0, len(users))
+    for rows.Next() {
+        u, err := scanUser(rows)
+        if err != nil {
+            return nil, err
+        }
+        created[u.ID] = u
+        inserted = append(inserted, u)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, err
+    }
+    if opening {
+        if err := insertOpeningEntries(ctx, q, inserted, now); err != nil {
+            return nil, err
+        }
+    }
+    if len(inserted) > 0 {
+        if err := recordAudit(ctx, q, inserted, now); err != nil {
+            return nil, err
+        }
+    }
+
+    results := make([]CreateUserResult, len(users))
+    for i, u := range users {
+        if user, ok := created[u.ID]; ok {
+            results[i].User = user
+            delete(created, u.ID)
+            continue
+        }
+        results[i].Err = ErrUserExists
+    }
+    return results, nil
+}
 
-    var balance int64
-    err = tx.QueryRow(ctx, "SELECT balance FROM users WHERE id = $1 FOR UPDATE", input.UserID).Scan(&balance)
+func (s *Store) GetUserByExternalID(ctx context.Context, externalID string) (User, error) {
+    u, err := scanUser(s.pool.QueryRow(ctx, `
+        SELECT `+userColumns+`
+        FROM users
+        WHERE external_id = $1
+    `, externalID))
     if err != nil {
         if errors.Is(err, pgx.ErrNoRows) {
-            return Withdrawal{}, ErrUserNotFound
+            return User{}, ErrUserNotFound
         }
-        return Withdrawal{}, err
+        return User{}, err
+    }
+    if err := checkTenant(ctx, u.TenantID); err != nil {
+        return User{}, err
     }
+    return u, nil
+}
 
-    existing, err := getWithdrawalByIdempotency(ctx, tx, input.UserID, input.IdempotencyKey)
-    if err == nil {
-        if !samePayload(existing, input) {
-            return Withdrawal{}, ErrIdempotencyConflict
+func (s *Store) GetUser(ctx context.Context, id int64) (User, error) {
+    u, err := scanUser(s.pool.QueryRow(ctx, `
+        SELECT `+userColumns+`
+        FROM users
+        WHERE id = $1
+    `, id))
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return User{}, ErrUserNotFound
         }
-        return existing, nil
+        return User{}, err
     }
-    if !errors.Is(err, pgx.ErrNoRows) {
-        return Withdrawal{}, err
+    if err := checkTenant(ctx, u.TenantID); err != nil {
+        return User{}, err
     }
+    return u, nil
+}
 
-    if balance < input.Amount {
-        return Withdrawal{}, ErrInsufficientBalance
+// GetUserBalance returns the user's current balance without reading the rest
+// of the row or taking a lock, for callers that only need the balance.
+func (s *Store) GetUserBalance(ctx context.Context, id int64) (int64, error) {
+    var (
+        balance int64
+        tenant  TenantID
+    )
+    err := s.pool.QueryRow(ctx, "SELECT balance, tenant_id FROM users WHERE id = $1", id).Scan(&balance, &tenant)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return 0, ErrUserNotFound
+        }
+        return 0, err
+    }
+    if err := checkTenant(ctx, tenant); err != nil {
+        return 0, err
     }
+    return balance, nil
+}
 
-    created, err := insertWithdrawal(ctx, tx, input)
+func (s *Store) UpdateUserTier(ctx context.Context, id int64, tier string) (User, error) {
+    if !validTier(tier) {
+        return User{}, ErrInvalidTier
+    }
+    var u User
+    now := s.now()
+    err := s.withAuditTx(ctx, func(q querier) error {
+        if err := authorizeUser(ctx, q, id); err != nil {
+            return err
+        }
+        var err error
+        u, err = scanUser(q.QueryRow(ctx, `
+            UPDATE users SET tier = $2, updated_at = $3
+            WHERE id = $1
+            RETURNING `+userColumns, id, tier, now))
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrUserNotFound
+        }
+        if err != nil {
+            return err
+        }
+        return recordAudit(ctx, q, u, now)
+    })
     if err != nil {
-        if isUniqueViolation(err) {
-            existing, gerr := getWithdrawalByIdempotency(ctx, tx, input.UserID, input.IdempotencyKey)
-            if gerr == nil {
-                if !samePayload(existing, input) {
-                    return Withdrawal{}, ErrIdempotencyConflict
-                }
-                return existing, nil
+        return User{}, err
+    }
+    return u, nil
+}
+
+func validTier(tier string) bool {
+    for _, t := range Tiers {
+        if t == tier {
+            return true
+        }
+    }
+    return false
+}
+
+func (s *Store) CreateWithdrawal(ctx context.Context, input CreateWithdrawalInput) (created CreateWithdrawalResult, err error) {
+    ctx, span := tracer.Start(ctx, "store.CreateWithdrawal", trace.WithAttributes(
+        attribute.Int64("user_id", input.UserID),
+    ))
+    defer func() {
+        if err == nil {
+            span.SetAttributes(attribute.Int64("withdrawal_id", created.ID))
+        }
+        endSpan(span, err)
+    }()
+
+    if input, err = s.NormalizeWithdrawalInput(input); err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+    if s.idempotencyCache != nil {
+        if cached, ok := s.idempotencyCache.get(input.UserID, input.IdempotencyKey, s.now()); ok {
+            return s.replayCached(ctx, cached, input)
+        }
+    }
+
+    err = retryOnSerializationFailure(ctx, func() error {
+        var err error
+        created, err = s.createWithdrawal(ctx, input)
+        return err
+    }, defaultMaxAttempts)
+    if err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+    if s.idempotencyCache != nil {
+        s.idempotencyCache.put(created.Withdrawal, s.now())
+    }
+    return created, nil
+}
+
+// createWithdrawal checks for the idempotency key without a lock first. A key
+// that exists is read again under the user's row lock and replayed; a new one
+// goes straight to the checks and the insert, whose conflict clause catches a
+// concurrent request that created the key in between.
+//
+// The pre-check and the transaction share one connection. Left to the pool
+// they may run on different ones, and behind a proxy that sends reads to a
+// replica the pre-check could miss a withdrawal the primary just committed.
+func (s *Store) createWithdrawal(ctx context.Context, input CreateWithdrawalInput) (CreateWithdrawalResult, error) {
+    conn, err := s.pool.Acquire(ctx)
+    if err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+    defer conn.Release()
+
+    lookupKey := true
+    if !s.skipIdempotencyPrecheck {
+        exists, err := withdrawalExistsByIdempotency(ctx, conn, input.UserID, input.IdempotencyKey)
+        if err != nil {
+            return CreateWithdrawalResult{}, err
+        }
+        lookupKey = exists
+    }
+
+    var result CreateWithdrawalResult
+    err = withTx(ctx, conn, func(tx pgx.Tx) error {
+        var err error
+        result, err = s.createWithdrawalTx(ctx, tx, input, lookupKey)
+        return err
+    })
+    return result, err
+}
+
+func (s *Store) createWithdrawalTx(ctx context.Context, tx pgx.Tx, input CreateWithdrawalInput, lookupKey bool) (CreateWithdrawalResult, error) {
+    var (
+        balance   int64
+        overdraft int64
+        tier      string
+        tenant    TenantID
+    )
+    now := s.now()
+    err := tx.QueryRow(ctx, "SELECT balance, overdraft_limit, tier, tenant_id FROM users WHERE id = $1 FOR UPDATE", input.UserID).Scan(&balance, &overdraft, &tier, &tenant)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return CreateWithdrawalResult{}, ErrUserNotFound
+        }
+        return CreateWithdrawalResult{}, err
+    }
+    if err := checkTenant(ctx, tenant); err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+
+    if lookupKey {
+        existing, err := getWithdrawalByIdempotency(ctx, tx, input.UserID, input.IdempotencyKey)
+        if err == nil {
+            return replayWithdrawal(existing, input, balance)
+        }
+        if !errors.Is(err, pgx.ErrNoRows) {
+            return CreateWithdrawalResult{}, err
+        }
+    }
+    // reject fails a check. Without the lookup above, a concurrent request
+    // may have created the key since the pre-check, and then its withdrawal
+    // is the answer rather than the error.
+    reject := func(rejection error) (CreateWithdrawalResult, error) {
+        if !lookupKey {
+            existing, err := getWithdrawalByIdempotency(ctx, tx, input.UserID, input.IdempotencyKey)
+            if err == nil {
+                return replayWithdrawal(existing, input, balance)
+            }
+            if !errors.Is(err, pgx.ErrNoRows) {
+                return CreateWithdrawalResult{}, err
             }
         }
-        return Withdrawal{}, err
+        return CreateWithdrawalResult{}, rejection
     }
 
-    _, err = tx.Exec(ctx, "UPDATE users SET balance = balance - $1 WHERE id = $2", input.Amount, input.UserID)
+    blocked, err := isDestinationBlacklisted(ctx, tx, input.Destination)
     if err != nil {
-        return Withdrawal{}, err
+        return CreateWithdrawalResult{}, err
+    }
+    if blocked {
+        return reject(ErrDestinationBlacklisted)
     }
 
-    if err := insertLedgerEntry(ctx, tx, created.ID, input); err != nil {
-        return Withdrawal{}, err
+    fee := s.withdrawalFee(tier, input.Currency, input.Amount)
+    if available := balance + overdraft; available < input.Amount || available-input.Amount < fee {
+        return reject(&InsufficientBalanceError{Balance: balance, Overdraft: overdraft, Requested: input.Amount + fee})
     }
 
-    if err := tx.Commit(ctx); err != nil {
-        return Withdrawal{}, err
+    if s.maxPendingWithdrawals > 0 {
+        pending, err := withdrawalsByUserAndStatus(ctx, tx, input.UserID, StatusPending)
+        if err != nil {
+            return CreateWithdrawalResult{}, err
+        }
+        if len(pending) >= s.maxPendingWithdrawals {
+            return reject(ErrTooManyPending)
+        }
     }
 
-    return created, nil
+    created, err := insertWithdrawal(ctx, tx, input, fee, s.reservedUntil(now), tenant, now)
+    if errors.Is(err, pgx.ErrNoRows) {
+        // A concurrent request committed the same key first. The insert did
+        // not abort the transaction, so the winner's row can be read here.
+        existing, err := getWithdrawalByIdempotency(ctx, tx, input.UserID, input.IdempotencyKey)
+        if err != nil {
+            return CreateWithdrawalResult{}, err
+        }
+        return replayWithdrawal(existing, input, balance)
+    }
+    if err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+
+    before := balance
+    err = tx.QueryRow(ctx, "UPDATE users SET balance = balance - $1, updated_at = $3 WHERE id = $2 RETURNING balance", input.Amount+fee, input.UserID, now).Scan(&balance)
+    if err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+    if err := s.recordBalanceChange(ctx, tx, OperationWithdrawalCreate, created, before, balance, now); err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+
+    if err := insertLedgerEntry(ctx, tx, input.UserID, created.ID, input.Amount, input.Currency, DirectionDebit, now); err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+    if fee > 0 {
+        if err := insertLedgerEntry(ctx, tx, input.UserID, created.ID, fee, input.Currency, DirectionFee, now); err != nil {
+            return CreateWithdrawalResult{}, err
+        }
+    }
+    if err := recordAudit(ctx, tx, created, now); err != nil {
+        return CreateWithdrawalResult{}, err
+    }
+
+    return CreateWithdrawalResult{Withdrawal: created, Balance: balance}, nil
 }
 
 func (s *Store) GetWithdrawal(ctx context.Context, id int64) (Withdrawal, error) {
-    var w Withdrawal
-    err := s.pool.QueryRow(ctx, `
-        SELECT id, user_id, amount, currency, destination, status, idempotency_key, created_at
+    w, err := scanWithdrawal(s.pool.QueryRow(ctx, `
+        SELECT `+withdrawalColumns+`
         FROM withdrawals
         WHERE id = $1
-    `, id).Scan(
-        &w.ID,
-        &w.UserID,
-        &w.Amount,
-        &w.Currency,
-        &w.Destination,
-        &w.Status,
-        &w.IdempotencyKey,
-        &w.CreatedAt,
-    )
+    `, id))
     if err != nil {
         if errors.Is(err, pgx.ErrNoRows) {
             return Withdrawal{}, ErrNotFound
         }
         return Withdrawal{}, err
     }
+    if err := checkTenant(ctx, w.TenantID); err != nil {
+        return Withdrawal{}, err
+    }
     return w, nil
 }
 
-func (s *Store) ConfirmWithdrawal(ctx context.Context, id int64) (Withdrawal, error) {
-    tx, err := s.pool.BeginTx(ctx, pgx.TxOptions{})
+// GetWithdrawalWithUser returns the withdrawal together with its owner's
+// balance and tier, read in one query.
+func (s *Store) GetWithdrawalWithUser(ctx context.Context, id int64) (WithdrawalWithUser, error) {
+    var ww WithdrawalWithUser
+    err := s.pool.QueryRow(ctx, `
+        SELECT `+prefixColumns("w", withdrawalColumns)+`, u.balance, u.tier
+        FROM withdrawals w
+        JOIN users u ON u.id = w.user_id
+        WHERE w.id = $1
+    `, id).Scan(append(withdrawalDest(&ww.Withdrawal), &ww.UserBalance, &ww.UserTier)...)
     if err != nil {
-        return Withdrawal{}, err
+        if errors.Is(err, pgx.ErrNoRows) {
+            return WithdrawalWithUser{}, ErrNotFound
+        }
+        return WithdrawalWithUser{}, err
     }
-    defer func() {
-        _ = tx.Rollback(ctx)
-    }()
+    if err := checkTenant(ctx, ww.TenantID); err != nil {
+        return WithdrawalWithUser{}, err
+    }
+    return ww, nil
+}
 
-    var w Withdrawal
-    err = tx.QueryRow(ctx, `
-        SELECT id, user_id, amount, currency, destination, status, idempotency_key, created_at
+// GetWithdrawalsByIDs returns the withdrawals with the given ids in the order
+// they were requested, skipping repeated ids. Ids that do not exist, or belong
+// to another tenant, are omitted.
+func (s *Store) GetWithdrawalsByIDs(ctx context.Context, ids []int64) ([]Withdrawal, error) {
+    rows, err := s.pool.Query(ctx, `
+        SELECT `+withdrawalColumns+`
         FROM withdrawals
-        WHERE id = $1
-        FOR UPDATE
-    `, id).Scan(
-        &w.ID,
-        &w.UserID,
-        &w.Amount,
-        &w.Currency,
-        &w.Destination,
-        &w.Status,
-        &w.IdempotencyKey,
-        &w.CreatedAt,
+        WHERE id = ANY($1) AND `+tenantFilter("", 2)+`
+    `, ids, tenantArg(ctx))
+    if err != nil {
+        return nil, err
+    }
+    found, err := collectWithdrawals(rows)
+    if err != nil {
+        return nil, err
+    }
+
+    byID := make(map[int64]Withdrawal, len(found))
+    for _, w := range found {
+        byID[w.ID] = w
+    }
+    withdrawals := make([]Withdrawal, 0, len(found))
+    for _, id := range ids {
+        if w, ok := byID[id]; ok {
+            withdrawals = append(withdrawals, w)
+            delete(byID, id)
+        }
+    }
+    return withdrawals, nil
+}
+
+// GetWithdrawalAge returns the number of minutes since the withdrawal was
+// created, measured by the store's clock.
+func (s *Store) GetWithdrawalAge(ctx context.Context, id int64) (float64, error) {
+    var (
+        minutes float64
+        tenant  TenantID
     )
+    err := s.pool.QueryRow(ctx, `
+        SELECT EXTRACT(EPOCH FROM ($2::timestamptz - created_at))::float8 / 60, tenant_id
+        FROM withdrawals
+        WHERE id = $1
+    `, id, s.now()).Scan(&minutes, &tenant)
     if err != nil {
         if errors.Is(err, pgx.ErrNoRows) {
-            return Withdrawal{}, ErrNotFound
+            return 0, ErrNotFound
         }
+        return 0, err
+    }
+    if err := checkTenant(ctx, tenant); err != nil {
+        return 0, err
+    }
+    return minutes, nil
+}
+
+// GetStalePendingWithdrawals returns up to limit pending withdrawals
+// untouched for more than olderThanMinutes, oldest first. A limit of 0 means
+// DefaultListLimit; it may not exceed MaxListLimit. A pending withdrawal's
+// updated_at is its creation time unless a processor bumped it with
+// TouchWithdrawal.
+func (s *Store) GetStalePendingWithdrawals(ctx context.Context, olderThanMinutes float64, limit int) ([]Withdrawal, error) {
+    limit, err := listLimit(limit)
+    if err != nil {
+        return nil, err
+    }
+    rows, err := s.pool.Query(ctx, `
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE status = $1 AND updated_at < $2 AND `+tenantFilter("", 4)+`
+        ORDER BY updated_at, id
+        LIMIT $3
+    `, StatusPending, s.now().Add(-time.Duration(olderThanMinutes*float64(time.Minute))), limit, tenantArg(ctx))
+    if err != nil {
+        return nil, err
+    }
+    return collectWithdrawals(rows)
+}
+
+// GetStuckPendingWithdrawals returns up to limit withdrawals still pending
+// more than olderThan after they were created, oldest first, with limit as in
+// GetStalePendingWithdrawals. Unlike GetStalePendingWithdrawals it ignores
+// TouchWithdrawal, so a withdrawal a processor keeps touching without
+// confirming still shows up.
+func (s *Store) GetStuckPendingWithdrawals(ctx context.Context, olderThan time.Duration, limit int) ([]Withdrawal, error) {
+    limit, err := listLimit(limit)
+    if err != nil {
+        return nil, err
+    }
+    rows, err := s.pool.Query(ctx, `
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE status = $1 AND created_at < $2 AND `+tenantFilter("", 4)+`
+        ORDER BY created_at, id
+        LIMIT $3
+    `, StatusPending, s.now().Add(-olderThan), limit, tenantArg(ctx))
+    if err != nil {
+        return nil, err
+    }
+    return collectWithdrawals(rows)
+}
+
+// TouchWithdrawal bumps updated_at of a pending withdrawal. It returns
+// ErrNotFound when no pending withdrawal has that id.
+func (s *Store) TouchWithdrawal(ctx context.Context, id int64) error {
+    now := s.now()
+    return s.withAuditTx(ctx, func(q querier) error {
+        if err := authorizeWithdrawal(ctx, q, id); err != nil {
+            return err
+        }
+        touched, err := scanWithdrawal(q.QueryRow(ctx, `
+            UPDATE withdrawals SET updated_at = $3, version = version + 1
+            WHERE id = $1 AND status = $2
+            RETURNING `+withdrawalColumns, id, StatusPending, now))
+        if errors.Is(err, pgx.ErrNoRows) {
+            return ErrNotFound
+        }
+        if err != nil {
+            return err
+        }
+        return recordAudit(ctx, q, touched, now)
+    })
+}
+
+func (s *Store) WithdrawalsByUserAndStatus(ctx context.Context, userID int64, status string) ([]Withdrawal, error) {
+    return withdrawalsByUserAndStatus(ctx, s.pool, userID, status)
+}
+
+func withdrawalsByUserAndStatus(ctx context.Context, q querier, userID int64, status string) ([]Withdrawal, error) {
+    rows, err := q.Query(ctx, `
+        SELECT `+withdrawalColumns+`
+        FROM withdrawals
+        WHERE user_id = $1 AND status = $2 AND `+tenantFilter("", 3)+`
+        ORDER BY id
+    `, userID, status, tenantArg(ctx))
+    if err != nil {
+        return nil, err
+    }
+    return collectWithdrawals(rows)
+}
+
+// ConfirmWithdrawal confirms a pending withdrawal; confirming a confirmed one
+// returns it unchanged. It races ReleaseExpiredReservations for holds that run
+// out: both lock the row, and whichever commits first decides the outcome. A
+// confirm that loses, or that finds the hold already run out, returns
+// ErrReservationExpired and leaves the release to the sweeper.
+func (s *Store) ConfirmWithdrawal(ctx context.Context, id int64) (Withdrawal, error) {
+    return s.confirmWithdrawal(ctx, id, 0)
+}
+
+// ConfirmWithdrawalIfVersion is ConfirmWithdrawal for a caller that last saw
+// the withdrawal at version. If it has been updated since, nothing changes
+// and a *VersionConflictError carrying the current withdrawal is returned.
+func (s *Store) ConfirmWithdrawalIfVersion(ctx context.Context, id, version int64) (Withdrawal, error) {
+    return s.confirmWithdrawal(ctx, id, version)
+}
+
+// confirmWithdrawal confirms withdrawal id; a version of 0 skips the version
+// check.
+func (s *Store) confirmWithdrawal(ctx context.Context, id, version int64) (confirmed Withdrawal, err error) {
+    ctx, span := tracer.Start(ctx, "store.ConfirmWithdrawal", trace.WithAttributes(
+        attribute.Int64("withdrawal_id", id),
+    ))
+    defer func() {
+        if err == nil {
+            span.SetAttributes(attribute.Int64("user_id", confirmed.UserID))
+        }
+        endSpan(span, err)
+    }()
+
+    err = s.WithTx(ctx, func(tx pgx.Tx) error {
+        var err error
+        confirmed, err = s.confirmWithdrawalTx(ctx, tx, id, version, s.now())
+        return err
+    })
+    return confirmed, err
+}
+
+func (s *Store) confirmWithdrawalTx(ctx context.Context, tx pgx.Tx, id, version int64, now time.Time) (Withdrawal, error) {
+    w, err := lockWithdrawal(ctx, tx, id)
+    if err != nil {
         return Withdrawal{}, err
     }
+    if err := checkOriginKey(ctx, w.CreatedByKey); err != nil {
+        return Withdrawal{}, err
+    }
+    if version != 0 && w.Version != version {
+        return Withdrawal{}, &VersionConflictError{Current: w}
+    }
 
     if w.Status == StatusConfirmed {
-        if err := tx.Commit(ctx); err != nil {
-            return Withdrawal{}, err
-        }
         return w, nil
     }
 
-    if w.Status != StatusPending {
-        return Withdrawal{}, ErrInvalidStatus
+    if w.Status == StatusExpired || (w.Status == StatusPending && reservationExpired(w, now)) {
+        return Withdrawal{}, ErrReservationExpired
     }
 
-    _, err = tx.Exec(ctx, "UPDATE withdrawals SET status = $1 WHERE id = $2", StatusConfirmed, id)
+    confirmed, err := transitionWithdrawal(ctx, tx, w, StatusConfirmed, now, "confirmed_at = $4")
     if err != nil {
         return Withdrawal{}, err
     }
-    w.Status = StatusConfirmed
-
-    if err := tx.Commit(ctx); err != nil {
+    if s.settlementLedgerEntries {
+        if err := insertSettlementEntry(ctx, tx, confirmed, now); err != nil {
+            return Withdrawal{}, err
+        }
+    }
+    if s.auditSink != nil {
+        // Confirming settles the amount reserved at creation, so the
+        // balance is recorded unchanged.
+        var balance int64
+        if err := tx.QueryRow(ctx, "SELECT balance FROM users WHERE id = $1", w.UserID).Scan(&balance); err != nil {
+            return Withdrawal{}, err
+        }
+        if err := s.recordBalanceChange(ctx, tx, OperationWithdrawalConfirm, confirmed, balance, balance, now); err != nil {
+            return Withdrawal{}, err
+        }
+    }
+    if err := recordAudit(ctx, tx, confirmed, now); err != nil {
         return Withdrawal{}, err
     }
-
-    return w, nil
+    return confirmed, nil
 }
 
-func insertWithdrawal(ctx context.Context, tx pgx.Tx, input CreateWithdrawalInput) (Withdrawal, error) {
-    var w Withdrawal
-    err := tx.QueryRow(ctx, `
-        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
-        VALUES ($1, $2, $3, $4, $5, $6)
-        RETURNING id, user_id, amount, currency, destination, status, idempotency_key, created_at
-    `,
+func insertWithdrawal(ctx context.Context, tx pgx.Tx, input CreateWithdrawalInput, fee int64, reservedUntil *time.Time, tenant TenantID, now time.Time) (Withdrawal, error) {
+    return scanWithdrawal(tx.QueryRow(ctx, `
+        INSERT INTO withdrawals (user_id, amount, fee, currency, destination, status, idempotency_key, reserved_until, tenant_id, created_by_key, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $11)
+        ON CONFLICT (user_id, idempotency_key) DO NOTHING
+        RETURNING `+withdrawalColumns,
         input.UserID,
         input.Amount,
+        fee,
         input.Currency,
         input.Destination,
         StatusPending,
         input.IdempotencyKey,
-    ).Scan(
-        &w.ID,
-        &w.UserID,
-        &w.Amount,
-        &w.Currency,
-        &w.Destination,
-        &w.Status,
-        &w.IdempotencyKey,
-        &w.CreatedAt,
-    )
-    return w, err
+        reservedUntil,
+        tenant,
+        input.CreatedByKey,
+        now,
+    ))
 }
 
-func insertLedgerEntry(ctx context.Context, tx pgx.Tx, withdrawalID int64, input CreateWithdrawalInput) error {
+func insertLedgerEntry(ctx context.Context, tx pgx.Tx, userID, withdrawalID, amount int64, currency, direction string, now time.Time) error {
     _, err := tx.Exec(ctx, `
-        INSERT INTO ledger_entries (user_id, withdrawal_id, amount, currency, direction)
-        VALUES ($1, $2, $3, $4, $5)
-    `, input.UserID, withdrawalID, input.Amount, input.Currency, DirectionDebit)
+        INSERT INTO ledger_entries (user_id, withdrawal_id, amount, currency, direction, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+    `, userID, withdrawalID, amount, currency, direction, now)
     return err
 }
 
 func getWithdrawalByIdempotency(ctx context.Context, tx pgx.Tx, userID int64, key string) (Withdrawal, error) {
-    var w Withdrawal
-    err := tx.QueryRow(ctx, `
-        SELECT id, user_id, amount, currency, destination, status, idempotency_key, created_at
-        FROM withdrawals
-        WHERE user_id = $1 AND idempotency_key = $2
-    `, userID, key).Scan(
-        &w.ID,
-        &w.UserID,
-        &w.Amount,
-        &w.Currency,
-        &w.Destination,
-        &w.Status,
-        &w.IdempotencyKey,
-        &w.CreatedAt,
-    )
-    return w, err
+    return scanWithdrawal(checkIdempotency(ctx, tx, withdrawalScope, userID, key))
+}
+
+func replayWithdrawal(existing Withdrawal, input CreateWithdrawalInput, balance int64) (CreateWithdrawalResult, error) {
+    if !samePayload(existing, input) {
+        return CreateWithdrawalResult{}, &IdempotencyConflictError{Existing: existing}
+    }
+    existing.Replayed = true
+    return CreateWithdrawalResult{Withdrawal: existing, Balance: balance}, nil
 }
 
 func samePayload(w Withdrawal, input CreateWithdrawalInput) bool {
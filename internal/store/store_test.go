@@ -0,0 +1,2808 @@
+package store_test
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "task.hh/internal/store"
+    "task.hh/internal/store/storetest"
+    "task.hh/internal/testutil"
+)
+
+func setupStore(t testing.TB, opts ...store.Option) (*store.Store, *pgxpool.Pool) {
+    t.Helper()
+
+    if testDatabaseURL == "" {
+        t.Skip("DATABASE_URL is not set and no Docker host is available")
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    pool, err := pgxpool.New(ctx, testDatabaseURL)
+    if err != nil {
+        t.Fatalf("db connection: %v", err)
+    }
+    t.Cleanup(pool.Close)
+
+    applySchema(t, pool)
+    if _, err := pool.Exec(ctx, "TRUNCATE audit_log, balance_audit, ledger_entries, ledger_entries_archive, withdrawal_notes, withdrawals, users, blacklisted_destinations, daily_summaries, maintenance_mode RESTART IDENTITY"); err != nil {
+        t.Fatalf("reset db: %v", err)
+    }
+
+    return store.New(pool, opts...), pool
+}
+
+func exec(t testing.TB, pool *pgxpool.Pool, sql string, args ...any) {
+    t.Helper()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    if _, err := pool.Exec(ctx, sql, args...); err != nil {
+        t.Fatalf("exec %q: %v", sql, err)
+    }
+}
+
+func TestConformance(t *testing.T) {
+    storetest.Run(t, "postgres", func(t *testing.T) storetest.Store {
+        st, _ := setupStore(t)
+        return st
+    })
+}
+
+func TestCheckSchema(t *testing.T) {
+    st, _ := setupStore(t)
+
+    if err := st.CheckSchema(context.Background()); err != nil {
+        t.Fatalf("expected schema to be present, got %v", err)
+    }
+}
+
+func TestCheckSchemaMissingTable(t *testing.T) {
+    st, pool := setupStore(t)
+
+    // The next setupStore applies the schema again.
+    exec(t, pool, "DROP TABLE withdrawal_notes")
+    err := st.CheckSchema(context.Background())
+    if !errors.Is(err, store.ErrSchemaMissing) || !strings.Contains(err.Error(), "withdrawal_notes") {
+        t.Fatalf("expected the missing withdrawal_notes table, got %v", err)
+    }
+}
+
+func TestWithdrawalsByUserAndStatus(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (1, 10, 'USDT', 'a', 'pending', 'k1'),
+               (1, 20, 'USDT', 'a', 'confirmed', 'k2'),
+               (1, 30, 'USDT', 'a', 'pending', 'k3'),
+               (2, 40, 'USDT', 'a', 'pending', 'k4')
+    `)
+
+    pending, err := st.WithdrawalsByUserAndStatus(ctx, 1, store.StatusPending)
+    if err != nil {
+        t.Fatalf("list pending: %v", err)
+    }
+    if len(pending) != 2 || pending[0].Amount != 10 || pending[1].Amount != 30 {
+        t.Fatalf("unexpected pending withdrawals: %+v", pending)
+    }
+
+    confirmed, err := st.WithdrawalsByUserAndStatus(ctx, 1, store.StatusConfirmed)
+    if err != nil {
+        t.Fatalf("list confirmed: %v", err)
+    }
+    if len(confirmed) != 1 || confirmed[0].Amount != 20 {
+        t.Fatalf("unexpected confirmed withdrawals: %+v", confirmed)
+    }
+
+    none, err := st.WithdrawalsByUserAndStatus(ctx, 3, store.StatusPending)
+    if err != nil {
+        t.Fatalf("list unknown user: %v", err)
+    }
+    if len(none) != 0 {
+        t.Fatalf("expected no withdrawals, got %d", len(none))
+    }
+}
+
+func TestWithdrawalAgeAndStalePending(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (id, user_id, amount, currency, destination, status, idempotency_key, created_at)
+        VALUES (1, 1, 10, 'USDT', 'a', 'pending', 'k1', now() - INTERVAL '90 minutes'),
+               (2, 1, 20, 'USDT', 'a', 'confirmed', 'k2', now() - INTERVAL '120 minutes'),
+               (3, 1, 30, 'USDT', 'a', 'pending', 'k3', now() - INTERVAL '10 minutes'),
+               (4, 1, 40, 'USDT', 'a', 'pending', 'k4', now() - INTERVAL '180 minutes'),
+               (5, 1, 50, 'USDT', 'a', 'pending', 'k5', now() - INTERVAL '240 minutes')
+    `)
+    exec(t, pool, "UPDATE withdrawals SET updated_at = created_at")
+    if err := st.TouchWithdrawal(ctx, 5); err != nil {
+        t.Fatalf("touch: %v", err)
+    }
+
+    age, err := st.GetWithdrawalAge(ctx, 1)
+    if err != nil {
+        t.Fatalf("get age: %v", err)
+    }
+    if age < 90 || age > 91 {
+        t.Fatalf("expected age of about 90 minutes, got %f", age)
+    }
+    if _, err := st.GetWithdrawalAge(ctx, 42); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound, got %v", err)
+    }
+
+    stale, err := st.GetStalePendingWithdrawals(ctx, 60, 0)
+    if err != nil {
+        t.Fatalf("get stale: %v", err)
+    }
+    if len(stale) != 2 || stale[0].ID != 4 || stale[1].ID != 1 {
+        t.Fatalf("unexpected stale withdrawals: %+v", stale)
+    }
+    stale, err = st.GetStalePendingWithdrawals(ctx, 60, 1)
+    if err != nil || len(stale) != 1 || stale[0].ID != 4 {
+        t.Fatalf("expected only the oldest stale withdrawal, got %+v (%v)", stale, err)
+    }
+    if _, err := st.GetStalePendingWithdrawals(ctx, 60, store.MaxListLimit+1); !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter for a limit over the maximum, got %v", err)
+    }
+
+    // A touch does not make a withdrawal any less stuck.
+    stuck, err := st.GetStuckPendingWithdrawals(ctx, time.Hour, 0)
+    if err != nil {
+        t.Fatalf("get stuck: %v", err)
+    }
+    if len(stuck) != 3 || stuck[0].ID != 5 || stuck[1].ID != 4 || stuck[2].ID != 1 {
+        t.Fatalf("unexpected stuck withdrawals: %+v", stuck)
+    }
+    stuck, err = st.GetStuckPendingWithdrawals(ctx, time.Hour, 2)
+    if err != nil || len(stuck) != 2 || stuck[1].ID != 4 {
+        t.Fatalf("expected the two oldest stuck withdrawals, got %+v (%v)", stuck, err)
+    }
+    stuck, err = st.GetStuckPendingWithdrawals(ctx, 5*time.Hour, 0)
+    if err != nil || len(stuck) != 0 {
+        t.Fatalf("expected no withdrawals stuck for 5 hours, got %+v (%v)", stuck, err)
+    }
+}
+
+func TestCreateWithdrawalInsufficientBalance(t *testing.T) {
+    st, pool := setupStore(t, store.WithFeePolicies(map[string]store.FeePolicy{
+        "USDT": {BasisPoints: 100, Rounding: store.RoundCeil},
+    }))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 150)")
+    _, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 200, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if !errors.Is(err, store.ErrInsufficientBalance) {
+        t.Fatalf("expected ErrInsufficientBalance, got %v", err)
+    }
+    var insufficient *store.InsufficientBalanceError
+    if !errors.As(err, &insufficient) {
+        t.Fatalf("expected InsufficientBalanceError, got %T", err)
+    }
+    if insufficient.Balance != 150 || insufficient.Requested != 202 || insufficient.Shortfall() != 52 {
+        t.Fatalf("unexpected shortfall: %+v", insufficient)
+    }
+}
+
+func TestCreateWithdrawalIdempotency(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    input := store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1"}
+
+    first, err := st.CreateWithdrawal(ctx, input)
+    if err != nil {
+        t.Fatalf("create: %v", err)
+    }
+    replay, err := st.CreateWithdrawal(ctx, input)
+    if err != nil || !replay.Replayed || replay.ID != first.ID || replay.Balance != 900 {
+        t.Fatalf("expected replay of %d with balance 900, got %+v err=%v", first.ID, replay, err)
+    }
+
+    conflicting := input
+    conflicting.Amount = 200
+    _, err = st.CreateWithdrawal(ctx, conflicting)
+    if !errors.Is(err, store.ErrIdempotencyConflict) {
+        t.Fatalf("expected ErrIdempotencyConflict, got %v", err)
+    }
+    var conflict *store.IdempotencyConflictError
+    if !errors.As(err, &conflict) || conflict.Existing.ID != first.ID || conflict.Existing.Amount != 100 {
+        t.Fatalf("expected the conflict to carry withdrawal %d, got %v", first.ID, err)
+    }
+
+    other := input
+    other.UserID = 2
+    created, err := st.CreateWithdrawal(ctx, other)
+    if err != nil || created.Replayed || created.ID == first.ID {
+        t.Fatalf("expected a new withdrawal for another user, got %+v err=%v", created, err)
+    }
+}
+
+func TestCreateWithdrawalIdempotencyCache(t *testing.T) {
+    st, pool := setupStore(t, store.WithIdempotencyCache(10, time.Minute))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    input := store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1"}
+
+    first, err := st.CreateWithdrawal(ctx, input)
+    if err != nil || first.Replayed {
+        t.Fatalf("create: %+v err=%v", first, err)
+    }
+    if _, err := st.ConfirmWithdrawal(ctx, first.ID); err != nil {
+        t.Fatalf("confirm: %v", err)
+    }
+    exec(t, pool, "UPDATE users SET balance = 500 WHERE id = 1")
+
+    // The cached replay still reports the current status and balance.
+    replay, err := st.CreateWithdrawal(ctx, input)
+    if err != nil || !replay.Replayed || replay.ID != first.ID || replay.Status != store.StatusConfirmed || replay.Balance != 500 {
+        t.Fatalf("expected a confirmed replay of %d with balance 500, got %+v err=%v", first.ID, replay, err)
+    }
+
+    conflicting := input
+    conflicting.Destination = "b"
+    var conflict *store.IdempotencyConflictError
+    if _, err := st.CreateWithdrawal(ctx, conflicting); !errors.As(err, &conflict) || conflict.Existing.ID != first.ID {
+        t.Fatalf("expected an idempotency conflict with %d, got %v", first.ID, err)
+    }
+
+    var count int
+    if err := pool.QueryRow(ctx, "SELECT count(*) FROM withdrawals").Scan(&count); err != nil || count != 1 {
+        t.Fatalf("expected one withdrawal, got %d err=%v", count, err)
+    }
+}
+
+// backendTracer records the backend that ran each query containing one of its
+// markers.
+type backendTracer struct {
+    markers []string
+
+    mu   sync.Mutex
+    pids map[string][]uint32
+}
+
+func (b *backendTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    for _, m := range b.markers {
+        if strings.Contains(data.SQL, m) {
+            b.pids[m] = append(b.pids[m], conn.PgConn().PID())
+        }
+    }
+    return ctx
+}
+
+func (b *backendTracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+func TestCreateWithdrawalIdempotencyPrecheckConnection(t *testing.T) {
+    _, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+
+    const precheck, insert = "SELECT EXISTS (SELECT 1 FROM withdrawals", "INSERT INTO withdrawals"
+    tracer := &backendTracer{markers: []string{precheck, insert}, pids: map[string][]uint32{}}
+    config, err := pgxpool.ParseConfig(testDatabaseURL)
+    if err != nil {
+        t.Fatalf("parse config: %v", err)
+    }
+    config.ConnConfig.Tracer = tracer
+    // Never hand out the connection handed out last, like a pool spreading
+    // queries over a primary and lagging replicas: a pre-check acquired on
+    // its own would then read from another backend than the insert.
+    var last atomic.Uint32
+    config.BeforeAcquire = func(_ context.Context, conn *pgx.Conn) bool {
+        pid := conn.PgConn().PID()
+        return last.Swap(pid) != pid
+    }
+    spread, err := pgxpool.NewWithConfig(ctx, config)
+    if err != nil {
+        t.Fatalf("db connection: %v", err)
+    }
+    t.Cleanup(spread.Close)
+    st := store.New(spread)
+
+    input := store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1"}
+    first, err := st.CreateWithdrawal(ctx, input)
+    if err != nil {
+        t.Fatalf("create: %v", err)
+    }
+    replay, err := st.CreateWithdrawal(ctx, input)
+    if err != nil || !replay.Replayed || replay.ID != first.ID {
+        t.Fatalf("expected replay of %d, got %+v err=%v", first.ID, replay, err)
+    }
+    input.IdempotencyKey = "k2"
+    if _, err := st.CreateWithdrawal(ctx, input); err != nil {
+        t.Fatalf("create: %v", err)
+    }
+
+    checks, inserts := tracer.pids[precheck], tracer.pids[insert]
+    if len(checks) != 3 || len(inserts) != 2 {
+        t.Fatalf("expected 3 pre-checks and 2 inserts, got %d and %d", len(checks), len(inserts))
+    }
+    // The replay in between inserts nothing.
+    if checks[0] != inserts[0] || checks[2] != inserts[1] {
+        t.Fatalf("expected each pre-check on its insert's backend, got pre-checks on %v and inserts on %v", checks, inserts)
+    }
+}
+
+func TestWithTxRollsBackOnPanic(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    func() {
+        defer func() {
+            if recover() == nil {
+                t.Fatalf("expected the panic to propagate")
+            }
+        }()
+        _ = st.WithTx(ctx, func(tx pgx.Tx) error {
+            if _, err := tx.Exec(ctx, "INSERT INTO users (id, balance) VALUES (1, 100)"); err != nil {
+                t.Fatalf("insert user: %v", err)
+            }
+            panic("boom")
+        })
+    }()
+
+    if _, err := st.GetUser(ctx, 1); !errors.Is(err, store.ErrUserNotFound) {
+        t.Fatalf("expected the insert to be rolled back, got %v", err)
+    }
+
+    err := st.WithTx(ctx, func(tx pgx.Tx) error {
+        if _, err := tx.Exec(ctx, "INSERT INTO users (id, balance) VALUES (2, 100)"); err != nil {
+            return err
+        }
+        return errors.New("abort")
+    })
+    if err == nil || err.Error() != "abort" {
+        t.Fatalf("expected fn error to be returned, got %v", err)
+    }
+    var count int
+    if err := pool.QueryRow(ctx, "SELECT count(*) FROM users").Scan(&count); err != nil {
+        t.Fatalf("count users: %v", err)
+    }
+    if count != 0 {
+        t.Fatalf("expected no users, got %d", count)
+    }
+}
+
+func TestTouchWithdrawal(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (id, user_id, amount, currency, destination, status, idempotency_key, updated_at)
+        VALUES (1, 1, 10, 'USDT', 'a', 'pending', 'k1', now() - INTERVAL '1 hour'),
+               (2, 1, 20, 'USDT', 'a', 'confirmed', 'k2', now() - INTERVAL '1 hour')
+    `)
+
+    if err := st.TouchWithdrawal(ctx, 1); err != nil {
+        t.Fatalf("touch pending: %v", err)
+    }
+    w, err := st.GetWithdrawal(ctx, 1)
+    if err != nil {
+        t.Fatalf("get withdrawal: %v", err)
+    }
+    if time.Since(w.UpdatedAt) > time.Minute || w.Status != store.StatusPending || w.Amount != 10 {
+        t.Fatalf("expected only updated_at to change, got %+v", w)
+    }
+
+    if err := st.TouchWithdrawal(ctx, 42); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound for unknown id, got %v", err)
+    }
+    if err := st.TouchWithdrawal(ctx, 2); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound for confirmed withdrawal, got %v", err)
+    }
+}
+
+func TestReverseWithdrawal(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (id, user_id, amount, fee, currency, destination, status, idempotency_key)
+        VALUES (1, 1, 100, 5, 'USDT', 'a', 'confirmed', 'k1'),
+               (2, 1, 20, 0, 'USDT', 'a', 'pending', 'k2')
+    `)
+
+    w, err := st.ReverseWithdrawal(ctx, 1, "chargeback")
+    if err != nil {
+        t.Fatalf("reverse: %v", err)
+    }
+    if w.Status != store.StatusReversed || w.ReversalReason != "chargeback" {
+        t.Fatalf("unexpected withdrawal: %+v", w)
+    }
+    user, err := st.GetUser(ctx, 1)
+    if err != nil {
+        t.Fatalf("get user: %v", err)
+    }
+    if user.Balance != 1100 {
+        t.Fatalf("expected the amount without fee credited back, got balance %d", user.Balance)
+    }
+    _, entries, err := st.GetWithdrawalWithLedger(ctx, 1)
+    if err != nil {
+        t.Fatalf("get ledger: %v", err)
+    }
+    if len(entries) != 1 || entries[0].Direction != store.DirectionCredit || entries[0].Amount != 100 {
+        t.Fatalf("unexpected ledger entries: %+v", entries)
+    }
+
+    for _, id := range []int64{1, 2} {
+        if _, err := st.ReverseWithdrawal(ctx, id, "again"); !errors.Is(err, store.ErrInvalidStatus) {
+            t.Fatalf("withdrawal %d: expected ErrInvalidStatus, got %v", id, err)
+        }
+    }
+    if _, err := st.ReverseWithdrawal(ctx, 42, "missing"); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound, got %v", err)
+    }
+}
+
+func TestCreateWithdrawalInputNormalize(t *testing.T) {
+    valid := store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "addr", IdempotencyKey: "k1"}
+
+    got, err := store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "usdt", Destination: " addr\n", IdempotencyKey: "\tk1 "}.Normalize()
+    if err != nil || got != valid {
+        t.Fatalf("expected %+v, got %+v, %v", valid, got, err)
+    }
+
+    withKey := func(key string) store.CreateWithdrawalInput {
+        in := valid
+        in.IdempotencyKey = key
+        return in
+    }
+    withDestination := func(destination string) store.CreateWithdrawalInput {
+        in := valid
+        in.Destination = destination
+        return in
+    }
+    withCurrency := func(currency string) store.CreateWithdrawalInput {
+        in := valid
+        in.Currency = currency
+        return in
+    }
+    tests := []struct {
+        name   string
+        input  store.CreateWithdrawalInput
+        field  string
+        reason string
+    }{
+        {"blank key", withKey("   "), "idempotency_key", store.ReasonRequired},
+        {"long key", withKey(strings.Repeat("k", store.MaxIdempotencyKeyLength+1)), "idempotency_key", store.ReasonTooLong},
+        {"non-ascii key", withKey("ключ"), "idempotency_key", store.ReasonInvalidCharacters},
+        {"blank destination", withDestination(""), "destination", store.ReasonRequired},
+        {"long destination", withDestination(strings.Repeat("ы", store.MaxDestinationLength+1)), "destination", store.ReasonTooLong},
+        {"destination with a space", withDestination("a b"), "destination", store.ReasonInvalidCharacters},
+        {"destination with a control character", withDestination("a\u0085b"), "destination", store.ReasonInvalidCharacters},
+        {"destination not utf-8", withDestination("a\xffb"), "destination", store.ReasonInvalidCharacters},
+        {"blank currency", withCurrency(""), "currency", store.ReasonRequired},
+        {"padded currency", withCurrency(" USDT"), "currency", store.ReasonInvalidFormat},
+        {"currency with a sign", withCurrency("U$DT"), "currency", store.ReasonInvalidFormat},
+    }
+    for _, tt := range tests {
+        _, err := tt.input.Normalize()
+        var invalid *store.InvalidInputError
+        if !errors.As(err, &invalid) || !errors.Is(err, store.ErrInvalidInput) {
+            t.Fatalf("%s: expected InvalidInputError, got %v", tt.name, err)
+        }
+        if len(invalid.Fields) != 1 || invalid.Fields[0] != (store.FieldError{Field: tt.field, Reason: tt.reason}) {
+            t.Fatalf("%s: expected %s %s, got %v", tt.name, tt.field, tt.reason, invalid.Fields)
+        }
+    }
+
+    // The longest accepted values are counted in characters.
+    longest := withKey(strings.Repeat("k", store.MaxIdempotencyKeyLength))
+    longest.Destination = strings.Repeat("ы", store.MaxDestinationLength)
+    if _, err := longest.Normalize(); err != nil {
+        t.Fatalf("longest values: %v", err)
+    }
+}
+
+func TestCanonicalIdempotencyKey(t *testing.T) {
+    for _, tt := range []struct {
+        key  string
+        want string
+    }{
+        {"Key-1", "key-1"},
+        {"key-1", "key-1"},
+        {"\u212a1", "k1"},
+        {"E\u0301", "\u00e9"},
+    } {
+        if got := store.CanonicalIdempotencyKey(tt.key); got != tt.want {
+            t.Fatalf("%q: expected %q, got %q", tt.key, tt.want, got)
+        }
+    }
+
+    in := store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "addr", IdempotencyKey: " Key-1 "}
+    got, err := store.New(nil).NormalizeWithdrawalInput(in)
+    if err != nil || got.IdempotencyKey != "Key-1" {
+        t.Fatalf("expected the key kept byte for byte by default, got %q, %v", got.IdempotencyKey, err)
+    }
+    got, err = store.New(nil, store.WithCanonicalIdempotencyKeys(true)).NormalizeWithdrawalInput(in)
+    if err != nil || got.IdempotencyKey != "key-1" {
+        t.Fatalf("expected the canonical key, got %q, %v", got.IdempotencyKey, err)
+    }
+    // A Kelvin sign is not ASCII, but its canonical form is.
+    in.IdempotencyKey = "\u212a1"
+    if got, err = store.New(nil, store.WithCanonicalIdempotencyKeys(true)).NormalizeWithdrawalInput(in); err != nil || got.IdempotencyKey != "k1" {
+        t.Fatalf("expected k1, got %q, %v", got.IdempotencyKey, err)
+    }
+}
+
+func TestCanonicalIdempotencyKeys(t *testing.T) {
+    st, pool := setupStore(t, store.WithCanonicalIdempotencyKeys(true))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    input := func(amount int64, key string) store.CreateWithdrawalInput {
+        return store.CreateWithdrawalInput{UserID: 1, Amount: amount, Currency: "USDT", Destination: "a", IdempotencyKey: key}
+    }
+
+    created, err := st.CreateWithdrawal(ctx, input(100, "Key-1"))
+    if err != nil {
+        t.Fatalf("create: %v", err)
+    }
+    if created.IdempotencyKey != "key-1" {
+        t.Fatalf("expected the key stored as key-1, got %q", created.IdempotencyKey)
+    }
+    replayed, err := st.CreateWithdrawal(ctx, input(100, "KEY-1"))
+    if err != nil || !replayed.Replayed || replayed.ID != created.ID {
+        t.Fatalf("expected KEY-1 to replay withdrawal %d, got %+v, %v", created.ID, replayed.Withdrawal, err)
+    }
+
+    _, err = st.CreateWithdrawal(ctx, input(200, "kEY-1"))
+    if !errors.Is(err, store.ErrIdempotencyConflict) || !strings.Contains(err.Error(), `"key-1"`) {
+        t.Fatalf("expected a conflict naming key-1, got %v", err)
+    }
+    if _, err := st.CreateWithdrawalBatch(ctx, []store.CreateWithdrawalInput{input(100, "K2"), input(100, "k2")}); !errors.Is(err, store.ErrIdempotencyConflict) {
+        t.Fatalf("expected K2 and k2 to collide within a batch, got %v", err)
+    }
+
+    found, err := st.GetWithdrawalByIdempotencyKey(ctx, 1, " KEY-1 ")
+    if err != nil || found.ID != created.ID {
+        t.Fatalf("expected the lookup to find withdrawal %d, got %+v, %v", created.ID, found, err)
+    }
+    if _, err := st.GetWithdrawalByIdempotencyKey(ctx, 2, "key-1"); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound for another user, got %v", err)
+    }
+
+    // Without the option the same keys are distinct.
+    plain := store.New(pool)
+    other, err := plain.CreateWithdrawal(ctx, input(100, "Key-1"))
+    if err != nil || other.Replayed || other.ID == created.ID {
+        t.Fatalf("expected Key-1 to create another withdrawal byte for byte, got %+v, %v", other.Withdrawal, err)
+    }
+}
+
+func TestSupportedCurrencies(t *testing.T) {
+    st, pool := setupStore(t, store.WithSupportedCurrencies("usdt", " eur ", "USDT"))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    if got := st.SupportedCurrencies(); fmt.Sprint(got) != "[USDT EUR]" {
+        t.Fatalf("expected [USDT EUR], got %v", got)
+    }
+    input := func(currency, key string) store.CreateWithdrawalInput {
+        return store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: currency, Destination: "a", IdempotencyKey: key}
+    }
+
+    var invalid *store.InvalidInputError
+    _, err := st.CreateWithdrawal(ctx, input("BTC", "k1"))
+    if !errors.As(err, &invalid) || fmt.Sprint(invalid.Fields) != fmt.Sprint([]store.FieldError{{Field: "currency", Reason: store.ReasonUnsupported}}) {
+        t.Fatalf("expected BTC rejected as unsupported, got %v", err)
+    }
+    if _, err := st.CreateWithdrawalBatch(ctx, []store.CreateWithdrawalInput{input("USDT", "k1"), input("btc", "k2")}); !errors.Is(err, store.ErrInvalidInput) {
+        t.Fatalf("expected the batch rejected for btc, got %v", err)
+    }
+
+    // The database accepts EUR only once it is registered.
+    exec(t, pool, "DELETE FROM currencies WHERE code = 'EUR'")
+    if _, err := st.CreateWithdrawal(ctx, input("eur", "k3")); err == nil {
+        t.Fatalf("expected the database to reject an unregistered currency")
+    }
+    if err := st.RegisterCurrencies(ctx); err != nil {
+        t.Fatalf("register currencies: %v", err)
+    }
+    created, err := st.CreateWithdrawal(ctx, input("eur", "k3"))
+    if err != nil || created.Currency != "EUR" {
+        t.Fatalf("expected a EUR withdrawal, got %+v, %v", created.Withdrawal, err)
+    }
+    if _, err := pool.Exec(ctx, "UPDATE withdrawals SET currency = 'BTC' WHERE id = $1", created.ID); err == nil {
+        t.Fatalf("expected the database to reject BTC")
+    }
+}
+
+func TestRecordExternalTxHash(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (id, user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (1, 1, 100, 'USDT', 'a', 'confirmed', 'k1'),
+               (2, 1, 100, 'USDT', 'a', 'pending', 'k2')
+    `)
+
+    for _, hash := range []string{"", strings.Repeat("a", store.MaxTxHashLength+1)} {
+        if _, err := st.RecordExternalTxHash(ctx, 1, hash); !errors.Is(err, store.ErrInvalidTxHash) {
+            t.Fatalf("hash of %d characters: expected ErrInvalidTxHash, got %v", len(hash), err)
+        }
+    }
+
+    w, err := st.RecordExternalTxHash(ctx, 1, "0xabc")
+    if err != nil {
+        t.Fatalf("record: %v", err)
+    }
+    if w.ExternalTxHash == nil || *w.ExternalTxHash != "0xabc" || w.Version != 2 {
+        t.Fatalf("unexpected withdrawal: %+v", w)
+    }
+    again, err := st.RecordExternalTxHash(ctx, 1, "0xabc")
+    if err != nil {
+        t.Fatalf("record again: %v", err)
+    }
+    if again.Version != w.Version {
+        t.Fatalf("expected recording the same hash to change nothing, got version %d", again.Version)
+    }
+    if _, err := st.RecordExternalTxHash(ctx, 1, "0xdef"); !errors.Is(err, store.ErrTxHashConflict) {
+        t.Fatalf("expected ErrTxHashConflict, got %v", err)
+    }
+
+    if _, err := st.RecordExternalTxHash(ctx, 2, "0xabc"); !errors.Is(err, store.ErrInvalidStatus) {
+        t.Fatalf("pending withdrawal: expected ErrInvalidStatus, got %v", err)
+    }
+    if _, err := st.RecordExternalTxHash(ctx, 42, "0xabc"); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound, got %v", err)
+    }
+}
+
+func TestMaxPendingWithdrawals(t *testing.T) {
+    st, pool := setupStore(t, store.WithMaxPendingWithdrawals(1))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+
+    input := store.CreateWithdrawalInput{UserID: 1, Amount: 10, Currency: "USDT", Destination: "a", IdempotencyKey: "k1"}
+    if _, err := st.CreateWithdrawal(ctx, input); err != nil {
+        t.Fatalf("first withdrawal: %v", err)
+    }
+
+    if _, err := st.CreateWithdrawal(ctx, input); err != nil {
+        t.Fatalf("replay should not be limited: %v", err)
+    }
+
+    input.IdempotencyKey = "k2"
+    if _, err := st.CreateWithdrawal(ctx, input); !errors.Is(err, store.ErrTooManyPending) {
+        t.Fatalf("expected ErrTooManyPending, got %v", err)
+    }
+}
+
+func TestCreateWithdrawalFeeLedgerMatchesDebit(t *testing.T) {
+    st, pool := setupStore(t, store.WithFeePolicies(map[string]store.FeePolicy{
+        "USDT": {BasisPoints: 50, Rounding: store.RoundCeil},
+    }))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+
+    w, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    if w.Fee != 1 {
+        t.Fatalf("expected fee 1, got %d", w.Fee)
+    }
+
+    var balance, debited int64
+    if err := pool.QueryRow(ctx, "SELECT balance FROM users WHERE id = 1").Scan(&balance); err != nil {
+        t.Fatalf("get balance: %v", err)
+    }
+    if err := pool.QueryRow(ctx, "SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE user_id = 1").Scan(&debited); err != nil {
+        t.Fatalf("sum ledger: %v", err)
+    }
+    if balance != 899 || debited != 101 {
+        t.Fatalf("expected balance 899 and ledger total 101, got %d and %d", balance, debited)
+    }
+
+    _, err = st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 899, Currency: "USDT", Destination: "a", IdempotencyKey: "k2",
+    })
+    if !errors.Is(err, store.ErrInsufficientBalance) {
+        t.Fatalf("expected fee to count against balance, got %v", err)
+    }
+}
+
+func TestFeeEntriesByTier(t *testing.T) {
+    st, pool := setupStore(t,
+        store.WithSupportedCurrencies("USDT", "EUR"),
+        store.WithFeePolicies(map[string]store.FeePolicy{
+            "USDT": {BasisPoints: 100, Rounding: store.RoundCeil},
+            "EUR":  {BasisPoints: 100, Rounding: store.RoundCeil},
+        }),
+        store.WithFeeExemptTiers(store.TierPremium),
+    )
+    ctx := context.Background()
+    if err := st.RegisterCurrencies(ctx); err != nil {
+        t.Fatalf("register currencies: %v", err)
+    }
+
+    exec(t, pool, "INSERT INTO users (id, balance, tier) VALUES (1, 1000, 'standard'), (2, 1000, 'premium')")
+
+    for _, userID := range []int64{1, 2} {
+        if _, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+            UserID: userID, Amount: 200, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+        }); err != nil {
+            t.Fatalf("create withdrawal for user %d: %v", userID, err)
+        }
+    }
+    if _, err := st.CreateWithdrawalBatch(ctx, []store.CreateWithdrawalInput{
+        {UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k2"},
+        {UserID: 2, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k2"},
+    }); err != nil {
+        t.Fatalf("create batch: %v", err)
+    }
+    if _, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 50, Currency: "EUR", Destination: "a", IdempotencyKey: "k3",
+    }); err != nil {
+        t.Fatalf("create EUR withdrawal: %v", err)
+    }
+
+    for _, tc := range []struct {
+        userID  int64
+        fees    []store.FeeSummary
+        balance int64
+    }{
+        {1, []store.FeeSummary{{Currency: "EUR", Count: 1, Total: 1}, {Currency: "USDT", Count: 2, Total: 3}}, 646},
+        {2, []store.FeeSummary{}, 700},
+    } {
+        fees, err := st.GetFeeSummary(ctx, tc.userID)
+        if err != nil {
+            t.Fatalf("fee summary: %v", err)
+        }
+        if fmt.Sprint(fees) != fmt.Sprint(tc.fees) {
+            t.Fatalf("user %d: expected fees %+v, got %+v", tc.userID, tc.fees, fees)
+        }
+        user, err := st.GetUser(ctx, tc.userID)
+        if err != nil {
+            t.Fatalf("get user: %v", err)
+        }
+        if user.Balance != tc.balance {
+            t.Fatalf("user %d: expected balance %d, got %d", tc.userID, tc.balance, user.Balance)
+        }
+    }
+
+    if _, err := st.GetFeeSummary(ctx, 3); !errors.Is(err, store.ErrUserNotFound) {
+        t.Fatalf("expected ErrUserNotFound, got %v", err)
+    }
+}
+
+func TestReservationExpiry(t *testing.T) {
+    clock := testutil.NewFakeClock(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+    st, pool := setupStore(t, store.WithReservationTTL(time.Minute), store.WithClock(clock))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+
+    w, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    if w.ReservedUntil == nil || !w.ReservedUntil.Equal(clock.Now().Add(time.Minute)) {
+        t.Fatalf("expected reserved_until a minute from now, got %v", w.ReservedUntil)
+    }
+
+    clock.Advance(time.Minute - time.Microsecond)
+    early, err := st.ReleaseExpiredReservations(ctx, 10)
+    if err != nil || len(early) != 0 {
+        t.Fatalf("expected nothing to release before the hold runs out, got %d (%v)", len(early), err)
+    }
+
+    clock.Advance(time.Microsecond)
+    if _, err := st.ConfirmWithdrawal(ctx, w.ID); !errors.Is(err, store.ErrReservationExpired) {
+        t.Fatalf("expected ErrReservationExpired, got %v", err)
+    }
+
+    released, err := st.ReleaseExpiredReservations(ctx, 10)
+    if err != nil {
+        t.Fatalf("release: %v", err)
+    }
+    if len(released) != 1 || released[0].ID != w.ID || released[0].Status != store.StatusExpired {
+        t.Fatalf("unexpected released withdrawals: %+v", released)
+    }
+    if !released[0].UpdatedAt.Equal(clock.Now()) {
+        t.Fatalf("expected the release at %s, got %s", clock.Now(), released[0].UpdatedAt)
+    }
+
+    var balance int64
+    if err := pool.QueryRow(ctx, "SELECT balance FROM users WHERE id = 1").Scan(&balance); err != nil {
+        t.Fatalf("get balance: %v", err)
+    }
+    if balance != 1000 {
+        t.Fatalf("expected balance restored to 1000, got %d", balance)
+    }
+
+    var credits int64
+    if err := pool.QueryRow(ctx, "SELECT COALESCE(SUM(amount), 0) FROM ledger_entries WHERE withdrawal_id = $1 AND direction = 'credit'", w.ID).Scan(&credits); err != nil {
+        t.Fatalf("sum credits: %v", err)
+    }
+    if credits != 100 {
+        t.Fatalf("expected credit of 100, got %d", credits)
+    }
+
+    if _, err := st.ConfirmWithdrawal(ctx, w.ID); !errors.Is(err, store.ErrReservationExpired) {
+        t.Fatalf("expected ErrReservationExpired after release, got %v", err)
+    }
+
+    again, err := st.ReleaseExpiredReservations(ctx, 10)
+    if err != nil || len(again) != 0 {
+        t.Fatalf("expected nothing left to release, got %d (%v)", len(again), err)
+    }
+}
+
+func TestStoreClock(t *testing.T) {
+    start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+    clock := testutil.NewFakeClock(start)
+    st, pool := setupStore(t, store.WithClock(clock))
+    ctx := context.Background()
+
+    if _, err := st.CreateUser(ctx, 1, 1000, nil); err != nil {
+        t.Fatalf("create user: %v", err)
+    }
+    w, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    if !w.CreatedAt.Equal(start) || !w.UpdatedAt.Equal(start) {
+        t.Fatalf("expected the withdrawal stamped %s, got created_at=%s updated_at=%s", start, w.CreatedAt, w.UpdatedAt)
+    }
+    var entryAt time.Time
+    if err := pool.QueryRow(ctx, "SELECT created_at FROM ledger_entries WHERE withdrawal_id = $1", w.ID).Scan(&entryAt); err != nil || !entryAt.Equal(start) {
+        t.Fatalf("expected the ledger entry stamped %s, got %s (%v)", start, entryAt, err)
+    }
+
+    clock.Advance(90 * time.Minute)
+    age, err := st.GetWithdrawalAge(ctx, w.ID)
+    if err != nil || age != 90 {
+        t.Fatalf("expected an age of 90 minutes, got %f (%v)", age, err)
+    }
+    stale, err := st.GetStalePendingWithdrawals(ctx, 60, 0)
+    if err != nil || len(stale) != 1 || stale[0].ID != w.ID {
+        t.Fatalf("expected withdrawal %d to be stale, got %+v (%v)", w.ID, stale, err)
+    }
+
+    if err := st.TouchWithdrawal(ctx, w.ID); err != nil {
+        t.Fatalf("touch: %v", err)
+    }
+    stale, err = st.GetStalePendingWithdrawals(ctx, 60, 0)
+    if err != nil || len(stale) != 0 {
+        t.Fatalf("expected no stale withdrawals after a touch, got %+v (%v)", stale, err)
+    }
+
+    clock.Advance(61 * time.Minute)
+    stale, err = st.GetStalePendingWithdrawals(ctx, 60, 0)
+    if err != nil || len(stale) != 1 {
+        t.Fatalf("expected the withdrawal stale again, got %+v (%v)", stale, err)
+    }
+
+    confirmed, err := st.ConfirmWithdrawal(ctx, w.ID)
+    if err != nil {
+        t.Fatalf("confirm: %v", err)
+    }
+    if confirmed.ConfirmedAt == nil || !confirmed.ConfirmedAt.Equal(clock.Now()) || !confirmed.CreatedAt.Equal(start) {
+        t.Fatalf("expected confirmed_at %s and created_at %s, got %+v", clock.Now(), start, confirmed)
+    }
+}
+
+func TestConfirmWithdrawalIfVersion(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    w, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create: %v", err)
+    }
+    if _, err := st.AddWithdrawalNote(ctx, w.ID, "alice", "hello"); err != nil {
+        t.Fatalf("add note: %v", err)
+    }
+
+    var conflict *store.VersionConflictError
+    _, err = st.ConfirmWithdrawalIfVersion(ctx, w.ID, w.Version)
+    if !errors.As(err, &conflict) || conflict.Current.Version != w.Version+1 || conflict.Current.Status != store.StatusPending {
+        t.Fatalf("expected a version conflict at version %d, got %v", w.Version+1, err)
+    }
+
+    confirmed, err := st.ConfirmWithdrawalIfVersion(ctx, w.ID, w.Version+1)
+    if err != nil {
+        t.Fatalf("confirm: %v", err)
+    }
+    if confirmed.Status != store.StatusConfirmed || confirmed.Version != w.Version+2 {
+        t.Fatalf("expected confirmation to bump the version, got %+v", confirmed)
+    }
+}
+
+func TestConfirmWithdrawalOriginKey(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    w, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1", CreatedByKey: "partner-a",
+    })
+    if err != nil {
+        t.Fatalf("create: %v", err)
+    }
+    if w.CreatedByKey != "partner-a" {
+        t.Fatalf("expected created_by_key partner-a, got %q", w.CreatedByKey)
+    }
+
+    if _, err := st.ConfirmWithdrawal(store.WithOriginKey(ctx, "partner-b"), w.ID); !errors.Is(err, store.ErrOriginKeyMismatch) {
+        t.Fatalf("expected ErrOriginKeyMismatch, got %v", err)
+    }
+    got, err := st.GetWithdrawal(ctx, w.ID)
+    if err != nil || got.Status != store.StatusPending {
+        t.Fatalf("expected the withdrawal to stay pending, got %+v %v", got, err)
+    }
+    if _, err := st.ConfirmWithdrawal(store.WithOriginKey(ctx, "partner-a"), w.ID); err != nil {
+        t.Fatalf("confirm with the creating key: %v", err)
+    }
+
+    // Withdrawals recorded without a key can be confirmed by any key.
+    created, err := st.CreateWithdrawalBatch(ctx, []store.CreateWithdrawalInput{
+        {UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k2", CreatedByKey: "partner-a"},
+        {UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k3"},
+    })
+    if err != nil {
+        t.Fatalf("create batch: %v", err)
+    }
+    if created[0].CreatedByKey != "partner-a" {
+        t.Fatalf("expected the batch to record the creating key, got %+v", created[0])
+    }
+    if _, err := st.ConfirmWithdrawal(store.WithOriginKey(ctx, "partner-b"), created[1].ID); err != nil {
+        t.Fatalf("confirm a withdrawal without a creating key: %v", err)
+    }
+}
+
+func TestBalanceAudit(t *testing.T) {
+    st, pool := setupStore(t, store.WithAuditSink(store.PostgresAuditSink{}), store.WithFeePolicies(map[string]store.FeePolicy{"USDT": {BasisPoints: 500, Rounding: store.RoundCeil}}))
+    ctx := store.WithActor(context.Background(), "partner-a")
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    created, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1"})
+    if err != nil {
+        t.Fatalf("create: %v", err)
+    }
+    // Replays and confirming twice move no money and are not recorded.
+    if _, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1"}); err != nil {
+        t.Fatalf("replay: %v", err)
+    }
+    for i := 0; i < 2; i++ {
+        if _, err := st.ConfirmWithdrawal(ctx, created.ID); err != nil {
+            t.Fatalf("confirm: %v", err)
+        }
+    }
+    if _, err := st.ReverseWithdrawal(context.Background(), created.ID, "chargeback"); err != nil {
+        t.Fatalf("reverse: %v", err)
+    }
+
+    changes, err := st.ListBalanceChanges(ctx, created.ID)
+    if err != nil {
+        t.Fatalf("list changes: %v", err)
+    }
+    type change struct {
+        actor, operation string
+        amount, fee      int64
+        before, after    int64
+    }
+    want := []change{
+        {"partner-a", store.OperationWithdrawalCreate, 100, 5, 1000, 895},
+        {"partner-a", store.OperationWithdrawalConfirm, 100, 5, 895, 895},
+        {store.SystemActor, store.OperationWithdrawalReverse, 100, 5, 895, 995},
+    }
+    if len(changes) != len(want) {
+        t.Fatalf("expected %d changes, got %+v", len(want), changes)
+    }
+    for i, c := range changes {
+        got := change{c.Actor, c.Operation, c.Amount, c.Fee, c.BalanceBefore, c.BalanceAfter}
+        if got != want[i] || c.UserID != 1 || c.Currency != "USDT" || c.CreatedAt.IsZero() {
+            t.Fatalf("change %d: expected %+v, got %+v", i, want[i], c)
+        }
+    }
+
+    batch, err := st.CreateWithdrawalBatch(ctx, []store.CreateWithdrawalInput{
+        {UserID: 1, Amount: 10, Currency: "USDT", Destination: "a", IdempotencyKey: "k2"},
+        {UserID: 1, Amount: 20, Currency: "USDT", Destination: "a", IdempotencyKey: "k3"},
+    })
+    if err != nil {
+        t.Fatalf("create batch: %v", err)
+    }
+    // Each item starts from the balance the one before it left.
+    before := int64(995)
+    for i, w := range batch {
+        changes, err := st.ListBalanceChanges(ctx, w.ID)
+        if err != nil {
+            t.Fatalf("list changes: %v", err)
+        }
+        after := before - w.Amount - w.Fee
+        if len(changes) != 1 || changes[0].BalanceBefore != before || changes[0].BalanceAfter != after {
+            t.Fatalf("batch item %d: expected %d -> %d, got %+v", i, before, after, changes)
+        }
+        before = after
+    }
+
+    exec(t, pool, "UPDATE balance_audit SET balance_after = 0")
+    exec(t, pool, "DELETE FROM balance_audit")
+    var rows int
+    if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM balance_audit WHERE balance_after <> 0").Scan(&rows); err != nil {
+        t.Fatalf("count changes: %v", err)
+    }
+    if rows != 5 {
+        t.Fatalf("expected the audit rows to survive update and delete, got %d", rows)
+    }
+}
+
+type failingAuditSink struct{}
+
+func (failingAuditSink) RecordBalanceChange(ctx context.Context, tx pgx.Tx, c store.BalanceChange) error {
+    return errors.New("audit unavailable")
+}
+
+func TestBalanceAuditFailureRollsBack(t *testing.T) {
+    st, pool := setupStore(t, store.WithAuditSink(failingAuditSink{}))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    if _, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1"}); err == nil {
+        t.Fatalf("expected the audit failure to fail the create")
+    }
+    user, err := st.GetUser(ctx, 1)
+    if err != nil {
+        t.Fatalf("get user: %v", err)
+    }
+    if user.Balance != 1000 {
+        t.Fatalf("expected the debit rolled back, got balance %d", user.Balance)
+    }
+    var withdrawals int
+    if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM withdrawals").Scan(&withdrawals); err != nil {
+        t.Fatalf("count withdrawals: %v", err)
+    }
+    if withdrawals != 0 {
+        t.Fatalf("expected the withdrawal rolled back, got %d", withdrawals)
+    }
+}
+
+func TestConfirmRacesReservationRelease(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 100000)")
+    for i := 0; i < 100; i++ {
+        var id int64
+        err := pool.QueryRow(ctx, `
+            INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key, reserved_until)
+            VALUES (1, 100, 'USDT', 'a', 'pending', $1, now() + interval '20 milliseconds')
+            RETURNING id
+        `, fmt.Sprintf("race%d", i)).Scan(&id)
+        if err != nil {
+            t.Fatalf("insert withdrawal: %v", err)
+        }
+        time.Sleep(20 * time.Millisecond)
+
+        var (
+            wg         sync.WaitGroup
+            confirmErr error
+            released   []store.Withdrawal
+            releaseErr error
+        )
+        wg.Add(2)
+        go func() {
+            defer wg.Done()
+            _, confirmErr = st.ConfirmWithdrawal(ctx, id)
+        }()
+        go func() {
+            defer wg.Done()
+            released, releaseErr = st.ReleaseExpiredReservations(ctx, 10)
+        }()
+        wg.Wait()
+
+        if releaseErr != nil {
+            t.Fatalf("release: %v", releaseErr)
+        }
+        if confirmErr != nil && !errors.Is(confirmErr, store.ErrReservationExpired) {
+            t.Fatalf("confirm: %v", confirmErr)
+        }
+        if confirmErr == nil && len(released) != 0 {
+            t.Fatalf("withdrawal %d was both confirmed and released", id)
+        }
+        // A release that skipped the row while confirm held it leaves the
+        // expired hold to the next run.
+        if _, err := st.ReleaseExpiredReservations(ctx, 10); err != nil {
+            t.Fatalf("release: %v", err)
+        }
+
+        var status string
+        var credits int64
+        err = pool.QueryRow(ctx, `
+            SELECT w.status, (SELECT COUNT(*) FROM ledger_entries l WHERE l.withdrawal_id = w.id AND l.direction = 'credit')
+            FROM withdrawals w WHERE w.id = $1
+        `, id).Scan(&status, &credits)
+        if err != nil {
+            t.Fatalf("get withdrawal: %v", err)
+        }
+        switch {
+        case confirmErr == nil && (status != store.StatusConfirmed || credits != 0):
+            t.Fatalf("confirm won but withdrawal %d is %s with %d credits", id, status, credits)
+        case confirmErr != nil && (status != store.StatusExpired || credits != 1):
+            t.Fatalf("release won but withdrawal %d is %s with %d credits", id, status, credits)
+        }
+    }
+}
+
+func TestConcurrentReversals(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 0)")
+    for i := 0; i < 100; i++ {
+        var id int64
+        err := pool.QueryRow(ctx, `
+            INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+            VALUES (1, 100, 'USDT', 'a', 'confirmed', $1)
+            RETURNING id
+        `, fmt.Sprintf("race%d", i)).Scan(&id)
+        if err != nil {
+            t.Fatalf("insert withdrawal: %v", err)
+        }
+
+        var (
+            wg   sync.WaitGroup
+            errs [2]error
+        )
+        for j := range errs {
+            wg.Add(1)
+            go func(j int) {
+                defer wg.Done()
+                _, errs[j] = st.ReverseWithdrawal(ctx, id, "chargeback")
+            }(j)
+        }
+        wg.Wait()
+
+        wins := 0
+        for _, err := range errs {
+            switch {
+            case err == nil:
+                wins++
+            case !errors.Is(err, store.ErrInvalidStatus):
+                t.Fatalf("reverse: %v", err)
+            }
+        }
+        if wins != 1 {
+            t.Fatalf("withdrawal %d: expected exactly one reversal to win, got %d", id, wins)
+        }
+    }
+
+    var balance, credits int64
+    err := pool.QueryRow(ctx, `
+        SELECT u.balance, (SELECT COUNT(*) FROM ledger_entries l WHERE l.user_id = u.id AND l.direction = 'credit')
+        FROM users u WHERE u.id = 1
+    `).Scan(&balance, &credits)
+    if err != nil {
+        t.Fatalf("get user: %v", err)
+    }
+    if balance != 100*100 || credits != 100 {
+        t.Fatalf("expected one refund per withdrawal, got balance %d and %d credits", balance, credits)
+    }
+}
+
+func TestLedgerCursorRoundTrip(t *testing.T) {
+    c := store.LedgerCursor{CreatedAt: time.Date(2026, 1, 2, 3, 4, 5, 123456000, time.UTC), ID: 42}
+    parsed, err := store.ParseLedgerCursor(c.String())
+    if err != nil || parsed != c {
+        t.Fatalf("expected %+v, got %+v (%v)", c, parsed, err)
+    }
+    for _, raw := range []string{"", "nope", "MTIz", "MTIzOjA"} {
+        if _, err := store.ParseLedgerCursor(raw); !errors.Is(err, store.ErrInvalidFilter) {
+            t.Fatalf("%q: expected ErrInvalidFilter, got %v", raw, err)
+        }
+    }
+}
+
+func TestStreamLedgerEntriesAdmin(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    // More than one batch, with created_at ties so pages split inside them.
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 0), (2, 0)")
+    exec(t, pool, `
+        INSERT INTO ledger_entries (user_id, amount, currency, direction, created_at)
+        SELECT 1 + i % 2, i, 'USDT', 'credit', '2026-01-01T00:00:00Z'::timestamptz + (i / 3) * INTERVAL '1 second'
+        FROM generate_series(1, 2100) AS i
+    `)
+
+    var seen []store.LedgerEntry
+    err := st.StreamLedgerEntriesAdmin(ctx, store.LedgerFilter{UserID: 1}, func(e store.LedgerEntry) error {
+        seen = append(seen, e)
+        return nil
+    })
+    if err != nil {
+        t.Fatalf("stream: %v", err)
+    }
+    if len(seen) != 1050 {
+        t.Fatalf("expected 1050 entries, got %d", len(seen))
+    }
+    for i, e := range seen {
+        if e.UserID != 1 {
+            t.Fatalf("unexpected entry of user %d", e.UserID)
+        }
+        if i > 0 {
+            prev := seen[i-1]
+            if e.CreatedAt.Before(prev.CreatedAt) || e.CreatedAt.Equal(prev.CreatedAt) && e.ID <= prev.ID {
+                t.Fatalf("entry %d out of order: %+v after %+v", i, e, prev)
+            }
+        }
+    }
+
+    stop := errors.New("stop")
+    calls := 0
+    err = st.StreamLedgerEntriesAdmin(ctx, store.LedgerFilter{}, func(store.LedgerEntry) error {
+        calls++
+        return stop
+    })
+    if !errors.Is(err, stop) || calls != 1 {
+        t.Fatalf("expected the stream to stop at the first error, got %v after %d calls", err, calls)
+    }
+}
+
+func TestCreateWithdrawalBatch(t *testing.T) {
+    st, pool := setupStore(t, store.WithFeePolicies(map[string]store.FeePolicy{
+        "USDT": {BasisPoints: 100, Rounding: store.RoundCeil},
+    }))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 500)")
+    input := func(userID, amount int64, key string) store.CreateWithdrawalInput {
+        return store.CreateWithdrawalInput{UserID: userID, Amount: amount, Currency: "USDT", Destination: "a", IdempotencyKey: key}
+    }
+
+    created, err := st.CreateWithdrawalBatch(ctx, []store.CreateWithdrawalInput{
+        input(1, 100, "k1"),
+        input(2, 200, "k1"),
+        input(1, 300, "k2"),
+    })
+    if err != nil {
+        t.Fatalf("create batch: %v", err)
+    }
+    if len(created) != 3 || created[0].Amount != 100 || created[1].UserID != 2 || created[2].Amount != 300 || created[2].Fee != 3 {
+        t.Fatalf("unexpected withdrawals: %+v", created)
+    }
+
+    var balance1, balance2, entries int64
+    err = pool.QueryRow(ctx, `
+        SELECT (SELECT balance FROM users WHERE id = 1), (SELECT balance FROM users WHERE id = 2), (SELECT COUNT(*) FROM ledger_entries)
+    `).Scan(&balance1, &balance2, &entries)
+    if err != nil {
+        t.Fatalf("read state: %v", err)
+    }
+    // 100+1 and 300+3 for user 1, 200+2 for user 2, each with a debit and a fee entry.
+    if balance1 != 596 || balance2 != 298 || entries != 6 {
+        t.Fatalf("unexpected state: balances %d/%d, %d ledger entries", balance1, balance2, entries)
+    }
+
+    tests := []struct {
+        name   string
+        inputs []store.CreateWithdrawalInput
+        index  int
+        want   error
+    }{
+        {"unknown user", []store.CreateWithdrawalInput{input(1, 10, "k3"), input(9, 10, "k1")}, 1, store.ErrUserNotFound},
+        {"used key", []store.CreateWithdrawalInput{input(2, 10, "k2"), input(2, 10, "k1")}, 1, store.ErrIdempotencyConflict},
+        {"repeated key", []store.CreateWithdrawalInput{input(2, 10, "k3"), input(2, 20, "k3")}, 1, store.ErrIdempotencyConflict},
+        {"used key with spaces", []store.CreateWithdrawalInput{input(2, 10, "k3"), input(2, 10, " k1 ")}, 1, store.ErrIdempotencyConflict},
+        {"blank key", []store.CreateWithdrawalInput{input(2, 10, "k3"), input(2, 10, "  ")}, 1, store.ErrInvalidInput},
+        {"balance spent by earlier item", []store.CreateWithdrawalInput{input(2, 200, "k3"), input(2, 100, "k4")}, 1, store.ErrInsufficientBalance},
+    }
+    for _, tt := range tests {
+        _, err := st.CreateWithdrawalBatch(ctx, tt.inputs)
+        var item *store.BatchItemError
+        if !errors.As(err, &item) || item.Index != tt.index || !errors.Is(err, tt.want) {
+            t.Fatalf("%s: expected item %d to fail with %v, got %v", tt.name, tt.index, tt.want, err)
+        }
+    }
+
+    var withdrawals int64
+    if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM withdrawals").Scan(&withdrawals); err != nil {
+        t.Fatalf("count withdrawals: %v", err)
+    }
+    if withdrawals != 3 {
+        t.Fatalf("expected failed batches to create nothing, found %d withdrawals", withdrawals)
+    }
+}
+
+func TestDestinationBlacklist(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    input := func(destination, key string) store.CreateWithdrawalInput {
+        return store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: destination, IdempotencyKey: key}
+    }
+    before, err := st.CreateWithdrawal(ctx, input("sanctioned", "k1"))
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+
+    added, err := st.BlacklistDestination(ctx, "sanctioned")
+    if err != nil || !added {
+        t.Fatalf("expected address to be added, got %v, %v", added, err)
+    }
+    if added, err := st.BlacklistDestination(ctx, "sanctioned"); err != nil || added {
+        t.Fatalf("expected second add to be a no-op, got %v, %v", added, err)
+    }
+    for destination, want := range map[string]bool{"sanctioned": true, "Sanctioned": false, "other": false} {
+        blocked, err := st.IsDestinationBlacklisted(ctx, destination)
+        if err != nil || blocked != want {
+            t.Fatalf("%s: expected blacklisted %v, got %v, %v", destination, want, blocked, err)
+        }
+    }
+
+    if _, err := st.CreateWithdrawal(ctx, input("sanctioned", "k2")); !errors.Is(err, store.ErrDestinationBlacklisted) {
+        t.Fatalf("expected ErrDestinationBlacklisted, got %v", err)
+    }
+    _, err = st.CreateWithdrawalBatch(ctx, []store.CreateWithdrawalInput{input("other", "k2"), input("sanctioned", "k3")})
+    var item *store.BatchItemError
+    if !errors.As(err, &item) || item.Index != 1 || !errors.Is(err, store.ErrDestinationBlacklisted) {
+        t.Fatalf("expected item 1 to fail with ErrDestinationBlacklisted, got %v", err)
+    }
+    replayed, err := st.CreateWithdrawal(ctx, input("sanctioned", "k1"))
+    if err != nil || !replayed.Replayed || replayed.ID != before.ID {
+        t.Fatalf("expected replay of withdrawal %d, got %+v, %v", before.ID, replayed.Withdrawal, err)
+    }
+    var balance int64
+    if err := pool.QueryRow(ctx, "SELECT balance FROM users WHERE id = 1").Scan(&balance); err != nil {
+        t.Fatalf("read balance: %v", err)
+    }
+    if balance != 900 {
+        t.Fatalf("expected rejected creates to leave balance at 900, got %d", balance)
+    }
+
+    if err := st.UnblacklistDestination(ctx, "sanctioned"); err != nil {
+        t.Fatalf("unblacklist: %v", err)
+    }
+    if err := st.UnblacklistDestination(ctx, "sanctioned"); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound, got %v", err)
+    }
+    if _, err := st.CreateWithdrawal(ctx, input("sanctioned", "k2")); err != nil {
+        t.Fatalf("expected create after unblacklist to succeed, got %v", err)
+    }
+}
+
+func TestOverdraftLimit(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 100), (2, 100)")
+    for _, id := range []int64{1, 2} {
+        u, err := st.SetOverdraftLimit(ctx, id, 50)
+        if err != nil || u.OverdraftLimit != 50 {
+            t.Fatalf("set overdraft limit of user %d: %+v %v", id, u, err)
+        }
+    }
+    input := func(userID, amount int64, key string) store.CreateWithdrawalInput {
+        return store.CreateWithdrawalInput{UserID: userID, Amount: amount, Currency: "USDT", Destination: "a", IdempotencyKey: key}
+    }
+
+    var insufficient *store.InsufficientBalanceError
+    _, err := st.CreateWithdrawal(ctx, input(1, 151, "above"))
+    if !errors.As(err, &insufficient) || insufficient.Overdraft != 50 || insufficient.Shortfall() != 1 {
+        t.Fatalf("above the limit: expected a shortfall of 1 with overdraft 50, got %v", err)
+    }
+    at, err := st.CreateWithdrawal(ctx, input(1, 150, "at"))
+    if err != nil || at.Balance != -50 {
+        t.Fatalf("at the limit: expected balance -50, got %+v %v", at, err)
+    }
+    below, err := st.CreateWithdrawal(ctx, input(2, 120, "below"))
+    if err != nil || below.Balance != -20 {
+        t.Fatalf("below the limit: expected balance -20, got %+v %v", below, err)
+    }
+
+    _, err = st.CreateWithdrawalBatch(ctx, []store.CreateWithdrawalInput{input(2, 20, "b1"), input(2, 11, "b2")})
+    var itemErr *store.BatchItemError
+    if !errors.As(err, &itemErr) || itemErr.Index != 1 || !errors.Is(err, store.ErrInsufficientBalance) {
+        t.Fatalf("batch past the limit: expected item 1 to be insufficient, got %v", err)
+    }
+
+    if _, err := st.SetOverdraftLimit(ctx, 1, 49); !errors.Is(err, store.ErrOverdraftInUse) {
+        t.Fatalf("expected ErrOverdraftInUse, got %v", err)
+    }
+    if _, err := st.SetOverdraftLimit(ctx, 1, -1); !errors.Is(err, store.ErrInvalidOverdraftLimit) {
+        t.Fatalf("expected ErrInvalidOverdraftLimit, got %v", err)
+    }
+    if _, err := st.SetOverdraftLimit(ctx, 3, 10); !errors.Is(err, store.ErrUserNotFound) {
+        t.Fatalf("expected ErrUserNotFound, got %v", err)
+    }
+    if u, err := st.GetUser(ctx, 1); err != nil || u.Balance != -50 || u.OverdraftLimit != 50 {
+        t.Fatalf("expected balance -50 with overdraft 50, got %+v %v", u, err)
+    }
+}
+
+func BenchmarkCreateWithdrawalBatch(b *testing.B) {
+    st, pool := setupStore(b)
+    ctx := context.Background()
+
+    exec(b, pool, "INSERT INTO users (id, balance) VALUES (1, 9000000000000000000)")
+    for _, size := range []int{10, 50, 100} {
+        b.Run(fmt.Sprintf("items=%d", size), func(b *testing.B) {
+            inputs := make([]store.CreateWithdrawalInput, size)
+            for i := 0; i < b.N; i++ {
+                for j := range inputs {
+                    inputs[j] = store.CreateWithdrawalInput{
+                        UserID:         1,
+                        Amount:         1,
+                        Currency:       "USDT",
+                        Destination:    "a",
+                        IdempotencyKey: fmt.Sprintf("bench-%d-%d-%d", size, i, j),
+                    }
+                }
+                if _, err := st.CreateWithdrawalBatch(ctx, inputs); err != nil {
+                    b.Fatalf("create batch: %v", err)
+                }
+            }
+            b.ReportMetric(float64(b.N*size)/b.Elapsed().Seconds(), "withdrawals/s")
+        })
+    }
+}
+
+// BenchmarkCreateWithdrawalRetries creates withdrawals for one user from
+// parallel clients, four in five of them retries of a key that already
+// exists, with and without the lock-free idempotency pre-check.
+func BenchmarkCreateWithdrawalRetries(b *testing.B) {
+    for _, tt := range []struct {
+        name     string
+        precheck bool
+    }{
+        {"precheck", true},
+        {"always_lock", false},
+    } {
+        b.Run(tt.name, func(b *testing.B) {
+            st, pool := setupStore(b)
+            if !tt.precheck {
+                store.SkipIdempotencyPrecheck(st)
+            }
+            ctx := context.Background()
+
+            exec(b, pool, "INSERT INTO users (id, balance) VALUES (1, 9000000000000000000)")
+            const retried = 100
+            input := func(key string) store.CreateWithdrawalInput {
+                return store.CreateWithdrawalInput{UserID: 1, Amount: 1, Currency: "USDT", Destination: "a", IdempotencyKey: key}
+            }
+            for i := 0; i < retried; i++ {
+                if _, err := st.CreateWithdrawal(ctx, input(fmt.Sprintf("retry-%d", i))); err != nil {
+                    b.Fatalf("seed: %v", err)
+                }
+            }
+
+            var n atomic.Int64
+            b.ResetTimer()
+            b.RunParallel(func(pb *testing.PB) {
+                for pb.Next() {
+                    i := n.Add(1)
+                    key := fmt.Sprintf("retry-%d", i%retried)
+                    if i%5 == 0 {
+                        key = fmt.Sprintf("new-%d", i)
+                    }
+                    if _, err := st.CreateWithdrawal(ctx, input(key)); err != nil {
+                        b.Errorf("create %s: %v", key, err)
+                        return
+                    }
+                }
+            })
+        })
+    }
+}
+
+func TestGetWithdrawalWithLedger(t *testing.T) {
+    st, pool := setupStore(t, store.WithFeePolicies(map[string]store.FeePolicy{
+        "USDT": {BasisPoints: 100, Rounding: store.RoundCeil},
+    }))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    created, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create: %v", err)
+    }
+
+    w, entries, err := st.GetWithdrawalWithLedger(ctx, created.ID)
+    if err != nil {
+        t.Fatalf("get with ledger: %v", err)
+    }
+    if w.ID != created.ID || len(entries) != 2 {
+        t.Fatalf("unexpected result: %+v %+v", w, entries)
+    }
+    if entries[0].Direction != store.DirectionDebit || entries[0].Amount != 100 || entries[1].Direction != store.DirectionFee || entries[1].Amount != 1 {
+        t.Fatalf("unexpected ledger entries: %+v", entries)
+    }
+
+    if _, _, err := st.GetWithdrawalWithLedger(ctx, 42); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound, got %v", err)
+    }
+}
+
+func TestGetWithdrawalWithUser(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance, tier) VALUES (1, 1000, 'premium')")
+    created, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create: %v", err)
+    }
+
+    got, err := st.GetWithdrawalWithUser(ctx, created.ID)
+    if err != nil {
+        t.Fatalf("get with user: %v", err)
+    }
+    if got.ID != created.ID || got.Amount != 100 || got.Destination != "a" {
+        t.Fatalf("unexpected withdrawal: %+v", got.Withdrawal)
+    }
+    if got.UserBalance != 900 || got.UserTier != "premium" {
+        t.Fatalf("unexpected user fields: balance=%d tier=%q", got.UserBalance, got.UserTier)
+    }
+
+    if _, err := st.GetWithdrawalWithUser(ctx, 42); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("expected ErrNotFound, got %v", err)
+    }
+}
+
+func TestSumLedgerByDirection(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    exec(t, pool, `
+        INSERT INTO ledger_entries (user_id, amount, currency, direction)
+        VALUES (1, 100, 'USDT', 'debit'),
+               (1, 50, 'USDT', 'debit'),
+               (1, 5, 'USDT', 'fee'),
+               (1, 30, 'USDT', 'credit'),
+               (2, 999, 'USDT', 'debit')
+    `)
+
+    debit, credit, err := st.SumLedgerByDirection(ctx, 1)
+    if err != nil {
+        t.Fatalf("sum ledger: %v", err)
+    }
+    if debit != 155 || credit != 30 {
+        t.Fatalf("expected debit 155 and credit 30, got %d and %d", debit, credit)
+    }
+
+    debit, credit, err = st.SumLedgerByDirection(ctx, 3)
+    if err != nil {
+        t.Fatalf("sum ledger for unknown user: %v", err)
+    }
+    if debit != 0 || credit != 0 {
+        t.Fatalf("expected zero totals, got %d and %d", debit, credit)
+    }
+}
+
+func TestGetLedgerSummary(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    exec(t, pool, `
+        INSERT INTO ledger_entries (user_id, amount, currency, direction, created_at)
+        VALUES (1, 100, 'USDT', 'debit', '2024-01-01T00:00:00Z'),
+               (1, 5, 'USDT', 'fee', '2024-01-01T00:00:00Z'),
+               (1, 30, 'USDT', 'credit', '2024-02-01T00:00:00Z'),
+               (2, 999, 'USDT', 'debit', '2024-01-01T00:00:00Z')
+    `)
+
+    sum, err := st.GetLedgerSummary(ctx, 1, store.LedgerSummaryFilter{})
+    if err != nil {
+        t.Fatalf("ledger summary: %v", err)
+    }
+    if sum != (store.LedgerSummary{Count: 3, Debits: 105, Credits: 30, Net: -75}) {
+        t.Fatalf("unexpected summary: %+v", sum)
+    }
+
+    from := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+    sum, err = st.GetLedgerSummary(ctx, 1, store.LedgerSummaryFilter{From: &from})
+    if err != nil {
+        t.Fatalf("ledger summary from: %v", err)
+    }
+    if sum != (store.LedgerSummary{Count: 1, Credits: 30, Net: 30}) {
+        t.Fatalf("unexpected summary from %s: %+v", from, sum)
+    }
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (3, 0)")
+    if sum, err := st.GetLedgerSummary(ctx, 3, store.LedgerSummaryFilter{}); err != nil || sum != (store.LedgerSummary{}) {
+        t.Fatalf("expected a zero summary, got %+v (%v)", sum, err)
+    }
+    if _, err := st.GetLedgerSummary(ctx, 4, store.LedgerSummaryFilter{}); !errors.Is(err, store.ErrUserNotFound) {
+        t.Fatalf("expected ErrUserNotFound, got %v", err)
+    }
+    if _, err := st.GetLedgerSummary(ctx, 1, store.LedgerSummaryFilter{From: &from, To: &from}); !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter, got %v", err)
+    }
+}
+
+func TestUpdatedAtTracksMutations(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    w, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID:         1,
+        Amount:         100,
+        Currency:       "USDT",
+        Destination:    "addr",
+        IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    if !w.UpdatedAt.Equal(w.CreatedAt) {
+        t.Fatalf("expected updated_at to equal created_at on insert, got %s and %s", w.UpdatedAt, w.CreatedAt)
+    }
+
+    past := time.Now().Add(-time.Hour)
+    exec(t, pool, "UPDATE withdrawals SET created_at = $1, updated_at = $1 WHERE id = $2", past, w.ID)
+    exec(t, pool, "UPDATE users SET updated_at = $1 WHERE id = 1", past)
+
+    confirmed, err := st.ConfirmWithdrawal(ctx, w.ID)
+    if err != nil {
+        t.Fatalf("confirm withdrawal: %v", err)
+    }
+    if !confirmed.UpdatedAt.After(past) || !confirmed.CreatedAt.Equal(past.Truncate(time.Microsecond)) {
+        t.Fatalf("expected only updated_at to move, got created_at=%s updated_at=%s", confirmed.CreatedAt, confirmed.UpdatedAt)
+    }
+
+    user, err := st.UpdateUserTier(ctx, 1, store.TierPremium)
+    if err != nil {
+        t.Fatalf("update tier: %v", err)
+    }
+    if !user.UpdatedAt.After(past) {
+        t.Fatalf("expected user updated_at to move, got %s", user.UpdatedAt)
+    }
+}
+
+func TestGetUserBalance(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+
+    balance, err := st.GetUserBalance(ctx, 1)
+    if err != nil || balance != 1000 {
+        t.Fatalf("expected balance 1000, got %d err=%v", balance, err)
+    }
+    if _, err := st.GetUserBalance(ctx, 2); !errors.Is(err, store.ErrUserNotFound) {
+        t.Fatalf("expected ErrUserNotFound, got %v", err)
+    }
+    if _, err := st.GetUserBalance(store.WithTenant(ctx, 2), 1); !errors.Is(err, store.ErrTenantMismatch) {
+        t.Fatalf("expected ErrTenantMismatch, got %v", err)
+    }
+}
+
+func BenchmarkGetUserBalance(b *testing.B) {
+    st, pool := setupStore(b)
+    ctx := context.Background()
+
+    exec(b, pool, "INSERT INTO users (id, balance, external_id) VALUES (1, 1000, 'crm-1')")
+    b.Run("GetUser", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            if _, err := st.GetUser(ctx, 1); err != nil {
+                b.Fatalf("get user: %v", err)
+            }
+        }
+    })
+    b.Run("GetUserBalance", func(b *testing.B) {
+        for i := 0; i < b.N; i++ {
+            if _, err := st.GetUserBalance(ctx, 1); err != nil {
+                b.Fatalf("get balance: %v", err)
+            }
+        }
+    })
+}
+
+func TestGetUserStats(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (1, 10, 'USDT', 'a', 'pending', 'k1'),
+               (1, 20, 'USDT', 'a', 'confirmed', 'k2'),
+               (1, 30, 'USDT', 'a', 'confirmed', 'k3'),
+               (1, 40, 'USDT', 'a', 'expired', 'k4'),
+               (2, 50, 'USDT', 'a', 'confirmed', 'k5')
+    `)
+
+    stats, err := st.GetUserStats(ctx, 1)
+    if err != nil {
+        t.Fatalf("get stats: %v", err)
+    }
+    if stats.WithdrawalCount != 4 || stats.TotalWithdrawn != 50 || stats.PendingAmount != 10 {
+        t.Fatalf("unexpected totals: %+v", stats)
+    }
+    if got := stats.ByStatus[store.StatusExpired]; got.Count != 1 || got.Amount != 40 {
+        t.Fatalf("unexpected expired stats: %+v", got)
+    }
+
+    empty, err := st.GetUserStats(ctx, 3)
+    if err != nil {
+        t.Fatalf("get stats for unknown user: %v", err)
+    }
+    if empty.WithdrawalCount != 0 || len(empty.ByStatus) != 0 {
+        t.Fatalf("expected empty stats, got %+v", empty)
+    }
+}
+
+func TestWithdrawalStats(t *testing.T) {
+    st, pool := setupStore(t, store.WithSupportedCurrencies("USDT", "EUR"))
+    ctx := context.Background()
+    if err := st.RegisterCurrencies(ctx); err != nil {
+        t.Fatalf("register currencies: %v", err)
+    }
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key, created_at)
+        VALUES (1, 10, 'USDT', 'a', 'pending', 'k1', '2026-01-01T10:00:00Z'),
+               (1, 20, 'USDT', 'a', 'confirmed', 'k2', '2026-01-01T12:00:00Z'),
+               (1, 30, 'USDT', 'a', 'confirmed', 'k3', '2026-01-01T22:30:00Z'),
+               (1, 40, 'USDT', 'a', 'pending', 'k4', '2026-01-02T09:00:00Z'),
+               (1, 50, 'USDT', 'a', 'confirmed', 'k5', '2026-01-03T00:00:00Z'),
+               (1, 7, 'EUR', 'a', 'pending', 'k6', '2026-01-01T11:00:00Z')
+    `)
+
+    from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+    to := time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC)
+
+    rows, err := st.WithdrawalStats(ctx, store.WithdrawalStatsQuery{
+        From:    from,
+        To:      to,
+        GroupBy: []string{store.GroupByStatus, store.GroupByCurrency},
+    })
+    if err != nil {
+        t.Fatalf("stats: %v", err)
+    }
+    want := []store.WithdrawalStatsRow{
+        {Status: "confirmed", Currency: "USDT", Count: 2, Amount: 50},
+        {Status: "pending", Currency: "EUR", Count: 1, Amount: 7},
+        {Status: "pending", Currency: "USDT", Count: 2, Amount: 50},
+    }
+    if fmt.Sprint(rows) != fmt.Sprint(want) {
+        t.Fatalf("expected %+v, got %+v", want, rows)
+    }
+
+    rows, err = st.WithdrawalStats(ctx, store.WithdrawalStatsQuery{
+        From:     from,
+        To:       to,
+        GroupBy:  []string{store.GroupByDay, store.GroupByStatus},
+        TZOffset: 3 * time.Hour,
+    })
+    if err != nil {
+        t.Fatalf("stats by day: %v", err)
+    }
+    // Currency is added as the last key, so amounts are never summed across
+    // currencies.
+    want = []store.WithdrawalStatsRow{
+        {Day: "2026-01-01", Status: "confirmed", Currency: "USDT", Count: 1, Amount: 20},
+        {Day: "2026-01-01", Status: "pending", Currency: "EUR", Count: 1, Amount: 7},
+        {Day: "2026-01-01", Status: "pending", Currency: "USDT", Count: 1, Amount: 10},
+        {Day: "2026-01-02", Status: "confirmed", Currency: "USDT", Count: 1, Amount: 30},
+        {Day: "2026-01-02", Status: "pending", Currency: "USDT", Count: 1, Amount: 40},
+    }
+    if fmt.Sprint(rows) != fmt.Sprint(want) {
+        t.Fatalf("expected %+v, got %+v", want, rows)
+    }
+
+    rows, err = st.WithdrawalStats(ctx, store.WithdrawalStatsQuery{From: from, To: to})
+    if err != nil {
+        t.Fatalf("stats total: %v", err)
+    }
+    want = []store.WithdrawalStatsRow{
+        {Currency: "EUR", Count: 1, Amount: 7},
+        {Currency: "USDT", Count: 4, Amount: 100},
+    }
+    if fmt.Sprint(rows) != fmt.Sprint(want) {
+        t.Fatalf("expected %+v, got %+v", want, rows)
+    }
+
+    exec(t, pool, `
+        UPDATE withdrawals SET confirmed_at = created_at + CASE idempotency_key
+            WHEN 'k2' THEN interval '13 hours'
+            ELSE interval '1 hour'
+        END
+        WHERE status = 'confirmed'
+    `)
+    rows, err = st.WithdrawalStats(ctx, store.WithdrawalStatsQuery{
+        From:           from,
+        To:             to,
+        GroupBy:        []string{store.GroupByDay, store.GroupByStatus},
+        ByConfirmation: true,
+    })
+    if err != nil {
+        t.Fatalf("stats by confirmation: %v", err)
+    }
+    want = []store.WithdrawalStatsRow{
+        {Day: "2026-01-01", Status: "confirmed", Currency: "USDT", Count: 1, Amount: 30},
+        {Day: "2026-01-02", Status: "confirmed", Currency: "USDT", Count: 1, Amount: 20},
+    }
+    if fmt.Sprint(rows) != fmt.Sprint(want) {
+        t.Fatalf("expected %+v, got %+v", want, rows)
+    }
+}
+
+func TestSendDailySummaryOnce(t *testing.T) {
+    st, _ := setupStore(t)
+    ctx := context.Background()
+    day := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+    sendErr := errors.New("relay down")
+    sent, err := st.SendDailySummaryOnce(ctx, day, func(context.Context) error { return sendErr })
+    if !errors.Is(err, sendErr) || sent {
+        t.Fatalf("expected the send error, got %v, %v", sent, err)
+    }
+
+    calls := 0
+    send := func(context.Context) error {
+        calls++
+        return nil
+    }
+    for i := 0; i < 2; i++ {
+        if _, err := st.SendDailySummaryOnce(ctx, day, send); err != nil {
+            t.Fatalf("send %d: %v", i, err)
+        }
+    }
+    if calls != 1 {
+        t.Fatalf("expected one send after the failed one, got %d", calls)
+    }
+
+    sent, err = st.SendDailySummaryOnce(ctx, day.AddDate(0, 0, 1), send)
+    if err != nil || !sent || calls != 2 {
+        t.Fatalf("expected the next day to be sent, got %v, %v, %d calls", sent, err, calls)
+    }
+}
+
+func TestFindWithdrawalsByDestination(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key, created_at)
+        VALUES (1, 10, 'USDT', 'flagged', 'pending', 'k1', '2026-01-01T00:00:00Z'),
+               (2, 20, 'USDT', 'flagged', 'confirmed', 'k2', '2026-01-02T00:00:00Z'),
+               (1, 30, 'USDT', 'other', 'pending', 'k3', '2026-01-02T00:00:00Z'),
+               (2, 40, 'USDT', 'flagged', 'pending', 'k4', '2026-01-03T00:00:00Z')
+    `)
+
+    var ids []int64
+    filter := store.DestinationFilter{Destination: "flagged", Limit: 2}
+    for {
+        page, err := st.FindWithdrawalsByDestination(ctx, filter)
+        if err != nil {
+            t.Fatalf("find by destination: %v", err)
+        }
+        for _, w := range page {
+            ids = append(ids, w.ID)
+        }
+        if len(page) < filter.Limit {
+            break
+        }
+        filter.Before = page[len(page)-1].ID
+    }
+    if fmt.Sprint(ids) != "[4 2 1]" {
+        t.Fatalf("unexpected ids: %v", ids)
+    }
+
+    from := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+    to := time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC)
+    ranged, err := st.FindWithdrawalsByDestination(ctx, store.DestinationFilter{Destination: "flagged", From: &from, To: &to})
+    if err != nil {
+        t.Fatalf("find in range: %v", err)
+    }
+    if len(ranged) != 1 || ranged[0].ID != 2 {
+        t.Fatalf("unexpected withdrawals in range: %+v", ranged)
+    }
+
+    none, err := st.FindWithdrawalsByDestination(ctx, store.DestinationFilter{Destination: "unknown"})
+    if err != nil || len(none) != 0 {
+        t.Fatalf("expected no withdrawals, got %d err=%v", len(none), err)
+    }
+
+    bounded, err := st.FindWithdrawalsByDestination(ctx, store.DestinationFilter{Destination: "flagged", MinAmount: amount(20), MaxAmount: amount(40)})
+    if err != nil || len(bounded) != 2 || bounded[0].ID != 4 || bounded[1].ID != 2 {
+        t.Fatalf("expected withdrawals 4 and 2 within the amount bounds, got %+v err=%v", bounded, err)
+    }
+    none, err = st.FindWithdrawalsByDestination(ctx, store.DestinationFilter{Destination: "flagged", MaxAmount: amount(0)})
+    if err != nil || len(none) != 0 {
+        t.Fatalf("expected a zero max amount to match nothing, got %d err=%v", len(none), err)
+    }
+    if _, err := st.FindWithdrawalsByDestination(ctx, store.DestinationFilter{Destination: "flagged", MinAmount: amount(5), MaxAmount: amount(1)}); !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter for crossed bounds, got %v", err)
+    }
+
+    if _, err := st.FindWithdrawalsByDestination(ctx, store.DestinationFilter{}); !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter, got %v", err)
+    }
+}
+
+func TestTopWithdrawalRecipients(t *testing.T) {
+    st, pool := setupStore(t, store.WithSupportedCurrencies("USDT", "EUR"))
+    ctx := context.Background()
+    if err := st.RegisterCurrencies(ctx); err != nil {
+        t.Fatalf("register currencies: %v", err)
+    }
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (1, 10, 'USDT', 'small', 'confirmed', 'k1'),
+               (1, 30, 'USDT', 'big', 'confirmed', 'k2'),
+               (1, 25, 'USDT', 'big', 'expired', 'k3'),
+               (1, 40, 'USDT', 'medium', 'pending', 'k4'),
+               (1, 35, 'USDT', 'big', 'reversed', 'k5'),
+               (1, 15, 'EUR', 'big', 'confirmed', 'k6'),
+               (1, 5, 'USDT', 'big', 'confirmed', 'k7'),
+               (2, 500, 'USDT', 'small', 'confirmed', 'k8')
+    `)
+
+    got, err := st.TopWithdrawalRecipients(ctx, 1, 3)
+    if err != nil {
+        t.Fatalf("top recipients: %v", err)
+    }
+    want := []store.RecipientSummary{
+        {Destination: "big", Currency: "USDT", Count: 2, TotalAmount: 35},
+        {Destination: "big", Currency: "EUR", Count: 1, TotalAmount: 15},
+        {Destination: "small", Currency: "USDT", Count: 1, TotalAmount: 10},
+    }
+    if fmt.Sprint(got) != fmt.Sprint(want) {
+        t.Fatalf("expected %+v, got %+v", want, got)
+    }
+
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+        SELECT 2, 1, 'USDT', 'addr-' || i, 'confirmed', 'bulk-' || i
+        FROM generate_series(1, 150) AS i
+    `)
+    capped, err := st.TopWithdrawalRecipients(ctx, 2, 1000)
+    if err != nil {
+        t.Fatalf("top recipients: %v", err)
+    }
+    if len(capped) != store.MaxTopRecipients || capped[0].Destination != "small" {
+        t.Fatalf("expected %d recipients led by small, got %d", store.MaxTopRecipients, len(capped))
+    }
+
+    defaulted, err := st.TopWithdrawalRecipients(ctx, 2, 0)
+    if err != nil || len(defaulted) != store.DefaultTopRecipients {
+        t.Fatalf("expected %d recipients, got %d err=%v", store.DefaultTopRecipients, len(defaulted), err)
+    }
+}
+
+func TestAuditLogChain(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    var inserted []store.AuditEntry
+    for i, actor := range []string{"billing", "ops", "billing"} {
+        e, err := st.InsertAuditEntry(ctx, store.AuditEntry{
+            Actor:        actor,
+            Action:       "withdrawal.create",
+            ResourceType: "withdrawal",
+            ResourceID:   fmt.Sprint(i%2 + 1),
+            RequestID:    fmt.Sprintf("req-%d", i),
+            Summary:      map[string]any{"amount": 100 * (i + 1)},
+        })
+        if err != nil {
+            t.Fatalf("insert audit entry: %v", err)
+        }
+        inserted = append(inserted, e)
+    }
+    ids := []int64{inserted[0].ID, inserted[1].ID, inserted[2].ID}
+    if inserted[0].PrevHash != "" || inserted[1].PrevHash != "" || inserted[2].PrevHash != inserted[0].Hash {
+        t.Fatalf("expected each resource chained on its own: %+v", inserted)
+    }
+
+    entries, err := st.ListAuditEntries(ctx, store.AuditFilter{Actor: "billing"})
+    if err != nil {
+        t.Fatalf("list audit entries: %v", err)
+    }
+    if len(entries) != 2 || entries[0].ID != ids[2] || entries[1].ID != ids[0] {
+        t.Fatalf("unexpected entries: %+v", entries)
+    }
+
+    broken, err := st.VerifyAuditLog(ctx)
+    if err != nil || broken != 0 {
+        t.Fatalf("expected intact log, got broken=%d err=%v", broken, err)
+    }
+
+    exec(t, pool, `DELETE FROM audit_log WHERE id = $1`, ids[0])
+    broken, err = st.VerifyAuditLog(ctx)
+    if err != nil || broken != ids[2] {
+        t.Fatalf("expected entry %d to be reported, got broken=%d err=%v", ids[2], broken, err)
+    }
+    exec(t, pool, `UPDATE audit_log SET summary = '{"amount":1}' WHERE id = $1`, ids[1])
+    broken, err = st.VerifyAuditLog(ctx)
+    if err != nil || broken != ids[1] {
+        t.Fatalf("expected entry %d to be reported, got broken=%d err=%v", ids[1], broken, err)
+    }
+}
+
+func TestAuditEntryInOperationTransaction(t *testing.T) {
+    st, pool := setupStore(t)
+
+    ctx := store.WithAudit(context.Background(), func(u store.User) store.AuditEntry {
+        return store.AuditEntry{Actor: "ops", Action: "user.create", ResourceType: "user", ResourceID: fmt.Sprint(u.ID)}
+    })
+    if _, err := st.CreateUser(ctx, 1, 100, nil); err != nil {
+        t.Fatalf("create user: %v", err)
+    }
+    if _, err := st.CreateUser(ctx, 1, 100, nil); !errors.Is(err, store.ErrUserExists) {
+        t.Fatalf("expected ErrUserExists, got %v", err)
+    }
+    var count int
+    if err := pool.QueryRow(context.Background(), "SELECT count(*) FROM audit_log WHERE resource_type = 'user' AND resource_id = '1'").Scan(&count); err != nil {
+        t.Fatalf("count audit entries: %v", err)
+    }
+    if count != 1 {
+        t.Fatalf("expected only the committed create audited, got %d entries", count)
+    }
+
+    // An entry that cannot be built for the result rolls the operation back.
+    mismatched := store.WithAudit(context.Background(), func(w store.Withdrawal) store.AuditEntry {
+        return store.AuditEntry{}
+    })
+    if _, err := st.CreateUser(mismatched, 2, 100, nil); err == nil {
+        t.Fatalf("expected an error for an entry of the wrong result type")
+    }
+    if _, err := st.GetUser(context.Background(), 2); !errors.Is(err, store.ErrUserNotFound) {
+        t.Fatalf("expected the create rolled back, got %v", err)
+    }
+}
+
+func TestMaintenance(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    if enabled, err := st.Maintenance(ctx); err != nil || enabled {
+        t.Fatalf("expected maintenance off until set, got %t (%v)", enabled, err)
+    }
+    if changed, err := st.SetMaintenance(ctx, false); err != nil || changed {
+        t.Fatalf("expected turning an unset mode off to change nothing, got %t (%v)", changed, err)
+    }
+
+    // A replica sharing the database sees the mode set through another.
+    replica := store.New(pool)
+    if changed, err := st.SetMaintenance(ctx, true); err != nil || !changed {
+        t.Fatalf("expected maintenance turned on, got %t (%v)", changed, err)
+    }
+    if enabled, err := replica.Maintenance(ctx); err != nil || !enabled {
+        t.Fatalf("expected the replica to see maintenance on, got %t (%v)", enabled, err)
+    }
+    if changed, err := replica.SetMaintenance(ctx, true); err != nil || changed {
+        t.Fatalf("expected turning maintenance on again to change nothing, got %t (%v)", changed, err)
+    }
+}
+
+func TestGetWithdrawalTimeSeries(t *testing.T) {
+    st, pool := setupStore(t, store.WithSupportedCurrencies("USDT", "EUR"))
+    ctx := context.Background()
+    if err := st.RegisterCurrencies(ctx); err != nil {
+        t.Fatalf("register currencies: %v", err)
+    }
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key, created_at)
+        VALUES (1, 10, 'USDT', 'a', 'pending', 'k1', '2026-01-01T10:00:00Z'),
+               (1, 20, 'USDT', 'a', 'confirmed', 'k2', '2026-01-01T10:59:59Z'),
+               (1, 30, 'USDT', 'a', 'confirmed', 'k3', '2026-01-01T12:30:00Z'),
+               (1, 40, 'USDT', 'a', 'pending', 'k4', '2026-01-01T14:00:00Z'),
+               (2, 50, 'USDT', 'a', 'pending', 'k5', '2026-01-01T10:15:00Z'),
+               (1, 5, 'EUR', 'a', 'pending', 'k6', '2026-01-01T10:30:00Z')
+    `)
+
+    from := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+    to := time.Date(2026, 1, 1, 14, 0, 0, 0, time.UTC)
+
+    buckets, err := st.GetWithdrawalTimeSeries(ctx, 1, from, to, 60)
+    if err != nil {
+        t.Fatalf("time series: %v", err)
+    }
+    want := []store.TimeSeriesBucket{
+        {BucketStart: from, Currency: "EUR", Count: 1, TotalAmount: 5},
+        {BucketStart: from, Currency: "USDT", Count: 2, TotalAmount: 30},
+        {BucketStart: from.Add(2 * time.Hour), Currency: "USDT", Count: 1, TotalAmount: 30},
+    }
+    if fmt.Sprint(buckets) != fmt.Sprint(want) {
+        t.Fatalf("expected %+v, got %+v", want, buckets)
+    }
+
+    // Buckets are aligned to from, not to the top of the hour.
+    buckets, err = st.GetWithdrawalTimeSeries(ctx, 1, from.Add(30*time.Minute), to, 120)
+    if err != nil {
+        t.Fatalf("time series: %v", err)
+    }
+    want = []store.TimeSeriesBucket{
+        {BucketStart: from.Add(30 * time.Minute), Currency: "EUR", Count: 1, TotalAmount: 5},
+        {BucketStart: from.Add(30 * time.Minute), Currency: "USDT", Count: 1, TotalAmount: 20},
+        {BucketStart: from.Add(150 * time.Minute), Currency: "USDT", Count: 1, TotalAmount: 30},
+    }
+    if fmt.Sprint(buckets) != fmt.Sprint(want) {
+        t.Fatalf("expected %+v, got %+v", want, buckets)
+    }
+
+    _, err = st.GetWithdrawalTimeSeries(ctx, 1, from, from.Add(91*24*time.Hour), 60)
+    if !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter for a 91-day range, got %v", err)
+    }
+}
+
+func seedWithdrawals(t *testing.T, pool *pgxpool.Pool, userID int64, n int) {
+    t.Helper()
+
+    for i := 1; i <= n; i++ {
+        exec(t, pool, `
+            INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+            VALUES ($1, $2, 'USDT', 'a', 'pending', $3)
+        `, userID, int64(i), fmt.Sprintf("k%d", i))
+    }
+}
+
+func pageAll(t *testing.T, st *store.Store, direction string, limit int) []int64 {
+    t.Helper()
+
+    var ids []int64
+    filter := store.ListWithdrawalsFilter{UserID: 1, Direction: direction, Limit: limit}
+    for {
+        page, err := st.ListWithdrawals(context.Background(), filter)
+        if err != nil {
+            t.Fatalf("list withdrawals: %v", err)
+        }
+        for _, w := range page {
+            ids = append(ids, w.ID)
+        }
+        if len(page) < limit {
+            return ids
+        }
+        last := page[len(page)-1].ID
+        if direction == store.DirectionDesc {
+            filter.Before = last
+        } else {
+            filter.After = last
+        }
+    }
+}
+
+func TestListWithdrawalsReverse(t *testing.T) {
+    st, pool := setupStore(t)
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    seedWithdrawals(t, pool, 1, 5)
+
+    page, err := st.ListWithdrawals(context.Background(), store.ListWithdrawalsFilter{
+        UserID:    1,
+        Direction: store.DirectionDesc,
+        Before:    4,
+        Limit:     2,
+    })
+    if err != nil {
+        t.Fatalf("list withdrawals: %v", err)
+    }
+    if len(page) != 2 || page[0].ID != 3 || page[1].ID != 2 {
+        t.Fatalf("unexpected page: %+v", page)
+    }
+
+    if ids := pageAll(t, st, store.DirectionDesc, 2); len(ids) != 5 || ids[0] != 5 || ids[4] != 1 {
+        t.Fatalf("unexpected reverse iteration: %v", ids)
+    }
+}
+
+func TestWithdrawalsByDestination(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (1, 10, 'USDT', 'TAbc', 'pending', 'k1'),
+               (2, 20, 'USDT', 'TAbc', 'confirmed', 'k2'),
+               (1, 30, 'USDT', 'tabc', 'pending', 'k3'),
+               (1, 40, 'USDT', 'TAbcd', 'pending', 'k4')
+    `)
+
+    tests := []struct {
+        destination string
+        filter      store.ListWithdrawalsFilter
+        want        []int64
+    }{
+        {"TAbc", store.ListWithdrawalsFilter{}, []int64{1, 2}},
+        {"tabc", store.ListWithdrawalsFilter{}, []int64{3}},
+        {"TABC", store.ListWithdrawalsFilter{}, nil},
+        {"TAb", store.ListWithdrawalsFilter{}, nil},
+        {"TAbc", store.ListWithdrawalsFilter{Status: store.StatusConfirmed}, []int64{2}},
+        {"TAbc", store.ListWithdrawalsFilter{Destination: "tabc"}, []int64{1, 2}},
+    }
+    for _, tt := range tests {
+        got, err := st.WithdrawalsByDestination(ctx, tt.destination, tt.filter)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.destination, err)
+        }
+        var ids []int64
+        for _, w := range got {
+            ids = append(ids, w.ID)
+        }
+        if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+            t.Fatalf("%s %+v: expected %v, got %v", tt.destination, tt.filter, tt.want, ids)
+        }
+    }
+
+    if _, err := st.WithdrawalsByDestination(ctx, "", store.ListWithdrawalsFilter{}); !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter for an empty destination, got %v", err)
+    }
+}
+
+func TestListWithdrawalsSorted(t *testing.T) {
+    st, pool := setupStore(t)
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    seedWithdrawals(t, pool, 1, 3)
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (1, 2, 'USDT', 'a', 'pending', 'dup1'), (1, 2, 'USDT', 'a', 'pending', 'dup2')
+    `)
+
+    var got []int64
+    filter := store.ListWithdrawalsFilter{UserID: 1, Sort: "-amount", Limit: 2}
+    for {
+        page, err := st.ListWithdrawals(context.Background(), filter)
+        if err != nil {
+            t.Fatalf("list withdrawals: %v", err)
+        }
+        for _, w := range page {
+            got = append(got, w.ID)
+        }
+        if len(page) < filter.Limit {
+            break
+        }
+        filter.Cursor = store.EncodeSortCursor(filter.Sort, page[len(page)-1])
+    }
+
+    // amount 3, then the three rows with amount 2 by id descending, then 1.
+    want := []int64{3, 5, 4, 2, 1}
+    if fmt.Sprint(got) != fmt.Sprint(want) {
+        t.Fatalf("expected %v, got %v", want, got)
+    }
+
+    _, err := st.ListWithdrawals(context.Background(), store.ListWithdrawalsFilter{
+        UserID: 1,
+        Sort:   "amount",
+        Cursor: store.EncodeSortCursor("-amount", store.Withdrawal{ID: 1, Amount: 1}),
+    })
+    if !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter for a cursor from another sort, got %v", err)
+    }
+}
+
+func TestListWithdrawalsSortBy(t *testing.T) {
+    st, pool := setupStore(t)
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    // Amounts and creation times each order the rows differently from ids.
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key, created_at)
+        VALUES (1, 20, 'USDT', 'a', 'pending', 'k1', '2026-01-02T00:00:00Z'),
+               (1, 30, 'USDT', 'a', 'pending', 'k2', '2026-01-01T00:00:00Z'),
+               (1, 10, 'USDT', 'a', 'pending', 'k3', '2026-01-03T00:00:00Z')
+    `)
+
+    tests := []struct {
+        by   store.SortBy
+        want []int64
+    }{
+        {store.SortBy{Field: "amount", Order: "asc"}, []int64{3, 1, 2}},
+        {store.SortBy{Field: "amount", Order: "desc"}, []int64{2, 1, 3}},
+        {store.SortBy{Field: "created_at", Order: "asc"}, []int64{2, 1, 3}},
+        {store.SortBy{Field: "created_at", Order: "desc"}, []int64{3, 1, 2}},
+        {store.SortBy{Field: "amount"}, []int64{3, 1, 2}},
+    }
+    for _, tt := range tests {
+        sort, err := tt.by.Key()
+        if err != nil {
+            t.Fatalf("%+v: %v", tt.by, err)
+        }
+        // Page by one to exercise the cursor of each ordering as well.
+        filter := store.ListWithdrawalsFilter{UserID: 1, Sort: sort, Limit: 1}
+        var got []int64
+        for len(got) <= len(tt.want) {
+            page, err := st.ListWithdrawals(context.Background(), filter)
+            if err != nil {
+                t.Fatalf("%+v: list withdrawals: %v", tt.by, err)
+            }
+            if len(page) == 0 {
+                break
+            }
+            got = append(got, page[0].ID)
+            filter.Cursor = store.EncodeSortCursor(sort, page[0])
+        }
+        if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Fatalf("%+v: expected %v, got %v", tt.by, tt.want, got)
+        }
+    }
+}
+
+func TestListWithdrawalsWithTotal(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    seedWithdrawals(t, pool, 1, 5)
+    seedWithdrawals(t, pool, 2, 2)
+    exec(t, pool, "UPDATE withdrawals SET status = 'confirmed' WHERE id IN (1, 2)")
+
+    page, total, err := st.ListWithdrawalsWithTotal(ctx, store.ListWithdrawalsFilter{
+        UserID: 1,
+        Status: "pending",
+        After:  3,
+        Limit:  1,
+    })
+    if err != nil {
+        t.Fatalf("list withdrawals: %v", err)
+    }
+    if len(page) != 1 || page[0].ID != 4 {
+        t.Fatalf("unexpected page: %+v", page)
+    }
+    // The cursor narrows the page but not the total.
+    if total != (store.Total{Count: 3}) {
+        t.Fatalf("expected 3 pending withdrawals of user 1, got %+v", total)
+    }
+
+    capped := store.New(pool, store.WithCountCap(4))
+    _, total, err = capped.ListWithdrawalsWithTotal(ctx, store.ListWithdrawalsFilter{})
+    if err != nil {
+        t.Fatalf("list withdrawals: %v", err)
+    }
+    if total != (store.Total{Count: 4, Capped: true}) {
+        t.Fatalf("expected capped total, got %+v", total)
+    }
+    _, total, err = capped.ListWithdrawalsWithTotal(ctx, store.ListWithdrawalsFilter{UserID: 2})
+    if err != nil {
+        t.Fatalf("list withdrawals: %v", err)
+    }
+    if total != (store.Total{Count: 2}) {
+        t.Fatalf("expected exact total below the cap, got %+v", total)
+    }
+
+    _, total, err = st.FindWithdrawalsByDestinationWithTotal(ctx, store.DestinationFilter{Destination: "a", Before: 7})
+    if err != nil {
+        t.Fatalf("find withdrawals: %v", err)
+    }
+    if total != (store.Total{Count: 7}) {
+        t.Fatalf("expected 7 withdrawals to destination a, got %+v", total)
+    }
+}
+
+func TestListWithdrawalsAmountAndDestination(t *testing.T) {
+    st, pool := setupStore(t)
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 100000), (2, 100000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (id, user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (1, 1, 15000, 'USDT', 'flagged', 'pending', 'k1'),
+               (2, 1, 5000, 'USDT', 'flagged', 'pending', 'k2'),
+               (3, 2, 20000, 'USDT', 'flagged', 'confirmed', 'k3'),
+               (4, 2, 30000, 'USDT', 'other', 'pending', 'k4'),
+               (5, 2, 10000, 'USDT', 'flagged', 'pending', 'k5')
+    `)
+
+    tests := []struct {
+        name   string
+        filter store.ListWithdrawalsFilter
+        want   []int64
+    }{
+        {"destination across users", store.ListWithdrawalsFilter{Destination: "flagged"}, []int64{1, 2, 3, 5}},
+        {"min amount is inclusive", store.ListWithdrawalsFilter{Destination: "flagged", MinAmount: amount(10000)}, []int64{1, 3, 5}},
+        {"amount range", store.ListWithdrawalsFilter{MinAmount: amount(10000), MaxAmount: amount(20000)}, []int64{1, 3, 5}},
+        {"with status and user", store.ListWithdrawalsFilter{UserID: 2, Status: "pending", MinAmount: amount(10000)}, []int64{4, 5}},
+        {"exact match only", store.ListWithdrawalsFilter{Destination: "FLAGGED"}, nil},
+        {"max amount is inclusive", store.ListWithdrawalsFilter{MaxAmount: amount(10000)}, []int64{2, 5}},
+        {"equal bounds", store.ListWithdrawalsFilter{MinAmount: amount(5000), MaxAmount: amount(5000)}, []int64{2}},
+        {"zero max amount is a bound", store.ListWithdrawalsFilter{MaxAmount: amount(0)}, nil},
+    }
+    for _, tt := range tests {
+        page, err := st.ListWithdrawals(ctx, tt.filter)
+        if err != nil {
+            t.Fatalf("%s: %v", tt.name, err)
+        }
+        var got []int64
+        for _, w := range page {
+            got = append(got, w.ID)
+        }
+        if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+            t.Fatalf("%s: expected %v, got %v", tt.name, tt.want, got)
+        }
+    }
+
+    _, err := st.ListWithdrawals(ctx, store.ListWithdrawalsFilter{MinAmount: amount(10), MaxAmount: amount(5)})
+    if !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter for min > max, got %v", err)
+    }
+    _, err = st.ListWithdrawals(ctx, store.ListWithdrawalsFilter{MinAmount: amount(-1)})
+    if !errors.Is(err, store.ErrInvalidFilter) {
+        t.Fatalf("expected ErrInvalidFilter for a negative min amount, got %v", err)
+    }
+}
+
+func amount(n int64) *int64 {
+    return &n
+}
+
+func TestListWithdrawalsBidirectional(t *testing.T) {
+    st, pool := setupStore(t)
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000), (2, 1000)")
+    seedWithdrawals(t, pool, 1, 7)
+    exec(t, pool, `
+        INSERT INTO withdrawals (user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (2, 1, 'USDT', 'a', 'pending', 'other')
+    `)
+
+    forward := pageAll(t, st, store.DirectionAsc, 3)
+    backward := pageAll(t, st, store.DirectionDesc, 3)
+
+    if len(forward) != 7 || len(backward) != len(forward) {
+        t.Fatalf("expected 7 withdrawals each way, got %d and %d", len(forward), len(backward))
+    }
+    for i := range forward {
+        if forward[i] != backward[len(backward)-1-i] {
+            t.Fatalf("forward %v is not the reverse of backward %v", forward, backward)
+        }
+    }
+}
+
+func applySchema(t testing.TB, pool *pgxpool.Pool) {
+    t.Helper()
+
+    data, err := os.ReadFile(filepath.Join("..", "..", "schema.sql"))
+    if err != nil {
+        t.Fatalf("read schema: %v", err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    for _, stmt := range strings.Split(string(data), ";") {
+        s := strings.TrimSpace(stmt)
+        if s == "" {
+            continue
+        }
+        if _, err := pool.Exec(ctx, s); err != nil {
+            t.Fatalf("apply schema: %v", err)
+        }
+    }
+}
+
+func TestCreateUserOpeningLedgerEntry(t *testing.T) {
+    st, pool := setupStore(t, store.WithOpeningLedgerEntries(true))
+    ctx := context.Background()
+
+    if _, err := st.CreateUser(ctx, 1, 1000, nil); err != nil {
+        t.Fatalf("create user: %v", err)
+    }
+    if _, err := st.CreateUser(ctx, 2, 0, nil); err != nil {
+        t.Fatalf("create user with empty balance: %v", err)
+    }
+    if _, err := st.CreateUsers(ctx, []store.NewUser{{ID: 3, Balance: 300}, {ID: 1, Balance: 50}}); err != nil {
+        t.Fatalf("create users: %v", err)
+    }
+
+    for _, tc := range []struct {
+        userID int64
+        credit int64
+    }{{1, 1000}, {2, 0}, {3, 300}} {
+        debit, credit, err := st.SumLedgerByDirection(ctx, tc.userID)
+        if err != nil {
+            t.Fatalf("sum ledger: %v", err)
+        }
+        if debit != 0 || credit != tc.credit {
+            t.Fatalf("user %d: expected credit %d, got debit %d credit %d", tc.userID, tc.credit, debit, credit)
+        }
+    }
+
+    var withdrawalID *int64
+    var direction string
+    if err := pool.QueryRow(ctx, "SELECT withdrawal_id, direction FROM ledger_entries WHERE user_id = 1").Scan(&withdrawalID, &direction); err != nil {
+        t.Fatalf("read opening entry: %v", err)
+    }
+    if withdrawalID != nil || direction != store.DirectionCredit {
+        t.Fatalf("unexpected opening entry: withdrawal_id=%v direction=%s", withdrawalID, direction)
+    }
+
+    plain, plainPool := setupStore(t)
+    if _, err := plain.CreateUser(ctx, 1, 1000, nil); err != nil {
+        t.Fatalf("create user: %v", err)
+    }
+    var count int
+    if err := plainPool.QueryRow(ctx, "SELECT count(*) FROM ledger_entries").Scan(&count); err != nil {
+        t.Fatalf("count ledger entries: %v", err)
+    }
+    if count != 0 {
+        t.Fatalf("expected no opening entry by default, got %d", count)
+    }
+}
+
+func TestConfirmSettlementLedgerEntry(t *testing.T) {
+    for _, enabled := range []bool{false, true} {
+        st, pool := setupStore(t, store.WithSettlementLedgerEntries(enabled))
+        ctx := context.Background()
+
+        exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+        created, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1"})
+        if err != nil {
+            t.Fatalf("create: %v", err)
+        }
+        // Confirming twice settles once.
+        for i := 0; i < 2; i++ {
+            if _, err := st.ConfirmWithdrawal(ctx, created.ID); err != nil {
+                t.Fatalf("confirm: %v", err)
+            }
+        }
+
+        _, entries, err := st.GetWithdrawalWithLedger(ctx, created.ID)
+        if err != nil {
+            t.Fatalf("get with ledger: %v", err)
+        }
+        var directions []string
+        for _, e := range entries {
+            directions = append(directions, e.Direction)
+        }
+        want := []string{store.DirectionDebit}
+        if enabled {
+            want = append(want, store.DirectionSettlement)
+            if settlement := entries[1]; settlement.Amount != 100 || settlement.Currency != "USDT" || settlement.WithdrawalID != created.ID {
+                t.Fatalf("unexpected settlement entry: %+v", settlement)
+            }
+        }
+        if fmt.Sprint(directions) != fmt.Sprint(want) {
+            t.Fatalf("settlement %t: expected entries %v, got %v", enabled, want, directions)
+        }
+
+        // The marker moves no money.
+        debit, credit, err := st.SumLedgerByDirection(ctx, 1)
+        if err != nil || debit != 100 || credit != 0 {
+            t.Fatalf("settlement %t: expected debit 100 and credit 0, got %d, %d, %v", enabled, debit, credit, err)
+        }
+        summary, err := st.GetLedgerSummary(ctx, 1, store.LedgerSummaryFilter{})
+        if err != nil || summary.Net != -100 || summary.Count != int64(len(want)) {
+            t.Fatalf("settlement %t: expected net -100 over %d entries, got %+v, %v", enabled, len(want), summary, err)
+        }
+        if got := st.Features()["settlement_ledger_entries"]; got != enabled {
+            t.Fatalf("expected the feature reported as %t, got %t", enabled, got)
+        }
+    }
+}
+
+func TestRefundEntryNetsWithdrawalToZero(t *testing.T) {
+    st, pool := setupStore(t,
+        store.WithReservationTTL(time.Millisecond),
+        store.WithFeePolicies(map[string]store.FeePolicy{"USDT": {BasisPoints: 100, Rounding: store.RoundCeil}}),
+    )
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    w, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    time.Sleep(10 * time.Millisecond)
+    if _, err := st.ReleaseExpiredReservations(ctx, 10); err != nil {
+        t.Fatalf("release: %v", err)
+    }
+
+    _, entries, err := st.GetWithdrawalWithLedger(ctx, w.ID)
+    if err != nil {
+        t.Fatalf("get ledger: %v", err)
+    }
+    var net int64
+    var refund *store.LedgerEntry
+    for i, e := range entries {
+        if e.Direction == store.DirectionCredit {
+            net += e.Amount
+            refund = &entries[i]
+        } else {
+            net -= e.Amount
+        }
+    }
+    if len(entries) != 3 || net != 0 {
+        t.Fatalf("expected debit, fee and credit netting to zero, got %+v", entries)
+    }
+    if refund == nil || refund.Reason != store.RefundReasonExpired {
+        t.Fatalf("expected a credit with reason expired, got %+v", refund)
+    }
+
+    _, err = pool.Exec(ctx, `
+        INSERT INTO ledger_entries (user_id, withdrawal_id, amount, currency, direction, reason)
+        VALUES (1, $1, 101, 'USDT', 'credit', 'failed')
+    `, w.ID)
+    if err == nil {
+        t.Fatalf("expected a second refund of the same withdrawal to be rejected")
+    }
+}
+
+func TestArchiveLedgerEntries(t *testing.T) {
+    now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+    st, pool := setupStore(t, store.WithClock(testutil.NewFakeClock(now)))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    exec(t, pool, `
+        INSERT INTO withdrawals (id, user_id, amount, currency, destination, status, idempotency_key)
+        VALUES (1, 1, 100, 'USDT', 'a', 'reversed', 'k1'),
+               (2, 1, 50, 'USDT', 'b', 'confirmed', 'k2'),
+               (3, 1, 70, 'USDT', 'c', 'expired', 'k3')
+    `)
+    old := now.AddDate(0, 0, -31)
+    _, err := pool.Exec(ctx, `
+        INSERT INTO ledger_entries (id, user_id, withdrawal_id, amount, currency, direction, reason, created_at)
+        VALUES (1, 1, 1, 100, 'USDT', 'debit', '', $1),
+               (2, 1, 1, 5, 'USDT', 'fee', '', $2),
+               (3, 1, 1, 100, 'USDT', 'credit', 'reversed', $2),
+               (4, 1, 2, 50, 'USDT', 'debit', '', $1),
+               (5, 1, 3, 70, 'USDT', 'debit', '', $1),
+               (6, 1, 3, 70, 'USDT', 'credit', 'expired', $3),
+               (7, 1, NULL, 1000, 'USDT', 'credit', '', $1),
+               (8, 1, NULL, 1000, 'USDT', 'credit', '', $3)
+    `, old, now.AddDate(0, 0, -30).Add(-time.Second), now.AddDate(0, 0, -29))
+    if err != nil {
+        t.Fatalf("seed ledger: %v", err)
+    }
+    debitsBefore, creditsBefore, err := st.SumLedgerByDirection(ctx, 1)
+    if err != nil {
+        t.Fatalf("sum ledger: %v", err)
+    }
+
+    if _, err := st.ArchiveLedgerEntries(ctx, 0); !errors.Is(err, store.ErrInvalidRetention) {
+        t.Fatalf("expected ErrInvalidRetention for 0 days, got %v", err)
+    }
+
+    // Only the reversed withdrawal's entries and the old entry without a
+    // withdrawal move: withdrawal 2 is not terminal and withdrawal 3 still
+    // has an entry inside the window.
+    archived, err := st.ArchiveLedgerEntries(ctx, 30)
+    if err != nil {
+        t.Fatalf("archive: %v", err)
+    }
+    if archived != 4 {
+        t.Fatalf("expected 4 archived entries, got %d", archived)
+    }
+
+    var ids []int64
+    rows, err := pool.Query(ctx, "SELECT id FROM ledger_entries ORDER BY id")
+    if err != nil {
+        t.Fatalf("read ledger: %v", err)
+    }
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            t.Fatalf("scan ledger: %v", err)
+        }
+        ids = append(ids, id)
+    }
+    rows.Close()
+    if fmt.Sprint(ids) != "[4 5 6 8]" {
+        t.Fatalf("expected entries 4, 5, 6 and 8 left in the ledger, got %v", ids)
+    }
+
+    var debit store.LedgerEntry
+    err = pool.QueryRow(ctx, `
+        SELECT id, user_id, COALESCE(withdrawal_id, 0), amount, currency, direction, reason, created_at
+        FROM ledger_entries_archive
+        WHERE id = 1
+    `).Scan(&debit.ID, &debit.UserID, &debit.WithdrawalID, &debit.Amount, &debit.Currency, &debit.Direction, &debit.Reason, &debit.CreatedAt)
+    if err != nil {
+        t.Fatalf("read archived entry: %v", err)
+    }
+    if debit.UserID != 1 || debit.WithdrawalID != 1 || debit.Amount != 100 || debit.Direction != store.DirectionDebit || !debit.CreatedAt.Equal(old) {
+        t.Fatalf("unexpected archived entry: %+v", debit)
+    }
+
+    if archived, err := st.ArchiveLedgerEntries(ctx, 30); err != nil || archived != 0 {
+        t.Fatalf("expected a second run to archive nothing, got %d, %v", archived, err)
+    }
+
+    debits, credits, err := st.SumLedgerByDirection(ctx, 1)
+    if err != nil {
+        t.Fatalf("sum ledger: %v", err)
+    }
+    if debits != debitsBefore || credits != creditsBefore {
+        t.Fatalf("expected totals %d/%d to survive archiving, got %d/%d", debitsBefore, creditsBefore, debits, credits)
+    }
+    _, entries, err := st.GetWithdrawalWithLedger(ctx, 1)
+    if err != nil {
+        t.Fatalf("get withdrawal with ledger: %v", err)
+    }
+    if len(entries) != 3 || entries[0].ID != 1 || entries[2].ID != 3 {
+        t.Fatalf("expected the archived entries 1 to 3 in the withdrawal's ledger, got %+v", entries)
+    }
+}
+
+func TestRefundAfterArchiveLedgerEntries(t *testing.T) {
+    now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+    clock := testutil.NewFakeClock(now.AddDate(0, 0, -40))
+    st, pool := setupStore(t, store.WithClock(clock))
+    ctx := context.Background()
+
+    exec(t, pool, "INSERT INTO users (id, balance) VALUES (1, 1000)")
+    w, err := st.CreateWithdrawal(ctx, store.CreateWithdrawalInput{
+        UserID: 1, Amount: 100, Currency: "USDT", Destination: "a", IdempotencyKey: "k1",
+    })
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    if _, err := st.ConfirmWithdrawal(ctx, w.ID); err != nil {
+        t.Fatalf("confirm withdrawal: %v", err)
+    }
+    if _, err := st.ReverseWithdrawal(ctx, w.ID, "chargeback"); err != nil {
+        t.Fatalf("reverse withdrawal: %v", err)
+    }
+
+    clock.Set(now)
+    if archived, err := st.ArchiveLedgerEntries(ctx, 30); err != nil || archived == 0 {
+        t.Fatalf("expected the reversed withdrawal's entries archived, got %d, %v", archived, err)
+    }
+
+    // The credit now lives only in the archive, out of reach of the unique
+    // index; a second refund must still be refused.
+    exec(t, pool, fmt.Sprintf("UPDATE withdrawals SET status = 'confirmed' WHERE id = %d", w.ID))
+    if _, err := st.ReverseWithdrawal(ctx, w.ID, "chargeback"); !errors.Is(err, store.ErrAlreadyRefunded) {
+        t.Fatalf("expected ErrAlreadyRefunded, got %v", err)
+    }
+    var credits int
+    if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM ledger_entries WHERE withdrawal_id = $1", w.ID).Scan(&credits); err != nil {
+        t.Fatalf("count credits: %v", err)
+    }
+    if credits != 0 {
+        t.Fatalf("expected no new credit entry, got %d", credits)
+    }
+}
@@ -0,0 +1,373 @@
+// Package storetest is the conformance suite every store implementation must
+// pass: store.Store against Postgres and memstore.Store in memory. A method
+// joins Store when it gains a second implementation, together with tests here
+// for the invariants callers rely on, so the implementations cannot drift.
+package storetest
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "sync"
+    "testing"
+
+    "task.hh/internal/store"
+)
+
+// Store is the part of store.Store that has more than one implementation.
+type Store interface {
+    CreateUser(ctx context.Context, id int64, balance int64, externalID *string) (store.User, error)
+    GetUser(ctx context.Context, id int64) (store.User, error)
+    CreateWithdrawal(ctx context.Context, input store.CreateWithdrawalInput) (store.CreateWithdrawalResult, error)
+    GetWithdrawal(ctx context.Context, id int64) (store.Withdrawal, error)
+    ConfirmWithdrawal(ctx context.Context, id int64) (store.Withdrawal, error)
+}
+
+// Factory returns an empty store with default options. It is called once
+// per test and may skip t when the implementation is unavailable.
+type Factory func(t *testing.T) Store
+
+// Run runs the whole suite against the implementation called name. Failures
+// are reported under name/<test>, and their messages state the invariant
+// that was broken.
+func Run(t *testing.T, name string, newStore Factory) {
+    t.Run(name, func(t *testing.T) {
+        for _, tt := range []struct {
+            name string
+            run  func(*testing.T, Factory)
+        }{
+            {"CreateUser", TestCreateUser},
+            {"CreateWithdrawal", TestCreateWithdrawal},
+            {"CreateWithdrawalIdempotency", TestCreateWithdrawalIdempotency},
+            {"CreateWithdrawalInsufficientBalance", TestCreateWithdrawalInsufficientBalance},
+            {"CreateWithdrawalInvalidInput", TestCreateWithdrawalInvalidInput},
+            {"ConcurrentWithdrawals", TestConcurrentWithdrawals},
+            {"ConcurrentWithdrawalsSameKey", TestConcurrentWithdrawalsSameKey},
+            {"ConfirmWithdrawal", TestConfirmWithdrawal},
+        } {
+            t.Run(tt.name, func(t *testing.T) {
+                tt.run(t, newStore)
+            })
+        }
+    })
+}
+
+func withdrawal(userID, amount int64, key string) store.CreateWithdrawalInput {
+    return store.CreateWithdrawalInput{UserID: userID, Amount: amount, Currency: "USDT", Destination: "addr", IdempotencyKey: key}
+}
+
+func seedUser(t *testing.T, st Store, id, balance int64) {
+    t.Helper()
+
+    if _, err := st.CreateUser(context.Background(), id, balance, nil); err != nil {
+        t.Fatalf("seed user %d: %v", id, err)
+    }
+}
+
+func balance(t *testing.T, st Store, id int64) int64 {
+    t.Helper()
+
+    u, err := st.GetUser(context.Background(), id)
+    if err != nil {
+        t.Fatalf("get user %d: %v", id, err)
+    }
+    return u.Balance
+}
+
+// TestCreateUser checks that users read back as created and that ids are
+// unique.
+func TestCreateUser(t *testing.T, newStore Factory) {
+    st := newStore(t)
+    ctx := context.Background()
+
+    externalID := "crm-1"
+    created, err := st.CreateUser(ctx, 1, 500, &externalID)
+    if err != nil {
+        t.Fatalf("create user: %v", err)
+    }
+    if created.ID != 1 || created.Balance != 500 || created.Tier != store.TierStandard || created.ExternalID == nil || *created.ExternalID != externalID {
+        t.Fatalf("a created user keeps its fields: got %+v", created)
+    }
+    got, err := st.GetUser(ctx, 1)
+    if err != nil || got.Balance != 500 || !got.CreatedAt.Equal(created.CreatedAt) {
+        t.Fatalf("a created user reads back unchanged: got %+v, %v", got, err)
+    }
+
+    if _, err := st.CreateUser(ctx, 1, 100, nil); !errors.Is(err, store.ErrUserExists) {
+        t.Fatalf("a taken id gives ErrUserExists: got %v", err)
+    }
+    if _, err := st.CreateUser(ctx, 2, 100, &externalID); !errors.Is(err, store.ErrExternalIDExists) {
+        t.Fatalf("a taken external id gives ErrExternalIDExists: got %v", err)
+    }
+    if _, err := st.GetUser(ctx, 2); !errors.Is(err, store.ErrUserNotFound) {
+        t.Fatalf("an unknown user gives ErrUserNotFound: got %v", err)
+    }
+}
+
+// TestCreateWithdrawal checks that a withdrawal debits the balance and
+// starts pending.
+func TestCreateWithdrawal(t *testing.T, newStore Factory) {
+    st := newStore(t)
+    ctx := context.Background()
+    seedUser(t, st, 1, 1000)
+
+    created, err := st.CreateWithdrawal(ctx, withdrawal(1, 300, "k1"))
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    if created.ID == 0 || created.Status != store.StatusPending || created.Replayed || created.Version != 1 {
+        t.Fatalf("a new withdrawal is pending at version 1 and not replayed: got %+v", created.Withdrawal)
+    }
+    if created.Balance != 700 || balance(t, st, 1) != 700 {
+        t.Fatalf("a withdrawal debits its amount: result balance %d, stored balance %d, want 700", created.Balance, balance(t, st, 1))
+    }
+
+    got, err := st.GetWithdrawal(ctx, created.ID)
+    if err != nil {
+        t.Fatalf("get withdrawal: %v", err)
+    }
+    if got.Amount != 300 || got.Currency != "USDT" || got.Destination != "addr" || got.IdempotencyKey != "k1" || !got.CreatedAt.Equal(created.CreatedAt) {
+        t.Fatalf("a created withdrawal reads back unchanged: got %+v, want %+v", got, created.Withdrawal)
+    }
+
+    second, err := st.CreateWithdrawal(ctx, withdrawal(1, 100, "k2"))
+    if err != nil {
+        t.Fatalf("create second withdrawal: %v", err)
+    }
+    if second.ID == created.ID {
+        t.Fatalf("withdrawals get distinct ids: both got %d", created.ID)
+    }
+
+    if _, err := st.CreateWithdrawal(ctx, withdrawal(9, 100, "k1")); !errors.Is(err, store.ErrUserNotFound) {
+        t.Fatalf("a withdrawal for an unknown user gives ErrUserNotFound: got %v", err)
+    }
+    if _, err := st.GetWithdrawal(ctx, 999); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("an unknown withdrawal gives ErrNotFound: got %v", err)
+    }
+}
+
+// TestCreateWithdrawalIdempotency checks that a key replays its withdrawal
+// without a second debit, and rejects a different payload.
+func TestCreateWithdrawalIdempotency(t *testing.T, newStore Factory) {
+    st := newStore(t)
+    ctx := context.Background()
+    seedUser(t, st, 1, 1000)
+    seedUser(t, st, 2, 1000)
+
+    created, err := st.CreateWithdrawal(ctx, withdrawal(1, 300, "k1"))
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    replayed, err := st.CreateWithdrawal(ctx, withdrawal(1, 300, "k1"))
+    if err != nil {
+        t.Fatalf("replay withdrawal: %v", err)
+    }
+    if !replayed.Replayed || replayed.ID != created.ID {
+        t.Fatalf("a repeated key replays the withdrawal: got %+v, want id %d", replayed.Withdrawal, created.ID)
+    }
+    if replayed.Balance != 700 || balance(t, st, 1) != 700 {
+        t.Fatalf("a replay does not debit again: result balance %d, stored balance %d, want 700", replayed.Balance, balance(t, st, 1))
+    }
+
+    _, err = st.CreateWithdrawal(ctx, withdrawal(1, 301, "k1"))
+    var conflict *store.IdempotencyConflictError
+    if !errors.As(err, &conflict) || !errors.Is(err, store.ErrIdempotencyConflict) || conflict.Existing.ID != created.ID {
+        t.Fatalf("a repeated key with another amount gives an IdempotencyConflictError for withdrawal %d: got %v", created.ID, err)
+    }
+    other := withdrawal(1, 300, "k1")
+    other.Destination = "other"
+    if _, err := st.CreateWithdrawal(ctx, other); !errors.Is(err, store.ErrIdempotencyConflict) {
+        t.Fatalf("a repeated key with another destination gives ErrIdempotencyConflict: got %v", err)
+    }
+
+    second, err := st.CreateWithdrawal(ctx, withdrawal(2, 300, "k1"))
+    if err != nil || second.Replayed || second.ID == created.ID {
+        t.Fatalf("keys are scoped to the user: got %+v, %v", second.Withdrawal, err)
+    }
+}
+
+// TestCreateWithdrawalInvalidInput checks that keys and destinations are
+// trimmed before they are stored or compared, and that an input Normalize
+// rejects fails without changing anything.
+func TestCreateWithdrawalInvalidInput(t *testing.T, newStore Factory) {
+    st := newStore(t)
+    ctx := context.Background()
+    seedUser(t, st, 1, 1000)
+
+    padded := withdrawal(1, 100, " k1 ")
+    padded.Destination = "addr\t"
+    padded.Currency = "usdt"
+    created, err := st.CreateWithdrawal(ctx, padded)
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    if created.IdempotencyKey != "k1" || created.Destination != "addr" || created.Currency != "USDT" {
+        t.Fatalf("the key and destination are stored trimmed, the currency uppercase: got %q, %q, %q", created.IdempotencyKey, created.Destination, created.Currency)
+    }
+    replayed, err := st.CreateWithdrawal(ctx, withdrawal(1, 100, "k1"))
+    if err != nil || !replayed.Replayed || replayed.ID != created.ID {
+        t.Fatalf("a key differing only in surrounding spaces replays withdrawal %d: got %+v, %v", created.ID, replayed.Withdrawal, err)
+    }
+
+    invalid := withdrawal(1, 100, "k2\x00")
+    invalid.Destination = "a b"
+    invalid.Currency = "US-DT"
+    _, err = st.CreateWithdrawal(ctx, invalid)
+    var inputErr *store.InvalidInputError
+    if !errors.As(err, &inputErr) || !errors.Is(err, store.ErrInvalidInput) {
+        t.Fatalf("an invalid input gives an InvalidInputError: got %v", err)
+    }
+    want := []store.FieldError{
+        {Field: "idempotency_key", Reason: store.ReasonInvalidCharacters},
+        {Field: "destination", Reason: store.ReasonInvalidCharacters},
+        {Field: "currency", Reason: store.ReasonInvalidFormat},
+    }
+    if fmt.Sprint(inputErr.Fields) != fmt.Sprint(want) {
+        t.Fatalf("every invalid field is reported: got %v, want %v", inputErr.Fields, want)
+    }
+    if got := balance(t, st, 1); got != 900 {
+        t.Fatalf("a rejected input does not debit: balance %d, want 900", got)
+    }
+}
+
+// TestCreateWithdrawalInsufficientBalance checks that a withdrawal over the
+// balance fails without changing anything.
+func TestCreateWithdrawalInsufficientBalance(t *testing.T, newStore Factory) {
+    st := newStore(t)
+    ctx := context.Background()
+    seedUser(t, st, 1, 100)
+
+    _, err := st.CreateWithdrawal(ctx, withdrawal(1, 101, "k1"))
+    var insufficient *store.InsufficientBalanceError
+    if !errors.As(err, &insufficient) || !errors.Is(err, store.ErrInsufficientBalance) {
+        t.Fatalf("a withdrawal over the balance gives an InsufficientBalanceError: got %v", err)
+    }
+    if insufficient.Balance != 100 || insufficient.Requested != 101 || insufficient.Shortfall() != 1 {
+        t.Fatalf("the error reports balance 100 and requested 101: got %+v", insufficient)
+    }
+    if got := balance(t, st, 1); got != 100 {
+        t.Fatalf("a rejected withdrawal leaves the balance: got %d, want 100", got)
+    }
+
+    if _, err := st.CreateWithdrawal(ctx, withdrawal(1, 100, "k1")); err != nil {
+        t.Fatalf("a rejected key can be used again and the whole balance withdrawn: %v", err)
+    }
+}
+
+// TestConcurrentWithdrawals checks that concurrent withdrawals never spend
+// more than the balance.
+func TestConcurrentWithdrawals(t *testing.T, newStore Factory) {
+    st := newStore(t)
+    seedUser(t, st, 1, 1000)
+
+    const attempts = 20
+    var (
+        wg           sync.WaitGroup
+        mu           sync.Mutex
+        created      int
+        insufficient int
+        unexpected   []error
+    )
+    for i := 0; i < attempts; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            _, err := st.CreateWithdrawal(context.Background(), withdrawal(1, 100, fmt.Sprintf("k%d", i)))
+            mu.Lock()
+            defer mu.Unlock()
+            switch {
+            case err == nil:
+                created++
+            case errors.Is(err, store.ErrInsufficientBalance):
+                insufficient++
+            default:
+                unexpected = append(unexpected, err)
+            }
+        }(i)
+    }
+    wg.Wait()
+
+    if len(unexpected) > 0 {
+        t.Fatalf("concurrent withdrawals fail only for the balance: got %v", unexpected)
+    }
+    if created != 10 || insufficient != attempts-10 {
+        t.Fatalf("exactly the balance is spent: %d created, %d rejected, want 10 and %d", created, insufficient, attempts-10)
+    }
+    if got := balance(t, st, 1); got != 0 {
+        t.Fatalf("the balance never goes negative: got %d, want 0", got)
+    }
+}
+
+// TestConcurrentWithdrawalsSameKey checks that concurrent requests with one
+// key create a single withdrawal.
+func TestConcurrentWithdrawalsSameKey(t *testing.T, newStore Factory) {
+    st := newStore(t)
+    seedUser(t, st, 1, 1000)
+
+    const attempts = 10
+    results := make([]store.CreateWithdrawalResult, attempts)
+    errs := make([]error, attempts)
+    var wg sync.WaitGroup
+    for i := 0; i < attempts; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            results[i], errs[i] = st.CreateWithdrawal(context.Background(), withdrawal(1, 100, "k1"))
+        }(i)
+    }
+    wg.Wait()
+
+    fresh := 0
+    for i, err := range errs {
+        if err != nil {
+            t.Fatalf("concurrent requests with one key all succeed: request %d got %v", i, err)
+        }
+        if results[i].ID != results[0].ID {
+            t.Fatalf("concurrent requests with one key get the same withdrawal: got %d and %d", results[0].ID, results[i].ID)
+        }
+        if !results[i].Replayed {
+            fresh++
+        }
+    }
+    if fresh != 1 {
+        t.Fatalf("exactly one request creates the withdrawal: %d were not replays", fresh)
+    }
+    if got := balance(t, st, 1); got != 900 {
+        t.Fatalf("one key debits once: got balance %d, want 900", got)
+    }
+}
+
+// TestConfirmWithdrawal checks that confirming is idempotent and bumps the
+// version once.
+func TestConfirmWithdrawal(t *testing.T, newStore Factory) {
+    st := newStore(t)
+    ctx := context.Background()
+    seedUser(t, st, 1, 1000)
+
+    created, err := st.CreateWithdrawal(ctx, withdrawal(1, 100, "k1"))
+    if err != nil {
+        t.Fatalf("create withdrawal: %v", err)
+    }
+    confirmed, err := st.ConfirmWithdrawal(ctx, created.ID)
+    if err != nil {
+        t.Fatalf("confirm withdrawal: %v", err)
+    }
+    if confirmed.Status != store.StatusConfirmed || confirmed.ConfirmedAt == nil || confirmed.Version != created.Version+1 {
+        t.Fatalf("confirming sets the status and confirmed_at and bumps the version: got %+v", confirmed)
+    }
+
+    again, err := st.ConfirmWithdrawal(ctx, created.ID)
+    if err != nil {
+        t.Fatalf("confirming twice succeeds: %v", err)
+    }
+    if again.Status != store.StatusConfirmed || again.Version != confirmed.Version || again.ConfirmedAt == nil || !again.ConfirmedAt.Equal(*confirmed.ConfirmedAt) {
+        t.Fatalf("confirming twice changes nothing: got %+v, want %+v", again, confirmed)
+    }
+    if got := balance(t, st, 1); got != 900 {
+        t.Fatalf("confirming does not move money: got balance %d, want 900", got)
+    }
+
+    if _, err := st.ConfirmWithdrawal(ctx, 999); !errors.Is(err, store.ErrNotFound) {
+        t.Fatalf("confirming an unknown withdrawal gives ErrNotFound: got %v", err)
+    }
+}
@@ -0,0 +1,39 @@
+package store
+
+import (
+    "context"
+    "time"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// SendDailySummaryOnce calls send unless the summary for day was already
+// sent, so replicas sharing the database mail it once. It reports whether
+// send ran. The day stays claimed in a transaction while send runs: another
+// replica waits for it, then skips the day if send succeeded or claims it in
+// turn if send failed.
+func (s *Store) SendDailySummaryOnce(ctx context.Context, day time.Time, send func(ctx context.Context) error) (bool, error) {
+    sent := false
+    err := s.WithTx(ctx, func(tx pgx.Tx) error {
+        tag, err := tx.Exec(ctx, `
+            INSERT INTO daily_summaries (day, sent_at)
+            VALUES ($1, $2)
+            ON CONFLICT (day) DO NOTHING
+        `, day.Format(time.DateOnly), s.now())
+        if err != nil {
+            return err
+        }
+        if tag.RowsAffected() == 0 {
+            return nil
+        }
+        if err := send(ctx); err != nil {
+            return err
+        }
+        sent = true
+        return nil
+    })
+    if err != nil {
+        return false, err
+    }
+    return sent, nil
+}
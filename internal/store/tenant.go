@@ -0,0 +1,113 @@
+package store
+
+import (
+    "context"
+    "errors"
+    "fmt"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// TenantID identifies the tenant that owns users and their withdrawals in a
+// multi-tenant deployment. Rows created without a tenant belong to tenant 0.
+type TenantID int64
+
+type tenantKey struct{}
+
+// WithTenant scopes every store call made with the returned context to
+// tenant: lists and aggregates only see its rows, and lookups of another
+// tenant's user or withdrawal return ErrTenantMismatch.
+//
+// A context without a tenant is unscoped. Single-tenant deployments, admin
+// endpoints and background jobs use it to see every row.
+func WithTenant(ctx context.Context, tenant TenantID) context.Context {
+    return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant ctx is scoped to, if any.
+func TenantFromContext(ctx context.Context) (TenantID, bool) {
+    tenant, ok := ctx.Value(tenantKey{}).(TenantID)
+    return tenant, ok
+}
+
+// tenantOf is the tenant new rows created with ctx belong to.
+func tenantOf(ctx context.Context) TenantID {
+    tenant, _ := TenantFromContext(ctx)
+    return tenant
+}
+
+// tenantArg is the argument for a "($n::bigint IS NULL OR tenant_id = $n)"
+// filter: nil for an unscoped ctx, so that every row matches.
+func tenantArg(ctx context.Context) *int64 {
+    tenant, ok := TenantFromContext(ctx)
+    if !ok {
+        return nil
+    }
+    id := int64(tenant)
+    return &id
+}
+
+// tenantFilter renders the tenant condition for placeholder $n, qualified
+// with alias when it is not empty.
+func tenantFilter(alias string, n int) string {
+    column := "tenant_id"
+    if alias != "" {
+        column = alias + "." + column
+    }
+    return fmt.Sprintf("($%d::bigint IS NULL OR %s = $%d)", n, column, n)
+}
+
+// checkTenant returns ErrTenantMismatch when ctx is scoped to a tenant other
+// than owner.
+func checkTenant(ctx context.Context, owner TenantID) error {
+    if tenant, ok := TenantFromContext(ctx); ok && tenant != owner {
+        return ErrTenantMismatch
+    }
+    return nil
+}
+
+type originKeyKey struct{}
+
+// WithOriginKey restricts confirms made with the returned context to
+// withdrawals created by key; confirming any other returns
+// ErrOriginKeyMismatch, so that one integration cannot confirm another's
+// withdrawals. Withdrawals without a recorded key are not restricted.
+func WithOriginKey(ctx context.Context, key string) context.Context {
+    return context.WithValue(ctx, originKeyKey{}, key)
+}
+
+// checkOriginKey returns ErrOriginKeyMismatch when ctx is restricted to a
+// key other than createdBy.
+func checkOriginKey(ctx context.Context, createdBy string) error {
+    key, ok := ctx.Value(originKeyKey{}).(string)
+    if ok && createdBy != "" && createdBy != key {
+        return ErrOriginKeyMismatch
+    }
+    return nil
+}
+
+// authorizeRow checks that the row of table with id is visible to ctx before
+// a statement that would otherwise touch it blindly. It costs nothing for an
+// unscoped ctx.
+func authorizeRow(ctx context.Context, q querier, table string, id int64, notFound error) error {
+    if _, ok := TenantFromContext(ctx); !ok {
+        return nil
+    }
+    var owner TenantID
+    err := q.QueryRow(ctx, "SELECT tenant_id FROM "+table+" WHERE id = $1", id).Scan(&owner)
+    if err != nil {
+        if errors.Is(err, pgx.ErrNoRows) {
+            return notFound
+        }
+        return err
+    }
+    return checkTenant(ctx, owner)
+}
+
+func authorizeUser(ctx context.Context, q querier, id int64) error {
+    return authorizeRow(ctx, q, "users", id, ErrUserNotFound)
+}
+
+func authorizeWithdrawal(ctx context.Context, q querier, id int64) error {
+    return authorizeRow(ctx, q, "withdrawals", id, ErrNotFound)
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+    "context"
+
+    "github.com/jackc/pgx/v5"
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/attribute"
+    "go.opentelemetry.io/otel/codes"
+    "go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("task.hh/internal/store")
+
+// QueryTracer is a pgx.QueryTracer that records a client span per query. Set
+// it on pgxpool.Config.ConnConfig.Tracer.
+type QueryTracer struct{}
+
+func (QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+    ctx, _ = tracer.Start(ctx, "db.query",
+        trace.WithSpanKind(trace.SpanKindClient),
+        trace.WithAttributes(
+            attribute.String("db.system", "postgresql"),
+            attribute.String("db.statement", data.SQL),
+        ),
+    )
+    return ctx
+}
+
+func (QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+    span := trace.SpanFromContext(ctx)
+    endSpan(span, data.Err)
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+    if err != nil {
+        span.RecordError(err)
+        span.SetStatus(codes.Error, err.Error())
+    }
+    span.End()
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+    "context"
+    "unicode/utf8"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// MaxTxHashLength bounds a recorded transaction hash, in characters.
+const MaxTxHashLength = 128
+
+// RecordExternalTxHash attaches the hash of the on-chain transaction that
+// paid out a confirmed withdrawal and returns the updated withdrawal. An
+// empty or longer than MaxTxHashLength hash returns ErrInvalidTxHash.
+// Recording the hash the withdrawal already has returns it unchanged, in any
+// status, so retries succeed; a different one returns ErrTxHashConflict. A
+// withdrawal without a hash that is not confirmed returns ErrInvalidStatus,
+// and a missing one ErrNotFound.
+func (s *Store) RecordExternalTxHash(ctx context.Context, withdrawalID int64, txHash string) (Withdrawal, error) {
+    if txHash == "" || utf8.RuneCountInString(txHash) > MaxTxHashLength {
+        return Withdrawal{}, ErrInvalidTxHash
+    }
+
+    var recorded Withdrawal
+    now := s.now()
+    err := s.WithTx(ctx, func(tx pgx.Tx) error {
+        w, err := lockWithdrawal(ctx, tx, withdrawalID)
+        if err != nil {
+            return err
+        }
+        if w.ExternalTxHash != nil {
+            if *w.ExternalTxHash != txHash {
+                return ErrTxHashConflict
+            }
+            recorded = w
+            return nil
+        }
+        if w.Status != StatusConfirmed {
+            return ErrInvalidStatus
+        }
+
+        recorded, err = scanWithdrawal(tx.QueryRow(ctx, `
+            UPDATE withdrawals SET external_tx_hash = $2, updated_at = $3, version = version + 1
+            WHERE id = $1
+            RETURNING `+withdrawalColumns,
+            withdrawalID, txHash, now,
+        ))
+        if err != nil {
+            return err
+        }
+        return recordAudit(ctx, tx, recorded, now)
+    })
+    if err != nil {
+        return Withdrawal{}, err
+    }
+    return recorded, nil
+}
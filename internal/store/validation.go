@@ -0,0 +1,128 @@
+package store
+
+import (
+    "fmt"
+    "regexp"
+    "strings"
+    "unicode"
+    "unicode/utf8"
+)
+
+// Bounds on the text fields of a withdrawal, which are stored, indexed and
+// exported to CSV as they are.
+const (
+    MaxIdempotencyKeyLength = 128
+    MaxDestinationLength    = 256
+)
+
+// currencyPattern is the shape of the codes in the currency registry.
+var currencyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+
+// Reasons a FieldError gives for rejecting a field.
+const (
+    ReasonRequired          = "required"
+    ReasonTooLong           = "too_long"
+    ReasonInvalidCharacters = "invalid_characters"
+    ReasonInvalidFormat     = "invalid_format"
+    ReasonUnsupported       = "unsupported"
+)
+
+// FieldError is one rejected field of an input, named as in the API.
+type FieldError struct {
+    Field  string
+    Reason string
+}
+
+// InvalidInputError lists every field an input was rejected for. It matches
+// ErrInvalidInput.
+type InvalidInputError struct {
+    Fields []FieldError
+}
+
+func (e *InvalidInputError) Error() string {
+    parts := make([]string, len(e.Fields))
+    for i, f := range e.Fields {
+        parts[i] = f.Field + " " + f.Reason
+    }
+    return fmt.Sprintf("%v: %s", ErrInvalidInput, strings.Join(parts, ", "))
+}
+
+func (e *InvalidInputError) Unwrap() error {
+    return ErrInvalidInput
+}
+
+// Normalize trims the idempotency key and destination, uppercases the
+// currency and checks the text fields: the key must be 1 to
+// MaxIdempotencyKeyLength printable ASCII characters, the destination 1 to
+// MaxDestinationLength characters without whitespace or control characters,
+// and the currency shaped like a registry code. Every store method creating
+// withdrawals normalizes its input, so a key differing only in surrounding
+// spaces replays the same withdrawal whichever way it arrives. Violations are
+// returned as an *InvalidInputError.
+func (in CreateWithdrawalInput) Normalize() (CreateWithdrawalInput, error) {
+    in.IdempotencyKey = strings.TrimSpace(in.IdempotencyKey)
+    in.Destination = strings.TrimSpace(in.Destination)
+    in.Currency = strings.ToUpper(in.Currency)
+
+    var fields []FieldError
+    if reason := checkIdempotencyKey(in.IdempotencyKey); reason != "" {
+        fields = append(fields, FieldError{Field: "idempotency_key", Reason: reason})
+    }
+    if reason := checkDestination(in.Destination); reason != "" {
+        fields = append(fields, FieldError{Field: "destination", Reason: reason})
+    }
+    if reason := checkCurrency(in.Currency); reason != "" {
+        fields = append(fields, FieldError{Field: "currency", Reason: reason})
+    }
+    if len(fields) > 0 {
+        return in, &InvalidInputError{Fields: fields}
+    }
+    return in, nil
+}
+
+func checkIdempotencyKey(key string) string {
+    switch {
+    case key == "":
+        return ReasonRequired
+    case len(key) > MaxIdempotencyKeyLength:
+        return ReasonTooLong
+    }
+    for i := 0; i < len(key); i++ {
+        if key[i] < ' ' || key[i] > '~' {
+            return ReasonInvalidCharacters
+        }
+    }
+    return ""
+}
+
+func checkDestination(destination string) string {
+    switch {
+    case destination == "":
+        return ReasonRequired
+    case !utf8.ValidString(destination):
+        return ReasonInvalidCharacters
+    case utf8.RuneCountInString(destination) > MaxDestinationLength:
+        return ReasonTooLong
+    }
+    for _, r := range destination {
+        if unicode.IsSpace(r) || unicode.IsControl(r) {
+            return ReasonInvalidCharacters
+        }
+    }
+    return ""
+}
+
+// ValidCurrencyCode reports whether code is shaped like a registry code.
+func ValidCurrencyCode(code string) bool {
+    return checkCurrency(code) == ""
+}
+
+func checkCurrency(currency string) string {
+    switch {
+    case currency == "":
+        return ReasonRequired
+    case !currencyPattern.MatchString(currency):
+        return ReasonInvalidFormat
+    }
+    return ""
+}
@@ -0,0 +1,39 @@
+// Package testutil holds helpers shared by the tests of several packages.
+package testutil
+
+import (
+    "sync"
+    "time"
+)
+
+// FakeClock is a clock that only moves when told to. It satisfies
+// store.Clock and is safe for concurrent use.
+type FakeClock struct {
+    mu  sync.Mutex
+    now time.Time
+}
+
+// NewFakeClock returns a clock stopped at now.
+func NewFakeClock(now time.Time) *FakeClock {
+    return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = c.now.Add(d)
+}
+
+// Set moves the clock to now, which may be in the past.
+func (c *FakeClock) Set(now time.Time) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.now = now
+}
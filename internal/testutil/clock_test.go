@@ -0,0 +1,22 @@
+package testutil
+
+import (
+    "testing"
+    "time"
+)
+
+func TestFakeClock(t *testing.T) {
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    c := NewFakeClock(start)
+    if !c.Now().Equal(start) {
+        t.Fatalf("expected %s, got %s", start, c.Now())
+    }
+    c.Advance(90 * time.Second)
+    if want := start.Add(90 * time.Second); !c.Now().Equal(want) {
+        t.Fatalf("expected %s after advancing, got %s", want, c.Now())
+    }
+    c.Set(start)
+    if !c.Now().Equal(start) {
+        t.Fatalf("expected %s after set, got %s", start, c.Now())
+    }
+}
@@ -0,0 +1,8 @@
+// Package fixtures builds the users, withdrawals and request bodies tests
+// need. Records are created through store.Store rather than raw SQL, so a
+// schema change only needs the store and, at most, a builder updated. A test
+// seeds with fixtures.User(t, pool).WithBalance(1000).Create() or
+// fixtures.Withdrawal(t, pool).Amount(200).Confirmed().Create(), and sends
+// fixtures.WithdrawalRequest().Set("amount", 200).JSON() to a handler.
+// Builders fail the test on any error, so a test reads as its setup.
+package fixtures
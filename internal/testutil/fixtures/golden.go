@@ -0,0 +1,74 @@
+package fixtures
+
+import (
+    "encoding/json"
+    "flag"
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+var updateGolden = flag.Bool("update-golden", false, "rewrite the response shapes in testdata/shapes")
+
+// AssertShape compares the shape of the JSON document body with the golden
+// file testdata/shapes/<name>.json of the package under test. The shape
+// keeps field names, nesting and the JSON type of each value but not the
+// values, so ids and timestamps do not churn the golden; arrays are shaped
+// by their first element. Run the tests with -update-golden to rewrite it
+// after an intended change.
+func AssertShape(t testing.TB, name string, body []byte) {
+    t.Helper()
+
+    var doc any
+    if err := json.Unmarshal(body, &doc); err != nil {
+        t.Fatalf("fixtures: %s is not JSON: %v: %s", name, err, body)
+    }
+    got, err := json.MarshalIndent(shapeOf(doc), "", "  ")
+    if err != nil {
+        t.Fatalf("fixtures: marshal shape: %v", err)
+    }
+    got = append(got, '\n')
+
+    path := filepath.Join("testdata", "shapes", name+".json")
+    if *updateGolden {
+        if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+            t.Fatalf("fixtures: %v", err)
+        }
+        if err := os.WriteFile(path, got, 0o644); err != nil {
+            t.Fatalf("fixtures: write golden: %v", err)
+        }
+        return
+    }
+    want, err := os.ReadFile(path)
+    if err != nil {
+        t.Fatalf("fixtures: read golden (run with -update-golden to create it): %v", err)
+    }
+    if string(got) != string(want) {
+        t.Fatalf("fixtures: shape of %s changed; run with -update-golden if intended\ngot:\n%s\nwant:\n%s", name, got, want)
+    }
+}
+
+// shapeOf replaces every value in doc with the name of its JSON type.
+func shapeOf(doc any) any {
+    switch v := doc.(type) {
+    case map[string]any:
+        shape := make(map[string]any, len(v))
+        for k, field := range v {
+            shape[k] = shapeOf(field)
+        }
+        return shape
+    case []any:
+        if len(v) == 0 {
+            return []any{}
+        }
+        return []any{shapeOf(v[0])}
+    case string:
+        return "string"
+    case float64:
+        return "number"
+    case bool:
+        return "boolean"
+    default:
+        return "null"
+    }
+}
@@ -0,0 +1,36 @@
+package fixtures
+
+import (
+    "encoding/json"
+    "testing"
+)
+
+func TestBody(t *testing.T) {
+    tests := []struct {
+        name string
+        body *Body
+        want string
+    }{
+        {"withdrawal", WithdrawalRequest(), `{"user_id":1,"amount":100,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`},
+        {"set keeps order", WithdrawalRequest().Set("amount", 200).Set("user_id", 2), `{"user_id":2,"amount":200,"currency":"USDT","destination":"addr","idempotency_key":"k1"}`},
+        {"without", WithdrawalRequest().Without("currency").Without("nope"), `{"user_id":1,"amount":100,"destination":"addr","idempotency_key":"k1"}`},
+        {"raw", UserRequest().Set("balance", json.RawMessage(`1.5`)), `{"id":1,"balance":1.5}`},
+    }
+    for _, tt := range tests {
+        if got := tt.body.JSON(); got != tt.want {
+            t.Fatalf("%s: expected %s, got %s", tt.name, tt.want, got)
+        }
+    }
+}
+
+func TestShapeOf(t *testing.T) {
+    var doc any
+    if err := json.Unmarshal([]byte(`{"id":1,"tags":["a","b"],"none":[],"user":{"ok":true,"name":null}}`), &doc); err != nil {
+        t.Fatal(err)
+    }
+    got, _ := json.Marshal(shapeOf(doc))
+    want := `{"id":"number","none":[],"tags":["string"],"user":{"name":"null","ok":"boolean"}}`
+    if string(got) != want {
+        t.Fatalf("expected %s, got %s", want, got)
+    }
+}
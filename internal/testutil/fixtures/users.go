@@ -0,0 +1,78 @@
+package fixtures
+
+import (
+    "context"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "task.hh/internal/store"
+)
+
+// UserBuilder creates a user. The zero configuration is user 1 with a zero
+// balance, the standard tier and no overdraft.
+type UserBuilder struct {
+    t     testing.TB
+    store *store.Store
+
+    id         int64
+    balance    int64
+    tier       string
+    externalID *string
+    overdraft  int64
+}
+
+// User starts a user stored in pool.
+func User(t testing.TB, pool *pgxpool.Pool) *UserBuilder {
+    return &UserBuilder{t: t, store: store.New(pool), id: 1}
+}
+
+func (b *UserBuilder) WithID(id int64) *UserBuilder {
+    b.id = id
+    return b
+}
+
+func (b *UserBuilder) WithBalance(balance int64) *UserBuilder {
+    b.balance = balance
+    return b
+}
+
+func (b *UserBuilder) WithTier(tier string) *UserBuilder {
+    b.tier = tier
+    return b
+}
+
+func (b *UserBuilder) WithExternalID(id string) *UserBuilder {
+    b.externalID = &id
+    return b
+}
+
+func (b *UserBuilder) WithOverdraft(limit int64) *UserBuilder {
+    b.overdraft = limit
+    return b
+}
+
+// Create stores the user and returns it as read back.
+func (b *UserBuilder) Create() store.User {
+    b.t.Helper()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    u, err := b.store.CreateUser(ctx, b.id, b.balance, b.externalID)
+    if err != nil {
+        b.t.Fatalf("fixtures: create user %d: %v", b.id, err)
+    }
+    if b.tier != "" && b.tier != u.Tier {
+        if u, err = b.store.UpdateUserTier(ctx, b.id, b.tier); err != nil {
+            b.t.Fatalf("fixtures: set tier of user %d: %v", b.id, err)
+        }
+    }
+    if b.overdraft != 0 {
+        if u, err = b.store.SetOverdraftLimit(ctx, b.id, b.overdraft); err != nil {
+            b.t.Fatalf("fixtures: set overdraft of user %d: %v", b.id, err)
+        }
+    }
+    return u
+}
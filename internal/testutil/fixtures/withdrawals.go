@@ -0,0 +1,112 @@
+package fixtures
+
+import (
+    "context"
+    "fmt"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/jackc/pgx/v5/pgxpool"
+
+    "task.hh/internal/store"
+)
+
+var keySeq atomic.Int64
+
+// WithdrawalBuilder creates a withdrawal, debiting the user as the API
+// would. The zero configuration is a pending withdrawal of 100 USDT by user 1
+// to "addr" under a key no other fixture uses.
+type WithdrawalBuilder struct {
+    t     testing.TB
+    store *store.Store
+
+    input  store.CreateWithdrawalInput
+    status string
+    reason string
+}
+
+// Withdrawal starts a withdrawal stored in pool. The user must exist.
+func Withdrawal(t testing.TB, pool *pgxpool.Pool) *WithdrawalBuilder {
+    return &WithdrawalBuilder{
+        t:     t,
+        store: store.New(pool),
+        input: store.CreateWithdrawalInput{
+            UserID:         1,
+            Amount:         100,
+            Currency:       "USDT",
+            Destination:    "addr",
+            IdempotencyKey: fmt.Sprintf("fixture-%d", keySeq.Add(1)),
+        },
+        status: store.StatusPending,
+    }
+}
+
+func (b *WithdrawalBuilder) ForUser(id int64) *WithdrawalBuilder {
+    b.input.UserID = id
+    return b
+}
+
+func (b *WithdrawalBuilder) Amount(amount int64) *WithdrawalBuilder {
+    b.input.Amount = amount
+    return b
+}
+
+func (b *WithdrawalBuilder) Currency(currency string) *WithdrawalBuilder {
+    b.input.Currency = currency
+    return b
+}
+
+func (b *WithdrawalBuilder) Destination(destination string) *WithdrawalBuilder {
+    b.input.Destination = destination
+    return b
+}
+
+func (b *WithdrawalBuilder) Key(key string) *WithdrawalBuilder {
+    b.input.IdempotencyKey = key
+    return b
+}
+
+func (b *WithdrawalBuilder) Pending() *WithdrawalBuilder {
+    b.status = store.StatusPending
+    return b
+}
+
+func (b *WithdrawalBuilder) Confirmed() *WithdrawalBuilder {
+    b.status = store.StatusConfirmed
+    return b
+}
+
+// Reversed confirms the withdrawal and then reverses it for reason.
+func (b *WithdrawalBuilder) Reversed(reason string) *WithdrawalBuilder {
+    b.status = store.StatusReversed
+    b.reason = reason
+    return b
+}
+
+// Create stores the withdrawal and moves it to the chosen status through the
+// same transitions the API makes.
+func (b *WithdrawalBuilder) Create() store.Withdrawal {
+    b.t.Helper()
+
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+
+    created, err := b.store.CreateWithdrawal(ctx, b.input)
+    if err != nil {
+        b.t.Fatalf("fixtures: create withdrawal %q: %v", b.input.IdempotencyKey, err)
+    }
+    w := created.Withdrawal
+    if b.status == store.StatusPending {
+        return w
+    }
+    if w, err = b.store.ConfirmWithdrawal(ctx, w.ID); err != nil {
+        b.t.Fatalf("fixtures: confirm withdrawal %d: %v", created.ID, err)
+    }
+    if b.status == store.StatusReversed {
+        if w, err = b.store.ReverseWithdrawal(ctx, w.ID, b.reason); err != nil {
+            b.t.Fatalf("fixtures: reverse withdrawal %d: %v", created.ID, err)
+        }
+    }
+    return w
+}
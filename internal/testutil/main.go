@@ -0,0 +1,78 @@
+package testutil
+
+import (
+    "context"
+    "log"
+    "os"
+    "testing"
+    "time"
+
+    "github.com/testcontainers/testcontainers-go"
+    "github.com/testcontainers/testcontainers-go/modules/postgres"
+    "github.com/testcontainers/testcontainers-go/wait"
+)
+
+// RunWithDatabase runs a package's tests for its TestMain and returns the
+// exit code. Before m.Run it points *url at a database of the package's own,
+// named after prefix, on the Postgres server from DATABASE_URL when set,
+// otherwise on a container started for the test binary. *url stays empty only
+// when neither is available, and integration tests are expected to skip then.
+func RunWithDatabase(m *testing.M, prefix string, url *string) int {
+    ctx := context.Background()
+    if serverURL := os.Getenv("DATABASE_URL"); serverURL != "" {
+        return runOnServer(ctx, m, prefix, serverURL, url)
+    }
+
+    container, err := startPostgres(ctx)
+    if err != nil {
+        log.Printf("postgres container unavailable, integration tests will be skipped: %v", err)
+        return m.Run()
+    }
+    defer func() {
+        if err := container.Terminate(ctx); err != nil {
+            log.Printf("terminate postgres container: %v", err)
+        }
+    }()
+
+    serverURL, err := container.ConnectionString(ctx, "sslmode=disable")
+    if err != nil {
+        log.Printf("postgres container connection string: %v", err)
+        return 1
+    }
+    return runOnServer(ctx, m, prefix, serverURL, url)
+}
+
+// runOnServer runs the tests against a fresh database on the server at
+// serverURL, so they do not reset tables under another package's tests. A
+// role that may not create databases gets the database from serverURL
+// itself.
+func runOnServer(ctx context.Context, m *testing.M, prefix, serverURL string, url *string) int {
+    dbURL, drop, err := CreateDatabase(ctx, serverURL, prefix)
+    if err != nil {
+        log.Printf("no database of its own, sharing the configured one: %v", err)
+        *url = serverURL
+        return m.Run()
+    }
+    defer func() {
+        if err := drop(ctx); err != nil {
+            log.Printf("drop test database: %v", err)
+        }
+    }()
+
+    *url = dbURL
+    return m.Run()
+}
+
+func startPostgres(ctx context.Context) (*postgres.PostgresContainer, error) {
+    return postgres.RunContainer(ctx,
+        testcontainers.WithImage("postgres:16-alpine"),
+        postgres.WithDatabase("app"),
+        postgres.WithUsername("postgres"),
+        postgres.WithPassword("postgres"),
+        testcontainers.WithWaitStrategy(
+            wait.ForLog("database system is ready to accept connections").
+                WithOccurrence(2).
+                WithStartupTimeout(60*time.Second),
+        ),
+    )
+}
@@ -0,0 +1,65 @@
+package testutil
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "fmt"
+    "net/url"
+    "strings"
+
+    "github.com/jackc/pgx/v5"
+)
+
+// CreateDatabase creates a database named after prefix plus a random suffix
+// on the server baseURL points at, and returns a URL for it along with a
+// func that drops it. Giving each test binary its own database lets the
+// packages whose tests truncate tables run in parallel. The role in baseURL
+// needs the CREATEDB privilege.
+func CreateDatabase(ctx context.Context, baseURL, prefix string) (string, func(context.Context) error, error) {
+    suffix := make([]byte, 4)
+    if _, err := rand.Read(suffix); err != nil {
+        return "", nil, err
+    }
+    name := prefix + "_" + hex.EncodeToString(suffix)
+
+    dbURL, err := databaseURL(baseURL, name)
+    if err != nil {
+        return "", nil, err
+    }
+    if err := execOn(ctx, baseURL, "CREATE DATABASE "+pgx.Identifier{name}.Sanitize()); err != nil {
+        return "", nil, fmt.Errorf("create database %s: %w", name, err)
+    }
+
+    drop := func(ctx context.Context) error {
+        return execOn(ctx, baseURL, "DROP DATABASE IF EXISTS "+pgx.Identifier{name}.Sanitize()+" WITH (FORCE)")
+    }
+    return dbURL, drop, nil
+}
+
+func execOn(ctx context.Context, connURL, sql string) error {
+    conn, err := pgx.Connect(ctx, connURL)
+    if err != nil {
+        return err
+    }
+    defer conn.Close(ctx)
+
+    _, err = conn.Exec(ctx, sql)
+    return err
+}
+
+// databaseURL points connURL, a URL or a key/value connection string, at the
+// database name.
+func databaseURL(connURL, name string) (string, error) {
+    if !strings.HasPrefix(connURL, "postgres://") && !strings.HasPrefix(connURL, "postgresql://") {
+        // A later key overrides an earlier one.
+        return connURL + " dbname=" + name, nil
+    }
+    u, err := url.Parse(connURL)
+    if err != nil {
+        return "", fmt.Errorf("parse database url: %w", err)
+    }
+    u.Path = "/" + name
+    u.RawPath = ""
+    return u.String(), nil
+}
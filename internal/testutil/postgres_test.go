@@ -0,0 +1,20 @@
+package testutil
+
+import "testing"
+
+func TestDatabaseURL(t *testing.T) {
+    tests := []struct {
+        in   string
+        want string
+    }{
+        {"postgres://u:p@db:5432/app?sslmode=disable", "postgres://u:p@db:5432/store_1?sslmode=disable"},
+        {"postgresql://db", "postgresql://db/store_1"},
+        {"host=db dbname=app sslmode=disable", "host=db dbname=app sslmode=disable dbname=store_1"},
+    }
+    for _, tt := range tests {
+        got, err := databaseURL(tt.in, "store_1")
+        if err != nil || got != tt.want {
+            t.Fatalf("databaseURL(%q): expected %q, got %q %v", tt.in, tt.want, got, err)
+        }
+    }
+}
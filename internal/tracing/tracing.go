@@ -0,0 +1,52 @@
+// Package tracing configures the global OpenTelemetry tracer provider.
+//
+// The exporter is configured through the standard OTLP environment variables
+// (OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_TRACES_ENDPOINT,
+// OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME, ...). When no endpoint is
+// set the global no-op provider is left in place, so instrumented code costs
+// next to nothing.
+package tracing
+
+import (
+    "context"
+
+    "go.opentelemetry.io/otel"
+    "go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+    "go.opentelemetry.io/otel/propagation"
+    "go.opentelemetry.io/otel/sdk/resource"
+    sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Setup installs the W3C trace context propagator and, if an OTLP endpoint is
+// configured, a batching tracer provider. The returned function flushes and
+// stops the provider.
+func Setup(ctx context.Context, getenv func(string) string) (func(context.Context) error, error) {
+    otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+        propagation.TraceContext{},
+        propagation.Baggage{},
+    ))
+
+    if !Enabled(getenv) {
+        return func(context.Context) error { return nil }, nil
+    }
+
+    exporter, err := otlptracehttp.New(ctx)
+    if err != nil {
+        return nil, err
+    }
+    res, err := resource.Merge(resource.Default(), resource.Environment())
+    if err != nil {
+        return nil, err
+    }
+    provider := sdktrace.NewTracerProvider(
+        sdktrace.WithBatcher(exporter),
+        sdktrace.WithResource(res),
+    )
+    otel.SetTracerProvider(provider)
+    return provider.Shutdown, nil
+}
+
+// Enabled reports whether an OTLP traces endpoint is configured.
+func Enabled(getenv func(string) string) bool {
+    return getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
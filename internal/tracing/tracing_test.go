@@ -0,0 +1,35 @@
+package tracing
+
+import (
+    "context"
+    "testing"
+)
+
+func TestSetupWithoutEndpointIsNoop(t *testing.T) {
+    shutdown, err := Setup(context.Background(), func(string) string { return "" })
+    if err != nil {
+        t.Fatalf("setup: %v", err)
+    }
+    if err := shutdown(context.Background()); err != nil {
+        t.Fatalf("shutdown: %v", err)
+    }
+}
+
+func TestEnabled(t *testing.T) {
+    cases := map[string]bool{
+        "":                                   false,
+        "OTEL_EXPORTER_OTLP_ENDPOINT":        true,
+        "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT": true,
+    }
+    for key, want := range cases {
+        getenv := func(k string) string {
+            if k == key {
+                return "http://collector:4318"
+            }
+            return ""
+        }
+        if got := Enabled(getenv); got != want {
+            t.Fatalf("%q: expected %v, got %v", key, want, got)
+        }
+    }
+}
@@ -1,31 +1,185 @@
 CREATE TABLE IF NOT EXISTS users (
     id BIGINT PRIMARY KEY,
-    balance BIGINT NOT NULL CHECK (balance >= 0),
-    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+    balance BIGINT NOT NULL,
+    tier VARCHAR(20) NOT NULL DEFAULT 'standard',
+    external_id VARCHAR(128),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    tenant_id BIGINT NOT NULL DEFAULT 0,
+    overdraft_limit BIGINT NOT NULL DEFAULT 0 CHECK (overdraft_limit >= 0),
+    CONSTRAINT users_balance_check CHECK (balance >= -overdraft_limit)
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tier VARCHAR(20) NOT NULL DEFAULT 'standard';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+UPDATE users SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE users ALTER COLUMN updated_at SET DEFAULT now();
+ALTER TABLE users ALTER COLUMN updated_at SET NOT NULL;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(128);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS overdraft_limit BIGINT NOT NULL DEFAULT 0 CHECK (overdraft_limit >= 0);
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_balance_check;
+ALTER TABLE users ADD CONSTRAINT users_balance_check CHECK (balance >= -overdraft_limit);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_external_id ON users(external_id);
+
+CREATE TABLE IF NOT EXISTS currencies (
+    code TEXT PRIMARY KEY CHECK (code ~ '^[A-Z][A-Z0-9]{1,9}$')
 );
 
+INSERT INTO currencies (code) VALUES ('USDT') ON CONFLICT (code) DO NOTHING;
+
 CREATE TABLE IF NOT EXISTS withdrawals (
     id BIGSERIAL PRIMARY KEY,
     user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
     amount BIGINT NOT NULL CHECK (amount > 0),
-    currency TEXT NOT NULL CHECK (currency = 'USDT'),
+    fee BIGINT NOT NULL DEFAULT 0 CHECK (fee >= 0),
+    currency TEXT NOT NULL REFERENCES currencies(code),
     destination TEXT NOT NULL,
-    status TEXT NOT NULL CHECK (status IN ('pending', 'confirmed')),
+    status TEXT NOT NULL CHECK (status IN ('pending', 'confirmed', 'expired', 'reversed')),
     idempotency_key TEXT NOT NULL,
+    reserved_until TIMESTAMPTZ,
     created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    confirmed_at TIMESTAMPTZ,
+    note_count INT NOT NULL DEFAULT 0,
+    reversal_reason TEXT NOT NULL DEFAULT '',
+    tenant_id BIGINT NOT NULL DEFAULT 0,
+    version BIGINT NOT NULL DEFAULT 1,
+    created_by_key TEXT NOT NULL DEFAULT '',
+    external_tx_hash VARCHAR(128),
     UNIQUE (user_id, idempotency_key)
 );
 
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS fee BIGINT NOT NULL DEFAULT 0 CHECK (fee >= 0);
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reserved_until TIMESTAMPTZ;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+UPDATE withdrawals SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE withdrawals ALTER COLUMN updated_at SET DEFAULT now();
+ALTER TABLE withdrawals ALTER COLUMN updated_at SET NOT NULL;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS confirmed_at TIMESTAMPTZ;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS note_count INT NOT NULL DEFAULT 0;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS reversal_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS tenant_id BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS created_by_key TEXT NOT NULL DEFAULT '';
+ALTER TABLE withdrawals ADD COLUMN IF NOT EXISTS external_tx_hash VARCHAR(128);
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_status_check;
+ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_status_check CHECK (status IN ('pending', 'confirmed', 'expired', 'reversed'));
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_currency_check;
+ALTER TABLE withdrawals DROP CONSTRAINT IF EXISTS withdrawals_currency_fkey;
+ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_currency_fkey FOREIGN KEY (currency) REFERENCES currencies(code);
+
 CREATE INDEX IF NOT EXISTS idx_withdrawals_user_id ON withdrawals(user_id);
+CREATE INDEX IF NOT EXISTS idx_withdrawals_user_id_status ON withdrawals(user_id, status);
+CREATE INDEX IF NOT EXISTS idx_withdrawals_pending_reserved_until ON withdrawals(reserved_until) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_withdrawals_pending_updated_at ON withdrawals(updated_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_withdrawals_destination ON withdrawals(destination, id);
+CREATE INDEX IF NOT EXISTS idx_withdrawals_tenant_id ON withdrawals(tenant_id, id);
+DROP INDEX IF EXISTS idx_withdrawals_status_created_at;
+CREATE INDEX IF NOT EXISTS idx_withdrawals_pending_created_at ON withdrawals(created_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_withdrawals_confirmed_at ON withdrawals(confirmed_at) WHERE confirmed_at IS NOT NULL;
 
 CREATE TABLE IF NOT EXISTS ledger_entries (
     id BIGSERIAL PRIMARY KEY,
     user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
     withdrawal_id BIGINT REFERENCES withdrawals(id) ON DELETE SET NULL,
     amount BIGINT NOT NULL CHECK (amount > 0),
-    currency TEXT NOT NULL CHECK (currency = 'USDT'),
-    direction TEXT NOT NULL CHECK (direction IN ('debit', 'credit')),
+    currency TEXT NOT NULL REFERENCES currencies(code),
+    direction TEXT NOT NULL CHECK (direction IN ('debit', 'credit', 'fee', 'settlement')),
+    reason TEXT NOT NULL DEFAULT '',
     created_at TIMESTAMPTZ NOT NULL DEFAULT now()
 );
 
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_direction_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_direction_check CHECK (direction IN ('debit', 'credit', 'fee', 'settlement'));
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_currency_check;
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_currency_fkey;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_currency_fkey FOREIGN KEY (currency) REFERENCES currencies(code);
+
+ALTER TABLE ledger_entries ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_reason_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_reason_check CHECK (reason IN ('', 'cancelled', 'failed', 'expired', 'reversed'));
+
 CREATE INDEX IF NOT EXISTS idx_ledger_entries_user_id ON ledger_entries(user_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_withdrawal_id ON ledger_entries(withdrawal_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_entries_refund ON ledger_entries(withdrawal_id, direction) WHERE direction = 'credit';
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_entries_settlement ON ledger_entries(withdrawal_id) WHERE direction = 'settlement';
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_created_at ON ledger_entries(created_at, id);
+
+CREATE TABLE IF NOT EXISTS ledger_entries_archive (
+    id BIGINT PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE RESTRICT,
+    withdrawal_id BIGINT REFERENCES withdrawals(id) ON DELETE SET NULL,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    currency TEXT NOT NULL,
+    direction TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_archive_user_id ON ledger_entries_archive(user_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_archive_withdrawal_id ON ledger_entries_archive(withdrawal_id);
+
+CREATE TABLE IF NOT EXISTS withdrawal_notes (
+    id BIGSERIAL PRIMARY KEY,
+    withdrawal_id BIGINT NOT NULL REFERENCES withdrawals(id) ON DELETE RESTRICT,
+    author TEXT NOT NULL,
+    text TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_withdrawal_notes_withdrawal_id ON withdrawal_notes(withdrawal_id, id);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    actor TEXT NOT NULL,
+    action TEXT NOT NULL,
+    resource_type TEXT NOT NULL,
+    resource_id TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    summary TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    prev_hash TEXT NOT NULL,
+    hash TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor_created_at ON audit_log(actor, created_at);
+DROP INDEX IF EXISTS idx_audit_log_resource;
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource_chain ON audit_log(resource_type, resource_id, id);
+
+CREATE TABLE IF NOT EXISTS blacklisted_destinations (
+    address VARCHAR PRIMARY KEY,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS balance_audit (
+    id BIGSERIAL PRIMARY KEY,
+    actor TEXT NOT NULL,
+    operation TEXT NOT NULL,
+    user_id BIGINT NOT NULL,
+    withdrawal_id BIGINT NOT NULL,
+    currency TEXT NOT NULL,
+    amount BIGINT NOT NULL,
+    fee BIGINT NOT NULL,
+    balance_before BIGINT NOT NULL,
+    balance_after BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_balance_audit_withdrawal_id ON balance_audit(withdrawal_id, id);
+CREATE INDEX IF NOT EXISTS idx_balance_audit_user_id_created_at ON balance_audit(user_id, created_at);
+
+CREATE OR REPLACE RULE balance_audit_no_update AS ON UPDATE TO balance_audit DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE balance_audit_no_delete AS ON DELETE TO balance_audit DO INSTEAD NOTHING;
+
+CREATE TABLE IF NOT EXISTS maintenance_mode (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    enabled BOOLEAN NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS daily_summaries (
+    day DATE PRIMARY KEY,
+    sent_at TIMESTAMPTZ NOT NULL
+);